@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+const (
+	defaultResyncInterval = 300
+	changeDebounce        = time.Second
+)
+
+// node network agent, runs on every node (daemonset with host network),
+// keeps node network annotation up to date when host networking changes
+type Agent struct {
+	k8s            client.Client
+	nodeName       string
+	resyncInterval time.Duration
+}
+
+// entry of the agent subcommand
+func Main(args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	var (
+		nodeName       = fs.String("node-name", os.Getenv("NODE_NAME"), "Name of the node the agent runs on")
+		resyncInterval = fs.Int("resync-interval", defaultResyncInterval, "Full resync interval in seconds")
+	)
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(fs)
+	fs.Parse(args)
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog := ctrl.Log.WithName("agent")
+
+	if *nodeName == "" {
+		setupLog.Error(fmt.Errorf("node name not set"), "unable to start agent")
+		os.Exit(1)
+	}
+
+	k8s, err := client.New(ctrl.GetConfigOrDie(), client.Options{})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+
+	agent := &Agent{
+		k8s:            k8s,
+		nodeName:       *nodeName,
+		resyncInterval: time.Duration(*resyncInterval) * time.Second,
+	}
+
+	setupLog.Info("starting agent", "node", *nodeName)
+	if err := agent.Run(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running agent")
+		os.Exit(1)
+	}
+}
+
+// sync node network on start, on host network change and periodically
+func (a *Agent) Run(ctx context.Context) error {
+	changed := make(chan struct{}, 1)
+	go func() {
+		if err := watchNetwork(ctx, changed); err != nil {
+			log.Log.Error(err, "watch host network failed, fallback to periodic resync", "node", a.nodeName)
+		}
+	}()
+
+	resync := time.NewTicker(a.resyncInterval)
+	defer resync.Stop()
+
+	for {
+		if err := a.sync(ctx); err != nil {
+			log.Log.Error(err, "sync node network failed", "node", a.nodeName)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-resync.C:
+		case <-changed:
+			// netlink reports a burst of messages for one change, wait for it to settle
+			time.Sleep(changeDebounce)
+			select {
+			case <-changed:
+			default:
+			}
+		}
+	}
+}
+
+// patch node network annotation if changed
+func (a *Agent) sync(ctx context.Context) error {
+	nodeNetworks, err := GetNodeNetworks()
+	if err != nil {
+		return fmt.Errorf("get host network failed: %s", err.Error())
+	}
+	data, _ := json.Marshal(nodeNetworks)
+
+	node := &v1.Node{}
+	if err := a.k8s.Get(ctx, types.NamespacedName{Name: a.nodeName}, node); err != nil {
+		return err
+	}
+	if node.Annotations[handlers.NodeNetworkKey] == string(data) {
+		return nil
+	}
+
+	patch := client.MergeFrom(node.DeepCopy())
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[handlers.NodeNetworkKey] = string(data)
+	if err := a.k8s.Patch(ctx, node, patch); err != nil {
+		return err
+	}
+	log.Log.Info("node network updated", "node", a.nodeName, "network", string(data))
+	return nil
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// replace the host interfaces by fixed ones, addresses by interface name
+func fakeInterfaces(t *testing.T, ifaces []net.Interface, addrs map[string][]string) {
+	listInterfaces = func() ([]net.Interface, error) { return ifaces, nil }
+	interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) {
+		result := []net.Addr{}
+		for _, cidr := range addrs[iface.Name] {
+			ip, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: network.Mask})
+		}
+		return result, nil
+	}
+	t.Cleanup(func() {
+		listInterfaces = net.Interfaces
+		interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) { return iface.Addrs() }
+	})
+}
+
+func TestGetNodeNetworks(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifaces  []net.Interface
+		addrs   map[string][]string
+		want    []handlers.NodeNetwork
+		wantErr bool
+	}{
+		{
+			name:   "loopback and down interfaces skipped",
+			ifaces: []net.Interface{{Name: "lo", Flags: net.FlagUp | net.FlagLoopback}, {Name: "eth1"}},
+			addrs:  map[string][]string{"lo": {"127.0.0.1/8"}, "eth1": {"10.0.1.5/24"}},
+			want:   []handlers.NodeNetwork{},
+		},
+		{
+			name:   "ipv6 and link local addresses skipped",
+			ifaces: []net.Interface{{Name: "eth0", Flags: net.FlagUp}},
+			addrs:  map[string][]string{"eth0": {"fe80::1/64", "2001:db8::5/64", "169.254.3.4/16", "192.168.1.10/24"}},
+			want:   []handlers.NodeNetwork{{Network: "192.168.1.0/24", Device: "eth0", IPAddress: "192.168.1.10"}},
+		},
+		{
+			name:   "sorted by network and address",
+			ifaces: []net.Interface{{Name: "eth0", Flags: net.FlagUp}, {Name: "eth1", Flags: net.FlagUp}},
+			addrs:  map[string][]string{"eth0": {"192.168.1.10/24", "10.0.0.9/16"}, "eth1": {"10.0.0.5/16"}},
+			want: []handlers.NodeNetwork{
+				{Network: "10.0.0.0/16", Device: "eth1", IPAddress: "10.0.0.5"},
+				{Network: "10.0.0.0/16", Device: "eth0", IPAddress: "10.0.0.9"},
+				{Network: "192.168.1.0/24", Device: "eth0", IPAddress: "192.168.1.10"},
+			},
+		},
+		{
+			name:    "address lookup failed",
+			ifaces:  []net.Interface{{Name: "eth0", Flags: net.FlagUp}},
+			addrs:   map[string][]string{"eth0": {"invalid"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeInterfaces(t, tt.ifaces, tt.addrs)
+			got, err := GetNodeNetworks()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetNodeNetworks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetNodeNetworks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	scheme := runtime.NewScheme()
+	clientgoscheme.AddToScheme(scheme)
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: map[string]string{"other": "kept"}}}
+	k8s := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	a := &Agent{k8s: k8s, nodeName: "node-1"}
+	fakeInterfaces(t, []net.Interface{{Name: "eth0", Flags: net.FlagUp}}, map[string][]string{"eth0": {"192.168.1.10/24"}})
+	ctx := context.TODO()
+
+	if err := a.sync(ctx); err != nil {
+		t.Fatalf("sync failed: %s", err.Error())
+	}
+	synced := &v1.Node{}
+	if err := k8s.Get(ctx, types.NamespacedName{Name: "node-1"}, synced); err != nil {
+		t.Fatalf("get node failed: %s", err.Error())
+	}
+	want := `[{"network":"192.168.1.0/24","device":"eth0","ip_address":"192.168.1.10"}]`
+	if synced.Annotations[handlers.NodeNetworkKey] != want || synced.Annotations["other"] != "kept" {
+		t.Errorf("unexpected annotations: %v", synced.Annotations)
+	}
+
+	// unchanged network is not patched again
+	if err := a.sync(ctx); err != nil {
+		t.Fatalf("second sync failed: %s", err.Error())
+	}
+	latest := &v1.Node{}
+	k8s.Get(ctx, types.NamespacedName{Name: "node-1"}, latest)
+	if latest.ResourceVersion != synced.ResourceVersion {
+		t.Errorf("node patched without network change: %s -> %s", synced.ResourceVersion, latest.ResourceVersion)
+	}
+
+	// missing node is reported
+	a.nodeName = "node-2"
+	if err := a.sync(ctx); err == nil {
+		t.Errorf("sync of missing node succeeded")
+	}
+}
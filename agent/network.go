@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"net"
+	"sort"
+
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// host interfaces and their addresses, replaced by tests
+var (
+	listInterfaces = net.Interfaces
+	interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) { return iface.Addrs() }
+)
+
+// get host ipv4 networks, same content as the probe pod reports (connected routes with source address)
+func GetNodeNetworks() ([]handlers.NodeNetwork, error) {
+	ifaces, err := listInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	nodeNetworks := []handlers.NodeNetwork{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := interfaceAddrs(iface)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			network := net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask}
+			nodeNetworks = append(nodeNetworks, handlers.NodeNetwork{
+				Network:   network.String(),
+				Device:    iface.Name,
+				IPAddress: ipnet.IP.String(),
+			})
+		}
+	}
+
+	// stable order, avoid annotation churn
+	sort.Slice(nodeNetworks, func(i, j int) bool {
+		if nodeNetworks[i].Network != nodeNetworks[j].Network {
+			return nodeNetworks[i].Network < nodeNetworks[j].Network
+		}
+		return nodeNetworks[i].IPAddress < nodeNetworks[j].IPAddress
+	})
+	return nodeNetworks, nil
+}
@@ -0,0 +1,66 @@
+//go:build linux
+// +build linux
+
+package agent
+
+import (
+	"context"
+	"syscall"
+)
+
+// netlink multicast groups (linux/rtnetlink.h)
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+)
+
+// subscribe netlink link/address/route changes, notify changed channel without blocking
+func watchNetwork(ctx context.Context, changed chan<- struct{}) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv4Route,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		return err
+	}
+
+	// wake up periodically to check context
+	timeout := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 65536)
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+				continue
+			}
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case syscall.RTM_NEWLINK, syscall.RTM_DELLINK,
+				syscall.RTM_NEWADDR, syscall.RTM_DELADDR,
+				syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"context"
+	"fmt"
+)
+
+// netlink is linux only, rely on periodic resync
+func watchNetwork(ctx context.Context, changed chan<- struct{}) error {
+	return fmt.Errorf("watch host network not supported on this platform")
+}
@@ -0,0 +1,210 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultNamespace         = "kube-system"
+	DefaultClusterName       = "default"
+	DefaultIPPoolName        = "default"
+	DefaultProbeImage        = "busybox"
+	DefaultProbeTimeout      = 60 * time.Second
+	DefaultRefreshInterval   = 30 * time.Second
+	DefaultAPITimeout        = 10 * time.Second
+	DefaultDrainGracePeriod  = 60 * time.Second
+	DefaultCertExpiryWarning = 30 * 24 * time.Hour
+	DefaultConcurrency       = 4
+)
+
+// read config file, unknown fields are rejected
+func Load(path string) (*FlexLBControllerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &FlexLBControllerConfig{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("parse config file '%s' failed: %w", path, err)
+	}
+	if c.APIVersion != GroupVersion || c.Kind != Kind {
+		return nil, fmt.Errorf("config file '%s' is '%s/%s', expected '%s/%s'", path, c.APIVersion, c.Kind, GroupVersion, Kind)
+	}
+	return c, nil
+}
+
+// set defaults of fields not set
+func (c *FlexLBControllerConfig) Default() {
+	c.APIVersion, c.Kind = GroupVersion, Kind
+	f := &c.FlexLB
+	defaultString(&f.Namespace, DefaultNamespace)
+	defaultString(&f.DefaultCluster, DefaultClusterName)
+	defaultString(&f.DefaultIPPool, DefaultIPPoolName)
+	defaultString(&f.Probe.Image, DefaultProbeImage)
+	if f.TLS.Insecure == nil {
+		insecure := true
+		f.TLS.Insecure = &insecure
+	}
+	if f.Features.Ingress == nil {
+		ingress := true
+		f.Features.Ingress = &ingress
+	}
+	defaultDuration(&f.Probe.Timeout, DefaultProbeTimeout)
+	defaultDuration(&f.RefreshInterval, DefaultRefreshInterval)
+	defaultDuration(&f.APITimeout, DefaultAPITimeout)
+	defaultDuration(&f.DrainGracePeriod, DefaultDrainGracePeriod)
+	defaultDuration(&f.CertExpiryWarning, DefaultCertExpiryWarning)
+	for _, n := range []*int{&f.Concurrency.Cluster, &f.Concurrency.Instance, &f.Concurrency.Node,
+		&f.Concurrency.Service, &f.Concurrency.Gateway, &f.Concurrency.Ingress} {
+		if *n == 0 {
+			*n = DefaultConcurrency
+		}
+	}
+}
+
+func defaultString(value *string, defaultValue string) {
+	if *value == "" {
+		*value = defaultValue
+	}
+}
+
+func defaultDuration(value *metav1.Duration, defaultValue time.Duration) {
+	if value.Duration == 0 {
+		value.Duration = defaultValue
+	}
+}
+
+// validate defaulted config
+func (c *FlexLBControllerConfig) Validate() error {
+	errs := field.ErrorList{}
+	path := field.NewPath("flexlb")
+	f := &c.FlexLB
+
+	for _, msg := range validation.IsDNS1123Label(f.Namespace) {
+		errs = append(errs, field.Invalid(path.Child("namespace"), f.Namespace, msg))
+	}
+	for i, ns := range f.ClusterNamespaces {
+		if ns == "*" {
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(path.Child("clusterNamespaces").Index(i), ns, msg))
+		}
+	}
+	// default cluster is "<name>" or "<namespace>/<name>"
+	clusterName := f.DefaultCluster
+	if i := strings.Index(f.DefaultCluster, "/"); i >= 0 {
+		clusterNamespace := f.DefaultCluster[:i]
+		clusterName = f.DefaultCluster[i+1:]
+		for _, msg := range validation.IsDNS1123Label(clusterNamespace) {
+			errs = append(errs, field.Invalid(path.Child("defaultCluster"), f.DefaultCluster, msg))
+		}
+		if !watched(f, clusterNamespace) {
+			errs = append(errs, field.Invalid(path.Child("defaultCluster"), f.DefaultCluster, "namespace must be the flexlb namespace or in clusterNamespaces"))
+		}
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(clusterName) {
+		errs = append(errs, field.Invalid(path.Child("defaultCluster"), f.DefaultCluster, msg))
+	}
+	if strings.TrimSpace(f.DefaultIPPool) == "" {
+		errs = append(errs, field.Required(path.Child("defaultIPPool"), ""))
+	}
+	if f.LoadBalancerClass != "" {
+		// classes other than the kubernetes default must be prefixed, e.g. flexlb.flexlet.io/flexlb
+		for _, msg := range validation.IsQualifiedName(f.LoadBalancerClass) {
+			errs = append(errs, field.Invalid(path.Child("loadBalancerClass"), f.LoadBalancerClass, msg))
+		}
+		if !strings.Contains(f.LoadBalancerClass, "/") {
+			errs = append(errs, field.Invalid(path.Child("loadBalancerClass"), f.LoadBalancerClass, "must be prefixed, e.g. flexlb.flexlet.io/flexlb"))
+		}
+	}
+
+	// tls files are read on each connect, they must exist
+	tlsPath := path.Child("tls")
+	for name, file := range map[string]string{"caCert": f.TLS.CACert, "clientCert": f.TLS.ClientCert, "clientKey": f.TLS.ClientKey} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, field.Invalid(tlsPath.Child(name), file, err.Error()))
+		}
+	}
+	if (f.TLS.ClientCert == "") != (f.TLS.ClientKey == "") {
+		errs = append(errs, field.Invalid(tlsPath, "", "clientCert and clientKey must be set together"))
+	}
+
+	durations := map[string]metav1.Duration{
+		"probe.timeout": f.Probe.Timeout, "refreshInterval": f.RefreshInterval, "apiTimeout": f.APITimeout,
+		"drainGracePeriod": f.DrainGracePeriod, "certExpiryWarning": f.CertExpiryWarning,
+	}
+	for name, d := range durations {
+		if d.Duration <= 0 {
+			errs = append(errs, field.Invalid(path.Child(name), d.Duration.String(), "must be positive"))
+		}
+	}
+	if f.RefreshInterval.Duration > 0 && f.RefreshInterval.Duration < time.Second {
+		errs = append(errs, field.Invalid(path.Child("refreshInterval"), f.RefreshInterval.Duration.String(), "must be at least 1s"))
+	}
+
+	concurrency := map[string]int{
+		"cluster": f.Concurrency.Cluster, "instance": f.Concurrency.Instance, "node": f.Concurrency.Node,
+		"service": f.Concurrency.Service, "gateway": f.Concurrency.Gateway, "ingress": f.Concurrency.Ingress,
+	}
+	for name, n := range concurrency {
+		if n < 1 {
+			errs = append(errs, field.Invalid(path.Child("concurrency", name), n, "must be at least 1"))
+		}
+	}
+
+	return errs.ToAggregate()
+}
+
+// namespace of clusters is watched
+func watched(f *FlexLBConfig, namespace string) bool {
+	if namespace == f.Namespace {
+		return true
+	}
+	for _, ns := range f.ClusterNamespaces {
+		if ns == namespace || ns == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// fields applied without restart
+func (c *FlexLBControllerConfig) liveFields() []interface{} {
+	f := &c.FlexLB
+	return []interface{}{&f.Probe.Image, &f.Probe.Timeout, &f.RefreshInterval, &f.APITimeout, &f.DrainGracePeriod, &f.CertExpiryWarning}
+}
+
+// changed fields of config which require a restart, live fields ignored
+func (c *FlexLBControllerConfig) RestartRequired(old *FlexLBControllerConfig) []string {
+	oldCopy, newCopy := old.DeepCopy(), c.DeepCopy()
+	oldLive, newLive := oldCopy.liveFields(), newCopy.liveFields()
+	for i := range newLive {
+		reflect.ValueOf(newLive[i]).Elem().Set(reflect.ValueOf(oldLive[i]).Elem())
+	}
+
+	changed := []string{}
+	if !reflect.DeepEqual(oldCopy.ControllerManagerConfigurationSpec, newCopy.ControllerManagerConfigurationSpec) {
+		changed = append(changed, "manager")
+	}
+	oldValue, newValue := reflect.ValueOf(oldCopy.FlexLB), reflect.ValueOf(newCopy.FlexLB)
+	for i := 0; i < oldValue.NumField(); i++ {
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			name := strings.Split(oldValue.Type().Field(i).Tag.Get("json"), ",")[0]
+			changed = append(changed, "flexlb."+name)
+		}
+	}
+	return changed
+}
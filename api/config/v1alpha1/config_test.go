@@ -0,0 +1,192 @@
+package v1alpha1
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			data: "apiVersion: config.flexlb.flexlet.io/v1alpha1\nkind: FlexLBControllerConfig\nflexlb:\n  namespace: flexlb-system\n  apiTimeout: 5s\n",
+		},
+		{name: "unknown field", data: "apiVersion: config.flexlb.flexlet.io/v1alpha1\nkind: FlexLBControllerConfig\nflexlb:\n  timeout: 5s\n", wantErr: "parse config file"},
+		{name: "wrong kind", data: "apiVersion: v1\nkind: ConfigMap\n", wantErr: "expected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatalf("write config file failed: %s", err)
+			}
+			c, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if c.FlexLB.Namespace != "flexlb-system" || c.FlexLB.APITimeout.Duration != 5*time.Second {
+				t.Errorf("Load() flexlb = %+v, want namespace and api timeout of the file", c.FlexLB)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+			t.Errorf("Load() of a missing file error = nil")
+		}
+	})
+}
+
+func TestDefault(t *testing.T) {
+	c := &FlexLBControllerConfig{}
+	c.FlexLB.APITimeout = metav1.Duration{Duration: 5 * time.Second}
+	c.Default()
+	f := c.FlexLB
+	if c.Kind != Kind || f.Namespace != DefaultNamespace || f.Probe.Image != DefaultProbeImage || f.TLS.Insecure == nil || !*f.TLS.Insecure {
+		t.Errorf("Default() = %+v, want defaults", c)
+	}
+	if f.APITimeout.Duration != 5*time.Second || f.RefreshInterval.Duration != DefaultRefreshInterval || f.Concurrency.Ingress != DefaultConcurrency {
+		t.Errorf("Default() durations and concurrency = %+v, want set values kept, others defaulted", f)
+	}
+	if f.Features.Ingress == nil || !*f.Features.Ingress {
+		t.Errorf("Default() features = %+v, want the ingress controller enabled", f.Features)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() of defaults error = %v", err)
+	}
+
+	// a feature turned off is kept
+	disabled := false
+	c.FlexLB.Features.Ingress = &disabled
+	c.Default()
+	if *c.FlexLB.Features.Ingress {
+		t.Errorf("Default() enabled the ingress controller turned off")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tlsFile := filepath.Join(t.TempDir(), "tls.crt")
+	if err := os.WriteFile(tlsFile, []byte{}, 0600); err != nil {
+		t.Fatalf("write tls file failed: %s", err)
+	}
+	tests := []struct {
+		name    string
+		modify  func(f *FlexLBConfig)
+		wantErr string
+	}{
+		{name: "defaults", modify: func(f *FlexLBConfig) {}},
+		{name: "invalid namespace", modify: func(f *FlexLBConfig) { f.Namespace = "Flexlb" }, wantErr: "flexlb.namespace"},
+		{name: "all cluster namespaces", modify: func(f *FlexLBConfig) { f.ClusterNamespaces = []string{"*", "tenant-a"} }},
+		{name: "invalid cluster namespace", modify: func(f *FlexLBConfig) { f.ClusterNamespaces = []string{"tenant_a"} }, wantErr: "flexlb.clusterNamespaces[0]"},
+		{
+			name: "default cluster of a tenant namespace",
+			modify: func(f *FlexLBConfig) {
+				f.ClusterNamespaces = []string{"tenant-a"}
+				f.DefaultCluster = "tenant-a/edge"
+			},
+		},
+		{name: "default cluster of a namespace not watched", modify: func(f *FlexLBConfig) { f.DefaultCluster = "tenant-a/edge" }, wantErr: "must be the flexlb namespace"},
+		{name: "empty default ippool", modify: func(f *FlexLBConfig) { f.DefaultIPPool = " " }, wantErr: "flexlb.defaultIPPool"},
+		{name: "prefixed load balancer class", modify: func(f *FlexLBConfig) { f.LoadBalancerClass = "flexlb.flexlet.io/flexlb" }},
+		{name: "load balancer class not prefixed", modify: func(f *FlexLBConfig) { f.LoadBalancerClass = "flexlb" }, wantErr: "must be prefixed"},
+		{
+			name: "tls files",
+			modify: func(f *FlexLBConfig) {
+				f.TLS.CACert, f.TLS.ClientCert, f.TLS.ClientKey = tlsFile, tlsFile, tlsFile
+			},
+		},
+		{name: "tls file missing", modify: func(f *FlexLBConfig) { f.TLS.CACert = tlsFile + ".missing" }, wantErr: "flexlb.tls.caCert"},
+		{name: "client cert without key", modify: func(f *FlexLBConfig) { f.TLS.ClientCert = tlsFile }, wantErr: "must be set together"},
+		{name: "negative timeout", modify: func(f *FlexLBConfig) { f.APITimeout.Duration = -time.Second }, wantErr: "flexlb.apiTimeout"},
+		{name: "refresh interval under a second", modify: func(f *FlexLBConfig) { f.RefreshInterval.Duration = time.Millisecond }, wantErr: "must be at least 1s"},
+		{name: "no concurrency", modify: func(f *FlexLBConfig) { f.Concurrency.Node = -1 }, wantErr: "flexlb.concurrency.node"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &FlexLBControllerConfig{}
+			c.Default()
+			tt.modify(&c.FlexLB)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *FlexLBControllerConfig)
+		want   []string
+	}{
+		{name: "unchanged", modify: func(c *FlexLBControllerConfig) {}, want: []string{}},
+		{
+			name: "live fields",
+			modify: func(c *FlexLBControllerConfig) {
+				c.FlexLB.Probe.Image = "alpine"
+				c.FlexLB.APITimeout.Duration = time.Minute
+				c.FlexLB.CertExpiryWarning.Duration = time.Hour
+			},
+			want: []string{},
+		},
+		{
+			name: "probe image and a restart field",
+			modify: func(c *FlexLBControllerConfig) {
+				c.FlexLB.Probe.Image = "alpine"
+				c.FlexLB.Features.DryRun = true
+			},
+			want: []string{"flexlb.features"},
+		},
+		{
+			name: "ingress controller turned off",
+			modify: func(c *FlexLBControllerConfig) {
+				disabled := false
+				c.FlexLB.Features.Ingress = &disabled
+			},
+			want: []string{"flexlb.features"},
+		},
+		{
+			name: "manager and flexlb fields",
+			modify: func(c *FlexLBControllerConfig) {
+				c.SyncPeriod = &metav1.Duration{Duration: time.Hour}
+				c.FlexLB.Namespace = "flexlb-system"
+				c.FlexLB.Concurrency.Service = 8
+			},
+			want: []string{"manager", "flexlb.namespace", "flexlb.concurrency"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := &FlexLBControllerConfig{}
+			old.Default()
+			c := old.DeepCopy()
+			tt.modify(c)
+			if got := c.RestartRequired(old); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RestartRequired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Package v1alpha1 contains the config file API of the controller, config.flexlb.flexlet.io/v1alpha1
+//+kubebuilder:object:generate=true
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+const (
+	GroupVersion = "config.flexlb.flexlet.io/v1alpha1"
+	Kind         = "FlexLBControllerConfig"
+)
+
+//+kubebuilder:object:root=true
+
+// FlexLBControllerConfig is the config file of the controller, the controller-runtime manager fields
+// (health, metrics, webhook, leaderElection, ...) are inlined
+type FlexLBControllerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	FlexLB FlexLBConfig `json:"flexlb,omitempty"`
+}
+
+// Complete returns the controller-runtime manager config
+func (c *FlexLBControllerConfig) Complete() (cfg.ControllerManagerConfigurationSpec, error) {
+	return c.ControllerManagerConfigurationSpec, nil
+}
+
+type FlexLBConfig struct {
+	// namespace of flexlb clusters and probe pods, default: kube-system
+	Namespace string `json:"namespace,omitempty"`
+
+	// namespaces of tenant clusters besides the flexlb namespace, "*" for all namespaces
+	ClusterNamespaces []string `json:"clusterNamespaces,omitempty"`
+
+	// flexlb api client tls
+	TLS TLSConfig `json:"tls,omitempty"`
+
+	// node network probe pods
+	Probe ProbeConfig `json:"probe,omitempty"`
+
+	// default instance refresh interval, overridden by cluster spec.refresh, default: 30s
+	RefreshInterval metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// flexlb api call timeout, default: 10s
+	APITimeout metav1.Duration `json:"apiTimeout,omitempty"`
+
+	// backends of cordoned or deleted nodes are drained for the grace period before removal, default: 60s
+	DrainGracePeriod metav1.Duration `json:"drainGracePeriod,omitempty"`
+
+	// warn when a frontend tls certificate expires within the duration, default: 720h
+	CertExpiryWarning metav1.Duration `json:"certExpiryWarning,omitempty"`
+
+	// services of the load balancer class are handled, services without class if not set
+	LoadBalancerClass string `json:"loadBalancerClass,omitempty"`
+
+	// cluster and ippool of services without cluster or ippool annotation, default: default
+	// the cluster is "<name>" in the flexlb namespace or "<namespace>/<name>"
+	DefaultCluster string `json:"defaultCluster,omitempty"`
+	DefaultIPPool  string `json:"defaultIPPool,omitempty"`
+
+	// max concurrent reconciles of the controllers
+	Concurrency ConcurrencyConfig `json:"concurrency,omitempty"`
+
+	Features FeatureGates `json:"features,omitempty"`
+}
+
+type TLSConfig struct {
+	CACert     string `json:"caCert,omitempty"`
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	// ignore insecure server certificate, default: true
+	Insecure *bool `json:"insecure,omitempty"`
+}
+
+type ProbeConfig struct {
+	// probe pod image, default: busybox
+	Image string `json:"image,omitempty"`
+	// probe pod timeout, default: 60s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// max concurrent reconciles, default: 4
+type ConcurrencyConfig struct {
+	Cluster  int `json:"cluster,omitempty"`
+	Instance int `json:"instance,omitempty"`
+	Node     int `json:"node,omitempty"`
+	Service  int `json:"service,omitempty"`
+	Gateway  int `json:"gateway,omitempty"`
+	Ingress  int `json:"ingress,omitempty"`
+}
+
+type FeatureGates struct {
+	// gateway api controllers, requires gateway api CRDs installed
+	GatewayAPI bool `json:"gatewayAPI,omitempty"`
+	// node network reported by the agent daemonset, probe pods disabled
+	NodeNetworkAgent bool `json:"nodeNetworkAgent,omitempty"`
+	// plan only, nothing is written to flexlb or kubernetes
+	DryRun bool `json:"dryRun,omitempty"`
+	// service validating webhook of cluster and ippool access and quotas, requires webhook serving certificates
+	AdmissionWebhook bool `json:"admissionWebhook,omitempty"`
+	// ingress class controller, default: true
+	Ingress *bool `json:"ingress,omitempty"`
+}
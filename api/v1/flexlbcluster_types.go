@@ -13,12 +13,88 @@ type FlexLBIPPool struct {
 	Start          string `json:"start,omitempty"`
 	End            string `json:"end,omitempty"`
 	BackendNetwork string `json:"backend_network,omitempty"`
+
+	// backend node traffic ip sources, tried in order, default: [network]
+	BackendIPSources []string `json:"backend_ip_sources,omitempty"`
+
+	// only nodes matching the selector receive load balancer traffic, default: all nodes
+	NodeSelector *metav1.LabelSelector `json:"node_selector,omitempty"`
+
+	// namespaces allowed to allocate ips of the pool: listed, or matching the selector, default: all namespaces
+	AllowedNamespaces []string              `json:"allowed_namespaces,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespace_selector,omitempty"`
+
+	// max ips allocated per namespace, 0: unlimited
+	//+kubebuilder:validation:Minimum=0
+	NamespaceQuota int32 `json:"namespace_quota,omitempty"`
+
+	// max ips allocated of the namespaces, overrides namespace_quota, 0: unlimited
+	NamespaceQuotas map[string]int32 `json:"namespace_quotas,omitempty"`
+}
+
+// backend node traffic ip sources
+const (
+	// probed node network annotation, matches backend network
+	BackendIPSourceNetwork = "network"
+	// node status addresses (InternalIP, ExternalIP) in backend network
+	BackendIPSourceNodeAddress = "node_address"
+	// user set node annotation flexlb.flexlet.io/trafficIP
+	BackendIPSourceAnnotation = "annotation"
+	// probed node network of the device named by node label flexlb.flexlet.io/trafficInterface
+	BackendIPSourceInterface = "interface"
+)
+
+// Connection and rate limits of endpoints, 0: unlimited
+type FlexLBLimits struct {
+	// max concurrent connections per frontend
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=1000000
+	MaxConn int32 `json:"max_conn,omitempty"`
+
+	// max new connections per client source in rate period
+	//+kubebuilder:validation:Minimum=0
+	ConnRate int32 `json:"conn_rate,omitempty"`
+
+	// max http requests per client source in rate period, http ports only
+	//+kubebuilder:validation:Minimum=0
+	RequestRate int32 `json:"request_rate,omitempty"`
+
+	// rate period in seconds, default: 10
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=3600
+	RatePeriod int32 `json:"rate_period,omitempty"`
 }
 
 // FlexLBClusterSpec defines the desired state of FlexLBCluster
 type FlexLBClusterSpec struct {
 	IPPools  []FlexLBIPPool `json:"ippools,omitempty"`
 	Endpoint string         `json:"endpoint,omitempty"`
+
+	// default limits of service endpoints, overridden by service annotations
+	Limits *FlexLBLimits `json:"limits,omitempty"`
+
+	// refresh of instances from flexlb, default: --refresh-interval of controller
+	Refresh *FlexLBRefresh `json:"refresh,omitempty"`
+}
+
+// FlexLBRefresh are the refresh intervals of the instances of a cluster, in seconds
+type FlexLBRefresh struct {
+	// refresh interval of ready instances, overridden by instance annotation
+	//+kubebuilder:validation:Minimum=0
+	Interval int32 `json:"interval,omitempty"`
+
+	// refresh interval of instances not ready, default: interval / 4
+	//+kubebuilder:validation:Minimum=0
+	NotReadyInterval int32 `json:"not_ready_interval,omitempty"`
+
+	// refresh interval of instances ready for 3 refreshes, default: 2 * interval
+	//+kubebuilder:validation:Minimum=0
+	StableInterval int32 `json:"stable_interval,omitempty"`
+
+	// random spread of intervals in percent, default: 10
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=50
+	JitterPercent *int32 `json:"jitter_percent,omitempty"`
 }
 
 // FlexLBClusterStatus defines the observed state of FlexLBCluster
@@ -28,6 +104,9 @@ type FlexLBClusterStatus struct {
 
 	// FlexLBNode ready status, example: {node1: ready, node2: ready}
 	NodeStatus models.ReadyStatus `json:"node_status,omitempty"`
+
+	// last reconcile error, cleared when ready
+	LastError *ErrorStatus `json:"last_error,omitempty"`
 }
 
 const (
@@ -22,8 +22,58 @@ type FlexLBInstanceSpec struct {
 type FlexLBInstanceStatus struct {
 	Phase      string            `json:"phase"`
 	NodeStatus map[string]string `json:"node_status"`
+	// backend nodes being drained before removal
+	Draining []DrainingNode `json:"draining,omitempty"`
+	// tls certificates of frontend ports
+	Certificates []CertificateStatus `json:"certificates,omitempty"`
+	// client source ranges accepted by frontend ports
+	SourceRanges []EndpointSourceRanges `json:"source_ranges,omitempty"`
+	// last reconcile error, cleared on success
+	LastError *ErrorStatus `json:"last_error,omitempty"`
+	// summary of the node status, flexlb reports the instance status per node only, not per backend
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// ErrorStatus is the last reconcile error and its class, which decides the retry policy
+type ErrorStatus struct {
+	Class   string      `json:"class"`
+	Reason  string      `json:"reason,omitempty"`
+	Message string      `json:"message"`
+	Time    metav1.Time `json:"time"`
+	// consecutive failures of the same class and reason
+	Retries int32 `json:"retries"`
+}
+
+// CertificateStatus is the tls certificate terminated on a frontend port
+type CertificateStatus struct {
+	Port     uint16      `json:"port"`
+	Secret   string      `json:"secret"`
+	NotAfter metav1.Time `json:"not_after"`
+}
+
+// EndpointSourceRanges are the client source ranges accepted by a frontend port, all clients if none allowed
+type EndpointSourceRanges struct {
+	Port    uint16   `json:"port"`
+	Mode    string   `json:"mode"`
+	Allowed []string `json:"allowed,omitempty"`
+	Denied  []string `json:"denied,omitempty"`
+}
+
+// DrainingNode is a backend node being drained, its backends are removed after the grace period
+type DrainingNode struct {
+	Node      string      `json:"node"`
+	Ipaddress string      `json:"ipaddress"`
+	Mode      string      `json:"mode"`
+	Since     metav1.Time `json:"since"`
+	Backends  []string    `json:"backends,omitempty"`
+}
+
+// drain modes of backend servers
+const (
+	DrainModeDrain = "drain" // weight 0, established connections kept
+	DrainModeMaint = "maint" // maintenance, server disabled
+)
+
 const (
 	InstancePhaseClusterNotReady = "cluster_not_ready"
 	InstancePhaseCreated         = "created"
@@ -40,6 +90,24 @@ const (
 	InstanceStatusPending = "pending"
 )
 
+// instance conditions, also set on the services of instance prefixed by the api group
+const (
+	InstanceConditionNodesRunning = "NodesRunning"
+
+	InstanceReasonNodesRunning    = "NodesRunning"
+	InstanceReasonNodesNotRunning = "NodesNotRunning"
+	InstanceReasonNoNodeStatus    = "NoNodeStatus"
+)
+
+// reconcile error classes
+const (
+	ErrorClassTransient     = "transient"      // network errors, timeouts, server errors: retried with backoff
+	ErrorClassAuth          = "auth"           // tls or credential errors: retried slowly
+	ErrorClassConflict      = "conflict"       // concurrent updates: retried with backoff
+	ErrorClassInvalidConfig = "invalid_config" // retried on change
+	ErrorClassNotFound      = "not_found"      // referenced object missing: retried on change
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 
@@ -7,9 +7,88 @@ package v1
 
 import (
 	"github.com/flexlet/flexlb-client-go/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateStatus) DeepCopyInto(out *CertificateStatus) {
+	*out = *in
+	in.NotAfter.DeepCopyInto(&out.NotAfter)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateStatus.
+func (in *CertificateStatus) DeepCopy() *CertificateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DrainingNode) DeepCopyInto(out *DrainingNode) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+	if in.Backends != nil {
+		in, out := &in.Backends, &out.Backends
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrainingNode.
+func (in *DrainingNode) DeepCopy() *DrainingNode {
+	if in == nil {
+		return nil
+	}
+	out := new(DrainingNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EndpointSourceRanges) DeepCopyInto(out *EndpointSourceRanges) {
+	*out = *in
+	if in.Allowed != nil {
+		in, out := &in.Allowed, &out.Allowed
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Denied != nil {
+		in, out := &in.Denied, &out.Denied
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EndpointSourceRanges.
+func (in *EndpointSourceRanges) DeepCopy() *EndpointSourceRanges {
+	if in == nil {
+		return nil
+	}
+	out := new(EndpointSourceRanges)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ErrorStatus) DeepCopyInto(out *ErrorStatus) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ErrorStatus.
+func (in *ErrorStatus) DeepCopy() *ErrorStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ErrorStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FlexLBCluster) DeepCopyInto(out *FlexLBCluster) {
 	*out = *in
@@ -75,7 +154,19 @@ func (in *FlexLBClusterSpec) DeepCopyInto(out *FlexLBClusterSpec) {
 	if in.IPPools != nil {
 		in, out := &in.IPPools, &out.IPPools
 		*out = make([]FlexLBIPPool, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(FlexLBLimits)
+		**out = **in
+	}
+	if in.Refresh != nil {
+		in, out := &in.Refresh, &out.Refresh
+		*out = new(FlexLBRefresh)
+		(*in).DeepCopyInto(*out)
 	}
 }
 
@@ -99,6 +190,11 @@ func (in *FlexLBClusterStatus) DeepCopyInto(out *FlexLBClusterStatus) {
 			(*out)[key] = val
 		}
 	}
+	if in.LastError != nil {
+		in, out := &in.LastError, &out.LastError
+		*out = new(ErrorStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlexLBClusterStatus.
@@ -114,6 +210,33 @@ func (in *FlexLBClusterStatus) DeepCopy() *FlexLBClusterStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FlexLBIPPool) DeepCopyInto(out *FlexLBIPPool) {
 	*out = *in
+	if in.BackendIPSources != nil {
+		in, out := &in.BackendIPSources, &out.BackendIPSources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NamespaceQuotas != nil {
+		in, out := &in.NamespaceQuotas, &out.NamespaceQuotas
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlexLBIPPool.
@@ -126,6 +249,41 @@ func (in *FlexLBIPPool) DeepCopy() *FlexLBIPPool {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FlexLBLimits) DeepCopyInto(out *FlexLBLimits) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlexLBLimits.
+func (in *FlexLBLimits) DeepCopy() *FlexLBLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(FlexLBLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FlexLBRefresh) DeepCopyInto(out *FlexLBRefresh) {
+	*out = *in
+	if in.JitterPercent != nil {
+		in, out := &in.JitterPercent, &out.JitterPercent
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlexLBRefresh.
+func (in *FlexLBRefresh) DeepCopy() *FlexLBRefresh {
+	if in == nil {
+		return nil
+	}
+	out := new(FlexLBRefresh)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FlexLBInstance) DeepCopyInto(out *FlexLBInstance) {
 	*out = *in
@@ -195,6 +353,39 @@ func (in *FlexLBInstanceStatus) DeepCopyInto(out *FlexLBInstanceStatus) {
 			(*out)[key] = val
 		}
 	}
+	if in.Draining != nil {
+		in, out := &in.Draining, &out.Draining
+		*out = make([]DrainingNode, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Certificates != nil {
+		in, out := &in.Certificates, &out.Certificates
+		*out = make([]CertificateStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SourceRanges != nil {
+		in, out := &in.SourceRanges, &out.SourceRanges
+		*out = make([]EndpointSourceRanges, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastError != nil {
+		in, out := &in.LastError, &out.LastError
+		*out = new(ErrorStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlexLBInstanceStatus.
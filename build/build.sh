@@ -16,7 +16,7 @@ PKG_DIR="${TARGET_DIR}/${PROD}-${VERSION}"
 
 # build binary
 cd ${BASE_DIR}
-CGO_ENABLED=0 GOOS=linux GOARCH=${ARCH} go build -o ${PKG_DIR}/${PROD} main.go
+CGO_ENABLED=0 GOOS=linux GOARCH=${ARCH} go build -o ${PKG_DIR}/${PROD} .
 
 # build container image
 cd ${PKG_DIR}
@@ -24,6 +24,10 @@ docker build -t ${IMAGE_REPO}/${PROD}:${VERSION} -f ${BUILD_DIR}/Dockerfile
 docker push ${IMAGE_REPO}/${PROD}:${VERSION}
 rm -rf ${PROD}
 
+# build kubectl plugin
+cd ${BASE_DIR}
+CGO_ENABLED=0 GOOS=linux GOARCH=${ARCH} go build -o ${PKG_DIR}/kubectl-flexlb ./cmd/kubectl-flexlb
+
 # copy certs, install script
 cp -r ${RELEASE_DIR}/* ${PKG_DIR}/
 cp -r ${CONFIG_DIR} ${PKG_DIR}/
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+
+	"github.com/flexlet/flexlb-kube-controller/plugin"
+)
+
+// kubectl plugin, install kubectl-flexlb in PATH and run as: kubectl flexlb <command>
+func main() {
+	plugin.Main(os.Args[1:])
+}
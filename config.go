@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	configv1alpha1 "github.com/flexlet/flexlb-kube-controller/api/config/v1alpha1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// interval of checking the config file for changes, mounted configmaps are updated by kubelet within a minute
+const configReloadInterval = 10 * time.Second
+
+// load config file, defaults if no file; overrides are the flags and environment variables set
+func loadConfig(path string, overrides func(*configv1alpha1.FlexLBControllerConfig)) (*configv1alpha1.FlexLBControllerConfig, error) {
+	config := &configv1alpha1.FlexLBControllerConfig{}
+	if path != "" {
+		loaded, err := configv1alpha1.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		config = loaded
+	}
+	overrides(config)
+	config.Default()
+	return config, config.Validate()
+}
+
+// handler settings of config which can change while running
+func handlerSettings(config *configv1alpha1.FlexLBControllerConfig) handlers.Settings {
+	return handlers.Settings{
+		ProbePodImage:     config.FlexLB.Probe.Image,
+		ProbeTimeout:      config.FlexLB.Probe.Timeout.Duration,
+		APITimeout:        config.FlexLB.APITimeout.Duration,
+		DrainGrace:        config.FlexLB.DrainGracePeriod.Duration,
+		CertExpiryWarning: config.FlexLB.CertExpiryWarning.Duration,
+		RefreshInterval:   config.FlexLB.RefreshInterval.Duration,
+	}
+}
+
+// reloads the config file when changed, live settings are applied to the handler, invalid configs are ignored,
+// runs on all replicas
+type configReloader struct {
+	path      string
+	overrides func(*configv1alpha1.FlexLBControllerConfig)
+	config    *configv1alpha1.FlexLBControllerConfig
+	handler   *handlers.Handler
+}
+
+func (r *configReloader) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *configReloader) Start(ctx context.Context) error {
+	last, _ := os.ReadFile(r.path)
+	ticker := time.NewTicker(configReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(r.path)
+		if err != nil || bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+
+		config, err := loadConfig(r.path, r.overrides)
+		if err != nil {
+			setupLog.Error(err, "invalid config file ignored", "config", r.path)
+			continue
+		}
+		if changed := config.RestartRequired(r.config); len(changed) > 0 {
+			setupLog.Info("config changes ignored until restart", "config", r.path, "fields", strings.Join(changed, ", "))
+		}
+		r.handler.Reconfigure(handlerSettings(config))
+		setupLog.Info("config file reloaded", "config", r.path)
+	}
+}
@@ -0,0 +1,312 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	disv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// objects shared by the specs, created once
+
+const (
+	ippoolStart    = "192.168.100.10"
+	ippoolEnd      = "192.168.100.20"
+	tenantStart    = "192.168.101.10"
+	tenantEnd      = "192.168.101.20"
+	backendNetwork = "10.0.0.0/24"
+)
+
+// backend nodes and their traffic ips
+var testNodes = map[string]string{
+	"node-1": "10.0.0.1",
+	"node-2": "10.0.0.2",
+}
+
+// default cluster connected to the fake server, its default ippool takes node traffic ips from node addresses
+func ensureCluster() *crdv1.FlexLBCluster {
+	return ensureClusterIn(flexlbNamespace, handlers.DefaultClusterName, ippoolStart, ippoolEnd)
+}
+
+// cluster of namespace connected to the fake server, with a default ippool of the range
+func ensureClusterIn(namespace string, name string, start string, end string) *crdv1.FlexLBCluster {
+	cluster := &crdv1.FlexLBCluster{}
+	key := types.NamespacedName{Name: name, Namespace: namespace}
+	if err := k8sClient.Get(context.TODO(), key, cluster); err == nil {
+		return cluster
+	}
+	cluster = &crdv1.FlexLBCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Spec: crdv1.FlexLBClusterSpec{
+			Endpoint: fakeServer.Endpoint(),
+			IPPools: []crdv1.FlexLBIPPool{{
+				Name:             handlers.DefaultIPPoolName,
+				Interface:        "eth0",
+				NetPrefix:        24,
+				Start:            start,
+				End:              end,
+				BackendNetwork:   backendNetwork,
+				BackendIPSources: []string{crdv1.BackendIPSourceNodeAddress},
+			}},
+		},
+	}
+	Expect(k8sClient.Create(context.TODO(), cluster)).To(Succeed())
+	return cluster
+}
+
+// ready backend nodes with internal ip in backend network
+func ensureNodes() {
+	for name, ip := range testNodes {
+		node := &v1.Node{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name}, node); err == nil {
+			continue
+		}
+		node = &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		Expect(k8sClient.Create(context.TODO(), node)).To(Succeed())
+		node.Status = v1.NodeStatus{
+			Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: ip}},
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		}
+		Expect(k8sClient.Status().Update(context.TODO(), node)).To(Succeed())
+	}
+}
+
+func ensureNamespace(name string) {
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := k8sClient.Create(context.TODO(), ns); err != nil {
+		Expect(apierrors.IsAlreadyExists(err)).To(BeTrue())
+	}
+}
+
+// load balancer service of one tcp port, with an endpoint slice of pods on the nodes
+func createService(namespace string, name string, port int32, nodes ...string) *v1.Service {
+	return createAnnotatedService(namespace, name, nil, port, nodes...)
+}
+
+func createAnnotatedService(namespace string, name string, annotations map[string]string, port int32, nodes ...string) *v1.Service {
+	return createServiceOfType(v1.ServiceTypeLoadBalancer, namespace, name, annotations, port, nodes...)
+}
+
+// cluster ip service of one tcp port, backend of gateway routes and ingresses
+func createClusterIPService(namespace string, name string, port int32, nodes ...string) *v1.Service {
+	return createServiceOfType(v1.ServiceTypeClusterIP, namespace, name, nil, port, nodes...)
+}
+
+func createServiceOfType(svcType v1.ServiceType, namespace string, name string, annotations map[string]string, port int32,
+	nodes ...string) *v1.Service {
+	targetPort := int32(8080)
+	slice := &disv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{disv1.LabelServiceName: name},
+		},
+		AddressType: disv1.AddressTypeIPv4,
+		Ports:       []disv1.EndpointPort{{Port: &targetPort}},
+		Endpoints:   podEndpoints(name, nodes...),
+	}
+	Expect(k8sClient.Create(context.TODO(), slice)).To(Succeed())
+
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+		Spec: v1.ServiceSpec{
+			Type:     svcType,
+			Selector: map[string]string{"app": name},
+			Ports: []v1.ServicePort{{
+				Protocol:   v1.ProtocolTCP,
+				Port:       port,
+				TargetPort: intstr.FromInt(int(targetPort)),
+			}},
+		},
+	}
+	Expect(k8sClient.Create(context.TODO(), svc)).To(Succeed())
+	return svc
+}
+
+// one pod endpoint on each node
+func podEndpoints(name string, nodes ...string) []disv1.Endpoint {
+	endpoints := []disv1.Endpoint{}
+	for i, node := range nodes {
+		nodeName := node
+		podName := name + "-" + node
+		endpoints = append(endpoints, disv1.Endpoint{
+			Addresses: []string{fmt.Sprintf("172.16.0.%d", i+1)},
+			NodeName:  &nodeName,
+			TargetRef: &v1.ObjectReference{Kind: "Pod", Name: podName},
+		})
+	}
+	return endpoints
+}
+
+// set pod endpoints of service to the nodes
+func setServiceNodes(svc *v1.Service, nodes ...string) {
+	slice := &disv1.EndpointSlice{}
+	Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, slice)).To(Succeed())
+	slice.Endpoints = podEndpoints(svc.Name, nodes...)
+	Expect(k8sClient.Update(context.TODO(), slice)).To(Succeed())
+}
+
+// set annotation of service, retried on conflicts with the controller
+func annotateService(svc *v1.Service, key string, value string) {
+	Eventually(func() error {
+		latest := &v1.Service{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, latest); err != nil {
+			return err
+		}
+		if latest.Annotations == nil {
+			latest.Annotations = map[string]string{}
+		}
+		latest.Annotations[key] = value
+		return k8sClient.Update(context.TODO(), latest)
+	}, timeout, interval).Should(Succeed())
+}
+
+// reasons of the warning events of service
+func serviceWarnings(svc *v1.Service) func() []string {
+	return func() []string {
+		events := &v1.EventList{}
+		if err := k8sClient.List(context.TODO(), events, client.InNamespace(svc.Namespace)); err != nil {
+			return nil
+		}
+		reasons := []string{}
+		for _, e := range events.Items {
+			if e.InvolvedObject.Kind == "Service" && e.InvolvedObject.Name == svc.Name && e.Type == v1.EventTypeWarning {
+				reasons = append(reasons, e.Reason)
+			}
+		}
+		return reasons
+	}
+}
+
+// name of the instance allocated to service, empty if not allocated yet
+func serviceInstanceName(svc *v1.Service) func() string {
+	return func() string {
+		latest := &v1.Service{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, latest); err != nil {
+			return ""
+		}
+		return latest.Annotations[handlers.InstanceKey]
+	}
+}
+
+// phase of instance, empty if not exist
+func instancePhase(namespace string, name string) func() string {
+	return func() string {
+		inst := &crdv1.FlexLBInstance{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, inst); err != nil {
+			return ""
+		}
+		return inst.Status.Phase
+	}
+}
+
+// class of the last error of instance, empty if none
+func instanceErrorClass(namespace string, name string) func() string {
+	return func() string {
+		inst := &crdv1.FlexLBInstance{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, inst); err != nil || inst.Status.LastError == nil {
+			return ""
+		}
+		return inst.Status.LastError.Class
+	}
+}
+
+// backend server ips of the first endpoint of instance on the fake server
+func flexlbBackendIps(name string) func() []string {
+	return func() []string {
+		ips := []string{}
+		inst := fakeServer.Instance(name)
+		if inst == nil || len(inst.Config.Endpoints) == 0 {
+			return ips
+		}
+		for _, server := range inst.Config.Endpoints[0].BackendServers {
+			ips = append(ips, server.Ipaddress)
+		}
+		return ips
+	}
+}
+
+// frontend ports of instance on the fake server
+func flexlbFrontendPorts(name string) func() []int {
+	return func() []int {
+		ports := []int{}
+		inst := fakeServer.Instance(name)
+		if inst == nil {
+			return ports
+		}
+		for _, ep := range inst.Config.Endpoints {
+			ports = append(ports, int(ep.FrontendPort))
+		}
+		return ports
+	}
+}
+
+// kubernetes.io/tls secret with a self-signed certificate of host, returns the pem encoded certificate
+func createTLSSecret(namespace string, name string, host string) string {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       v1.SecretTypeTLS,
+	}
+	secret.Data = selfSignedCert(host)
+	Expect(k8sClient.Create(context.TODO(), secret)).To(Succeed())
+	return string(secret.Data[v1.TLSCertKey])
+}
+
+// replace the certificate of tls secret, returns the pem encoded certificate
+func rotateTLSSecret(namespace string, name string, host string) string {
+	secret := &v1.Secret{}
+	Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, secret)).To(Succeed())
+	secret.Data = selfSignedCert(host)
+	Expect(k8sClient.Update(context.TODO(), secret)).To(Succeed())
+	return string(secret.Data[v1.TLSCertKey])
+}
+
+// pem encoded self-signed certificate and key of host, valid for a year
+func selfSignedCert(host string) map[string][]byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return map[string][]byte{
+		v1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		v1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+// server certificate and frontend options of the first endpoint of instance on the fake server
+func flexlbServerCert(name string) func() string {
+	return func() string {
+		inst := fakeServer.Instance(name)
+		if inst == nil || len(inst.Config.Endpoints) == 0 || inst.Config.Endpoints[0].FrontendSslOptions == nil {
+			return ""
+		}
+		return inst.Config.Endpoints[0].FrontendSslOptions.ServerCert
+	}
+}
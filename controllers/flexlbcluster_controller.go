@@ -8,24 +8,29 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
 )
 
 // FlexLBClusterReconciler reconciles a FlexLBCluster object
 type FlexLBClusterReconciler struct {
 	client.Client
-	Scheme        *runtime.Scheme
-	Namespace     string
-	ChangeHandler func(client.Client, context.Context, *crdv1.FlexLBCluster) error
+	Scheme                  *runtime.Scheme
+	MaxConcurrentReconciles int
+	Namespace               string
+	Namespaces              []string // namespaces of clusters besides Namespace, "*" for all
+	ChangeHandler           func(client.Client, context.Context, *crdv1.FlexLBCluster) error
 }
 
 //+kubebuilder:rbac:groups=crd.flexlb.flexlet.io,resources=flexlbclusters,verbs=get;list;watch
 //+kubebuilder:rbac:groups=crd.flexlb.flexlet.io,resources=flexlbclusters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch;
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
 
 func (r *FlexLBClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx)
@@ -35,17 +40,19 @@ func (r *FlexLBClusterReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, nil
 	}
 
-	return ctrl.Result{}, r.ChangeHandler(r.Client, ctx, &cluster)
+	err := r.ChangeHandler(r.Client, ctx, &cluster)
+	recordClusterError(r.Client, ctx, req.NamespacedName, err)
+	return handlerResult(ctrl.Result{}, err, "controller", "FlexLBClusterReconciler", "object", req.NamespacedName)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *FlexLBClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	p := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
-			return e.Object.GetNamespace() == r.Namespace
+			return r.watched(e.Object.GetNamespace())
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
-			if e.ObjectNew.GetNamespace() != r.Namespace {
+			if !r.watched(e.ObjectNew.GetNamespace()) {
 				return false
 			}
 			old := e.ObjectOld.(*crdv1.FlexLBCluster)
@@ -54,13 +61,18 @@ func (r *FlexLBClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			return !cmp.Equal(new.Spec, old.Spec) || !new.DeletionTimestamp.IsZero()
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
-			return e.Object.GetNamespace() == r.Namespace
+			return r.watched(e.Object.GetNamespace())
 		},
 		GenericFunc: func(e event.GenericEvent) bool {
-			return e.Object.GetNamespace() == r.Namespace
+			return r.watched(e.Object.GetNamespace())
 		},
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&crdv1.FlexLBCluster{}, builder.WithPredicates(p)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles, RateLimiter: newRateLimiter()}).
 		Complete(r)
 }
+
+func (r *FlexLBClusterReconciler) watched(namespace string) bool {
+	return namespace == r.Namespace || handlers.InNamespaces(r.Namespaces, namespace)
+}
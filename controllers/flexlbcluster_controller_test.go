@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/test/fakeflexlb"
+)
+
+var _ = Describe("FlexLBCluster controller", func() {
+	BeforeEach(func() {
+		fakeServer.Reset()
+	})
+
+	It("reports ready status of flexlb nodes", func() {
+		cluster := ensureCluster()
+		Eventually(func() crdv1.FlexLBClusterStatus {
+			latest := &crdv1.FlexLBCluster{}
+			k8sClient.Get(context.TODO(), types.NamespacedName{Name: cluster.Name, Namespace: cluster.Namespace}, latest)
+			return latest.Status
+		}, timeout, interval).Should(Equal(crdv1.FlexLBClusterStatus{
+			ClusterStatus: crdv1.ClusterStatusReady,
+			NodeStatus:    map[string]string{"flexlb-1": fakeflexlb.NodeReady, "flexlb-2": fakeflexlb.NodeReady},
+		}))
+		Expect(fakeServer.Calls(fakeflexlb.OpReadyz)).To(BeNumerically(">", 0))
+	})
+})
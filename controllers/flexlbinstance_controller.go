@@ -2,16 +2,26 @@ package controllers
 
 import (
 	"context"
+	"sync"
 	"time"
 
+	"github.com/flexlet/flexlb-kube-controller/handlers"
 	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
 	"github.com/flexlet/flexlb-kube-controller/utils"
@@ -20,16 +30,24 @@ import (
 // FlexLBInstanceReconciler reconciles a FlexLBInstance object
 type FlexLBInstanceReconciler struct {
 	client.Client
-	Scheme          *runtime.Scheme
-	RefreshInterval time.Duration
-	ChangeHandler   func(client.Client, context.Context, *crdv1.FlexLBInstance) error
-	DeleteHandler   func(client.Client, context.Context, *crdv1.FlexLBInstance) error
+	Scheme                  *runtime.Scheme
+	MaxConcurrentReconciles int
+	RefreshInterval         time.Duration
+	ChangeHandler           func(client.Client, context.Context, *crdv1.FlexLBInstance) error
+	DeleteHandler           func(client.Client, context.Context, *crdv1.FlexLBInstance) error
+	// refresh intervals of instance, default: RefreshInterval
+	RefreshPolicy func(client.Client, context.Context, *crdv1.FlexLBInstance, time.Duration) handlers.RefreshPolicy
+
+	// consecutive ready refreshes of instances
+	readyRefreshes map[types.NamespacedName]int
+	lock           sync.Mutex
 }
 
 //+kubebuilder:rbac:groups=crd.flexlb.flexlet.io,resources=flexlbinstances,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=crd.flexlb.flexlet.io,resources=flexlbinstances/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=crd.flexlb.flexlet.io,resources=flexlbinstances/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch;
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
 func (r *FlexLBInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx)
@@ -50,16 +68,24 @@ func (r *FlexLBInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		}
 
 		// process change request
-		if err := r.ChangeHandler(r.Client, ctx, &instance); err != nil {
-			return ctrl.Result{}, err
+		err := r.ChangeHandler(r.Client, ctx, &instance)
+		recordInstanceError(r.Client, ctx, req.NamespacedName, err)
+		// instance not ready on flexlb is refreshed faster, other errors backoff
+		if err != nil && handlers.ErrorReason(err) != handlers.ErrorInstanceNotReady {
+			return handlerResult(ctrl.Result{}, err, "controller", "FlexLBInstanceReconciler", "object", req.NamespacedName)
 		}
+		return ctrl.Result{RequeueAfter: r.nextRefresh(ctx, req.NamespacedName, &instance)}, nil
 
 	} else {
 		// process delete request
 		if err := r.DeleteHandler(r.Client, ctx, &instance); err != nil {
-			return ctrl.Result{}, err
+			return handlerResult(ctrl.Result{}, err, "controller", "FlexLBInstanceReconciler", "object", req.NamespacedName)
 		}
 
+		r.lock.Lock()
+		delete(r.readyRefreshes, req.NamespacedName)
+		r.lock.Unlock()
+
 		// unset finalizer if set
 		if utils.UnsetFinalizer(&instance.ObjectMeta) {
 			log.Log.Info("unset finalizer ", "controller", "FlexLBInstanceReconciler", "object", req.NamespacedName)
@@ -70,11 +96,31 @@ func (r *FlexLBInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		}
 	}
 
-	return ctrl.Result{RequeueAfter: r.RefreshInterval}, nil
+	return ctrl.Result{}, nil
+}
+
+// jittered refresh interval by instance phase, set by the change handler
+func (r *FlexLBInstanceReconciler) nextRefresh(ctx context.Context, key types.NamespacedName, instance *crdv1.FlexLBInstance) time.Duration {
+	ready := instance.Status.Phase == crdv1.InstancePhaseReady
+	r.lock.Lock()
+	if ready {
+		r.readyRefreshes[key]++
+	} else {
+		delete(r.readyRefreshes, key)
+	}
+	readyRefreshes := r.readyRefreshes[key]
+	r.lock.Unlock()
+
+	policy := handlers.RefreshPolicy{Interval: r.RefreshInterval, NotReadyInterval: r.RefreshInterval, StableInterval: r.RefreshInterval}
+	if r.RefreshPolicy != nil {
+		policy = r.RefreshPolicy(r.Client, ctx, instance, r.RefreshInterval)
+	}
+	return policy.Next(ready, readyRefreshes)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *FlexLBInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.readyRefreshes = map[types.NamespacedName]int{}
 	p := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
 			return true
@@ -82,8 +128,9 @@ func (r *FlexLBInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		UpdateFunc: func(e event.UpdateEvent) bool {
 			old := e.ObjectOld.(*crdv1.FlexLBInstance)
 			new := e.ObjectNew.(*crdv1.FlexLBInstance)
-			// reconcile when spec changed or delete timestamp is set
-			return !cmp.Equal(new.Spec, old.Spec) || !new.DeletionTimestamp.IsZero()
+			// reconcile when spec changed, resync requested or delete timestamp is set
+			return !cmp.Equal(new.Spec, old.Spec) || !new.DeletionTimestamp.IsZero() ||
+				new.Annotations[handlers.ResyncKey] != old.Annotations[handlers.ResyncKey]
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
 			return true
@@ -92,7 +139,41 @@ func (r *FlexLBInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			return true
 		},
 	}
+	// instances by their tls secrets
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &crdv1.FlexLBInstance{}, instanceSecretIndex,
+		func(obj client.Object) []string {
+			return handlers.InstanceTLSSecrets(obj.(*crdv1.FlexLBInstance))
+		}); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&crdv1.FlexLBInstance{}, builder.WithPredicates(p)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles, RateLimiter: newRateLimiter()}).
+		// tls secret rotated, push the new certificate; only the metadata of tls secrets is cached, see SecretCacheSelector
+		Watches(&source.Kind{Type: &v1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+				return r.instancesOfSecret(obj.GetNamespace(), obj.GetName())
+			}), builder.OnlyMetadata).
 		Complete(r)
 }
+
+// instances terminating tls with the secret
+func (r *FlexLBInstanceReconciler) instancesOfSecret(namespace string, name string) []reconcile.Request {
+	requests := []reconcile.Request{}
+	insts := &crdv1.FlexLBInstanceList{}
+	if err := r.List(context.TODO(), insts, client.MatchingFields{instanceSecretIndex: namespace + "/" + name}); err != nil {
+		return requests
+	}
+	for i := 0; i < len(insts.Items); i++ {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: insts.Items[i].Namespace, Name: insts.Items[i].Name}})
+	}
+	return requests
+}
+
+// field index of instances by tls secret, <namespace>/<secret>
+const instanceSecretIndex = "tlsSecret"
+
+// cache selector of secrets: only kubernetes.io/tls secrets are watched, as metadata
+func SecretCacheSelector() cache.ObjectSelector {
+	return cache.ObjectSelector{Field: fields.OneTermEqualSelector("type", string(v1.SecretTypeTLS))}
+}
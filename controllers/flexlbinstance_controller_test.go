@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+	"github.com/flexlet/flexlb-kube-controller/test/fakeflexlb"
+)
+
+var _ = Describe("FlexLBInstance controller", func() {
+	const namespace = "instance-test"
+
+	BeforeEach(func() {
+		fakeServer.Reset()
+		ensureCluster()
+		ensureNodes()
+		ensureNamespace(namespace)
+	})
+
+	AfterEach(func() {
+		fakeServer.SetNodeStatus("flexlb-1", fakeflexlb.NodeReady)
+		fakeServer.SetNodeStatus("flexlb-2", fakeflexlb.NodeReady)
+	})
+
+	It("follows the ready status of the instance on flexlb", func() {
+		svc := createService(namespace, "status", 80, "node-1")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseReady))
+
+		By("reporting not ready when all flexlb nodes are down")
+		fakeServer.SetNodeStatus("flexlb-1", fakeflexlb.NodeNotReady)
+		fakeServer.SetNodeStatus("flexlb-2", fakeflexlb.NodeNotReady)
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseNotReady))
+
+		By("reporting ready again when a flexlb node is up")
+		fakeServer.SetNodeStatus("flexlb-1", fakeflexlb.NodeReady)
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseReady))
+	})
+
+	It("summarizes the node status in instance and service conditions", func() {
+		svc := createService(namespace, "nodes", 80, "node-1")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(instanceNodesRunning(namespace, name), timeout, interval).Should(Equal(metav1.ConditionTrue))
+		Eventually(serviceNodesRunning(namespace, svc.Name), timeout, interval).Should(Equal(metav1.ConditionTrue))
+
+		By("reporting the instance not running on a flexlb node which is down")
+		fakeServer.SetNodeStatus("flexlb-2", fakeflexlb.NodeNotReady)
+		Eventually(instanceNodesRunning(namespace, name), timeout, interval).Should(Equal(metav1.ConditionFalse))
+		Eventually(serviceNodesRunning(namespace, svc.Name), timeout, interval).Should(Equal(metav1.ConditionFalse))
+		Expect(instancePhase(namespace, name)()).To(Equal(crdv1.InstancePhaseReady))
+
+		By("reporting running again when the node is up")
+		fakeServer.SetNodeStatus("flexlb-2", fakeflexlb.NodeReady)
+		Eventually(instanceNodesRunning(namespace, name), timeout, interval).Should(Equal(metav1.ConditionTrue))
+		Eventually(serviceNodesRunning(namespace, svc.Name), timeout, interval).Should(Equal(metav1.ConditionTrue))
+	})
+
+	It("reports the cluster not ready when flexlb api times out", func() {
+		svc := createService(namespace, "slow", 80, "node-1")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseReady))
+
+		fakeServer.SetLatency(fakeflexlb.OpReadyz, 2*apiTimeout)
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseClusterNotReady))
+
+		fakeServer.SetLatency(fakeflexlb.OpReadyz, 0)
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseReady))
+	})
+
+	It("records the error class and does not hot loop on invalid config", func() {
+		fakeServer.Fail(fakeflexlb.OpCreate, 400)
+		svc := createService(namespace, "rejected", 80, "node-1")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(instanceErrorClass(namespace, name), timeout, interval).Should(Equal(crdv1.ErrorClassInvalidConfig))
+
+		By("waiting for a change instead of retrying")
+		calls := fakeServer.Calls(fakeflexlb.OpCreate)
+		Consistently(func() int { return fakeServer.Calls(fakeflexlb.OpCreate) }, 5*time.Second, interval).Should(BeNumerically("<=", calls+1))
+
+		By("clearing the error when resync succeeds")
+		fakeServer.Fail(fakeflexlb.OpCreate, 0)
+		inst := &crdv1.FlexLBInstance{}
+		Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, inst)).To(Succeed())
+		patch := client.MergeFrom(inst.DeepCopy())
+		inst.Annotations[handlers.ResyncKey] = time.Now().String()
+		Expect(k8sClient.Patch(context.TODO(), inst, patch)).To(Succeed())
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseReady))
+		Eventually(instanceErrorClass(namespace, name), timeout, interval).Should(BeEmpty())
+	})
+})
+
+// status of the NodesRunning condition of instance, empty if not set
+func instanceNodesRunning(namespace string, name string) func() metav1.ConditionStatus {
+	return func() metav1.ConditionStatus {
+		inst := &crdv1.FlexLBInstance{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, inst); err != nil {
+			return ""
+		}
+		if cond := meta.FindStatusCondition(inst.Status.Conditions, crdv1.InstanceConditionNodesRunning); cond != nil {
+			return cond.Status
+		}
+		return ""
+	}
+}
+
+// status of the NodesRunning condition of service, empty if not set
+func serviceNodesRunning(namespace string, name string) func() metav1.ConditionStatus {
+	return func() metav1.ConditionStatus {
+		svc := &corev1.Service{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, svc); err != nil {
+			return ""
+		}
+		if cond := meta.FindStatusCondition(svc.Status.Conditions, handlers.ServiceConditionNodesRunning); cond != nil {
+			return cond.Status
+		}
+		return ""
+	}
+}
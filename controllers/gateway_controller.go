@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+	"github.com/flexlet/flexlb-kube-controller/utils"
+	disv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// GatewayClassReconciler reconciles a GatewayClass object
+type GatewayClassReconciler struct {
+	client.Client
+	Scheme                  *runtime.Scheme
+	MaxConcurrentReconciles int
+	ChangeHandler           func(client.Client, context.Context, *unstructured.Unstructured) error
+}
+
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gatewayclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gatewayclasses/status,verbs=get;update;patch
+
+func (r *GatewayClassReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	class := handlers.NewGatewayObject(handlers.GatewayClassGVK)
+	if err := r.Get(ctx, req.NamespacedName, class); err != nil {
+		return ctrl.Result{}, nil
+	}
+	return handlerResult(ctrl.Result{}, r.ChangeHandler(r.Client, ctx, class), "controller", "GatewayClassReconciler", "object", req.NamespacedName)
+}
+
+func (r *GatewayClassReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(handlers.NewGatewayObject(handlers.GatewayClassGVK)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles, RateLimiter: newRateLimiter()}).
+		Complete(r)
+}
+
+// GatewayReconciler reconciles a Gateway object
+type GatewayReconciler struct {
+	client.Client
+	Scheme                  *runtime.Scheme
+	MaxConcurrentReconciles int
+	ChangeHandler           func(client.Client, context.Context, *unstructured.Unstructured) (time.Duration, error)
+	DeleteHandler           func(client.Client, context.Context, *unstructured.Unstructured) error
+}
+
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=tcproutes;udproutes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=tcproutes/status;udproutes/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+func (r *GatewayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	gw := handlers.NewGatewayObject(handlers.GatewayGVK)
+	if err := r.Get(ctx, req.NamespacedName, gw); err != nil {
+		return ctrl.Result{}, nil
+	}
+
+	if gw.GetDeletionTimestamp().IsZero() {
+		// gateway of other controllers, or not managed by flexlb any more
+		if !handlers.IsFlexLBGateway(r.Client, ctx, gw) {
+			if _, managed := gw.GetAnnotations()[handlers.InstanceKey]; !managed {
+				return ctrl.Result{}, nil
+			}
+		}
+
+		// set finalizer if not set
+		if utils.SetObjectFinalizer(gw) {
+			log.Log.Info("set finalizer ", "controller", "GatewayReconciler", "object", req.NamespacedName)
+			if err := r.Update(ctx, gw); err != nil {
+				log.Log.Info("set finalizer failed", "controller", "GatewayReconciler", "object", req.NamespacedName)
+				return ctrl.Result{}, err
+			}
+		}
+
+		// process change request, requeue while backends are draining
+		requeueAfter, err := r.ChangeHandler(r.Client, ctx, gw)
+		return handlerResult(ctrl.Result{RequeueAfter: requeueAfter}, err, "controller", "GatewayReconciler", "object", req.NamespacedName)
+
+	} else {
+		// process delete request
+		if err := r.DeleteHandler(r.Client, ctx, gw); err != nil {
+			return handlerResult(ctrl.Result{}, err, "controller", "GatewayReconciler", "object", req.NamespacedName)
+		}
+
+		// unset finalizer if set
+		if utils.UnsetObjectFinalizer(gw) {
+			log.Log.Info("unset finalizer ", "controller", "GatewayReconciler", "object", req.NamespacedName)
+			if err := r.Update(ctx, gw); err != nil {
+				log.Log.Info("unset finalizer failed", "controller", "GatewayReconciler", "object", req.NamespacedName)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *GatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	routeMapper := handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		route, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return []reconcile.Request{}
+		}
+		return gatewayRequests(handlers.GatewaysOfRoute(route))
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(handlers.NewGatewayObject(handlers.GatewayGVK)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles, RateLimiter: newRateLimiter()}).
+		Watches(&source.Kind{Type: handlers.NewGatewayObject(handlers.TCPRouteGVK)}, routeMapper).
+		Watches(&source.Kind{Type: handlers.NewGatewayObject(handlers.UDPRouteGVK)}, routeMapper).
+		Watches(&source.Kind{Type: handlers.NewGatewayObject(handlers.GatewayClassGVK)},
+			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+				return r.gatewaysOfClass(obj.GetName())
+			})).
+		Watches(&source.Kind{Type: &disv1.EndpointSlice{}},
+			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+				svcName, exist := obj.GetLabels()[disv1.LabelServiceName]
+				if !exist {
+					return []reconcile.Request{}
+				}
+				return gatewayRequests(handlers.GatewaysOfService(r.Client, context.TODO(), obj.GetNamespace(), svcName))
+			})).
+		Complete(r)
+}
+
+// gateways of gateway class
+func (r *GatewayReconciler) gatewaysOfClass(className string) []reconcile.Request {
+	requests := []reconcile.Request{}
+	gateways := handlers.NewGatewayObjectList(handlers.GatewayGVK)
+	if err := r.List(context.TODO(), gateways); err != nil {
+		return requests
+	}
+	for _, gw := range gateways.Items {
+		if name, _, _ := unstructured.NestedString(gw.Object, "spec", "gatewayClassName"); name == className {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: gw.GetNamespace(), Name: gw.GetName()}})
+		}
+	}
+	return requests
+}
+
+// deduplicated reconcile requests of gateways
+func gatewayRequests(gateways []types.NamespacedName) []reconcile.Request {
+	requests := []reconcile.Request{}
+	keys := []types.NamespacedName{}
+	for _, key := range gateways {
+		if !containsKey(keys, key) {
+			keys = append(keys, key)
+			requests = append(requests, reconcile.Request{NamespacedName: key})
+		}
+	}
+	return requests
+}
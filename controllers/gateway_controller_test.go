@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// gateway api object, nil if not exist
+func gatewayObject(gvk schema.GroupVersionKind, namespace string, name string) func() *unstructured.Unstructured {
+	return func() *unstructured.Unstructured {
+		obj := handlers.NewGatewayObject(gvk)
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, obj); err != nil {
+			return nil
+		}
+		return obj
+	}
+}
+
+// status of condition type in unstructured conditions, empty if not set
+func conditionStatus(conditions []interface{}, condType string) string {
+	for _, c := range conditions {
+		if cond, ok := c.(map[string]interface{}); ok && cond["type"] == condType {
+			status, _ := cond["status"].(string)
+			return status
+		}
+	}
+	return ""
+}
+
+// status of condition type of gateway api object, empty if not set
+func gatewayCondition(gvk schema.GroupVersionKind, namespace string, name string, condType string) func() string {
+	return func() string {
+		obj := gatewayObject(gvk, namespace, name)()
+		if obj == nil {
+			return ""
+		}
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		return conditionStatus(conditions, condType)
+	}
+}
+
+// status of condition type of the first parent of route, empty if not set
+func routeParentCondition(gvk schema.GroupVersionKind, namespace string, name string, condType string) func() string {
+	return func() string {
+		obj := gatewayObject(gvk, namespace, name)()
+		if obj == nil {
+			return ""
+		}
+		parents, _, _ := unstructured.NestedSlice(obj.Object, "status", "parents")
+		if len(parents) == 0 {
+			return ""
+		}
+		parent, _ := parents[0].(map[string]interface{})
+		conditions, _, _ := unstructured.NestedSlice(parent, "conditions")
+		return conditionStatus(conditions, condType)
+	}
+}
+
+// create gateway api object of kind with spec
+func createGatewayObject(gvk schema.GroupVersionKind, namespace string, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := handlers.NewGatewayObject(gvk)
+	obj.SetName(name)
+	obj.SetNamespace(namespace)
+	obj.Object["spec"] = spec
+	Expect(k8sClient.Create(context.TODO(), obj)).To(Succeed())
+	return obj
+}
+
+var _ = Describe("Gateway controller", func() {
+	const namespace = "gateway-test"
+	const className = "flexlb"
+
+	BeforeEach(func() {
+		fakeServer.Reset()
+		ensureCluster()
+		ensureNodes()
+		ensureNamespace(namespace)
+		if gatewayObject(handlers.GatewayClassGVK, "", className)() == nil {
+			createGatewayObject(handlers.GatewayClassGVK, "", className, map[string]interface{}{
+				"controllerName": handlers.GatewayControllerName,
+			})
+		}
+	})
+
+	It("accepts the gateway class of flexlb", func() {
+		Eventually(gatewayCondition(handlers.GatewayClassGVK, "", className, handlers.GatewayConditionAccepted),
+			timeout, interval).Should(Equal("True"))
+	})
+
+	It("allocates a vip for a gateway and renders its tcp routes", func() {
+		createClusterIPService(namespace, "tcp-backend", 9000, "node-1", "node-2")
+		gw := createGatewayObject(handlers.GatewayGVK, namespace, "tcp-gateway", map[string]interface{}{
+			"gatewayClassName": className,
+			"listeners": []interface{}{
+				map[string]interface{}{"name": "tcp", "port": int64(9000), "protocol": "TCP"},
+			},
+		})
+		createGatewayObject(handlers.TCPRouteGVK, namespace, "tcp-route", map[string]interface{}{
+			"parentRefs": []interface{}{map[string]interface{}{"name": gw.GetName()}},
+			"rules": []interface{}{map[string]interface{}{
+				"backendRefs": []interface{}{map[string]interface{}{"name": "tcp-backend", "port": int64(9000)}},
+			}},
+		})
+
+		By("creating the instance with the pods of the backend service")
+		Eventually(func() string {
+			if latest := gatewayObject(handlers.GatewayGVK, namespace, gw.GetName())(); latest != nil {
+				return latest.GetAnnotations()[handlers.InstanceKey]
+			}
+			return ""
+		}, timeout, interval).ShouldNot(BeEmpty())
+		name := gatewayObject(handlers.GatewayGVK, namespace, gw.GetName())().GetAnnotations()[handlers.InstanceKey]
+		Eventually(flexlbFrontendPorts(name), timeout, interval).Should(ConsistOf(9000))
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(ConsistOf("172.16.0.1", "172.16.0.2"))
+
+		By("reporting the address and conditions")
+		Eventually(gatewayCondition(handlers.GatewayGVK, namespace, gw.GetName(), handlers.GatewayConditionReady),
+			timeout, interval).Should(Equal("True"))
+		addresses, _, _ := unstructured.NestedSlice(gatewayObject(handlers.GatewayGVK, namespace, gw.GetName())().Object, "status", "addresses")
+		Expect(addresses).To(HaveLen(1))
+		Expect(addresses[0].(map[string]interface{})["value"]).To(WithTransform(func(v interface{}) uint32 { return ipValue(v.(string)) },
+			SatisfyAll(BeNumerically(">=", ipValue(ippoolStart)), BeNumerically("<=", ipValue(ippoolEnd)))))
+		Eventually(routeParentCondition(handlers.TCPRouteGVK, namespace, "tcp-route", handlers.GatewayConditionAccepted),
+			timeout, interval).Should(Equal("True"))
+		Eventually(routeParentCondition(handlers.TCPRouteGVK, namespace, "tcp-route", handlers.GatewayConditionResolvedRefs),
+			timeout, interval).Should(Equal("True"))
+
+		By("deleting the instance with the gateway")
+		Expect(k8sClient.Delete(context.TODO(), gw)).To(Succeed())
+		Eventually(func() bool {
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: gw.GetName(), Namespace: namespace}, handlers.NewGatewayObject(handlers.GatewayGVK))
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+		Eventually(func() bool { return fakeServer.Instance(name) == nil }, timeout, interval).Should(BeTrue())
+	})
+})
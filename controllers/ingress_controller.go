@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+	"github.com/flexlet/flexlb-kube-controller/utils"
+	v1 "k8s.io/api/core/v1"
+	disv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// IngressClassReconciler reconciles an IngressClass object, with the ingresses of the class
+type IngressClassReconciler struct {
+	client.Client
+	Scheme                  *runtime.Scheme
+	MaxConcurrentReconciles int
+	ChangeHandler           func(client.Client, context.Context, *networkingv1.IngressClass) (time.Duration, error)
+	DeleteHandler           func(client.Client, context.Context, *networkingv1.IngressClass) error
+}
+
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses/status,verbs=get;update;patch
+
+func (r *IngressClassReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	var class networkingv1.IngressClass
+	if err := r.Get(ctx, req.NamespacedName, &class); err != nil {
+		return ctrl.Result{}, nil
+	}
+
+	if class.ObjectMeta.DeletionTimestamp.IsZero() {
+		// ingress class of other controllers, or not managed by flexlb any more
+		if !handlers.IsFlexLBIngressClass(&class) {
+			if _, managed := class.Annotations[handlers.InstanceKey]; !managed {
+				return ctrl.Result{}, nil
+			}
+		}
+
+		// set finalizer if not set
+		if utils.SetFinalizer(&class.ObjectMeta) {
+			log.Log.Info("set finalizer ", "controller", "IngressClassReconciler", "object", req.NamespacedName)
+			if err := r.Update(ctx, &class); err != nil {
+				log.Log.Info("set finalizer failed", "controller", "IngressClassReconciler", "object", req.NamespacedName)
+				return ctrl.Result{}, err
+			}
+		}
+
+		// process change request, requeue while backends are draining
+		requeueAfter, err := r.ChangeHandler(r.Client, ctx, &class)
+		return handlerResult(ctrl.Result{RequeueAfter: requeueAfter}, err, "controller", "IngressClassReconciler", "object", req.NamespacedName)
+
+	} else {
+		// process delete request
+		if err := r.DeleteHandler(r.Client, ctx, &class); err != nil {
+			return handlerResult(ctrl.Result{}, err, "controller", "IngressClassReconciler", "object", req.NamespacedName)
+		}
+
+		// unset finalizer if set
+		if utils.UnsetFinalizer(&class.ObjectMeta) {
+			log.Log.Info("unset finalizer ", "controller", "IngressClassReconciler", "object", req.NamespacedName)
+			if err := r.Update(ctx, &class); err != nil {
+				log.Log.Info("unset finalizer failed", "controller", "IngressClassReconciler", "object", req.NamespacedName)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *IngressClassReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// node traffic ip, labels or readiness changed, or node deleted, re-render all flexlb ingress classes
+	nodePredicate := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return nodeBackendChanged(e.ObjectOld.(*v1.Node), e.ObjectNew.(*v1.Node))
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return true
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&networkingv1.IngressClass{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles, RateLimiter: newRateLimiter()}).
+		// old and new ingress class both re-rendered on update
+		Watches(&source.Kind{Type: &networkingv1.Ingress{}},
+			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+				ing, ok := obj.(*networkingv1.Ingress)
+				if !ok {
+					return []reconcile.Request{}
+				}
+				return ingressClassRequests([]string{handlers.IngressClassOfIngress(r.Client, context.TODO(), ing)})
+			})).
+		Watches(&source.Kind{Type: &disv1.EndpointSlice{}},
+			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+				svcName, exist := obj.GetLabels()[disv1.LabelServiceName]
+				if !exist {
+					return []reconcile.Request{}
+				}
+				return ingressClassRequests(handlers.IngressClassesOfService(r.Client, context.TODO(), obj.GetNamespace(), svcName))
+			})).
+		Watches(&source.Kind{Type: &v1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+				return r.flexlbIngressClasses()
+			}), builder.WithPredicates(nodePredicate)).
+		Complete(r)
+}
+
+// all ingress classes controlled by flexlb
+func (r *IngressClassReconciler) flexlbIngressClasses() []reconcile.Request {
+	classNames := []string{}
+	classes := &networkingv1.IngressClassList{}
+	if err := r.List(context.TODO(), classes); err != nil {
+		return []reconcile.Request{}
+	}
+	for i := 0; i < len(classes.Items); i++ {
+		if handlers.IsFlexLBIngressClass(&classes.Items[i]) {
+			classNames = append(classNames, classes.Items[i].Name)
+		}
+	}
+	return ingressClassRequests(classNames)
+}
+
+// reconcile requests of ingress classes, empty names are skipped
+func ingressClassRequests(classNames []string) []reconcile.Request {
+	requests := []reconcile.Request{}
+	for _, className := range classNames {
+		if className != "" {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: className}})
+		}
+	}
+	return requests
+}
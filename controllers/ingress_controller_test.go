@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// name of the instance allocated to ingress class, empty if not allocated yet
+func ingressClassInstanceName(className string) func() string {
+	return func() string {
+		class := &networkingv1.IngressClass{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: className}, class); err != nil {
+			return ""
+		}
+		return class.Annotations[handlers.InstanceKey]
+	}
+}
+
+// load balancer ip of ingress, empty if not set
+func ingressIp(namespace string, name string) func() string {
+	return func() string {
+		ing := &networkingv1.Ingress{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ing); err != nil ||
+			len(ing.Status.LoadBalancer.Ingress) == 0 {
+			return ""
+		}
+		return ing.Status.LoadBalancer.Ingress[0].IP
+	}
+}
+
+var _ = Describe("Ingress controller", func() {
+	const namespace = "ingress-test"
+	const className = "flexlb"
+
+	BeforeEach(func() {
+		fakeServer.Reset()
+		ensureCluster()
+		ensureNodes()
+		ensureNamespace(namespace)
+		class := &networkingv1.IngressClass{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: className}, class); apierrors.IsNotFound(err) {
+			class = &networkingv1.IngressClass{
+				ObjectMeta: metav1.ObjectMeta{Name: className},
+				Spec:       networkingv1.IngressClassSpec{Controller: handlers.IngressControllerName},
+			}
+			Expect(k8sClient.Create(context.TODO(), class)).To(Succeed())
+		}
+	})
+
+	It("renders the ingresses of the class to a http endpoint", func() {
+		// ingress backends are the node ports of the service
+		svc := createServiceOfType(v1.ServiceTypeNodePort, namespace, "web", nil, 8000, "node-1", "node-2")
+		pathType := networkingv1.PathTypePrefix
+		ingClassName := className
+		ing := &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace},
+			Spec: networkingv1.IngressSpec{
+				IngressClassName: &ingClassName,
+				Rules: []networkingv1.IngressRule{{
+					Host: "www.example.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{
+							Path:     "/",
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+								Name: svc.Name,
+								Port: networkingv1.ServiceBackendPort{Number: 8000},
+							}},
+						}},
+					}},
+				}},
+			},
+		}
+		Expect(k8sClient.Create(context.TODO(), ing)).To(Succeed())
+
+		By("creating the instance with the node port backends")
+		Eventually(ingressClassInstanceName(className), timeout, interval).ShouldNot(BeEmpty())
+		name := ingressClassInstanceName(className)()
+		Eventually(flexlbFrontendPorts(name), timeout, interval).Should(ConsistOf(handlers.IngressHTTPPort))
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(ConsistOf(testNodes["node-1"], testNodes["node-2"]))
+		Expect(fakeServer.Instance(name).Config.Endpoints[0].BackendOptions).To(ContainElement(
+			"acl r0_host req.hdr(host),field(1,:) -i www.example.com"))
+
+		By("reporting the frontend ip on the ingress")
+		Eventually(ingressIp(namespace, ing.Name), timeout, interval).Should(WithTransform(ipValue,
+			SatisfyAll(BeNumerically(">=", ipValue(ippoolStart)), BeNumerically("<=", ipValue(ippoolEnd)))))
+
+		By("deleting the instance with the last ingress of the class")
+		Expect(k8sClient.Delete(context.TODO(), ing)).To(Succeed())
+		Eventually(ingressClassInstanceName(className), timeout, interval).Should(BeEmpty())
+		Eventually(func() bool { return fakeServer.Instance(name) == nil }, timeout, interval).Should(BeTrue())
+	})
+})
@@ -2,23 +2,35 @@ package controllers
 
 import (
 	"context"
+	"time"
 
 	"github.com/flexlet/flexlb-kube-controller/handlers"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // FlexLBClusterReconciler reconciles a FlexLBCluster object
 type NodeReconciler struct {
 	client.Client
-	Scheme        *runtime.Scheme
-	ChangeHandler func(client.Client, context.Context, *v1.Node) error
+	Scheme                  *runtime.Scheme
+	MaxConcurrentReconciles int
+	Namespace               string
+	// nil if node network is reported by agent
+	ChangeHandler func(client.Client, context.Context, *v1.Node) (time.Duration, error)
+	DeleteHandler func(client.Client, context.Context, *v1.Node) error
 }
 
 //+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;update;patch
@@ -30,22 +42,49 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 
 	var node v1.Node
 	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
+		if apierrors.IsNotFound(err) {
+			// node deleted, cleanup
+			err := r.DeleteHandler(r.Client, ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: req.Name}})
+			return handlerResult(ctrl.Result{}, err, "controller", "NodeReconciler", "object", req.NamespacedName)
+		}
 		return ctrl.Result{}, nil
 	}
 
-	return ctrl.Result{}, r.ChangeHandler(r.Client, ctx, &node)
+	if r.ChangeHandler == nil {
+		return ctrl.Result{}, nil
+	}
+	requeueAfter, err := r.ChangeHandler(r.Client, ctx, &node)
+	return handlerResult(ctrl.Result{RequeueAfter: requeueAfter}, err, "controller", "NodeReconciler", "object", req.NamespacedName)
 }
 
 func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	p := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
 			// new create, add traffic node ip annotation
-			return true
+			return r.ChangeHandler != nil
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
 			annotations := e.ObjectNew.GetAnnotations()
 			_, exist := annotations[handlers.NodeNetworkKey]
-			return !exist
+			return r.ChangeHandler != nil && !exist
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			// node deleted, cleanup probe pod and report instances
+			return true
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+
+	// probe pod terminated, harvest the result
+	probePodPredicate := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			pod := e.ObjectNew.(*v1.Pod)
+			return r.ChangeHandler != nil && isProbePod(pod, r.Namespace) && (pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed)
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
 			return false
@@ -54,7 +93,22 @@ func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			return false
 		},
 	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1.Node{}, builder.WithPredicates(p)).
+		Watches(&source.Kind{Type: &v1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+				pod, ok := obj.(*v1.Pod)
+				if !ok || pod.Spec.NodeName == "" {
+					return []reconcile.Request{}
+				}
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: pod.Spec.NodeName}}}
+			}), builder.WithPredicates(probePodPredicate)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles, RateLimiter: newRateLimiter()}).
 		Complete(r)
 }
+
+// check whether pod is a node probe pod
+func isProbePod(pod *v1.Pod, namespace string) bool {
+	return pod.Namespace == namespace && pod.Labels[handlers.ProbePodLabel] == "true"
+}
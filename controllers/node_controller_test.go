@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// probe pod of node, nil if not exist
+func probePod(nodeName string) func() *v1.Pod {
+	return func() *v1.Pod {
+		pod := &v1.Pod{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: "flexlb-node-probe-" + nodeName, Namespace: flexlbNamespace}, pod); err != nil {
+			return nil
+		}
+		return pod
+	}
+}
+
+// uid of probe pod of node, empty if not exist
+func probePodUID(nodeName string) func() types.UID {
+	return func() types.UID {
+		if pod := probePod(nodeName)(); pod != nil {
+			return pod.UID
+		}
+		return ""
+	}
+}
+
+// terminate probe pod of node with phase, as the kubelet would
+func terminateProbePod(nodeName string, phase v1.PodPhase) {
+	Eventually(func() error {
+		pod := probePod(nodeName)()
+		if pod == nil {
+			return apierrors.NewNotFound(v1.Resource("pods"), nodeName)
+		}
+		pod.Status.Phase = phase
+		return k8sClient.Status().Update(context.TODO(), pod)
+	}, timeout, interval).Should(Succeed())
+}
+
+// set node cordoned or not, retried on conflicts
+func cordonNode(name string, unschedulable bool) {
+	Eventually(func() error {
+		node := &v1.Node{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name}, node); err != nil {
+			return err
+		}
+		node.Spec.Unschedulable = unschedulable
+		return k8sClient.Update(context.TODO(), node)
+	}, timeout, interval).Should(Succeed())
+}
+
+// options of the backend servers with ip of the first endpoint of instance on the fake server
+func flexlbBackendOptions(name string, ip string) func() []string {
+	return func() []string {
+		options := []string{}
+		inst := fakeServer.Instance(name)
+		if inst == nil || len(inst.Config.Endpoints) == 0 {
+			return options
+		}
+		for _, server := range inst.Config.Endpoints[0].BackendServers {
+			if server.Ipaddress == ip && server.Options != nil {
+				options = append(options, *server.Options)
+			} else if server.Ipaddress == ip {
+				options = append(options, "")
+			}
+		}
+		return options
+	}
+}
+
+// draining nodes of instance status
+func instanceDraining(namespace string, name string) func() []string {
+	return func() []string {
+		nodes := []string{}
+		inst := &crdv1.FlexLBInstance{}
+		if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, inst); err != nil {
+			return nodes
+		}
+		for _, d := range inst.Status.Draining {
+			nodes = append(nodes, d.Node)
+		}
+		return nodes
+	}
+}
+
+// reasons of events of instance
+func instanceEventReasons(namespace string, name string) func() []string {
+	return func() []string {
+		reasons := []string{}
+		events := &v1.EventList{}
+		if err := k8sClient.List(context.TODO(), events, client.InNamespace(namespace)); err != nil {
+			return reasons
+		}
+		for _, e := range events.Items {
+			if e.InvolvedObject.Kind == "FlexLBInstance" && e.InvolvedObject.Name == name {
+				reasons = append(reasons, e.Reason)
+			}
+		}
+		return reasons
+	}
+}
+
+var _ = Describe("Node controller", func() {
+	const namespace = "node-test"
+
+	BeforeEach(func() {
+		fakeServer.Reset()
+		ensureCluster()
+		ensureNodes()
+		ensureNamespace(namespace)
+	})
+
+	It("probes the network of a node with a probe pod", func() {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "probe-node"}}
+		Expect(k8sClient.Create(context.TODO(), node)).To(Succeed())
+		defer k8sClient.Delete(context.TODO(), node)
+
+		Eventually(probePod(node.Name), timeout, interval).ShouldNot(BeNil())
+		pod := probePod(node.Name)()
+		Expect(pod.Spec.NodeName).To(Equal(node.Name))
+		Expect(pod.Spec.HostNetwork).To(BeTrue())
+		Expect(pod.Labels[handlers.ProbePodLabel]).To(Equal("true"))
+
+		By("replacing the probe pod when it failed")
+		terminateProbePod(node.Name, v1.PodFailed)
+		Eventually(probePodUID(node.Name), timeout, interval).ShouldNot(Or(BeEmpty(), Equal(pod.UID)))
+
+		By("removing the probe pod once the node network is known")
+		latest := &v1.Node{}
+		Expect(k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(node), latest)).To(Succeed())
+		patch := client.MergeFrom(latest.DeepCopy())
+		latest.Annotations = map[string]string{handlers.NodeNetworkKey: `[{"network":"10.0.0.0/24","device":"eth0","ip_address":"10.0.0.9"}]`}
+		Expect(k8sClient.Patch(context.TODO(), latest, patch)).To(Succeed())
+		terminateProbePod(node.Name, v1.PodFailed)
+		Eventually(probePod(node.Name), timeout, interval).Should(BeNil())
+	})
+	It("drains the backends of a cordoned node before removing them", func() {
+		svc := createService(namespace, "drain", 80, "node-1", "node-2")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(ConsistOf(testNodes["node-1"], testNodes["node-2"]))
+
+		By("keeping the backends with weight 0 for the grace period")
+		cordonNode("node-2", true)
+		defer cordonNode("node-2", false)
+		Eventually(flexlbBackendOptions(name, testNodes["node-2"]), timeout, interval).Should(ConsistOf("weight 0"))
+		Eventually(instanceDraining(namespace, name), timeout, interval).Should(ConsistOf("node-2"))
+
+		By("removing the backends after the grace period")
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(ConsistOf(testNodes["node-1"]))
+		Eventually(instanceDraining(namespace, name), timeout, interval).Should(ConsistOf("node-2"))
+
+		By("serving again when the node is uncordoned")
+		cordonNode("node-2", false)
+		Eventually(flexlbBackendOptions(name, testNodes["node-2"]), timeout, interval).Should(ConsistOf(""))
+		Eventually(instanceDraining(namespace, name), timeout, interval).Should(BeEmpty())
+	})
+	It("re-renders instances when a backend node is deleted", func() {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-3"}}
+		Expect(k8sClient.Create(context.TODO(), node)).To(Succeed())
+		node.Status = v1.NodeStatus{
+			Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.3"}},
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		}
+		Expect(k8sClient.Status().Update(context.TODO(), node)).To(Succeed())
+		Eventually(probePod(node.Name), timeout, interval).ShouldNot(BeNil())
+
+		svc := createService(namespace, "deleted-node", 80, "node-1", "node-3")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(ConsistOf(testNodes["node-1"], "10.0.0.3"))
+
+		By("draining the backends of the deleted node")
+		Expect(k8sClient.Delete(context.TODO(), node)).To(Succeed())
+		Eventually(probePod(node.Name), timeout, interval).Should(BeNil())
+		Eventually(instanceEventReasons(namespace, name), timeout, interval).Should(ContainElement(handlers.BackendNodeDeleted))
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(ConsistOf(testNodes["node-1"]))
+	})
+})
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// retry policies of handler errors by error class: transient errors and conflicts are retried with per object
+// exponential backoff, auth errors (tls, credentials) slowly, invalid config and not found errors wait for a
+// change of the object and are checked again rarely
+const (
+	backoffBase            = time.Second
+	backoffMax             = 5 * time.Minute
+	authRetryInterval      = 5 * time.Minute
+	permanentRetryInterval = 30 * time.Minute
+)
+
+// per object exponential backoff, overall 10 qps with burst of 100
+func newRateLimiter() ratelimiter.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(backoffBase, backoffMax),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// reconcile result of handler error, result kept on success
+func handlerResult(result ctrl.Result, err error, kvs ...interface{}) (ctrl.Result, error) {
+	if err == nil {
+		return result, nil
+	}
+
+	var retryAfter time.Duration
+	class := handlers.ErrorClass(err)
+	switch class {
+	case crdv1.ErrorClassAuth:
+		retryAfter = authRetryInterval
+	case crdv1.ErrorClassInvalidConfig, crdv1.ErrorClassNotFound:
+		retryAfter = permanentRetryInterval
+	default:
+		// backoff of rate limiter
+		return ctrl.Result{}, err
+	}
+
+	// not returned, the backoff of object is reset
+	log.Log.Error(err, "reconcile failed", append(kvs, "class", class, "retryAfter", retryAfter)...)
+	if result.RequeueAfter > 0 && result.RequeueAfter < retryAfter {
+		retryAfter = result.RequeueAfter
+	}
+	return ctrl.Result{RequeueAfter: retryAfter}, nil
+}
+
+// record the last handler error in instance status, cleared on success
+func recordInstanceError(k8s client.Client, ctx context.Context, key types.NamespacedName, err error) {
+	if e := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1.FlexLBInstance{}
+		if err := k8s.Get(ctx, key, latest); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if err == nil && latest.Status.LastError == nil {
+			return nil
+		}
+		latest.Status.LastError = handlers.NewErrorStatus(err, latest.Status.LastError)
+		return k8s.Status().Update(ctx, latest)
+	}); e != nil {
+		log.Log.Info("record instance error failed", "object", key, "error", e.Error())
+	}
+}
+
+// record the last handler error in cluster status, cleared when the cluster is ready
+func recordClusterError(k8s client.Client, ctx context.Context, key types.NamespacedName, err error) {
+	if err == nil {
+		return
+	}
+	if e := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1.FlexLBCluster{}
+		if err := k8s.Get(ctx, key, latest); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		latest.Status.LastError = handlers.NewErrorStatus(err, latest.Status.LastError)
+		return k8s.Status().Update(ctx, latest)
+	}); e != nil {
+		log.Log.Info("record cluster error failed", "object", key, "error", e.Error())
+	}
+}
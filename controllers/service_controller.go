@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"context"
+	"time"
 
 	"github.com/flexlet/flexlb-kube-controller/handlers"
 	"github.com/flexlet/flexlb-kube-controller/utils"
+	"github.com/google/go-cmp/cmp"
 	v1 "k8s.io/api/core/v1"
 	disv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -12,6 +14,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -23,9 +26,12 @@ import (
 // FlexLBClusterReconciler reconciles a FlexLBCluster object
 type ServiceReconciler struct {
 	client.Client
-	Scheme        *runtime.Scheme
-	ChangeHandler func(client.Client, context.Context, *v1.Service) error
-	DeleteHandler func(client.Client, context.Context, *v1.Service) error
+	Scheme                  *runtime.Scheme
+	MaxConcurrentReconciles int
+	ChangeHandler           func(client.Client, context.Context, *v1.Service) (time.Duration, error)
+	DeleteHandler           func(client.Client, context.Context, *v1.Service) error
+	// services of the class are reconciled, services without class if empty
+	LoadBalancerClass string
 }
 
 //+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;update;patch;
@@ -52,15 +58,14 @@ func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			}
 		}
 
-		// process change request
-		if err := r.ChangeHandler(r.Client, ctx, &service); err != nil {
-			return ctrl.Result{}, err
-		}
+		// process change request, requeue while backends are draining
+		requeueAfter, err := r.ChangeHandler(r.Client, ctx, &service)
+		return handlerResult(ctrl.Result{RequeueAfter: requeueAfter}, err, "controller", "ServiceReconciler", "object", req.NamespacedName)
 
 	} else {
 		// process delete request
 		if err := r.DeleteHandler(r.Client, ctx, &service); err != nil {
-			return ctrl.Result{}, err
+			return handlerResult(ctrl.Result{}, err, "controller", "ServiceReconciler", "object", req.NamespacedName)
 		}
 
 		// unset finalizer if set
@@ -79,25 +84,41 @@ func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	p := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
 			svc := e.Object.(*v1.Service)
-			return needReconcile(svc, r.Client)
+			return r.needReconcile(svc)
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
 			old := e.ObjectOld.(*v1.Service)
 			new := e.ObjectNew.(*v1.Service)
 			// if old one is balancer but new one is not, need to delete instance
-			return needReconcile(old, r.Client) || needReconcile(new, r.Client)
+			return r.needReconcile(old) || r.needReconcile(new)
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
 			svc := e.Object.(*v1.Service)
-			return needReconcile(svc, r.Client)
+			return r.needReconcile(svc)
 		},
 		GenericFunc: func(e event.GenericEvent) bool {
 			svc := e.Object.(*v1.Service)
-			return needReconcile(svc, r.Client)
+			return r.needReconcile(svc)
+		},
+	}
+	// node traffic ip, labels or readiness changed, or node deleted, re-render services with backends on the node
+	nodePredicate := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return nodeBackendChanged(e.ObjectOld.(*v1.Node), e.ObjectNew.(*v1.Node))
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return true
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
 		},
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1.Service{}, builder.WithPredicates(p)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles, RateLimiter: newRateLimiter()}).
 		Watches(&source.Kind{Type: &disv1.EndpointSlice{}},
 			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
 				epSlice, ok := obj.(*disv1.EndpointSlice)
@@ -105,22 +126,26 @@ func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 					return []reconcile.Request{}
 				}
 				service, err := utils.GetServiceOfEndpointSlice(r.Client, context.TODO(), epSlice)
-				if err != nil || service.Spec.Type != v1.ServiceTypeLoadBalancer {
+				if err != nil || !r.isLoadBalancer(service) {
 					return []reconcile.Request{}
 				}
 				return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: service.Namespace, Name: service.Name}}}
 			})).
+		Watches(&source.Kind{Type: &v1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+				return r.servicesOfNode(obj.GetName())
+			}), builder.WithPredicates(nodePredicate)).
 		Complete(r)
 }
 
 // check whether service need reconcile
-func needReconcile(svc *v1.Service, k8s client.Client) bool {
-	if svc.Spec.Type != v1.ServiceTypeLoadBalancer {
-		// not balancer type
+func (r *ServiceReconciler) needReconcile(svc *v1.Service) bool {
+	if !r.isLoadBalancer(svc) {
+		// not balancer type, or balancer of another class
 		return false
 	}
 
-	if _, err := utils.GetEndpointSliceOfService(k8s, context.TODO(), svc); err != nil {
+	if _, err := utils.GetEndpointSliceOfService(r.Client, context.TODO(), svc); err != nil {
 		// no endpoint slice
 		return false
 	}
@@ -136,3 +161,56 @@ func needReconcile(svc *v1.Service, k8s client.Client) bool {
 	// if it's managed by flexlb, go to reconciler
 	return byFlexlb
 }
+
+// load balancer service of the class
+func (r *ServiceReconciler) isLoadBalancer(svc *v1.Service) bool {
+	return svc.Spec.Type == v1.ServiceTypeLoadBalancer && handlers.IsLoadBalancerClass(svc, r.LoadBalancerClass)
+}
+
+// load balancer services with endpoints on the node, or with instance backends on the node
+func (r *ServiceReconciler) servicesOfNode(nodeName string) []reconcile.Request {
+	requests := []reconcile.Request{}
+	services, err := utils.GetServicesOfNode(r.Client, context.TODO(), nodeName)
+	if err != nil {
+		return requests
+	}
+	if insts, err := handlers.GetInstancesOfNode(r.Client, context.TODO(), nodeName); err == nil {
+		for _, inst := range insts {
+			for _, svcName := range handlers.GetInstanceServices(&inst) {
+				key := types.NamespacedName{Namespace: inst.Namespace, Name: svcName}
+				if !containsKey(services, key) {
+					services = append(services, key)
+				}
+			}
+		}
+	}
+	for _, key := range services {
+		service := &v1.Service{}
+		if err := r.Get(context.TODO(), key, service); err != nil || !r.isLoadBalancer(service) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: key})
+	}
+	return requests
+}
+
+func containsKey(keys []types.NamespacedName, key types.NamespacedName) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// check whether node traffic ip sources or backend eligibility changed
+func nodeBackendChanged(old *v1.Node, new *v1.Node) bool {
+	return old.Annotations[handlers.NodeNetworkKey] != new.Annotations[handlers.NodeNetworkKey] ||
+		old.Annotations[handlers.TrafficIPKey] != new.Annotations[handlers.TrafficIPKey] ||
+		old.Annotations[handlers.DrainKey] != new.Annotations[handlers.DrainKey] ||
+		!cmp.Equal(old.Labels, new.Labels) ||
+		old.Spec.Unschedulable != new.Spec.Unschedulable ||
+		old.DeletionTimestamp.IsZero() != new.DeletionTimestamp.IsZero() ||
+		handlers.NodeReady(old) != handlers.NodeReady(new) ||
+		!cmp.Equal(old.Status.Addresses, new.Status.Addresses)
+}
@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+	"github.com/flexlet/flexlb-kube-controller/test/fakeflexlb"
+)
+
+var _ = Describe("Service controller", func() {
+	const namespace = "service-test"
+
+	BeforeEach(func() {
+		fakeServer.Reset()
+		ensureCluster()
+		ensureNodes()
+		ensureNamespace(namespace)
+	})
+
+	It("allocates a vip and creates the instance on flexlb", func() {
+		svc := createService(namespace, "web", 80, "node-1", "node-2")
+
+		By("allocating the vip from the ippool")
+		Eventually(func() string {
+			latest := &v1.Service{}
+			k8sClient.Get(context.TODO(), types.NamespacedName{Name: svc.Name, Namespace: namespace}, latest)
+			if len(latest.Status.LoadBalancer.Ingress) == 0 {
+				return ""
+			}
+			return latest.Status.LoadBalancer.Ingress[0].IP
+		}, timeout, interval).Should(WithTransform(ipValue, SatisfyAll(
+			BeNumerically(">=", ipValue(ippoolStart)), BeNumerically("<=", ipValue(ippoolEnd)))))
+
+		By("pushing the instance to flexlb")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(ConsistOf(testNodes["node-1"], testNodes["node-2"]))
+
+		latest := &v1.Service{}
+		Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Name: svc.Name, Namespace: namespace}, latest)).To(Succeed())
+		ep := fakeServer.Instance(name).Config.Endpoints[0]
+		Expect(ep.FrontendPort).To(BeEquivalentTo(80))
+		Expect(ep.BackendServers[0].Port).To(BeEquivalentTo(latest.Spec.Ports[0].NodePort))
+
+		By("reporting the instance ready")
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseReady))
+	})
+
+	It("updates backends when endpoints change", func() {
+		svc := createService(namespace, "api", 8080, "node-1", "node-2")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(HaveLen(2))
+
+		setServiceNodes(svc, "node-2")
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(ConsistOf(testNodes["node-2"]))
+	})
+
+	It("retries creating the instance when flexlb fails", func() {
+		fakeServer.Fail(fakeflexlb.OpCreate, 500)
+		svc := createService(namespace, "retry", 80, "node-1")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseCreateFailed))
+		Expect(fakeServer.Instance(name)).To(BeNil())
+
+		fakeServer.Fail(fakeflexlb.OpCreate, 0)
+		Eventually(func() bool { return fakeServer.Instance(name) != nil }, timeout, interval).Should(BeTrue())
+		Eventually(instancePhase(namespace, name), timeout, interval).Should(Equal(crdv1.InstancePhaseReady))
+	})
+
+	It("deletes the instance from flexlb when the service is deleted", func() {
+		svc := createService(namespace, "gone", 80, "node-1")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(func() bool { return fakeServer.Instance(name) != nil }, timeout, interval).Should(BeTrue())
+
+		Expect(k8sClient.Delete(context.TODO(), svc)).To(Succeed())
+		Eventually(func() bool {
+			err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, &crdv1.FlexLBInstance{})
+			return apierrors.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+		Eventually(func() bool { return fakeServer.Instance(name) == nil }, timeout, interval).Should(BeTrue())
+	})
+
+	It("shares one instance between services with the same sharing key", func() {
+		sharing := map[string]string{handlers.SharingKey: "shared"}
+		web := createAnnotatedService(namespace, "shared-web", sharing, 80, "node-1")
+		api := createAnnotatedService(namespace, "shared-api", sharing, 8080, "node-2")
+
+		By("merging both services onto one instance, also when created together")
+		Eventually(func() bool {
+			name := serviceInstanceName(web)()
+			return name != "" && serviceInstanceName(api)() == name
+		}, timeout, interval).Should(BeTrue())
+		name := serviceInstanceName(web)()
+		Eventually(flexlbFrontendPorts(name), timeout, interval).Should(ConsistOf(80, 8080))
+		Eventually(flexlbBackendIps(name), timeout, interval).Should(ConsistOf(testNodes["node-1"]))
+		Eventually(func() int {
+			insts := &crdv1.FlexLBInstanceList{}
+			k8sClient.List(context.TODO(), insts, client.InNamespace(namespace), client.MatchingLabels{handlers.SharingKey: "shared"})
+			return len(insts.Items)
+		}, timeout, interval).Should(Equal(1))
+
+		By("not allocating a service with a conflicting port")
+		conflict := createAnnotatedService(namespace, "shared-conflict", sharing, 80, "node-1")
+		Consistently(serviceInstanceName(conflict), 5*time.Second, interval).Should(BeEmpty())
+		Expect(k8sClient.Delete(context.TODO(), conflict)).To(Succeed())
+
+		By("keeping the instance until the last service is deleted")
+		Expect(k8sClient.Delete(context.TODO(), web)).To(Succeed())
+		Eventually(flexlbFrontendPorts(name), timeout, interval).Should(ConsistOf(8080))
+		Expect(k8sClient.Delete(context.TODO(), api)).To(Succeed())
+		Eventually(func() bool { return fakeServer.Instance(name) == nil }, timeout, interval).Should(BeTrue())
+	})
+
+	It("terminates tls with the certificate of the secret", func() {
+		cert := createTLSSecret(namespace, "secure-tls", "secure.example.com")
+		svc := createAnnotatedService(namespace, "secure", map[string]string{handlers.TLSSecretKey: "secure-tls"}, 443, "node-1")
+
+		By("pushing the certificate to flexlb, not to the instance")
+		Eventually(serviceInstanceName(svc), timeout, interval).ShouldNot(BeEmpty())
+		name := serviceInstanceName(svc)()
+		Eventually(flexlbServerCert(name), timeout, interval).Should(Equal(cert))
+		Expect(*fakeServer.Instance(name).Config.Endpoints[0].FrontendOptions).To(ContainSubstring("ssl"))
+		inst := &crdv1.FlexLBInstance{}
+		Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, inst)).To(Succeed())
+		Expect(inst.Spec.Config.Endpoints[0].FrontendSslOptions).To(BeNil())
+
+		By("reporting the certificate in the instance status")
+		Eventually(func() []crdv1.CertificateStatus {
+			latest := &crdv1.FlexLBInstance{}
+			k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, latest)
+			return latest.Status.Certificates
+		}, timeout, interval).Should(ConsistOf(WithTransform(func(c crdv1.CertificateStatus) string { return c.Secret },
+			Equal(namespace+"/secure-tls"))))
+
+		By("pushing the rotated certificate")
+		rotated := rotateTLSSecret(namespace, "secure-tls", "secure.example.com")
+		Eventually(flexlbServerCert(name), timeout, interval).Should(Equal(rotated))
+	})
+
+	It("allocates the vip from a cluster of the service namespace", func() {
+		const tenant = "tenant-a"
+		ensureNamespace(tenant)
+		ensureClusterIn(tenant, "own", tenantStart, tenantEnd)
+
+		svc := createService(tenant, "web", 80, "node-1")
+		annotateService(svc, handlers.ClusterKey, tenant+"/own")
+
+		Eventually(func() string {
+			latest := &v1.Service{}
+			k8sClient.Get(context.TODO(), types.NamespacedName{Name: svc.Name, Namespace: tenant}, latest)
+			if len(latest.Status.LoadBalancer.Ingress) == 0 {
+				return ""
+			}
+			return latest.Status.LoadBalancer.Ingress[0].IP
+		}, timeout, interval).Should(WithTransform(ipValue, SatisfyAll(
+			BeNumerically(">=", ipValue(tenantStart)), BeNumerically("<=", ipValue(tenantEnd)))))
+
+		name := serviceInstanceName(svc)()
+		inst := &crdv1.FlexLBInstance{}
+		Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: tenant}, inst)).To(Succeed())
+		Expect(inst.Labels).To(HaveKeyWithValue(handlers.ClusterKey, "own"))
+		Expect(inst.Labels).To(HaveKeyWithValue(handlers.ClusterNamespaceKey, tenant))
+	})
+
+	It("allocates no vip of an ippool not allowed to the service namespace", func() {
+		const tenant = "tenant-b"
+		ensureNamespace(tenant)
+		cluster := ensureClusterIn(tenant, "restricted", tenantStart, tenantEnd)
+		Eventually(func() error {
+			latest := &crdv1.FlexLBCluster{}
+			if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: cluster.Name, Namespace: tenant}, latest); err != nil {
+				return err
+			}
+			latest.Spec.IPPools[0].AllowedNamespaces = []string{"tenant-c"}
+			return k8sClient.Update(context.TODO(), latest)
+		}, timeout, interval).Should(Succeed())
+
+		svc := createAnnotatedService(tenant, "web", map[string]string{handlers.ClusterKey: tenant + "/restricted"}, 80, "node-1")
+		Consistently(serviceInstanceName(svc), 5*time.Second, interval).Should(BeEmpty())
+		Eventually(serviceWarnings(svc), timeout, interval).Should(ContainElement(handlers.ErrorIPPoolAccessDenied))
+	})
+
+	It("allocates no vip of a cluster of another namespace without the use verb granted", func() {
+		const tenant, other = "tenant-d", "tenant-a"
+		ensureNamespace(tenant)
+		ensureNamespace(other)
+		ensureClusterIn(other, "own", tenantStart, tenantEnd)
+
+		// the subject access review of group system:serviceaccounts:tenant-d is denied without rbac bindings
+		svc := createAnnotatedService(tenant, "web", map[string]string{handlers.ClusterKey: other + "/own"}, 80, "node-1")
+		Consistently(serviceInstanceName(svc), 5*time.Second, interval).Should(BeEmpty())
+		Eventually(serviceWarnings(svc), timeout, interval).Should(ContainElement(handlers.ErrorClusterAccessDenied))
+	})
+
+	It("allocates no more vips than the quota of the namespace", func() {
+		const tenant = "tenant-e"
+		ensureNamespace(tenant)
+		cluster := ensureClusterIn(tenant, "quota", tenantStart, tenantEnd)
+		Eventually(func() error {
+			latest := &crdv1.FlexLBCluster{}
+			if err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: cluster.Name, Namespace: tenant}, latest); err != nil {
+				return err
+			}
+			latest.Spec.IPPools[0].NamespaceQuota = 1
+			return k8sClient.Update(context.TODO(), latest)
+		}, timeout, interval).Should(Succeed())
+
+		// created together, the second is counted against the instance of the first before it is in the cache
+		annotations := map[string]string{handlers.ClusterKey: tenant + "/quota"}
+		web := createAnnotatedService(tenant, "web", annotations, 80, "node-1")
+		api := createAnnotatedService(tenant, "api", annotations, 8080, "node-2")
+		allocated := func() int {
+			n := 0
+			for _, svc := range []*v1.Service{web, api} {
+				if serviceInstanceName(svc)() != "" {
+					n++
+				}
+			}
+			return n
+		}
+		Eventually(allocated, timeout, interval).Should(Equal(1))
+		Consistently(allocated, 5*time.Second, interval).Should(Equal(1))
+
+		insts := &crdv1.FlexLBInstanceList{}
+		Expect(k8sClient.List(context.TODO(), insts, client.InNamespace(tenant))).To(Succeed())
+		Expect(insts.Items).To(HaveLen(1))
+
+		denied := api
+		if serviceInstanceName(web)() == "" {
+			denied = web
+		}
+		Eventually(serviceWarnings(denied), timeout, interval).Should(ContainElement(handlers.ErrorIPPoolQuotaExceeded))
+	})
+})
+
+// comparable value of ipv4 address, 0 if invalid
+func ipValue(ip string) uint32 {
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return 0
+	}
+	return uint32(parsed[0])<<24 | uint32(parsed[1])<<16 | uint32(parsed[2])<<8 | uint32(parsed[3])
+}
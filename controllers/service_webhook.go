@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	v1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// path of the service validating webhook
+const ServiceWebhookPath = "/validate-v1-service"
+
+// ServiceValidator validates load balancer services on admission
+type ServiceValidator struct {
+	client.Client
+	// validates the service, and the service before the update, nil on create
+	ValidateHandler func(client.Client, context.Context, *v1.Service, *v1.Service) error
+	decoder         *admission.Decoder
+}
+
+//+kubebuilder:webhook:path=/validate-v1-service,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=services,verbs=create;update,versions=v1,name=vservice.flexlb.flexlet.io,admissionReviewVersions=v1
+
+func (v *ServiceValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	svc := &v1.Service{}
+	if err := v.decoder.Decode(req, svc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var old *v1.Service
+	if len(req.OldObject.Raw) > 0 {
+		old = &v1.Service{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	err := v.ValidateHandler(v.Client, ctx, svc, old)
+	if err == nil {
+		return admission.Allowed("")
+	}
+	// errors reading the cluster state do not block the service, the reconcile checks again
+	if class := handlers.ErrorClass(err); class == crdv1.ErrorClassTransient || class == crdv1.ErrorClassNotFound {
+		log.FromContext(ctx).Error(err, "validate service failed", "service", req.Name, "namespace", req.Namespace)
+		return admission.Allowed("").WithWarnings("flexlb: " + err.Error())
+	}
+	return admission.Denied("flexlb: " + err.Error())
+}
+
+// SetupWebhookWithManager registers the webhook on the webhook server of the Manager.
+func (v *ServiceValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+	v.decoder = decoder
+	mgr.GetWebhookServer().Register(ServiceWebhookPath, &webhook.Admission{Handler: v})
+	return nil
+}
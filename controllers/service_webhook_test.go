@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+	"github.com/flexlet/flexlb-kube-controller/utils"
+)
+
+var _ = Describe("Service webhook", func() {
+	const tenant, other = "tenant-d", "tenant-a"
+	var validator *ServiceValidator
+
+	BeforeEach(func() {
+		ensureCluster()
+		ensureNamespace(tenant)
+		ensureNamespace(other)
+		ensureClusterIn(other, "own", tenantStart, tenantEnd)
+
+		handler := handlers.NewHandler("", "", "", true, flexlbNamespace, record.NewFakeRecorder(100))
+		handler.SetClusterNamespaces([]string{handlers.AllNamespaces})
+		decoder, err := admission.NewDecoder(scheme.Scheme)
+		Expect(err).NotTo(HaveOccurred())
+		validator = &ServiceValidator{Client: k8sClient, ValidateHandler: handler.ValidateService, decoder: decoder}
+	})
+
+	// load balancer service of the tenant namespace using the cluster
+	service := func(clusterRef string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: tenant, Annotations: map[string]string{handlers.ClusterKey: clusterRef}},
+			Spec: v1.ServiceSpec{
+				Type:  v1.ServiceTypeLoadBalancer,
+				Ports: []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 80}},
+			},
+		}
+	}
+	raw := func(svc *v1.Service) runtime.RawExtension {
+		data, err := json.Marshal(svc)
+		Expect(err).NotTo(HaveOccurred())
+		return runtime.RawExtension{Raw: data}
+	}
+	request := func(operation admissionv1.Operation, svc *v1.Service, old *v1.Service) admission.Request {
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: operation,
+			Name:      svc.Name,
+			Namespace: svc.Namespace,
+			Object:    raw(svc),
+		}}
+		if old != nil {
+			req.OldObject = raw(old)
+		}
+		return req
+	}
+
+	It("allows a service of a cluster of the flexlb namespace", func() {
+		resp := validator.Handle(context.TODO(), request(admissionv1.Create, service(handlers.DefaultClusterName), nil))
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("denies a service of a cluster of another namespace without the use verb granted", func() {
+		resp := validator.Handle(context.TODO(), request(admissionv1.Create, service(other+"/own"), nil))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(ContainSubstring("not allowed to use cluster"))
+	})
+
+	It("denies an update moving the service to a cluster not granted", func() {
+		old := service(handlers.DefaultClusterName)
+		resp := validator.Handle(context.TODO(), request(admissionv1.Update, service(other+"/own"), old))
+		Expect(resp.Allowed).To(BeFalse())
+	})
+
+	It("allows updates of the controller leaving cluster, ippool and sharing key unchanged", func() {
+		// the controller removes its annotations and finalizer of a service created before the access was revoked
+		old := service(other + "/own")
+		old.Annotations[handlers.InstanceKey] = "web-1a2b"
+		utils.SetFinalizer(&old.ObjectMeta)
+		updated := service(other + "/own")
+		resp := validator.Handle(context.TODO(), request(admissionv1.Update, updated, old))
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("allows deleted services", func() {
+		deleted := service(other + "/own")
+		now := metav1.Now()
+		deleted.DeletionTimestamp = &now
+		resp := validator.Handle(context.TODO(), request(admissionv1.Update, deleted, service(handlers.DefaultClusterName)))
+		Expect(resp.Allowed).To(BeTrue())
+	})
+})
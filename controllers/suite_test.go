@@ -1,13 +1,22 @@
 package controllers
 
 import (
+	"context"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
@@ -15,15 +24,32 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+	"github.com/flexlet/flexlb-kube-controller/test/fakeflexlb"
 	//+kubebuilder:scaffold:imports
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
 // http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
 
+// the controllers run against envtest and the fake flexlb api server
+
+const (
+	flexlbNamespace = "flexlb-system"
+
+	apiTimeout      = 2 * time.Second
+	refreshInterval = 2 * time.Second
+	drainGrace      = 5 * time.Second
+	timeout         = 60 * time.Second
+	interval        = 250 * time.Millisecond
+)
+
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var fakeServer *fakeflexlb.Server
+var cancel context.CancelFunc
+var kubeconfigDir string
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -38,11 +64,12 @@ var _ = BeforeSuite(func() {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases"), filepath.Join("..", "test", "crds")},
 		ErrorIfCRDPathMissing: true,
 	}
 
-	cfg, err := testEnv.Start()
+	var err error
+	cfg, err = testEnv.Start()
 	Expect(err).NotTo(HaveOccurred())
 	Expect(cfg).NotTo(BeNil())
 
@@ -55,10 +82,135 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	// probe pod logs are read with the config loaded by the handler itself
+	kubeconfigDir, err = os.MkdirTemp("", "flexlb-envtest-")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(writeKubeconfig(cfg, filepath.Join(kubeconfigDir, "kubeconfig"))).To(Succeed())
+
+	By("starting fake flexlb api server")
+	fakeServer = fakeflexlb.NewServer("flexlb-1", "flexlb-2")
+	Expect(k8sClient.Create(context.TODO(), &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: flexlbNamespace}})).To(Succeed())
+
+	By("starting controllers")
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+		NewCache: cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{&v1.Secret{}: SecretCacheSelector()},
+		}),
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	handler := handlers.NewHandler("", "", "", true, flexlbNamespace, mgr.GetEventRecorderFor("flexlb-handler"))
+	handler.Reconfigure(handlers.Settings{
+		ProbeTimeout:      time.Minute,
+		APITimeout:        apiTimeout,
+		DrainGrace:        drainGrace,
+		CertExpiryWarning: 30 * 24 * time.Hour,
+	})
+	handler.SetClusterNamespaces([]string{handlers.AllNamespaces})
+	handler.SetSecretReader(mgr.GetAPIReader())
+
+	Expect((&FlexLBClusterReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: 1,
+		Namespace:               flexlbNamespace,
+		Namespaces:              []string{handlers.AllNamespaces},
+		ChangeHandler:           handler.ClusterChanged,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	Expect((&FlexLBInstanceReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: 2,
+		RefreshInterval:         refreshInterval,
+		ChangeHandler:           handler.InstanceChanged,
+		DeleteHandler:           handler.InstanceDeleted,
+		RefreshPolicy:           handler.InstanceRefreshPolicy,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	// node traffic ips of the default ippool are taken from node addresses, probe pods are never scheduled
+	Expect((&NodeReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: 1,
+		Namespace:               flexlbNamespace,
+		ChangeHandler:           handler.NodeChanged,
+		DeleteHandler:           handler.NodeDeleted,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	Expect((&ServiceReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: 2,
+		ChangeHandler:           handler.ServiceChanged,
+		DeleteHandler:           handler.ServiceDeleted,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	Expect((&GatewayClassReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: 1,
+		ChangeHandler:           handler.GatewayClassChanged,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	Expect((&GatewayReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: 1,
+		ChangeHandler:           handler.GatewayChanged,
+		DeleteHandler:           handler.GatewayDeleted,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	Expect((&IngressClassReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: 1,
+		ChangeHandler:           handler.IngressClassChanged,
+		DeleteHandler:           handler.IngressClassDeleted,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.TODO())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	if cancel != nil {
+		cancel()
+	}
+	if fakeServer != nil {
+		fakeServer.Close()
+	}
+	if kubeconfigDir != "" {
+		os.RemoveAll(kubeconfigDir)
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+// write kubeconfig of the test environment and point KUBECONFIG to it
+func writeKubeconfig(cfg *rest.Config, path string) error {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["envtest"] = &clientcmdapi.Cluster{
+		Server:                   cfg.Host,
+		CertificateAuthorityData: cfg.CAData,
+	}
+	kubeconfig.AuthInfos["envtest"] = &clientcmdapi.AuthInfo{
+		ClientCertificateData: cfg.CertData,
+		ClientKeyData:         cfg.KeyData,
+		Token:                 cfg.BearerToken,
+	}
+	kubeconfig.Contexts["envtest"] = &clientcmdapi.Context{Cluster: "envtest", AuthInfo: "envtest"}
+	kubeconfig.CurrentContext = "envtest"
+	if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+		return err
+	}
+	return os.Setenv("KUBECONFIG", path)
+}
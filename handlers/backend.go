@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"context"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// LoadBalancerBackend manages the load balancer instances of a FlexLB cluster
+type LoadBalancerBackend interface {
+	// ready status of the load balancer nodes, example: {node1: ready, node2: ready}
+	ReadyStatus(ctx context.Context) (models.ReadyStatus, error)
+	ListInstances(ctx context.Context) ([]*models.Instance, error)
+	GetInstance(ctx context.Context, name string) (*models.Instance, error)
+	CreateInstance(ctx context.Context, cfg *models.InstanceConfig) (*models.Instance, error)
+	ModifyInstance(ctx context.Context, cfg *models.InstanceConfig) (*models.Instance, error)
+	DeleteInstance(ctx context.Context, name string) error
+}
+
+// BackendFactory connects the backend of cluster
+type BackendFactory func(cluster *crdv1.FlexLBCluster) (LoadBalancerBackend, error)
+
+// replace the backend factory, default: flexlb api client
+func (h *Handler) SetBackendFactory(factory BackendFactory) {
+	h.newBackend = factory
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// rbac verb on flexlbclusters granting the service accounts of a namespace the use of a cluster of another namespace
+const ClusterUseVerb = "use"
+
+// all namespaces in the cluster namespaces
+const AllNamespaces = "*"
+
+// how long cluster access reviews are cached
+const clusterAccessTTL = time.Minute
+
+// ParseClusterRef parses cluster reference "<namespace>/<name>", or "<name>" of a cluster in the default namespace
+func ParseClusterRef(ref string, defaultNamespace string) (types.NamespacedName, error) {
+	key := types.NamespacedName{Namespace: defaultNamespace, Name: ref}
+	if i := strings.Index(ref, "/"); i >= 0 {
+		key = types.NamespacedName{Namespace: ref[:i], Name: ref[i+1:]}
+	}
+	if key.Namespace == "" || key.Name == "" || strings.Contains(key.Name, "/") {
+		return key, fmt.Errorf("invalid cluster reference '%s', expected '<name>' or '<namespace>/<name>'", ref)
+	}
+	return key, nil
+}
+
+// InNamespaces checks whether namespace is one of namespaces, or namespaces contain "*"
+func InNamespaces(namespaces []string, namespace string) bool {
+	for _, ns := range namespaces {
+		if ns == namespace || ns == AllNamespaces {
+			return true
+		}
+	}
+	return false
+}
+
+// watch clusters of the namespaces besides the flexlb namespace, "*" for all namespaces, must be set before start
+func (h *Handler) SetClusterNamespaces(namespaces []string) {
+	h.clusterNamespaces = namespaces
+}
+
+// key of cluster reference, the namespace must be watched
+func (h *Handler) clusterKey(ref string) (types.NamespacedName, error) {
+	key, err := ParseClusterRef(ref, h.namespace)
+	if err != nil {
+		return key, classError(crdv1.ErrorClassInvalidConfig, err)
+	}
+	if key.Namespace != h.namespace && !InNamespaces(h.clusterNamespaces, key.Namespace) {
+		return key, classError(crdv1.ErrorClassInvalidConfig, fmt.Errorf("namespace of cluster '%s' is not watched", ref))
+	}
+	return key, nil
+}
+
+// reference of cluster, the name of clusters in the flexlb namespace
+func (h *Handler) clusterRef(key types.NamespacedName) string {
+	if key.Namespace == h.namespace {
+		return key.Name
+	}
+	return key.Namespace + "/" + key.Name
+}
+
+// get cluster by reference
+func (h *Handler) getCluster(k8s client.Client, ctx context.Context, ref string) (*crdv1.FlexLBCluster, error) {
+	key, err := h.clusterKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &crdv1.FlexLBCluster{}
+	if err := k8s.Get(ctx, key, cluster); err != nil {
+		return nil, fmt.Errorf("cluster '%s' does not exist", ref)
+	}
+	return cluster, nil
+}
+
+// set cluster and ippool labels of instance, clusters outside the flexlb namespace are labeled with their namespace
+func (h *Handler) setClusterLabels(instLabels map[string]string, key types.NamespacedName, ippoolName string) {
+	instLabels[ClusterKey] = key.Name
+	instLabels[IPPoolKey] = ippoolName
+	if key.Namespace == h.namespace {
+		delete(instLabels, ClusterNamespaceKey)
+	} else {
+		instLabels[ClusterNamespaceKey] = key.Namespace
+	}
+}
+
+// selector of instances of cluster ippool
+func (h *Handler) clusterSelector(key types.NamespacedName, ippoolName string) client.MatchingLabelsSelector {
+	return client.MatchingLabelsSelector{Selector: ClusterInstanceSelector(key, ippoolName, h.namespace)}
+}
+
+// ClusterInstanceSelector selects instances of cluster, of all ippools if ippool name is empty.
+// instances of clusters in the flexlb namespace have no cluster namespace label
+func ClusterInstanceSelector(key types.NamespacedName, ippoolName string, flexlbNamespace string) labels.Selector {
+	selector := labels.NewSelector()
+	add := func(k string, op selection.Operator, values ...string) {
+		req, _ := labels.NewRequirement(k, op, values)
+		selector = selector.Add(*req)
+	}
+	add(ClusterKey, selection.Equals, key.Name)
+	if ippoolName != "" {
+		add(IPPoolKey, selection.Equals, ippoolName)
+	}
+	if key.Namespace == flexlbNamespace {
+		add(ClusterNamespaceKey, selection.DoesNotExist)
+	} else {
+		add(ClusterNamespaceKey, selection.Equals, key.Namespace)
+	}
+	return selector
+}
+
+// cached results of cluster access reviews
+type clusterAccessCache struct {
+	lock    sync.Mutex
+	reviews map[string]clusterAccess
+}
+
+type clusterAccess struct {
+	allowed bool
+	reason  string
+	expires time.Time
+}
+
+// check whether objects of namespace may use the cluster: clusters of the flexlb namespace and of the same namespace
+// are allowed, others require verb "use" on the cluster granted to group "system:serviceaccounts:<namespace>"
+func (h *Handler) checkClusterAccess(k8s client.Client, ctx context.Context, key types.NamespacedName, namespace string) error {
+	if key.Namespace == h.namespace || key.Namespace == namespace {
+		return nil
+	}
+
+	cacheKey := namespace + "/" + key.String()
+	h.access.lock.Lock()
+	access, exist := h.access.reviews[cacheKey]
+	h.access.lock.Unlock()
+
+	if !exist || time.Now().After(access.expires) {
+		review := &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				Groups: []string{"system:serviceaccounts:" + namespace},
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: key.Namespace,
+					Verb:      ClusterUseVerb,
+					Group:     crdv1.GroupVersion.Group,
+					Resource:  "flexlbclusters",
+					Name:      key.Name,
+				},
+			},
+		}
+		if err := k8s.Create(ctx, review); err != nil {
+			return fmt.Errorf("review access of namespace '%s' to cluster '%s' failed: %w", namespace, key, err)
+		}
+		access = clusterAccess{allowed: review.Status.Allowed, reason: review.Status.Reason, expires: time.Now().Add(clusterAccessTTL)}
+
+		h.access.lock.Lock()
+		if h.access.reviews == nil {
+			h.access.reviews = map[string]clusterAccess{}
+		}
+		h.access.reviews[cacheKey] = access
+		h.access.lock.Unlock()
+	}
+
+	if !access.allowed {
+		err := fmt.Errorf("namespace '%s' is not allowed to use cluster '%s': verb '%s' on flexlbclusters not granted to group 'system:serviceaccounts:%s'",
+			namespace, key, ClusterUseVerb, namespace)
+		if access.reason != "" {
+			err = fmt.Errorf("%w: %s", err, access.reason)
+		}
+		return classError(crdv1.ErrorClassAuth, err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// client answering subject access reviews of the allowed namespaces
+type reviewClient struct {
+	client.Client
+	allowed map[string]bool
+	reviews int
+}
+
+func (c *reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if review, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+		c.reviews++
+		for _, group := range review.Spec.Groups {
+			review.Status.Allowed = review.Status.Allowed || c.allowed[group]
+		}
+		if !review.Status.Allowed {
+			review.Status.Reason = "no rbac binding"
+		}
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestParseClusterRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    types.NamespacedName
+		wantErr bool
+	}{
+		{ref: "edge", want: types.NamespacedName{Namespace: testNamespace, Name: "edge"}},
+		{ref: "tenant-a/edge", want: types.NamespacedName{Namespace: "tenant-a", Name: "edge"}},
+		{ref: "", wantErr: true},
+		{ref: "/edge", wantErr: true},
+		{ref: "tenant-a/", wantErr: true},
+		{ref: "tenant-a/edge/1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := ParseClusterRef(tt.ref, testNamespace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseClusterRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseClusterRef() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInNamespaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		namespace  string
+		want       bool
+	}{
+		{name: "no namespaces", namespace: "tenant-a", want: false},
+		{name: "listed", namespaces: []string{"tenant-a", "tenant-b"}, namespace: "tenant-b", want: true},
+		{name: "not listed", namespaces: []string{"tenant-a"}, namespace: "tenant-b", want: false},
+		{name: "all namespaces", namespaces: []string{AllNamespaces}, namespace: "tenant-b", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InNamespaces(tt.namespaces, tt.namespace); got != tt.want {
+				t.Errorf("InNamespaces() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClusterKey(t *testing.T) {
+	h, _ := newTestHandler()
+	h.SetClusterNamespaces([]string{"tenant-a"})
+	tests := []struct {
+		ref       string
+		want      types.NamespacedName
+		wantClass string
+	}{
+		{ref: "edge", want: types.NamespacedName{Namespace: testNamespace, Name: "edge"}},
+		{ref: "tenant-a/edge", want: types.NamespacedName{Namespace: "tenant-a", Name: "edge"}},
+		{ref: "tenant-b/edge", wantClass: crdv1.ErrorClassInvalidConfig},
+		{ref: "tenant-a/", wantClass: crdv1.ErrorClassInvalidConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := h.clusterKey(tt.ref)
+			if tt.wantClass != "" {
+				if err == nil || ErrorClass(err) != tt.wantClass {
+					t.Fatalf("clusterKey() error = %v, want class %q", err, tt.wantClass)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("clusterKey() = %v, %v, want %v", got, err, tt.want)
+			}
+			if ref := h.clusterRef(got); ref != tt.ref {
+				t.Errorf("clusterRef() = %q, want %q", ref, tt.ref)
+			}
+		})
+	}
+}
+
+func TestClusterInstanceSelector(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    types.NamespacedName
+		ippool string
+		labels labels.Set
+		want   bool
+	}{
+		{
+			name:   "cluster of the flexlb namespace",
+			key:    types.NamespacedName{Namespace: testNamespace, Name: "default"},
+			ippool: "default",
+			labels: labels.Set{ClusterKey: "default", IPPoolKey: "default"},
+			want:   true,
+		},
+		{
+			name:   "same name in a tenant namespace",
+			key:    types.NamespacedName{Namespace: testNamespace, Name: "default"},
+			labels: labels.Set{ClusterKey: "default", IPPoolKey: "default", ClusterNamespaceKey: "tenant-a"},
+			want:   false,
+		},
+		{
+			name:   "tenant cluster, all ippools",
+			key:    types.NamespacedName{Namespace: "tenant-a", Name: "default"},
+			labels: labels.Set{ClusterKey: "default", IPPoolKey: "dmz", ClusterNamespaceKey: "tenant-a"},
+			want:   true,
+		},
+		{
+			name:   "other ippool",
+			key:    types.NamespacedName{Namespace: "tenant-a", Name: "default"},
+			ippool: "default",
+			labels: labels.Set{ClusterKey: "default", IPPoolKey: "dmz", ClusterNamespaceKey: "tenant-a"},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClusterInstanceSelector(tt.key, tt.ippool, testNamespace).Matches(tt.labels); got != tt.want {
+				t.Errorf("selector matches %v = %v, want %v", tt.labels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckClusterAccess(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandler()
+	k8s := &reviewClient{Client: newFakeClient(), allowed: map[string]bool{"system:serviceaccounts:tenant-a": true}}
+	edge := types.NamespacedName{Namespace: "tenant-x", Name: "edge"}
+
+	tests := []struct {
+		name      string
+		key       types.NamespacedName
+		namespace string
+		wantClass string
+	}{
+		{name: "cluster of the flexlb namespace", key: types.NamespacedName{Namespace: testNamespace, Name: "default"}, namespace: "tenant-b"},
+		{name: "cluster of the same namespace", key: edge, namespace: "tenant-x"},
+		{name: "granted", key: edge, namespace: "tenant-a"},
+		{name: "granted, cached", key: edge, namespace: "tenant-a"},
+		{name: "denied", key: edge, namespace: "tenant-b", wantClass: crdv1.ErrorClassAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.checkClusterAccess(k8s, ctx, tt.key, tt.namespace)
+			if tt.wantClass == "" && err != nil {
+				t.Fatalf("checkClusterAccess() error = %v", err)
+			}
+			if tt.wantClass != "" && (err == nil || ErrorClass(err) != tt.wantClass) {
+				t.Fatalf("checkClusterAccess() error = %v, want class %q", err, tt.wantClass)
+			}
+		})
+	}
+	if k8s.reviews != 2 {
+		t.Errorf("access reviews = %d, want 2, reviews of the same namespace are cached", k8s.reviews)
+	}
+}
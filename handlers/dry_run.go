@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	"github.com/prometheus/client_golang/prometheus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// dry run: writes to kubernetes are sent as server side dry run, writes to flexlb are skipped; the planned
+// actions are logged, counted and reported as events of the object (flexlb writes: of the cluster)
+
+// planned action targets
+const (
+	dryRunKubernetes = "kubernetes"
+	dryRunFlexLB     = "flexlb"
+)
+
+var plannedActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "flexlb_dry_run_planned_actions_total",
+	Help: "Number of actions planned in dry run mode, not applied",
+}, []string{"target", "action", "kind"})
+
+func init() {
+	metrics.Registry.MustRegister(plannedActions)
+}
+
+// record planned action
+func planAction(recorder record.EventRecorder, obj runtime.Object, target string, action string, kind string, name string, kvs ...interface{}) {
+	plannedActions.WithLabelValues(target, action, kind).Inc()
+	log.Log.Info("dry run planned", append([]interface{}{"target", target, "action", action, "kind", kind, "name", name}, kvs...)...)
+	recorder.Eventf(obj, v1.EventTypeNormal, DryRunPlanned, "dry run: %s would %s %s '%s'", target, action, kind, name)
+}
+
+// kubernetes client of dry run, reads are served as usual
+type dryRunClient struct {
+	client.Client
+	live     client.Client
+	recorder record.EventRecorder
+}
+
+func NewDryRunClient(k8s client.Client, recorder record.EventRecorder) client.Client {
+	return &dryRunClient{Client: client.NewDryRunClient(k8s), live: k8s, recorder: recorder}
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	// access reviews write nothing, the result is needed
+	if _, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+		return c.live.Create(ctx, obj, opts...)
+	}
+	c.plan("create", obj)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.changes(ctx, obj, false) {
+		c.plan("update", obj)
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.plan("patch", obj)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.plan("delete", obj)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	c.plan("delete all of", obj)
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) Status() client.StatusWriter {
+	return &dryRunStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+// kubernetes status writer of dry run
+type dryRunStatusWriter struct {
+	client.StatusWriter
+	client *dryRunClient
+}
+
+func (sw *dryRunStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if sw.client.changes(ctx, obj, true) {
+		sw.client.plan("update status of", obj)
+	}
+	return sw.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (sw *dryRunStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	sw.client.plan("patch status of", obj)
+	return sw.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *dryRunClient) plan(action string, obj client.Object) {
+	kind := "object"
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	name := obj.GetName()
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+
+	// ip allocation and config of instances, ip of services
+	kvs := []interface{}{}
+	switch o := obj.(type) {
+	case *crdv1.FlexLBInstance:
+		config, _ := json.Marshal(o.Spec.Config)
+		kvs = append(kvs, "ip", o.Spec.Config.FrontendIpaddress, "config", string(config))
+	case *v1.Service:
+		if len(o.Status.LoadBalancer.Ingress) > 0 {
+			kvs = append(kvs, "ip", o.Status.LoadBalancer.Ingress[0].IP)
+		}
+	}
+	planAction(c.recorder, obj, dryRunKubernetes, action, kind, name, kvs...)
+}
+
+// check whether the write changes the object (status, or labels, annotations and content other than status),
+// writes of unchanged objects, e.g. finalizers set again on every reconcile, are not reported
+func (c *dryRunClient) changes(ctx context.Context, obj client.Object, status bool) bool {
+	live, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return true
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil {
+		return true
+	}
+	return !equality.Semantic.DeepEqual(comparableContent(live, status), comparableContent(obj, status))
+}
+
+func comparableContent(obj client.Object, status bool) interface{} {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil
+	}
+	// unstructured objects return their own content
+	content = runtime.DeepCopyJSON(content)
+	if status {
+		return content["status"]
+	}
+	delete(content, "status")
+	if metadata, ok := content["metadata"].(map[string]interface{}); ok {
+		content["metadata"] = map[string]interface{}{"labels": metadata["labels"], "annotations": metadata["annotations"]}
+	}
+	return content
+}
+
+// flexlb backend of dry run, reads are served by the backend
+type dryRunBackend struct {
+	LoadBalancerBackend
+	cluster  *crdv1.FlexLBCluster
+	recorder record.EventRecorder
+}
+
+func (b *dryRunBackend) CreateInstance(ctx context.Context, cfg *models.InstanceConfig) (*models.Instance, error) {
+	config, _ := json.Marshal(cfg)
+	planAction(b.recorder, b.cluster, dryRunFlexLB, "create", "instance", cfg.Name, "config", string(config))
+	return &models.Instance{Config: cfg, Status: map[string]string{}}, nil
+}
+
+func (b *dryRunBackend) ModifyInstance(ctx context.Context, cfg *models.InstanceConfig) (*models.Instance, error) {
+	config, _ := json.Marshal(cfg)
+	planAction(b.recorder, b.cluster, dryRunFlexLB, "modify", "instance", cfg.Name, "config", string(config))
+	return &models.Instance{Config: cfg, Status: map[string]string{}}, nil
+}
+
+func (b *dryRunBackend) DeleteInstance(ctx context.Context, name string) error {
+	planAction(b.recorder, b.cluster, dryRunFlexLB, "delete", "instance", name)
+	return nil
+}
+
+// plan flexlb writes instead of applying them, the backend factory must be set before
+func (h *Handler) EnableDryRun() {
+	factory := h.newBackend
+	h.newBackend = func(cluster *crdv1.FlexLBCluster) (LoadBalancerBackend, error) {
+		lb, err := factory(cluster)
+		if err != nil {
+			return nil, err
+		}
+		return &dryRunBackend{LoadBalancerBackend: lb, cluster: cluster, recorder: h.recorder}, nil
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/test/fakeflexlb"
+)
+
+func TestDryRunClient(t *testing.T) {
+	ctx := context.Background()
+	svc := testService(map[string]string{}, servicePort(v1.ProtocolTCP, 80))
+	live := newFakeClient(svc.DeepCopy())
+	_, recorder := newTestHandler()
+	k8s := NewDryRunClient(live, recorder)
+
+	t.Run("create planned, not applied", func(t *testing.T) {
+		before := testutil.ToFloat64(plannedActions.WithLabelValues(dryRunKubernetes, "create", "ConfigMap"))
+		cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "planned", Namespace: "default"}}
+		if err := k8s.Create(ctx, cm); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		if err := live.Get(ctx, types.NamespacedName{Name: "planned", Namespace: "default"}, &v1.ConfigMap{}); !apierrors.IsNotFound(err) {
+			t.Errorf("config map created in dry run, get error = %v", err)
+		}
+		if got := testutil.ToFloat64(plannedActions.WithLabelValues(dryRunKubernetes, "create", "ConfigMap")); got != before+1 {
+			t.Errorf("planned create actions = %v, want %v", got, before+1)
+		}
+		events := recordedEvents(recorder)
+		if len(events) != 1 || !strings.Contains(events[0], "dry run: kubernetes would create ConfigMap 'default/planned'") {
+			t.Errorf("events = %v, want one planned create", events)
+		}
+	})
+
+	t.Run("unchanged update not reported", func(t *testing.T) {
+		latest := &v1.Service{}
+		if err := k8s.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, latest); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if err := k8s.Update(ctx, latest); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if err := k8s.Status().Update(ctx, latest); err != nil {
+			t.Fatalf("Status().Update() error = %v", err)
+		}
+		if events := recordedEvents(recorder); len(events) != 0 {
+			t.Errorf("events = %v, want none", events)
+		}
+	})
+
+	t.Run("changed update planned, not applied", func(t *testing.T) {
+		latest := &v1.Service{}
+		if err := k8s.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, latest); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		latest.Annotations = map[string]string{InstanceKey: "web-instance"}
+		if err := k8s.Update(ctx, latest); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		latest.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "192.168.100.10"}}
+		if err := k8s.Status().Update(ctx, latest); err != nil {
+			t.Fatalf("Status().Update() error = %v", err)
+		}
+
+		applied := &v1.Service{}
+		if err := live.Get(ctx, types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, applied); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if _, exist := applied.Annotations[InstanceKey]; exist || len(applied.Status.LoadBalancer.Ingress) != 0 {
+			t.Errorf("service updated in dry run: %v, %v", applied.Annotations, applied.Status.LoadBalancer.Ingress)
+		}
+		events := recordedEvents(recorder)
+		if len(events) != 2 || !strings.Contains(events[0], "would update Service 'default/web'") ||
+			!strings.Contains(events[1], "would update status of Service 'default/web'") {
+			t.Errorf("events = %v, want planned update and status update", events)
+		}
+	})
+}
+
+func TestDryRunBackend(t *testing.T) {
+	server := fakeflexlb.NewServer()
+	defer server.Close()
+	h, recorder := newTestHandler()
+	h.EnableDryRun()
+	cluster := &crdv1.FlexLBCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultClusterName, Namespace: testNamespace},
+		Spec:       crdv1.FlexLBClusterSpec{Endpoint: server.Endpoint()},
+	}
+	lb, err := h.newBackend(cluster)
+	if err != nil {
+		t.Fatalf("connect dry run backend failed: %s", err)
+	}
+	ctx := context.Background()
+
+	before := testutil.ToFloat64(plannedActions.WithLabelValues(dryRunFlexLB, "create", "instance"))
+	cfg := &models.InstanceConfig{Name: "web", FrontendIpaddress: "192.168.100.10"}
+	inst, err := lb.CreateInstance(ctx, cfg)
+	if err != nil || inst.Config != cfg {
+		t.Fatalf("CreateInstance() = %v, %v, want the planned config", inst, err)
+	}
+	if _, err := lb.ModifyInstance(ctx, cfg); err != nil {
+		t.Fatalf("ModifyInstance() error = %v", err)
+	}
+	if err := lb.DeleteInstance(ctx, "web"); err != nil {
+		t.Fatalf("DeleteInstance() error = %v", err)
+	}
+	if calls := server.Calls(fakeflexlb.OpCreate) + server.Calls(fakeflexlb.OpModify) + server.Calls(fakeflexlb.OpDelete); calls != 0 {
+		t.Errorf("flexlb writes in dry run = %d, want 0", calls)
+	}
+	if got := testutil.ToFloat64(plannedActions.WithLabelValues(dryRunFlexLB, "create", "instance")); got != before+1 {
+		t.Errorf("planned create actions = %v, want %v", got, before+1)
+	}
+
+	// reads are served by flexlb
+	if _, err := lb.ReadyStatus(ctx); err != nil || server.Calls(fakeflexlb.OpReadyz) != 1 {
+		t.Errorf("ReadyStatus() error = %v, readyz calls = %d, want served", err, server.Calls(fakeflexlb.OpReadyz))
+	}
+
+	events := recordedEvents(recorder)
+	want := []string{"would create instance 'web'", "would modify instance 'web'", "would delete instance 'web'"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %d planned actions", events, len(want))
+	}
+	for i := range want {
+		if !strings.Contains(events[i], DryRunPlanned) || !strings.Contains(events[i], want[i]) {
+			t.Errorf("event = %q, want %s %q", events[i], DryRunPlanned, want[i])
+		}
+	}
+}
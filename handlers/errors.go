@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// ReconcileError is a handler error with its class, which decides the retry policy of the controllers
+type ReconcileError struct {
+	Class  string
+	Reason string
+	msg    string
+	err    error
+}
+
+func (e *ReconcileError) Error() string {
+	return e.msg
+}
+
+func (e *ReconcileError) Unwrap() error {
+	return e.err
+}
+
+// error of class, wrapping err
+func classError(class string, err error) error {
+	return &ReconcileError{Class: class, msg: err.Error(), err: err}
+}
+
+// class of errors by reason; a missing ippool is transient, services are not requeued when it is created
+var reasonClasses = map[string]string{
+	ErrorInvalidConfig:   crdv1.ErrorClassInvalidConfig,
+	ErrorPortConflict:    crdv1.ErrorClassInvalidConfig,
+	ErrorNoIPPool:        crdv1.ErrorClassTransient,
+	ErrorBackendNotFound: crdv1.ErrorClassNotFound,
+}
+
+// status code of flexlb api errors, e.g. "[POST /instances][400] createBadRequest ..."
+var apiStatusCode = regexp.MustCompile(`^\[[A-Z]+ [^\]]*\]\[(\d{3})\]`)
+
+// class of error, transient if unknown
+func ErrorClass(err error) string {
+	var reconcileErr *ReconcileError
+	if errors.As(err, &reconcileErr) && reconcileErr.Class != "" {
+		return reconcileErr.Class
+	}
+
+	// kubernetes api errors
+	switch {
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return crdv1.ErrorClassConflict
+	case apierrors.IsNotFound(err):
+		return crdv1.ErrorClassNotFound
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return crdv1.ErrorClassInvalidConfig
+	case apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+		return crdv1.ErrorClassAuth
+	}
+
+	// tls errors
+	var unknownAuthority x509.UnknownAuthorityError
+	var certInvalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var recordHeader tls.RecordHeaderError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &certInvalid) || errors.As(err, &hostname) || errors.As(err, &recordHeader) {
+		return crdv1.ErrorClassAuth
+	}
+
+	// flexlb api errors
+	code := 0
+	var apiErr *runtime.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.Code
+	} else if m := apiStatusCode.FindStringSubmatch(rootError(err).Error()); m != nil {
+		code, _ = strconv.Atoi(m[1])
+	}
+	switch code {
+	case 400:
+		return crdv1.ErrorClassInvalidConfig
+	case 401, 403:
+		return crdv1.ErrorClassAuth
+	case 404:
+		return crdv1.ErrorClassNotFound
+	case 409:
+		return crdv1.ErrorClassConflict
+	}
+
+	// network errors, timeouts, server errors and everything else
+	return crdv1.ErrorClassTransient
+}
+
+// reason of handler error, empty if not reported by errorf
+func ErrorReason(err error) string {
+	var reconcileErr *ReconcileError
+	if errors.As(err, &reconcileErr) {
+		return reconcileErr.Reason
+	}
+	return ""
+}
+
+func rootError(err error) error {
+	for {
+		unwrapped := errors.Unwrap(err)
+		if unwrapped == nil {
+			return err
+		}
+		err = unwrapped
+	}
+}
+
+// error status of err, nil if err is nil; retries are counted while class and reason stay the same
+func NewErrorStatus(err error, last *crdv1.ErrorStatus) *crdv1.ErrorStatus {
+	if err == nil {
+		return nil
+	}
+	status := &crdv1.ErrorStatus{
+		Class:   ErrorClass(err),
+		Message: err.Error(),
+		Time:    metav1.Now(),
+		Retries: 1,
+	}
+	var reconcileErr *ReconcileError
+	if errors.As(err, &reconcileErr) {
+		status.Reason = reconcileErr.Reason
+	}
+	if last != nil && last.Class == status.Class && last.Reason == status.Reason {
+		status.Retries = last.Retries + 1
+	}
+	return status
+}
+
+// warning events of an object with the same reason and message are reported once per window,
+// the next report after the window carries the number of suppressed events
+const eventAggregationWindow = 10 * time.Minute
+
+type eventAggregator struct {
+	lock   sync.Mutex
+	events map[string]*aggregatedEvent
+}
+
+type aggregatedEvent struct {
+	message    string
+	reported   time.Time
+	suppressed int
+}
+
+// message to report, false if suppressed
+func (a *eventAggregator) aggregate(key string, message string, now time.Time) (string, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.events == nil {
+		a.events = map[string]*aggregatedEvent{}
+	}
+
+	if e, exist := a.events[key]; exist && e.message == message && now.Sub(e.reported) < eventAggregationWindow {
+		e.suppressed++
+		return "", false
+	}
+
+	// drop expired events of other objects, their suppressed counts are not reported
+	for k, e := range a.events {
+		if k != key && now.Sub(e.reported) >= eventAggregationWindow {
+			delete(a.events, k)
+		}
+	}
+
+	report := message
+	if e, exist := a.events[key]; exist && e.message == message && e.suppressed > 0 {
+		report = fmt.Sprintf("%s (repeated %d times in %s)", message, e.suppressed, now.Sub(e.reported).Round(time.Second))
+	}
+	a.events[key] = &aggregatedEvent{message: message, reported: now}
+	return report, true
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestErrorClass(t *testing.T) {
+	gr := schema.GroupResource{Resource: "services"}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "classified", err: classError(crdv1.ErrorClassAuth, errors.New("denied")), want: crdv1.ErrorClassAuth},
+		{name: "wrapped classified", err: fmt.Errorf("sync: %w", classError(crdv1.ErrorClassConflict, errors.New("taken"))), want: crdv1.ErrorClassConflict},
+		{name: "kubernetes conflict", err: apierrors.NewConflict(gr, "web", errors.New("modified")), want: crdv1.ErrorClassConflict},
+		{name: "kubernetes not found", err: apierrors.NewNotFound(gr, "web"), want: crdv1.ErrorClassNotFound},
+		{name: "kubernetes invalid", err: apierrors.NewBadRequest("invalid"), want: crdv1.ErrorClassInvalidConfig},
+		{name: "kubernetes forbidden", err: apierrors.NewForbidden(gr, "web", errors.New("rbac")), want: crdv1.ErrorClassAuth},
+		{name: "unknown authority", err: fmt.Errorf("connect: %w", x509.UnknownAuthorityError{}), want: crdv1.ErrorClassAuth},
+		{name: "flexlb api bad request", err: runtime.NewAPIError("createBadRequest", nil, 400), want: crdv1.ErrorClassInvalidConfig},
+		{name: "flexlb api not found message", err: errors.New("[GET /instances/{name}][404] getNotFound"), want: crdv1.ErrorClassNotFound},
+		{name: "flexlb api server error", err: errors.New("[POST /instances][500] createInternalServerError"), want: crdv1.ErrorClassTransient},
+		{name: "unknown", err: errors.New("connection reset by peer"), want: crdv1.ErrorClassTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorClass(tt.err); got != tt.want {
+				t.Errorf("ErrorClass() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	tests := []struct {
+		name      string
+		reason    string
+		err       error
+		wantClass string
+	}{
+		{name: "invalid config", reason: ErrorInvalidConfig, err: errors.New("bad port"), wantClass: crdv1.ErrorClassInvalidConfig},
+		{name: "missing ippool is transient", reason: ErrorNoIPPool, err: apierrors.NewNotFound(schema.GroupResource{Resource: "flexlbippools"}, "default"), wantClass: crdv1.ErrorClassTransient},
+		{name: "class of the error", reason: ErrorIPPoolAccessDenied, err: apierrors.NewForbidden(schema.GroupResource{}, "default", errors.New("denied")), wantClass: crdv1.ErrorClassAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, recorder := newTestHandler()
+			err := h.errorf(testService(nil), tt.reason, tt.err, "sync failed")
+			if ErrorClass(err) != tt.wantClass || ErrorReason(err) != tt.reason {
+				t.Errorf("errorf() class, reason = %q, %q, want %q, %q", ErrorClass(err), ErrorReason(err), tt.wantClass, tt.reason)
+			}
+			if events := recordedEvents(recorder); len(events) != 1 || !strings.Contains(events[0], tt.reason) {
+				t.Errorf("events = %v, want one %s warning", events, tt.reason)
+			}
+		})
+	}
+}
+
+func TestNewErrorStatus(t *testing.T) {
+	if status := NewErrorStatus(nil, nil); status != nil {
+		t.Errorf("NewErrorStatus(nil) = %+v, want nil", status)
+	}
+	h, _ := newTestHandler()
+	err := h.errorf(testService(nil), ErrorInvalidConfig, errors.New("bad port"), "invalid ports")
+	first := NewErrorStatus(err, nil)
+	if first.Class != crdv1.ErrorClassInvalidConfig || first.Reason != ErrorInvalidConfig || first.Retries != 1 {
+		t.Errorf("NewErrorStatus() = %+v, want invalid config, first retry", first)
+	}
+	if again := NewErrorStatus(err, first); again.Retries != 2 {
+		t.Errorf("retries of the same error = %d, want 2", again.Retries)
+	}
+	if other := NewErrorStatus(errors.New("timeout"), first); other.Retries != 1 {
+		t.Errorf("retries of another error = %d, want 1", other.Retries)
+	}
+}
+
+func TestEventAggregator(t *testing.T) {
+	a := &eventAggregator{}
+	now := time.Now()
+	steps := []struct {
+		key        string
+		message    string
+		at         time.Duration
+		want       string
+		wantReport bool
+	}{
+		{key: "web", message: "ippool does not exist", want: "ippool does not exist", wantReport: true},
+		{key: "web", message: "ippool does not exist", at: time.Minute},
+		{key: "web", message: "ippool does not exist", at: 2 * time.Minute},
+		{key: "api", message: "ippool does not exist", at: 2 * time.Minute, want: "ippool does not exist", wantReport: true},
+		{key: "web", message: "ippool does not exist", at: eventAggregationWindow, want: "ippool does not exist (repeated 2 times in 10m0s)", wantReport: true},
+		{key: "web", message: "ippool access denied", at: eventAggregationWindow + time.Second, want: "ippool access denied", wantReport: true},
+	}
+	for i, step := range steps {
+		got, report := a.aggregate(step.key, step.message, now.Add(step.at))
+		if got != step.want || report != step.wantReport {
+			t.Errorf("step %d: aggregate() = %q, %v, want %q, %v", i, got, report, step.want, step.wantReport)
+		}
+	}
+}
+
+func TestWarnf(t *testing.T) {
+	h, recorder := newTestHandler()
+	svc := testService(nil)
+	for i := 0; i < 3; i++ {
+		h.warnf(svc, ErrorInvalidConfig, "source ranges not enforced on udp ports: %s", "udp/53")
+		h.warnf(svc, ErrorInvalidConfig, "affinity cookie not set on non http ports: %s", "tcp/22")
+	}
+	events := recordedEvents(recorder)
+	if len(events) != 2 || !strings.Contains(events[0], "udp/53") || !strings.Contains(events[1], "tcp/22") {
+		t.Errorf("events = %v, want each warning reported once", events)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// the handlers are tested against the fake client of controller-runtime, events are kept by a fake recorder
+
+const testNamespace = "flexlb-system"
+
+// handler of the flexlb namespace with a fake recorder
+func newTestHandler() (*Handler, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(100)
+	h := NewHandler("", "", "", true, testNamespace, recorder)
+	h.Reconfigure(Settings{
+		ProbePodImage:     "busybox",
+		ProbeTimeout:      time.Minute,
+		APITimeout:        time.Second,
+		DrainGrace:        time.Second,
+		CertExpiryWarning: 30 * 24 * time.Hour,
+	})
+	return h, recorder
+}
+
+// fake client with the objects
+func newFakeClient(objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	clientgoscheme.AddToScheme(scheme)
+	crdv1.AddToScheme(scheme)
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+// events recorded so far
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+// load balancer service of namespace default with the annotations and ports
+func testService(annotations map[string]string, ports ...v1.ServicePort) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: annotations},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer, Ports: ports},
+	}
+}
+
+func servicePort(protocol v1.Protocol, port int32) v1.ServicePort {
+	return v1.ServicePort{Protocol: protocol, Port: port}
+}
+
+// default cluster of the flexlb namespace with the default ippool
+func testCluster(ippool crdv1.FlexLBIPPool) *crdv1.FlexLBCluster {
+	ippool.Name = DefaultIPPoolName
+	return &crdv1.FlexLBCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultClusterName, Namespace: testNamespace},
+		Spec:       crdv1.FlexLBClusterSpec{IPPools: []crdv1.FlexLBIPPool{ippool}},
+	}
+}
+
+// pem encoded self-signed certificate and key expiring at notAfter
+func selfSignedCert(host string, notAfter time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+}
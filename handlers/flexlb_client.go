@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+
+	flexlb "github.com/flexlet/flexlb-client-go/client"
+	"github.com/flexlet/flexlb-client-go/client/instance"
+	"github.com/flexlet/flexlb-client-go/client/service"
+	models "github.com/flexlet/flexlb-client-go/models"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// flexlb api client backend
+type flexlbBackend struct {
+	lb *flexlb.Flexlb
+}
+
+// connect flexlb api of cluster endpoint over tls
+func NewFlexLBBackend(endpoint string, tlsCaCert string, tlsClientCert string, tlsClientKey string, tlsInsecure bool) (LoadBalancerBackend, error) {
+	lb, err := flexlb.NewTLSClient(endpoint, tlsCaCert, tlsClientCert, tlsClientKey, tlsInsecure, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &flexlbBackend{lb: lb}, nil
+}
+
+// backend factory of flexlb api clients sharing the tls settings
+func FlexLBBackendFactory(tlsCaCert string, tlsClientCert string, tlsClientKey string, tlsInsecure bool) BackendFactory {
+	return func(cluster *crdv1.FlexLBCluster) (LoadBalancerBackend, error) {
+		return NewFlexLBBackend(cluster.Spec.Endpoint, tlsCaCert, tlsClientCert, tlsClientKey, tlsInsecure)
+	}
+}
+
+func (b *flexlbBackend) ReadyStatus(ctx context.Context) (models.ReadyStatus, error) {
+	resp, err := b.lb.Service.Readyz(service.NewReadyzParamsWithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+func (b *flexlbBackend) ListInstances(ctx context.Context) ([]*models.Instance, error) {
+	resp, err := b.lb.Instance.List(instance.NewListParamsWithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+func (b *flexlbBackend) GetInstance(ctx context.Context, name string) (*models.Instance, error) {
+	resp, err := b.lb.Instance.Get(instance.NewGetParamsWithContext(ctx).WithName(name))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+func (b *flexlbBackend) CreateInstance(ctx context.Context, cfg *models.InstanceConfig) (*models.Instance, error) {
+	resp, err := b.lb.Instance.Create(instance.NewCreateParamsWithContext(ctx).WithConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+func (b *flexlbBackend) ModifyInstance(ctx context.Context, cfg *models.InstanceConfig) (*models.Instance, error) {
+	resp, err := b.lb.Instance.Modify(instance.NewModifyParamsWithContext(ctx).WithConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload, nil
+}
+
+func (b *flexlbBackend) DeleteInstance(ctx context.Context, name string) error {
+	_, err := b.lb.Instance.Delete(instance.NewDeleteParamsWithContext(ctx).WithName(name))
+	return err
+}
+
+// backend calls bounded by the handler api timeout, so a hanging backend
+// does not stall the reconcile worker
+
+func (h *Handler) apiContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := h.currentSettings().APITimeout
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (h *Handler) getReadyStatus(ctx context.Context, lb LoadBalancerBackend) (models.ReadyStatus, error) {
+	ctx, cancel := h.apiContext(ctx)
+	defer cancel()
+	return lb.ReadyStatus(ctx)
+}
+
+func (h *Handler) getInstance(ctx context.Context, lb LoadBalancerBackend, name string) (*models.Instance, error) {
+	ctx, cancel := h.apiContext(ctx)
+	defer cancel()
+	return lb.GetInstance(ctx, name)
+}
+
+func (h *Handler) createInstance(ctx context.Context, lb LoadBalancerBackend, cfg *models.InstanceConfig) (*models.Instance, error) {
+	ctx, cancel := h.apiContext(ctx)
+	defer cancel()
+	return lb.CreateInstance(ctx, cfg)
+}
+
+func (h *Handler) modifyInstance(ctx context.Context, lb LoadBalancerBackend, cfg *models.InstanceConfig) (*models.Instance, error) {
+	ctx, cancel := h.apiContext(ctx)
+	defer cancel()
+	return lb.ModifyInstance(ctx, cfg)
+}
+
+func (h *Handler) deleteInstance(ctx context.Context, lb LoadBalancerBackend, name string) error {
+	ctx, cancel := h.apiContext(ctx)
+	defer cancel()
+	return lb.DeleteInstance(ctx, name)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/test/fakeflexlb"
+)
+
+func TestFlexLBBackend(t *testing.T) {
+	server := fakeflexlb.NewServer("flexlb-1", "flexlb-2")
+	defer server.Close()
+	lb, err := NewFlexLBBackend(server.Endpoint(), "", "", "", true)
+	if err != nil {
+		t.Fatalf("NewFlexLBBackend() error = %v", err)
+	}
+	ctx := context.Background()
+
+	status, err := lb.ReadyStatus(ctx)
+	if err != nil || len(status) != 2 || status["flexlb-1"] != fakeflexlb.NodeReady {
+		t.Fatalf("ReadyStatus() = %v, %v, want both nodes ready", status, err)
+	}
+
+	cfg := &models.InstanceConfig{Name: "web", FrontendIpaddress: "192.168.100.10", Endpoints: []*models.Endpoint{{FrontendPort: 80}}}
+	if _, err := lb.CreateInstance(ctx, cfg); err != nil {
+		t.Fatalf("CreateInstance() error = %v", err)
+	}
+	if _, err := lb.CreateInstance(ctx, cfg); err == nil {
+		t.Errorf("CreateInstance() of an existing instance error = nil")
+	}
+
+	inst, err := lb.GetInstance(ctx, "web")
+	if err != nil || inst.Config.FrontendIpaddress != cfg.FrontendIpaddress || inst.Status["flexlb-2"] != crdv1.InstanceStatusUp {
+		t.Fatalf("GetInstance() = %+v, %v, want the created instance up", inst, err)
+	}
+
+	cfg.Endpoints = append(cfg.Endpoints, &models.Endpoint{FrontendPort: 443})
+	if inst, err := lb.ModifyInstance(ctx, cfg); err != nil || len(inst.Config.Endpoints) != 2 {
+		t.Fatalf("ModifyInstance() = %+v, %v, want 2 endpoints", inst, err)
+	}
+
+	insts, err := lb.ListInstances(ctx)
+	if err != nil || len(insts) != 1 || insts[0].Config.Name != "web" {
+		t.Fatalf("ListInstances() = %v, %v, want instance web", insts, err)
+	}
+
+	if err := lb.DeleteInstance(ctx, "web"); err != nil {
+		t.Fatalf("DeleteInstance() error = %v", err)
+	}
+	if _, err := lb.GetInstance(ctx, "web"); err == nil {
+		t.Errorf("GetInstance() of a deleted instance error = nil")
+	}
+
+	server.Fail(fakeflexlb.OpReadyz, http.StatusInternalServerError)
+	if _, err := lb.ReadyStatus(ctx); err == nil {
+		t.Errorf("ReadyStatus() of a failing server error = nil")
+	}
+}
+
+func TestAPIContext(t *testing.T) {
+	server := fakeflexlb.NewServer()
+	defer server.Close()
+	lb, err := NewFlexLBBackend(server.Endpoint(), "", "", "", true)
+	if err != nil {
+		t.Fatalf("NewFlexLBBackend() error = %v", err)
+	}
+
+	h, _ := newTestHandler()
+	server.SetLatency(fakeflexlb.OpGet, 5*time.Second)
+	start := time.Now()
+	if _, err := h.getInstance(context.Background(), lb, "web"); err == nil {
+		t.Errorf("getInstance() of a hanging server error = nil")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("getInstance() returned after %s, want within the api timeout", elapsed)
+	}
+
+	settings := h.currentSettings()
+	settings.APITimeout = 0
+	h.Reconfigure(settings)
+	ctx, cancel := h.apiContext(context.Background())
+	defer cancel()
+	if _, exist := ctx.Deadline(); exist {
+		t.Errorf("apiContext() has a deadline without api timeout")
+	}
+}
+
+func TestConnectCluster(t *testing.T) {
+	server := fakeflexlb.NewServer("flexlb-1")
+	defer server.Close()
+	cluster := func() *crdv1.FlexLBCluster {
+		return &crdv1.FlexLBCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultClusterName, Namespace: testNamespace},
+			Spec:       crdv1.FlexLBClusterSpec{Endpoint: server.Endpoint()},
+		}
+	}
+	tests := []struct {
+		name       string
+		factory    BackendFactory
+		fail       bool
+		wantErr    bool
+		wantClass  string
+		wantStatus string
+	}{
+		{name: "ready", wantStatus: crdv1.ClusterStatusReady},
+		{name: "ready status failed", fail: true, wantErr: true, wantClass: crdv1.ErrorClassTransient, wantStatus: crdv1.ClusterStatusNotReady},
+		{
+			name: "connect failed",
+			factory: func(*crdv1.FlexLBCluster) (LoadBalancerBackend, error) {
+				return nil, errors.New("invalid client certificate")
+			},
+			wantErr:    true,
+			wantClass:  crdv1.ErrorClassAuth,
+			wantStatus: crdv1.ClusterStatusNotReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler()
+			if tt.factory != nil {
+				h.SetBackendFactory(tt.factory)
+			}
+			if tt.fail {
+				server.Fail(fakeflexlb.OpReadyz, http.StatusServiceUnavailable)
+				defer server.Fail(fakeflexlb.OpReadyz, 0)
+			}
+			k8s := newFakeClient(cluster())
+
+			lb, err := h.connectCluster(k8s, context.Background(), cluster())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("connectCluster() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && ErrorClass(err) != tt.wantClass {
+				t.Errorf("connectCluster() error class = %q, want %q", ErrorClass(err), tt.wantClass)
+			}
+			if !tt.wantErr && lb == nil {
+				t.Errorf("connectCluster() backend = nil")
+			}
+
+			latest := &crdv1.FlexLBCluster{}
+			if err := k8s.Get(context.Background(), types.NamespacedName{Name: DefaultClusterName, Namespace: testNamespace}, latest); err != nil {
+				t.Fatalf("get cluster failed: %s", err)
+			}
+			if latest.Status.ClusterStatus != tt.wantStatus {
+				t.Errorf("cluster status = %q, want %q", latest.Status.ClusterStatus, tt.wantStatus)
+			}
+			if tt.wantStatus == crdv1.ClusterStatusReady && latest.Status.NodeStatus["flexlb-1"] != fakeflexlb.NodeReady {
+				t.Errorf("cluster node status = %v, want flexlb-1 ready", latest.Status.NodeStatus)
+			}
+		})
+	}
+}
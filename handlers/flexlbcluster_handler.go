@@ -4,15 +4,18 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	flexlb "github.com/flexlet/flexlb-client-go/client"
 	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
 )
 
 func (h *Handler) ClusterChanged(k8s client.Client, ctx context.Context, cluster *crdv1.FlexLBCluster) error {
-	h.lock("update cluster", "cluster", cluster.Name, "handler", "ClusterChanged")
-	defer h.unlock("update cluster end", "cluster", cluster.Name, "handler", "ClusterChanged")
+	log.Log.Info("update cluster", "cluster", cluster.Name, "handler", "ClusterChanged")
+	defer log.Log.Info("update cluster end", "cluster", cluster.Name, "handler", "ClusterChanged")
 
 	if _, err := h.connectCluster(k8s, ctx, cluster); err != nil {
 		return h.errorf(cluster, ErrorClusterNotReady, err, "cluster not ready")
@@ -22,21 +25,47 @@ func (h *Handler) ClusterChanged(k8s client.Client, ctx context.Context, cluster
 }
 
 // connect cluster and refresh status
-func (h *Handler) connectCluster(k8s client.Client, ctx context.Context, cluster *crdv1.FlexLBCluster) (*flexlb.Flexlb, error) {
-	lb, err1 := flexlb.NewTLSClient(cluster.Spec.Endpoint, h.tlsCaCert, h.tlsClientCert, h.tlsClientKey, h.tlsInsecure, nil)
+func (h *Handler) connectCluster(k8s client.Client, ctx context.Context, cluster *crdv1.FlexLBCluster) (LoadBalancerBackend, error) {
+	lb, err1 := h.newBackend(cluster)
 	if err1 != nil {
-		cluster.Status = crdv1.FlexLBClusterStatus{ClusterStatus: crdv1.ClusterStatusNotReady}
-		k8s.Status().Update(ctx, cluster)
-		return nil, fmt.Errorf("cluster '%s/%s' connect failed: %s", cluster.Namespace, cluster.Name, err1.Error())
+		h.updateClusterStatus(k8s, ctx, cluster, crdv1.FlexLBClusterStatus{ClusterStatus: crdv1.ClusterStatusNotReady})
+		// tls material of the api client is invalid
+		return nil, classError(crdv1.ErrorClassAuth, fmt.Errorf("cluster '%s/%s' connect failed: %w", cluster.Namespace, cluster.Name, err1))
 	}
 
-	nodeStatus, err2 := lb.GetReadyStatus()
+	nodeStatus, err2 := h.getReadyStatus(ctx, lb)
 	if err2 != nil {
-		cluster.Status = crdv1.FlexLBClusterStatus{ClusterStatus: crdv1.ClusterStatusNotReady}
-		k8s.Status().Update(ctx, cluster)
-		return nil, fmt.Errorf("cluster '%s/%s' get node ready status failed: %s", cluster.Namespace, cluster.Name, err2.Error())
+		h.updateClusterStatus(k8s, ctx, cluster, crdv1.FlexLBClusterStatus{ClusterStatus: crdv1.ClusterStatusNotReady})
+		return nil, fmt.Errorf("cluster '%s/%s' get node ready status failed: %w", cluster.Namespace, cluster.Name, err2)
 	}
 
-	cluster.Status = crdv1.FlexLBClusterStatus{ClusterStatus: crdv1.ClusterStatusReady, NodeStatus: nodeStatus}
-	return lb, k8s.Status().Update(ctx, cluster)
+	return lb, h.updateClusterStatus(k8s, ctx, cluster, crdv1.FlexLBClusterStatus{ClusterStatus: crdv1.ClusterStatusReady, NodeStatus: nodeStatus})
+}
+
+// update cluster status if changed, many instances refresh the same cluster concurrently
+func (h *Handler) updateClusterStatus(k8s client.Client, ctx context.Context, cluster *crdv1.FlexLBCluster, status crdv1.FlexLBClusterStatus) error {
+	key := clusterLockKey(cluster.Namespace, cluster.Name)
+	h.locks.Lock(key)
+	defer h.locks.Unlock(key)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1.FlexLBCluster{}
+		if err := k8s.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name}, latest); err != nil {
+			return err
+		}
+		// keep the last error until ready, recorded by the controllers
+		if status.ClusterStatus != crdv1.ClusterStatusReady {
+			status.LastError = latest.Status.LastError
+		}
+		if cmp.Equal(latest.Status, status) {
+			cluster.Status = latest.Status
+			return nil
+		}
+		latest.Status = status
+		if err := k8s.Status().Update(ctx, latest); err != nil {
+			return err
+		}
+		latest.DeepCopyInto(cluster)
+		return nil
+	})
 }
@@ -6,6 +6,8 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -13,22 +15,55 @@ import (
 )
 
 func (h *Handler) InstanceChanged(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance) error {
-	h.lock("update instance", "handler", "InstanceChanged", "instance", instance.Name, "namespace", instance.Namespace)
-	defer h.unlock("update instance end", "handler", "InstanceChanged", "instance", instance.Name, "namespace", instance.Namespace)
+	h.lock(instanceLockKey(instance.Namespace, instance.Name), "update instance", "handler", "InstanceChanged", "instance", instance.Name, "namespace", instance.Namespace)
+	defer h.unlock(instanceLockKey(instance.Namespace, instance.Name), "update instance end", "handler", "InstanceChanged", "instance", instance.Name, "namespace", instance.Namespace)
 
-	// get service annotation
-	if svcName, exist := instance.Annotations[ServiceKey]; exist {
+	// get service annotation, services sharing the instance
+	if services := GetInstanceServices(instance); len(services) > 0 {
 		// check service exist or not
-		svc := &v1.Service{}
-		if err := k8s.Get(ctx, types.NamespacedName{Name: svcName, Namespace: instance.Namespace}, svc); err != nil {
+		exist := false
+		for _, svcName := range services {
+			svc := &v1.Service{}
+			if err := k8s.Get(ctx, types.NamespacedName{Name: svcName, Namespace: instance.Namespace}, svc); err == nil {
+				exist = true
+				break
+			}
+		}
+		if !exist {
 			// service not exist, delete instance
 			h.errorf(instance, ErrorInvalidConfig, nil, "instance deleted because invalid config: service not exist")
 			return k8s.Delete(ctx, instance)
 		}
 	}
 
+	// get gateway annotation
+	if gwName, exist := instance.Annotations[GatewayKey]; exist {
+		// check gateway exist or not
+		gw := NewGatewayObject(GatewayGVK)
+		if err := k8s.Get(ctx, types.NamespacedName{Name: gwName, Namespace: instance.Namespace}, gw); apierrors.IsNotFound(err) {
+			// gateway not exist, delete instance
+			h.errorf(instance, ErrorInvalidConfig, nil, "instance deleted because invalid config: gateway not exist")
+			return k8s.Delete(ctx, instance)
+		}
+	}
+
+	// get ingress class annotation
+	if className, exist := instance.Annotations[IngressClassKey]; exist {
+		// check ingress class exist or not
+		class := &networkingv1.IngressClass{}
+		if err := k8s.Get(ctx, types.NamespacedName{Name: className}, class); apierrors.IsNotFound(err) {
+			// ingress class not exist, delete instance
+			h.errorf(instance, ErrorInvalidConfig, nil, "instance deleted because invalid config: ingress class not exist")
+			return k8s.Delete(ctx, instance)
+		}
+	}
+
 	// get owned cluster
-	cluster, err1 := getOwnedCluster(k8s, ctx, instance, h.namespace)
+	cluster, err1 := h.getOwnedCluster(k8s, ctx, instance)
+	if err1 != nil && !apierrors.IsNotFound(err1) {
+		// cluster namespace not watched, or cluster not readable: keep the instance
+		return h.errorf(instance, ErrorClusterNotReady, err1, "get cluster failed")
+	}
 	if err1 != nil {
 		// delete instance if cluster not exist
 		h.errorf(instance, ErrorInvalidConfig, nil, "instance deleted because invalid config: cluster not exist")
@@ -36,7 +71,7 @@ func (h *Handler) InstanceChanged(k8s client.Client, ctx context.Context, instan
 	}
 
 	// get owned IP pool
-	ippool, err2 := getOwnedIPPool(instance, cluster)
+	ippool, err2 := h.getOwnedIPPool(instance, cluster)
 	if err2 != nil {
 		// delete instance if ippool not exist
 		h.errorf(instance, ErrorInvalidConfig, nil, "instance deleted because invalid config: ippool not exist")
@@ -54,15 +89,24 @@ func (h *Handler) InstanceChanged(k8s client.Client, ctx context.Context, instan
 	lb, err3 := h.connectCluster(k8s, ctx, cluster)
 	if err3 != nil {
 		// connect failed, update intance status
-		updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseClusterNotReady, nil)
+		h.updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseClusterNotReady, nil)
 		return h.errorf(instance, ErrorClusterNotReady, err3, "cluster not ready")
 	}
 
+	// resolve tls certificates of frontend ports
+	config, certs, err := h.resolveTLSConfig(k8s, ctx, instance)
+	if err != nil {
+		h.updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseModifyFailed, nil)
+		return h.errorf(instance, ErrorTLSSecret, err, "resolve tls certificate failed")
+	}
+	instance.Status.Certificates = certs
+	instance.Status.SourceRanges = instanceSourceRanges(instance)
+
 	// check if exist
-	exist, _ := lb.GetInstance(instance.Spec.Config.Name)
+	exist, _ := h.getInstance(ctx, lb, instance.Spec.Config.Name)
 	if exist != nil {
 		// config same, load exist status
-		if cmp.Equal(exist.Config, &instance.Spec.Config) {
+		if cmp.Equal(exist.Config, config) {
 			phase := crdv1.InstancePhaseNotReady
 			for _, v := range exist.Status {
 				if v == crdv1.InstanceStatusUp {
@@ -71,7 +115,7 @@ func (h *Handler) InstanceChanged(k8s client.Client, ctx context.Context, instan
 				}
 			}
 			// update instance status
-			updateInstanceStatus(k8s, ctx, instance, phase, &exist.Status)
+			h.updateInstanceStatus(k8s, ctx, instance, phase, &exist.Status)
 
 			// if status not ready, retry later
 			if phase != crdv1.InstancePhaseReady {
@@ -83,40 +127,44 @@ func (h *Handler) InstanceChanged(k8s client.Client, ctx context.Context, instan
 		}
 
 		// config not same, modify exist
-		if modified, err := lb.ModifyInstance(&instance.Spec.Config); err != nil {
+		if modified, err := h.modifyInstance(ctx, lb, config); err != nil {
 			// modify failed, update instance status
-			updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseModifyFailed, nil)
+			h.updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseModifyFailed, nil)
 			// retry later
 			return h.errorf(instance, ErrorInstanceModifyFailed, err, "instance modify failed")
 		} else {
 			// modify succeed, update instance labels & status
-			updateInstanceLabels(k8s, ctx, instance)
-			updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseModified, &modified.Status)
+			h.updateInstanceLabels(k8s, ctx, instance)
+			instance.Status.Certificates = certs
+			instance.Status.SourceRanges = instanceSourceRanges(instance)
+			h.updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseModified, &modified.Status)
 			return nil
 		}
 	}
 
 	// not exist, create new one
-	created, err4 := lb.CreateInstance(&instance.Spec.Config)
+	created, err4 := h.createInstance(ctx, lb, config)
 	if err4 != nil {
 		// create failed, update instance status
-		updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseCreateFailed, nil)
+		h.updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseCreateFailed, nil)
 		return h.errorf(instance, ErrorInstanceCreateFailed, err4, "instance create failed")
 	}
 
 	// create succeed, update instance labels & status
-	updateInstanceLabels(k8s, ctx, instance)
-	updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseCreated, &created.Status)
+	h.updateInstanceLabels(k8s, ctx, instance)
+	instance.Status.Certificates = certs
+	instance.Status.SourceRanges = instanceSourceRanges(instance)
+	h.updateInstanceStatus(k8s, ctx, instance, crdv1.InstancePhaseCreated, &created.Status)
 
 	return nil
 }
 
 func (h *Handler) InstanceDeleted(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance) error {
-	h.lock("delete instance", "handler", "InstanceDeleted", "instance", instance.Name, "namespace", instance.Namespace)
-	defer h.unlock("delete instance end", "handler", "InstanceDeleted", "instance", instance.Name, "namespace", instance.Namespace)
+	h.lock(instanceLockKey(instance.Namespace, instance.Name), "delete instance", "handler", "InstanceDeleted", "instance", instance.Name, "namespace", instance.Namespace)
+	defer h.unlock(instanceLockKey(instance.Namespace, instance.Name), "delete instance end", "handler", "InstanceDeleted", "instance", instance.Name, "namespace", instance.Namespace)
 
 	// get owned cluster
-	cluster, err1 := getOwnedCluster(k8s, ctx, instance, h.namespace)
+	cluster, err1 := h.getOwnedCluster(k8s, ctx, instance)
 	if err1 != nil {
 		// cluster not exist, delete directly
 		return nil
@@ -130,52 +178,64 @@ func (h *Handler) InstanceDeleted(k8s client.Client, ctx context.Context, instan
 	}
 
 	// check if exist
-	exist, _ := lb.GetInstance(instance.Spec.Config.Name)
+	exist, _ := h.getInstance(ctx, lb, instance.Spec.Config.Name)
 	if exist != nil {
 		// delete if exist
-		lb.DeleteInstance(exist.Config.Name)
+		h.deleteInstance(ctx, lb, exist.Config.Name)
 	}
 
 	// not exist, or delete failed, delete directly
 	return nil
 }
 
-func updateInstanceLabels(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance) error {
+func (h *Handler) updateInstanceLabels(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance) error {
 	if instance.Labels == nil {
 		instance.Labels = map[string]string{}
 	}
 
+	clusterRef := h.defaultCluster
 	if instance.Spec.Cluster != "" {
-		instance.Labels[ClusterKey] = instance.Spec.Cluster
-	} else {
-		instance.Labels[ClusterKey] = DefaultClusterName
+		clusterRef = instance.Spec.Cluster
+	}
+	clusterKey, err := h.clusterKey(clusterRef)
+	if err != nil {
+		return err
 	}
 
+	ippoolName := h.defaultIPPool
 	if instance.Spec.IPPool != "" {
-		instance.Labels[IPPoolKey] = instance.Spec.IPPool
-	} else {
-		instance.Labels[IPPoolKey] = DefaultIPPoolName
+		ippoolName = instance.Spec.IPPool
 	}
+	h.setClusterLabels(instance.Labels, clusterKey, ippoolName)
 
 	return k8s.Update(ctx, instance)
 }
 
-func updateInstanceStatus(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance, phase string, nodeStatus *map[string]string) error {
+func (h *Handler) updateInstanceStatus(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance, phase string, nodeStatus *map[string]string) error {
+	// keep other status fields, e.g. draining nodes maintained by service handler, certificates set by caller
+	instance.Status.Phase = phase
 	if nodeStatus != nil {
-		instance.Status = crdv1.FlexLBInstanceStatus{Phase: phase, NodeStatus: *nodeStatus}
+		instance.Status.NodeStatus = *nodeStatus
 	} else {
-		instance.Status = crdv1.FlexLBInstanceStatus{Phase: phase}
+		instance.Status.NodeStatus = nil
 	}
-	return k8s.Status().Update(ctx, instance)
+	cond := setNodeStatusCondition(instance)
+	if err := k8s.Status().Update(ctx, instance); err != nil {
+		return err
+	}
+	h.reportNodeStatus(k8s, ctx, instance, cond)
+	return nil
 }
 
 // get the owned cluster of instance
-func getOwnedCluster(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance, namespace string) (*crdv1.FlexLBCluster, error) {
-	var clusterNamespacedName types.NamespacedName
+func (h *Handler) getOwnedCluster(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance) (*crdv1.FlexLBCluster, error) {
+	clusterRef := h.defaultCluster
 	if instance.Spec.Cluster != "" {
-		clusterNamespacedName = types.NamespacedName{Namespace: namespace, Name: instance.Spec.Cluster}
-	} else {
-		clusterNamespacedName = types.NamespacedName{Namespace: namespace, Name: DefaultClusterName}
+		clusterRef = instance.Spec.Cluster
+	}
+	clusterNamespacedName, err := h.clusterKey(clusterRef)
+	if err != nil {
+		return nil, err
 	}
 
 	var cluster crdv1.FlexLBCluster
@@ -186,8 +246,8 @@ func getOwnedCluster(k8s client.Client, ctx context.Context, instance *crdv1.Fle
 }
 
 // get the owned ippool of instance
-func getOwnedIPPool(instance *crdv1.FlexLBInstance, cluster *crdv1.FlexLBCluster) (*crdv1.FlexLBIPPool, error) {
-	var IPPoolName = DefaultIPPoolName
+func (h *Handler) getOwnedIPPool(instance *crdv1.FlexLBInstance, cluster *crdv1.FlexLBCluster) (*crdv1.FlexLBIPPool, error) {
+	var IPPoolName = h.defaultIPPool
 	if instance.Spec.IPPool != "" {
 		IPPoolName = instance.Spec.IPPool
 	}
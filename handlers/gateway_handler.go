@@ -0,0 +1,661 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/utils"
+)
+
+// accept gateway class controlled by flexlb, its parameters reference the FlexLBCluster
+func (h *Handler) GatewayClassChanged(k8s client.Client, ctx context.Context, class *unstructured.Unstructured) error {
+	spec := gatewayClassSpec{}
+	if err := fromUnstructured(class, "spec", &spec); err != nil {
+		return err
+	}
+	if spec.ControllerName != GatewayControllerName {
+		return nil
+	}
+
+	cond := metav1.Condition{
+		Type:               GatewayConditionAccepted,
+		Status:             metav1.ConditionTrue,
+		Reason:             GatewayReasonAccepted,
+		Message:            "accepted by flexlb",
+		ObservedGeneration: class.GetGeneration(),
+	}
+	// invalid parameters are reported in the condition, and returned after the status is updated
+	var invalid error
+	if _, err := h.getGatewayClassCluster(k8s, ctx, &spec); err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = GatewayReasonInvalidParameters
+		cond.Message = err.Error()
+		invalid = h.errorf(class, ErrorInvalidConfig, err, "invalid gateway class parameters")
+	}
+
+	status := gatewayClassStatus{}
+	if err := fromUnstructured(class, "status", &status); err != nil {
+		return err
+	}
+	if !setCondition(&status.Conditions, cond) {
+		return invalid
+	}
+	if err := toUnstructured(class, "status", &status); err != nil {
+		return err
+	}
+	if err := k8s.Status().Update(ctx, class); err != nil {
+		return err
+	}
+	return invalid
+}
+
+// check whether gateway belongs to a gateway class controlled by flexlb
+func IsFlexLBGateway(k8s client.Client, ctx context.Context, gw *unstructured.Unstructured) bool {
+	className, _, _ := unstructured.NestedString(gw.Object, "spec", "gatewayClassName")
+	class := NewGatewayObject(GatewayClassGVK)
+	if err := k8s.Get(ctx, types.NamespacedName{Name: className}, class); err != nil {
+		return false
+	}
+	controllerName, _, _ := unstructured.NestedString(class.Object, "spec", "controllerName")
+	return controllerName == GatewayControllerName
+}
+
+// allocate a flexlbinstance for gateway, listeners are rendered to endpoints with the backends of attached routes,
+// returns the duration after which the gateway should be checked again, when backends are draining
+func (h *Handler) GatewayChanged(k8s client.Client, ctx context.Context, gw *unstructured.Unstructured) (time.Duration, error) {
+	h.lock(gatewayLockKey(gw.GetNamespace(), gw.GetName()), "update gateway", "handler", "GatewayChanged", "gateway", gw.GetName(), "namespace", gw.GetNamespace())
+	defer h.unlock(gatewayLockKey(gw.GetNamespace(), gw.GetName()), "update gateway end", "handler", "GatewayChanged", "gateway", gw.GetName(), "namespace", gw.GetNamespace())
+
+	// gateway class changed to another controller, release instance
+	if !IsFlexLBGateway(k8s, ctx, gw) {
+		return 0, h.deleteInstanceForGateway(k8s, ctx, gw)
+	}
+
+	spec := gatewaySpec{}
+	if err := fromUnstructured(gw, "spec", &spec); err != nil {
+		return 0, err
+	}
+	status := gatewayStatus{}
+	if err := fromUnstructured(gw, "status", &status); err != nil {
+		return 0, err
+	}
+
+	// get cluster from gateway class, and ippool annotation
+	class := NewGatewayObject(GatewayClassGVK)
+	if err := k8s.Get(ctx, types.NamespacedName{Name: spec.GatewayClassName}, class); err != nil {
+		return 0, err
+	}
+	classSpec := gatewayClassSpec{}
+	if err := fromUnstructured(class, "spec", &classSpec); err != nil {
+		return 0, err
+	}
+	cluster, err := h.getGatewayClassCluster(k8s, ctx, &classSpec)
+	if err != nil {
+		h.updateGatewayStatus(k8s, ctx, gw, &status, GatewayConditionAccepted, GatewayReasonInvalidParameters, err.Error())
+		return 0, h.errorf(gw, ErrorInvalidConfig, err, "invalid gateway class parameters")
+	}
+	ippoolName, exist := gw.GetAnnotations()[IPPoolKey]
+	if !exist {
+		ippoolName = h.defaultIPPool
+	}
+	clusterName := h.clusterRef(client.ObjectKeyFromObject(cluster))
+	ippool, err := h.getIPPool(k8s, ctx, clusterName, ippoolName)
+	if err != nil {
+		h.updateGatewayStatus(k8s, ctx, gw, &status, GatewayConditionAccepted, GatewayReasonInvalidParameters, err.Error())
+		return 0, h.errorf(gw, ErrorNoIPPool, err, "ippool does not exist")
+	}
+	if err := checkIPPoolAccess(k8s, ctx, clusterName, ippool, gw.GetNamespace()); err != nil {
+		h.updateGatewayStatus(k8s, ctx, gw, &status, GatewayConditionAccepted, GatewayReasonInvalidParameters, err.Error())
+		return 0, h.errorf(gw, ErrorIPPoolAccessDenied, err, "ippool access denied")
+	}
+
+	// render listeners and attached routes
+	endpoints, nodes, err := h.getGatewayEndpoints(k8s, ctx, gw, &spec, &status, ippool)
+	if err != nil {
+		return 0, err
+	}
+	if len(nodes.excluded) > 0 {
+		h.warnf(gw, ErrorNoTrafficNodeIp, "nodes excluded from backends because no traffic ip found: %s",
+			strings.Join(nodes.excluded, ", "))
+	}
+
+	// create or update instance
+	inst := &crdv1.FlexLBInstance{}
+	instName, exist := gw.GetAnnotations()[InstanceKey]
+	if !exist || k8s.Get(ctx, types.NamespacedName{Name: instName, Namespace: gw.GetNamespace()}, inst) != nil {
+		inst = nil
+	}
+	if inst == nil || inst.Spec.Cluster != clusterName || inst.Spec.IPPool != ippoolName {
+		// the instance moved to another cluster or ippool is replaced, not counted
+		exclude := ""
+		if inst != nil {
+			exclude = inst.Name
+		}
+		if ipPoolQuota(ippool, gw.GetNamespace()) > 0 {
+			key := quotaLockKey(gw.GetNamespace(), clusterName, ippoolName)
+			h.lock(key, "check ippool quota", "gateway", gw.GetName(), "namespace", gw.GetNamespace(), "ippool", ippoolName)
+			defer h.unlock(key, "check ippool quota end", "gateway", gw.GetName(), "namespace", gw.GetNamespace(), "ippool", ippoolName)
+		}
+		if err := h.checkIPPoolQuota(k8s, ctx, client.ObjectKeyFromObject(cluster), ippool, gw.GetNamespace(), exclude); err != nil {
+			h.updateGatewayStatus(k8s, ctx, gw, &status, GatewayConditionReady, GatewayReasonAddressNotAssigned, err.Error())
+			return 0, h.errorf(gw, ErrorIPPoolQuotaExceeded, err, "ippool quota exceeded")
+		}
+	}
+	draining, requeue := h.drainBackends(k8s, ctx, inst, endpoints, nodes)
+	if inst == nil {
+		inst, err = h.createIntance(k8s, ctx, clusterName, ippoolName, gw.GetName(), gw.GetNamespace(),
+			map[string]string{GatewayKey: gw.GetName()}, map[string]string{}, endpoints, nodes)
+		if err != nil {
+			h.updateGatewayStatus(k8s, ctx, gw, &status, GatewayConditionReady, GatewayReasonAddressNotAssigned, err.Error())
+			return 0, h.errorf(gw, ErrorInstanceCreateFailed, err, "create instance failed")
+		}
+		annotations := gw.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[InstanceKey] = inst.Name
+		gw.SetAnnotations(annotations)
+		if err := k8s.Update(ctx, gw); err != nil {
+			return 0, err
+		}
+	} else if instanceChanged(inst, clusterName, ippoolName, endpoints, nodes) {
+		if inst, err = h.updateIntance(k8s, ctx, inst, clusterName, ippoolName, endpoints, nodes); err != nil {
+			h.updateGatewayStatus(k8s, ctx, gw, &status, GatewayConditionReady, GatewayReasonAddressNotAssigned, err.Error())
+			return 0, h.errorf(gw, ErrorInstanceModifyFailed, err, "update instance failed")
+		}
+	}
+	if err := updateDrainingStatus(k8s, ctx, inst, draining); err != nil {
+		return 0, err
+	}
+
+	// gateway accepted and address assigned
+	status.Addresses = []gatewayAddress{{Type: "IPAddress", Value: inst.Spec.Config.FrontendIpaddress}}
+	setCondition(&status.Conditions, metav1.Condition{Type: GatewayConditionAccepted, Status: metav1.ConditionTrue,
+		Reason: GatewayReasonAccepted, Message: "accepted by flexlb", ObservedGeneration: gw.GetGeneration()})
+	return requeue, h.updateGatewayStatus(k8s, ctx, gw, &status, GatewayConditionReady, GatewayReasonReady, "")
+}
+
+func (h *Handler) GatewayDeleted(k8s client.Client, ctx context.Context, gw *unstructured.Unstructured) error {
+	h.lock(gatewayLockKey(gw.GetNamespace(), gw.GetName()), "delete gateway", "handler", "GatewayDeleted", "gateway", gw.GetName(), "namespace", gw.GetNamespace())
+	defer h.unlock(gatewayLockKey(gw.GetNamespace(), gw.GetName()), "delete gateway end", "handler", "GatewayDeleted", "gateway", gw.GetName(), "namespace", gw.GetNamespace())
+
+	return h.deleteInstanceForGateway(k8s, ctx, gw)
+}
+
+// find flexlbinstance from gateway annotation and delete it
+func (h *Handler) deleteInstanceForGateway(k8s client.Client, ctx context.Context, gw *unstructured.Unstructured) error {
+	annotations := gw.GetAnnotations()
+	instName, exist := annotations[InstanceKey]
+	if !exist {
+		return nil
+	}
+	inst := &crdv1.FlexLBInstance{}
+	if err := k8s.Get(ctx, types.NamespacedName{Name: instName, Namespace: gw.GetNamespace()}, inst); err == nil {
+		if err := k8s.Delete(ctx, inst); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	delete(annotations, InstanceKey)
+	gw.SetAnnotations(annotations)
+	return k8s.Update(ctx, gw)
+}
+
+// get FlexLBCluster referenced by gateway class parameters, default cluster if not set
+func (h *Handler) getGatewayClassCluster(k8s client.Client, ctx context.Context, spec *gatewayClassSpec) (*crdv1.FlexLBCluster, error) {
+	clusterName := h.defaultCluster
+	if ref := spec.ParametersRef; ref != nil {
+		if ref.Group != crdv1.GroupVersion.Group || ref.Kind != "FlexLBCluster" {
+			return nil, fmt.Errorf("parametersRef must be '%s/FlexLBCluster'", crdv1.GroupVersion.Group)
+		}
+		clusterName = ref.Name
+		if ref.Namespace != nil {
+			clusterName = *ref.Namespace + "/" + ref.Name
+		}
+	}
+	return h.getCluster(k8s, ctx, clusterName)
+}
+
+// render one endpoint per listener, with the backends of attached routes
+// listener and route status are updated in place
+func (h *Handler) getGatewayEndpoints(k8s client.Client, ctx context.Context, gw *unstructured.Unstructured, spec *gatewaySpec,
+	status *gatewayStatus, ippool *crdv1.FlexLBIPPool) ([]*models.Endpoint, *backendNodes, error) {
+	endpoints := []*models.Endpoint{}
+	nodes := newBackendNodes()
+
+	listenerEndpoints := map[string]*models.Endpoint{}
+	listenerStatuses := map[string]*listenerStatus{}
+	status.Listeners = []listenerStatus{}
+	for _, l := range spec.Listeners {
+		ls := listenerStatus{Name: l.Name, SupportedKinds: []routeGroupKind{}, Conditions: listenerConditions(status, l.Name)}
+		mode, kind := listenerMode(l.Protocol)
+		if mode == "" {
+			setCondition(&ls.Conditions, metav1.Condition{Type: GatewayConditionAccepted, Status: metav1.ConditionFalse,
+				Reason: GatewayReasonUnsupportedProtocol, Message: fmt.Sprintf("protocol '%s' not supported", l.Protocol),
+				ObservedGeneration: gw.GetGeneration()})
+			status.Listeners = append(status.Listeners, ls)
+			continue
+		}
+		setCondition(&ls.Conditions, metav1.Condition{Type: GatewayConditionAccepted, Status: metav1.ConditionTrue,
+			Reason: GatewayReasonAccepted, Message: "accepted by flexlb", ObservedGeneration: gw.GetGeneration()})
+		ls.SupportedKinds = append(ls.SupportedKinds, routeGroupKind{Group: GatewayGroup, Kind: kind})
+		status.Listeners = append(status.Listeners, ls)
+
+		backendDefaultOptions := defaultBackendServerOptions
+		ep := &models.Endpoint{
+			FrontendPort:         uint16(l.Port),
+			Mode:                 mode,
+			Balance:              "roundrobin",
+			BackendOptions:       []string{},
+			BackendDefaultServer: &backendDefaultOptions,
+			BackendServers:       []*models.BackendServer{},
+		}
+		endpoints = append(endpoints, ep)
+		listenerEndpoints[l.Name] = ep
+	}
+	for i := 0; i < len(status.Listeners); i++ {
+		listenerStatuses[status.Listeners[i].Name] = &status.Listeners[i]
+	}
+
+	// attach routes to listeners
+	for _, kindGVK := range []schema.GroupVersionKind{TCPRouteGVK, UDPRouteGVK} {
+		routes := NewGatewayObjectList(kindGVK)
+		if err := k8s.List(ctx, routes); err != nil {
+			if meta.IsNoMatchError(err) {
+				// route CRD not installed
+				continue
+			}
+			return nil, nil, fmt.Errorf("list %s failed: %s", kindGVK.Kind, err.Error())
+		}
+		for i := 0; i < len(routes.Items); i++ {
+			route := &routes.Items[i]
+			if err := h.attachRoute(k8s, ctx, gw, spec, route, ippool, listenerEndpoints, listenerStatuses, nodes); err != nil {
+				log.Log.Info("attach route failed", "gateway", gw.GetName(), "route", route.GetName(), "namespace", route.GetNamespace(), "error", err.Error())
+			}
+		}
+	}
+	return endpoints, nodes, nil
+}
+
+// attach route to the matching listeners of gateway, and update route status of the gateway parent
+func (h *Handler) attachRoute(k8s client.Client, ctx context.Context, gw *unstructured.Unstructured, spec *gatewaySpec,
+	route *unstructured.Unstructured, ippool *crdv1.FlexLBIPPool, listenerEndpoints map[string]*models.Endpoint,
+	listenerStatuses map[string]*listenerStatus, nodes *backendNodes) error {
+	rs := routeSpec{}
+	if err := fromUnstructured(route, "spec", &rs); err != nil {
+		return err
+	}
+	status := routeStatus{}
+	if err := fromUnstructured(route, "status", &status); err != nil {
+		return err
+	}
+
+	var servers []*models.BackendServer
+	var resolved *metav1.Condition
+	changed := false
+	for _, ref := range rs.ParentRefs {
+		if !refersGateway(ref, route.GetNamespace(), gw) {
+			continue
+		}
+
+		// resolve backends once per route
+		if resolved == nil {
+			servers, resolved = h.resolveRouteBackends(k8s, ctx, route, &rs, ippool, nodes)
+			resolved.ObservedGeneration = route.GetGeneration()
+		}
+
+		attached := false
+		for _, l := range spec.Listeners {
+			ep, exist := listenerEndpoints[l.Name]
+			if !exist || !listenerMatches(ref, l, route.GetKind()) || !h.routeAllowed(k8s, ctx, gw, l, route.GetNamespace()) {
+				continue
+			}
+			attached = true
+			listenerStatuses[l.Name].AttachedRoutes++
+			for _, server := range servers {
+				if findBackend(ep, server.Name) == nil {
+					ep.BackendServers = append(ep.BackendServers, server)
+				}
+			}
+		}
+
+		accepted := metav1.Condition{Type: GatewayConditionAccepted, Status: metav1.ConditionTrue, Reason: GatewayReasonAccepted,
+			Message: "accepted by flexlb", ObservedGeneration: route.GetGeneration()}
+		if !attached {
+			accepted.Status = metav1.ConditionFalse
+			accepted.Reason = GatewayReasonNotAllowed
+			accepted.Message = "no listener matches or allows the route"
+		}
+		parent := findRouteParentStatus(&status, ref)
+		if setCondition(&parent.Conditions, accepted) {
+			changed = true
+		}
+		if setCondition(&parent.Conditions, *resolved) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	if err := toUnstructured(route, "status", &status); err != nil {
+		return err
+	}
+	return k8s.Status().Update(ctx, route)
+}
+
+// resolve route backend refs to backend servers: node ports of NodePort and LoadBalancer services, pods of others
+func (h *Handler) resolveRouteBackends(k8s client.Client, ctx context.Context, route *unstructured.Unstructured, rs *routeSpec,
+	ippool *crdv1.FlexLBIPPool, nodes *backendNodes) ([]*models.BackendServer, *metav1.Condition) {
+	servers := []*models.BackendServer{}
+	resolved := &metav1.Condition{Type: GatewayConditionResolvedRefs, Status: metav1.ConditionTrue, Reason: GatewayReasonResolvedRefs,
+		Message: "all backend refs resolved"}
+	unresolved := func(reason string, msgfmt string, args ...interface{}) {
+		resolved.Status = metav1.ConditionFalse
+		resolved.Reason = reason
+		resolved.Message = fmt.Sprintf(msgfmt, args...)
+	}
+
+	maxWeight := int32(0)
+	for _, rule := range rs.Rules {
+		for _, ref := range rule.BackendRefs {
+			if ref.Weight != nil && *ref.Weight > maxWeight {
+				maxWeight = *ref.Weight
+			}
+		}
+	}
+
+	for _, rule := range rs.Rules {
+		for _, ref := range rule.BackendRefs {
+			if (ref.Group != nil && *ref.Group != "") || (ref.Kind != nil && *ref.Kind != "Service") {
+				unresolved(GatewayReasonBackendNotFound, "backend ref '%s' is not a service", ref.Name)
+				continue
+			}
+			if ref.Namespace != nil && *ref.Namespace != route.GetNamespace() {
+				unresolved(GatewayReasonRefNotPermitted, "backend ref '%s/%s' in another namespace not permitted", *ref.Namespace, ref.Name)
+				continue
+			}
+			if ref.Port == nil {
+				unresolved(GatewayReasonBackendNotFound, "backend ref '%s' has no port", ref.Name)
+				continue
+			}
+			svc := &v1.Service{}
+			if err := k8s.Get(ctx, types.NamespacedName{Namespace: route.GetNamespace(), Name: ref.Name}, svc); err != nil {
+				unresolved(GatewayReasonBackendNotFound, "service '%s' not found", ref.Name)
+				continue
+			}
+			svcServers, err := serviceBackends(k8s, ctx, svc, *ref.Port, ippool, nodes)
+			if err != nil {
+				unresolved(GatewayReasonBackendNotFound, "service '%s' port %d: %s", ref.Name, *ref.Port, err.Error())
+				continue
+			}
+			for _, server := range svcServers {
+				if ref.Weight != nil && server.Options == nil {
+					options := fmt.Sprintf("weight %d", backendWeight(*ref.Weight, maxWeight))
+					server.Options = &options
+				}
+				servers = append(servers, server)
+			}
+		}
+	}
+	return servers, resolved
+}
+
+// haproxy weight of a backend ref weight: route weights range 0..1000000, haproxy weights 0..256, the weights of a
+// route above 256 are scaled proportionally to its largest weight, a weight not zero is kept at least 1
+func backendWeight(weight int32, maxWeight int32) int32 {
+	if weight <= 0 || maxWeight <= maxBackendWeight {
+		return weight
+	}
+	scaled := int32((int64(weight)*maxBackendWeight + int64(maxWeight)/2) / int64(maxWeight))
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
+// backend servers of service port: node ports if allocated, pods otherwise
+func serviceBackends(k8s client.Client, ctx context.Context, svc *v1.Service, port int32, ippool *crdv1.FlexLBIPPool,
+	nodes *backendNodes) ([]*models.BackendServer, error) {
+	var svcPort *v1.ServicePort
+	for i := 0; i < len(svc.Spec.Ports); i++ {
+		if svc.Spec.Ports[i].Port == port {
+			svcPort = &svc.Spec.Ports[i]
+			break
+		}
+	}
+	if svcPort == nil {
+		return nil, fmt.Errorf("port not found")
+	}
+
+	servers := []*models.BackendServer{}
+	if svcPort.NodePort != 0 {
+		selector, err := getNodeSelector(svc, ippool)
+		if err != nil {
+			return nil, err
+		}
+		svcEndpoints, svcNodes, err := getNodeIpEndpoints(k8s, ctx, svc, ippool, selector)
+		if err != nil {
+			return nil, err
+		}
+		nodes.merge(svcNodes)
+		for _, ep := range svcEndpoints {
+			if ep.FrontendPort != uint16(port) {
+				continue
+			}
+			for _, server := range ep.BackendServers {
+				// one pod may back several service ports
+				server.Name = fmt.Sprintf("%s-%d", server.Name, server.Port)
+				servers = append(servers, server)
+			}
+		}
+		return servers, nil
+	}
+
+	// pods of service, reachable when pod network is routed
+	eps, err := utils.GetEndpointSliceOfService(k8s, ctx, svc)
+	if err != nil {
+		return nil, err
+	}
+	var targetPort int32
+	for _, p := range eps.Ports {
+		if p.Port != nil && (p.Name == nil && svcPort.Name == "" || p.Name != nil && *p.Name == svcPort.Name) {
+			targetPort = *p.Port
+			break
+		}
+	}
+	if targetPort == 0 {
+		return nil, fmt.Errorf("target port not found")
+	}
+	for _, ep := range eps.Endpoints {
+		if len(ep.Addresses) == 0 || ep.TargetRef == nil || (ep.Conditions.Ready != nil && !*ep.Conditions.Ready) {
+			continue
+		}
+		servers = append(servers, &models.BackendServer{
+			Name:      fmt.Sprintf("%s-%d", ep.TargetRef.Name, targetPort),
+			Ipaddress: ep.Addresses[0],
+			Port:      uint16(targetPort),
+		})
+	}
+	return servers, nil
+}
+
+// update gateway status with condition
+func (h *Handler) updateGatewayStatus(k8s client.Client, ctx context.Context, gw *unstructured.Unstructured, status *gatewayStatus,
+	condType string, reason string, message string) error {
+	cond := metav1.Condition{Type: condType, Status: metav1.ConditionFalse, Reason: reason, Message: message, ObservedGeneration: gw.GetGeneration()}
+	if reason == GatewayReasonAccepted || reason == GatewayReasonReady {
+		cond.Status = metav1.ConditionTrue
+	}
+	if cond.Message == "" {
+		cond.Message = strings.ToLower(reason)
+	}
+	setCondition(&status.Conditions, cond)
+
+	old := gatewayStatus{}
+	if err := fromUnstructured(gw, "status", &old); err == nil && cmp.Equal(old, *status, cmpopts.EquateEmpty()) {
+		return nil
+	}
+	if err := toUnstructured(gw, "status", status); err != nil {
+		return err
+	}
+	return k8s.Status().Update(ctx, gw)
+}
+
+// listener endpoint mode and route kind of listener protocol
+func listenerMode(protocol string) (string, string) {
+	switch protocol {
+	case "TCP":
+		return models.EndpointModeTCP, TCPRouteGVK.Kind
+	case "UDP":
+		return models.EndpointModeUDP, UDPRouteGVK.Kind
+	}
+	return "", ""
+}
+
+// last listener conditions
+func listenerConditions(status *gatewayStatus, name string) []metav1.Condition {
+	for _, ls := range status.Listeners {
+		if ls.Name == name {
+			return ls.Conditions
+		}
+	}
+	return []metav1.Condition{}
+}
+
+// check whether route parent ref refers to gateway
+func refersGateway(ref parentRef, routeNamespace string, gw *unstructured.Unstructured) bool {
+	if ref.Group != nil && *ref.Group != GatewayGroup || ref.Kind != nil && *ref.Kind != GatewayGVK.Kind {
+		return false
+	}
+	namespace := routeNamespace
+	if ref.Namespace != nil {
+		namespace = *ref.Namespace
+	}
+	return ref.Name == gw.GetName() && namespace == gw.GetNamespace()
+}
+
+// check whether route parent ref matches listener section, port and protocol
+func listenerMatches(ref parentRef, l listener, routeKind string) bool {
+	if ref.SectionName != nil && *ref.SectionName != l.Name {
+		return false
+	}
+	if ref.Port != nil && *ref.Port != l.Port {
+		return false
+	}
+	_, kind := listenerMode(l.Protocol)
+	return kind == routeKind
+}
+
+// check whether listener allows routes of namespace: Same (default), All or Selector
+func (h *Handler) routeAllowed(k8s client.Client, ctx context.Context, gw *unstructured.Unstructured, l listener, namespace string) bool {
+	from := "Same"
+	var selector *metav1.LabelSelector
+	if l.AllowedRoutes != nil && l.AllowedRoutes.Namespaces != nil {
+		if l.AllowedRoutes.Namespaces.From != nil {
+			from = *l.AllowedRoutes.Namespaces.From
+		}
+		selector = l.AllowedRoutes.Namespaces.Selector
+	}
+	switch from {
+	case "All":
+		return true
+	case "Selector":
+		if selector == nil {
+			return false
+		}
+		s, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return false
+		}
+		ns := &v1.Namespace{}
+		if err := k8s.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+			return false
+		}
+		return s.Matches(labels.Set(ns.Labels))
+	}
+	return namespace == gw.GetNamespace()
+}
+
+// find route parent status of flexlb for parent ref, add if not exist
+func findRouteParentStatus(status *routeStatus, ref parentRef) *routeParentStatus {
+	for i := 0; i < len(status.Parents); i++ {
+		parent := &status.Parents[i]
+		if parent.ControllerName == GatewayControllerName && cmp.Equal(parent.ParentRef, ref) {
+			return parent
+		}
+	}
+	status.Parents = append(status.Parents, routeParentStatus{ParentRef: ref, ControllerName: GatewayControllerName})
+	return &status.Parents[len(status.Parents)-1]
+}
+
+// set condition, returns true if changed
+func setCondition(conditions *[]metav1.Condition, cond metav1.Condition) bool {
+	if old := meta.FindStatusCondition(*conditions, cond.Type); old != nil && old.Status == cond.Status &&
+		old.Reason == cond.Reason && old.Message == cond.Message && old.ObservedGeneration == cond.ObservedGeneration {
+		return false
+	}
+	meta.SetStatusCondition(conditions, cond)
+	return true
+}
+
+// gateways of service: parents of routes in the service namespace referencing the service
+func GatewaysOfService(k8s client.Client, ctx context.Context, namespace string, name string) []types.NamespacedName {
+	gateways := []types.NamespacedName{}
+	for _, kindGVK := range []schema.GroupVersionKind{TCPRouteGVK, UDPRouteGVK} {
+		routes := NewGatewayObjectList(kindGVK)
+		if err := k8s.List(ctx, routes, client.InNamespace(namespace)); err != nil {
+			continue
+		}
+		for i := 0; i < len(routes.Items); i++ {
+			rs := routeSpec{}
+			if err := fromUnstructured(&routes.Items[i], "spec", &rs); err != nil {
+				continue
+			}
+			for _, rule := range rs.Rules {
+				for _, ref := range rule.BackendRefs {
+					if ref.Name == name && (ref.Namespace == nil || *ref.Namespace == namespace) {
+						gateways = append(gateways, GatewaysOfRoute(&routes.Items[i])...)
+					}
+				}
+			}
+		}
+	}
+	return gateways
+}
+
+// parent gateways of route
+func GatewaysOfRoute(route *unstructured.Unstructured) []types.NamespacedName {
+	gateways := []types.NamespacedName{}
+	rs := routeSpec{}
+	if err := fromUnstructured(route, "spec", &rs); err != nil {
+		return gateways
+	}
+	for _, ref := range rs.ParentRefs {
+		if ref.Group != nil && *ref.Group != GatewayGroup || ref.Kind != nil && *ref.Kind != GatewayGVK.Kind {
+			continue
+		}
+		namespace := route.GetNamespace()
+		if ref.Namespace != nil {
+			namespace = *ref.Namespace
+		}
+		gateways = append(gateways, types.NamespacedName{Namespace: namespace, Name: ref.Name})
+	}
+	return gateways
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+	disv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+// gateway of namespace gw-ns
+func testGateway() *unstructured.Unstructured {
+	gw := NewGatewayObject(GatewayGVK)
+	gw.SetName("gw")
+	gw.SetNamespace("gw-ns")
+	return gw
+}
+
+func TestListenerMode(t *testing.T) {
+	tests := []struct{ protocol, mode, kind string }{
+		{"TCP", models.EndpointModeTCP, "TCPRoute"},
+		{"UDP", models.EndpointModeUDP, "UDPRoute"},
+		{"HTTP", "", ""},
+		{"tcp", "", ""},
+	}
+	for _, tt := range tests {
+		if mode, kind := listenerMode(tt.protocol); mode != tt.mode || kind != tt.kind {
+			t.Errorf("protocol %s: got %q %q, want %q %q", tt.protocol, mode, kind, tt.mode, tt.kind)
+		}
+	}
+}
+
+func TestRefersGateway(t *testing.T) {
+	gw := testGateway()
+	tests := []struct {
+		name           string
+		ref            parentRef
+		routeNamespace string
+		want           bool
+	}{
+		{name: "same namespace", ref: parentRef{Name: "gw"}, routeNamespace: "gw-ns", want: true},
+		{name: "other namespace", ref: parentRef{Name: "gw"}, routeNamespace: "other"},
+		{name: "explicit namespace", ref: parentRef{Name: "gw", Namespace: strPtr("gw-ns")}, routeNamespace: "other", want: true},
+		{name: "other name", ref: parentRef{Name: "other"}, routeNamespace: "gw-ns"},
+		{name: "explicit group and kind", ref: parentRef{Name: "gw", Group: strPtr(GatewayGroup), Kind: strPtr("Gateway")}, routeNamespace: "gw-ns", want: true},
+		{name: "other kind", ref: parentRef{Name: "gw", Kind: strPtr("Service")}, routeNamespace: "gw-ns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refersGateway(tt.ref, tt.routeNamespace, gw); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenerMatches(t *testing.T) {
+	l := listener{Name: "tcp", Port: 9000, Protocol: "TCP"}
+	tests := []struct {
+		name string
+		ref  parentRef
+		kind string
+		want bool
+	}{
+		{name: "any listener", ref: parentRef{}, kind: "TCPRoute", want: true},
+		{name: "section", ref: parentRef{SectionName: strPtr("tcp")}, kind: "TCPRoute", want: true},
+		{name: "other section", ref: parentRef{SectionName: strPtr("udp")}, kind: "TCPRoute"},
+		{name: "port", ref: parentRef{Port: int32Ptr(9000)}, kind: "TCPRoute", want: true},
+		{name: "other port", ref: parentRef{Port: int32Ptr(9001)}, kind: "TCPRoute"},
+		{name: "route kind of other protocol", ref: parentRef{}, kind: "UDPRoute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listenerMatches(tt.ref, l, tt.kind); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteAllowed(t *testing.T) {
+	ctx := context.TODO()
+	h, _ := newTestHandler()
+	k8s := newFakeClient(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"gateway": "shared"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	)
+	from := func(from string, selector *metav1.LabelSelector) listener {
+		return listener{AllowedRoutes: &allowedRoutes{Namespaces: &routeNamespaces{From: &from, Selector: selector}}}
+	}
+	shared := &metav1.LabelSelector{MatchLabels: map[string]string{"gateway": "shared"}}
+	tests := []struct {
+		name      string
+		l         listener
+		namespace string
+		want      bool
+	}{
+		{name: "same by default", l: listener{}, namespace: "gw-ns", want: true},
+		{name: "other namespace by default", l: listener{}, namespace: "team-a"},
+		{name: "all", l: from("All", nil), namespace: "team-b", want: true},
+		{name: "selector matched", l: from("Selector", shared), namespace: "team-a", want: true},
+		{name: "selector not matched", l: from("Selector", shared), namespace: "team-b"},
+		{name: "selector missing", l: from("Selector", nil), namespace: "team-a"},
+		{name: "namespace not found", l: from("Selector", shared), namespace: "team-c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.routeAllowed(k8s, ctx, testGateway(), tt.l, tt.namespace); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGatewaysOfRoute(t *testing.T) {
+	route := NewGatewayObject(TCPRouteGVK)
+	route.SetNamespace("team-a")
+	if err := toUnstructured(route, "spec", &routeSpec{ParentRefs: []parentRef{
+		{Name: "gw-1"},
+		{Name: "gw-2", Namespace: strPtr("gw-ns")},
+		{Name: "svc", Kind: strPtr("Service")},
+	}}); err != nil {
+		t.Fatalf("encode route failed: %s", err.Error())
+	}
+	gateways := GatewaysOfRoute(route)
+	want := []types.NamespacedName{{Namespace: "team-a", Name: "gw-1"}, {Namespace: "gw-ns", Name: "gw-2"}}
+	if len(gateways) != len(want) || gateways[0] != want[0] || gateways[1] != want[1] {
+		t.Errorf("got %v, want %v", gateways, want)
+	}
+}
+
+func TestSetCondition(t *testing.T) {
+	conditions := []metav1.Condition{}
+	cond := metav1.Condition{Type: GatewayConditionReady, Status: metav1.ConditionTrue, Reason: GatewayReasonReady, Message: "ready"}
+	if !setCondition(&conditions, cond) || len(conditions) != 1 {
+		t.Fatalf("new condition not set: %v", conditions)
+	}
+	if setCondition(&conditions, cond) {
+		t.Errorf("unchanged condition reported as changed")
+	}
+	cond.Status = metav1.ConditionFalse
+	if !setCondition(&conditions, cond) || conditions[0].Status != metav1.ConditionFalse {
+		t.Errorf("changed condition not set: %v", conditions)
+	}
+}
+
+func TestServiceBackendsOfPods(t *testing.T) {
+	ctx := context.TODO()
+	targetPort := int32(8080)
+	ready, notReady := true, false
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "team-a"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 9000, Protocol: v1.ProtocolTCP}}},
+	}
+	slice := &disv1.EndpointSlice{
+		ObjectMeta:  metav1.ObjectMeta{Name: "backend", Namespace: "team-a", Labels: map[string]string{disv1.LabelServiceName: "backend"}},
+		AddressType: disv1.AddressTypeIPv4,
+		Ports:       []disv1.EndpointPort{{Port: &targetPort}},
+		Endpoints: []disv1.Endpoint{
+			{Addresses: []string{"172.16.0.1"}, TargetRef: &v1.ObjectReference{Name: "pod-1"}, Conditions: disv1.EndpointConditions{Ready: &ready}},
+			{Addresses: []string{"172.16.0.2"}, TargetRef: &v1.ObjectReference{Name: "pod-2"}, Conditions: disv1.EndpointConditions{Ready: &notReady}},
+			{Addresses: []string{"172.16.0.3"}, TargetRef: &v1.ObjectReference{Name: "pod-3"}},
+		},
+	}
+	k8s := newFakeClient(svc, slice)
+
+	servers, err := serviceBackends(k8s, ctx, svc, 9000, &crdv1.FlexLBIPPool{}, newBackendNodes())
+	if err != nil {
+		t.Fatalf("resolve backends failed: %s", err.Error())
+	}
+	if len(servers) != 2 || servers[0].Name != "pod-1-8080" || servers[0].Port != 8080 || servers[1].Ipaddress != "172.16.0.3" {
+		t.Errorf("unexpected backends: %+v %+v", servers[0], servers[len(servers)-1])
+	}
+	if _, err := serviceBackends(k8s, ctx, svc, 9001, &crdv1.FlexLBIPPool{}, newBackendNodes()); err == nil {
+		t.Errorf("backends of unknown port resolved")
+	}
+}
+
+func TestGatewayClassChanged(t *testing.T) {
+	ctx := context.TODO()
+	h, _ := newTestHandler()
+	class := NewGatewayObject(GatewayClassGVK)
+	class.SetName("flexlb")
+	if err := toUnstructured(class, "spec", &gatewayClassSpec{ControllerName: GatewayControllerName}); err != nil {
+		t.Fatalf("encode gateway class failed: %s", err.Error())
+	}
+	cluster := &crdv1.FlexLBCluster{ObjectMeta: metav1.ObjectMeta{Name: DefaultClusterName, Namespace: testNamespace}}
+	k8s := newFakeClient(class, cluster)
+
+	if err := h.GatewayClassChanged(k8s, ctx, class); err != nil {
+		t.Fatalf("gateway class changed failed: %s", err.Error())
+	}
+	latest := NewGatewayObject(GatewayClassGVK)
+	if err := k8s.Get(ctx, types.NamespacedName{Name: "flexlb"}, latest); err != nil {
+		t.Fatalf("get gateway class failed: %s", err.Error())
+	}
+	status := gatewayClassStatus{}
+	fromUnstructured(latest, "status", &status)
+	if len(status.Conditions) != 1 || status.Conditions[0].Type != GatewayConditionAccepted || status.Conditions[0].Status != metav1.ConditionTrue {
+		t.Errorf("gateway class not accepted: %+v", status.Conditions)
+	}
+}
+
+func TestGatewayClassInvalidParameters(t *testing.T) {
+	ctx := context.TODO()
+	h, _ := newTestHandler()
+	class := NewGatewayObject(GatewayClassGVK)
+	class.SetName("flexlb")
+	spec := gatewayClassSpec{ControllerName: GatewayControllerName, ParametersRef: &parametersRef{Group: "example.com", Kind: "Config", Name: "edge"}}
+	if err := toUnstructured(class, "spec", &spec); err != nil {
+		t.Fatalf("encode gateway class failed: %s", err.Error())
+	}
+	k8s := newFakeClient(class)
+
+	err := h.GatewayClassChanged(k8s, ctx, class)
+	if ErrorClass(err) != crdv1.ErrorClassInvalidConfig {
+		t.Fatalf("GatewayClassChanged() error = %v, want invalid config", err)
+	}
+	latest := NewGatewayObject(GatewayClassGVK)
+	if err := k8s.Get(ctx, types.NamespacedName{Name: "flexlb"}, latest); err != nil {
+		t.Fatalf("get gateway class failed: %s", err.Error())
+	}
+	status := gatewayClassStatus{}
+	fromUnstructured(latest, "status", &status)
+	if len(status.Conditions) != 1 || status.Conditions[0].Status != metav1.ConditionFalse || status.Conditions[0].Reason != GatewayReasonInvalidParameters {
+		t.Errorf("gateway class accepted with invalid parameters: %+v", status.Conditions)
+	}
+
+	// condition unchanged, the error is still returned
+	if err := h.GatewayClassChanged(k8s, ctx, latest); err == nil {
+		t.Errorf("GatewayClassChanged() of an unchanged invalid class error = nil")
+	}
+}
+
+func TestBackendWeight(t *testing.T) {
+	tests := []struct {
+		name      string
+		weight    int32
+		maxWeight int32
+		want      int32
+	}{
+		{name: "zero", weight: 0, maxWeight: 1000000, want: 0},
+		{name: "haproxy range kept", weight: 3, maxWeight: 256, want: 3},
+		{name: "largest scaled to 256", weight: 1000000, maxWeight: 1000000, want: 256},
+		{name: "proportional", weight: 500, maxWeight: 1000, want: 128},
+		{name: "small weight kept at 1", weight: 1, maxWeight: 1000000, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backendWeight(tt.weight, tt.maxWeight); got != tt.want {
+				t.Errorf("backendWeight(%d, %d) = %d, want %d", tt.weight, tt.maxWeight, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// gateway api resources are handled as unstructured objects, so the gateway api CRDs are optional,
+// only the fields used by flexlb are declared below (gateway.networking.k8s.io/v1alpha2)
+
+const (
+	GatewayGroup          = "gateway.networking.k8s.io"
+	GatewayVersion        = "v1alpha2"
+	GatewayControllerName = "flexlb.flexlet.io/gateway-controller"
+
+	// largest backend server weight of haproxy
+	maxBackendWeight = 256
+)
+
+var (
+	GatewayClassGVK = schema.GroupVersionKind{Group: GatewayGroup, Version: GatewayVersion, Kind: "GatewayClass"}
+	GatewayGVK      = schema.GroupVersionKind{Group: GatewayGroup, Version: GatewayVersion, Kind: "Gateway"}
+	TCPRouteGVK     = schema.GroupVersionKind{Group: GatewayGroup, Version: GatewayVersion, Kind: "TCPRoute"}
+	UDPRouteGVK     = schema.GroupVersionKind{Group: GatewayGroup, Version: GatewayVersion, Kind: "UDPRoute"}
+)
+
+type gatewayClassSpec struct {
+	ControllerName string         `json:"controllerName"`
+	ParametersRef  *parametersRef `json:"parametersRef,omitempty"`
+}
+
+type parametersRef struct {
+	Group     string  `json:"group"`
+	Kind      string  `json:"kind"`
+	Name      string  `json:"name"`
+	Namespace *string `json:"namespace,omitempty"`
+}
+
+type gatewayClassStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+type gatewaySpec struct {
+	GatewayClassName string     `json:"gatewayClassName"`
+	Listeners        []listener `json:"listeners"`
+}
+
+type listener struct {
+	Name          string         `json:"name"`
+	Port          int32          `json:"port"`
+	Protocol      string         `json:"protocol"`
+	AllowedRoutes *allowedRoutes `json:"allowedRoutes,omitempty"`
+}
+
+type allowedRoutes struct {
+	Namespaces *routeNamespaces `json:"namespaces,omitempty"`
+}
+
+type routeNamespaces struct {
+	From     *string               `json:"from,omitempty"`
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+type gatewayStatus struct {
+	Addresses  []gatewayAddress   `json:"addresses,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	Listeners  []listenerStatus   `json:"listeners,omitempty"`
+}
+
+type gatewayAddress struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type listenerStatus struct {
+	Name           string             `json:"name"`
+	SupportedKinds []routeGroupKind   `json:"supportedKinds"`
+	AttachedRoutes int32              `json:"attachedRoutes"`
+	Conditions     []metav1.Condition `json:"conditions"`
+}
+
+type routeGroupKind struct {
+	Group string `json:"group,omitempty"`
+	Kind  string `json:"kind"`
+}
+
+// spec of TCPRoute and UDPRoute
+type routeSpec struct {
+	ParentRefs []parentRef `json:"parentRefs,omitempty"`
+	Rules      []routeRule `json:"rules"`
+}
+
+type parentRef struct {
+	Group       *string `json:"group,omitempty"`
+	Kind        *string `json:"kind,omitempty"`
+	Namespace   *string `json:"namespace,omitempty"`
+	Name        string  `json:"name"`
+	SectionName *string `json:"sectionName,omitempty"`
+	Port        *int32  `json:"port,omitempty"`
+}
+
+type routeRule struct {
+	BackendRefs []backendRef `json:"backendRefs,omitempty"`
+}
+
+type backendRef struct {
+	Group     *string `json:"group,omitempty"`
+	Kind      *string `json:"kind,omitempty"`
+	Name      string  `json:"name"`
+	Namespace *string `json:"namespace,omitempty"`
+	Port      *int32  `json:"port,omitempty"`
+	Weight    *int32  `json:"weight,omitempty"`
+}
+
+type routeStatus struct {
+	Parents []routeParentStatus `json:"parents"`
+}
+
+type routeParentStatus struct {
+	ParentRef      parentRef          `json:"parentRef"`
+	ControllerName string             `json:"controllerName"`
+	Conditions     []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// gateway api condition types and reasons
+const (
+	GatewayConditionAccepted     = "Accepted"
+	GatewayConditionReady        = "Ready"
+	GatewayConditionResolvedRefs = "ResolvedRefs"
+
+	GatewayReasonAccepted            = "Accepted"
+	GatewayReasonReady               = "Ready"
+	GatewayReasonInvalidParameters   = "InvalidParameters"
+	GatewayReasonAddressNotAssigned  = "AddressNotAssigned"
+	GatewayReasonUnsupportedProtocol = "UnsupportedProtocol"
+	GatewayReasonResolvedRefs        = "ResolvedRefs"
+	GatewayReasonRefNotPermitted     = "RefNotPermitted"
+	GatewayReasonBackendNotFound     = "BackendNotFound"
+	GatewayReasonNotAllowed          = "NotAllowedByListeners"
+)
+
+// new unstructured object of gateway api kind
+func NewGatewayObject(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	return obj
+}
+
+// new unstructured list of gateway api kind
+func NewGatewayObjectList(gvk schema.GroupVersionKind) *unstructured.UnstructuredList {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	return list
+}
+
+// decode a field (spec, status) of unstructured object
+func fromUnstructured(obj *unstructured.Unstructured, field string, out interface{}) error {
+	data, exist := obj.Object[field].(map[string]interface{})
+	if !exist {
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(data, out)
+}
+
+// encode a field (spec, status) of unstructured object
+func toUnstructured(obj *unstructured.Unstructured, field string, in interface{}) error {
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(in)
+	if err != nil {
+		return err
+	}
+	obj.Object[field] = data
+	return nil
+}
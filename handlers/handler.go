@@ -3,13 +3,16 @@ package handlers
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apiextensions-apiserver/pkg/client/clientset/deprecated/scheme"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
-	"k8s.io/client-go/tools/reference"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/flexlet/flexlb-kube-controller/utils"
 )
 
 type Handler struct {
@@ -18,20 +21,55 @@ type Handler struct {
 	tlsClientKey  string
 	tlsInsecure   bool
 	namespace     string
-	probePodImage string
 	recorder      record.EventRecorder
-	sync.Mutex
+
+	// settings reloaded from the config file
+	settings     Settings
+	settingsLock sync.RWMutex
+
+	// cluster and ippool of objects without cluster or ippool annotation
+	defaultCluster string
+	defaultIPPool  string
+
+	// namespaces of clusters besides the flexlb namespace, "*" for all
+	clusterNamespaces []string
+
+	// access of namespaces to clusters of other namespaces
+	access clusterAccessCache
+
+	// services of the class are handled, services without class if empty
+	loadBalancerClass string
+
+	// connects the load balancer backend of cluster
+	newBackend BackendFactory
+
+	// reads tls secrets, secrets are not cached by the manager
+	secretReader client.Reader
+
+	// fine grained locks: per object, per cluster and per ippool
+	locks utils.KeyedMutex
+
+	// warning events repeated on retries
+	events eventAggregator
+
+	// ips allocated recently, which may not be visible in the informer cache yet
+	reserved     map[string]map[string]time.Time
+	reservedLock sync.Mutex
 }
 
-func NewHandler(tlsCaCert string, tlsClientCert string, tlsClientKey string, tlsInsecure bool, namespace string, probePodImage string, recorder record.EventRecorder) *Handler {
+func NewHandler(tlsCaCert string, tlsClientCert string, tlsClientKey string, tlsInsecure bool, namespace string,
+	recorder record.EventRecorder) *Handler {
 	return &Handler{
-		tlsCaCert:     tlsCaCert,
-		tlsClientCert: tlsClientCert,
-		tlsClientKey:  tlsClientKey,
-		tlsInsecure:   tlsInsecure,
-		namespace:     namespace,
-		probePodImage: probePodImage,
-		recorder:      recorder,
+		tlsCaCert:      tlsCaCert,
+		tlsClientCert:  tlsClientCert,
+		tlsClientKey:   tlsClientKey,
+		tlsInsecure:    tlsInsecure,
+		namespace:      namespace,
+		recorder:       recorder,
+		defaultCluster: DefaultClusterName,
+		defaultIPPool:  DefaultIPPoolName,
+		newBackend:     FlexLBBackendFactory(tlsCaCert, tlsClientCert, tlsClientKey, tlsInsecure),
+		reserved:       map[string]map[string]time.Time{},
 	}
 }
 
@@ -48,11 +86,34 @@ const (
 	ErrorInstanceModifyFailed = "ErrorInstanceModifyFailed"
 	ErrorInstanceCreateFailed = "ErrorInstanceCreateFailed"
 	ErrorInstanceDeleteFailed = "ErrorInstanceDeleteFailed"
+	ErrorTLSSecret            = "ErrorTLSSecret"
+	ErrorClusterAccessDenied  = "ErrorClusterAccessDenied"
+	ErrorIPPoolAccessDenied   = "ErrorIPPoolAccessDenied"
+	ErrorIPPoolQuotaExceeded  = "ErrorIPPoolQuotaExceeded"
+)
+
+// instance events
+const (
+	CertificateExpiring = "CertificateExpiring"
+	// action planned in dry run mode
+	DryRunPlanned = "DryRunPlanned"
 )
 
 // instance annotation keys
 const (
 	ServiceKey = "flexlb.flexlet.io/service"
+	// traffic ips of backend nodes, json of {<node>: <traffic ip>}
+	BackendNodesKey = "flexlb.flexlet.io/backendNodes"
+	// gateway owns the instance
+	GatewayKey = "flexlb.flexlet.io/gateway"
+	// ingress class owns the instance
+	IngressClassKey = "flexlb.flexlet.io/ingressClass"
+	// tls secrets of frontend ports, json of {<port>: <namespace>/<secret>}
+	TLSSecretsKey = "flexlb.flexlet.io/tlsSecrets"
+	// changed to force reconcile of instance or service, value is a timestamp
+	ResyncKey = "flexlb.flexlet.io/resync"
+	// refresh interval of instance in seconds, overrides the cluster refresh interval
+	RefreshIntervalKey = "flexlb.flexlet.io/refreshInterval"
 )
 
 // node errors
@@ -61,9 +122,23 @@ const (
 	ErrorUpdateTrafficNodeIp = "ErrorUpdateTrafficNodeIp"
 )
 
-// node annotation key
+// node events
+const (
+	ProbeTrafficNodeIpStarted = "ProbeTrafficNodeIpStarted"
+	BackendNodeDeleted        = "BackendNodeDeleted"
+)
+
+// node annotation keys
 const (
 	NodeNetworkKey = "flexlb.flexlet.io/nodeNetwork"
+	TrafficIPKey   = "flexlb.flexlet.io/trafficIP"
+	// drain node backends before removal, value is drain mode: drain (default) or maint
+	DrainKey = "flexlb.flexlet.io/drain"
+)
+
+// node label keys
+const (
+	TrafficInterfaceKey = "flexlb.flexlet.io/trafficInterface"
 )
 
 // node network annotation struct
@@ -75,34 +150,121 @@ type NodeNetwork struct {
 
 // service annotation keys
 const (
+	// cluster reference: "<name>" in the flexlb namespace, or "<namespace>/<name>"
 	ClusterKey  = "flexlb.flexlet.io/cluster"
 	IPPoolKey   = "flexlb.flexlet.io/ippool"
 	InstanceKey = "flexlb.flexlet.io/instance"
+	// instance label: namespace of the cluster, not set for clusters in the flexlb namespace
+	ClusterNamespaceKey = "flexlb.flexlet.io/clusterNamespace"
+	// label selector of nodes receiving load balancer traffic, e.g. "zone=a,!edge"
+	NodeSelectorKey = "flexlb.flexlet.io/nodeSelector"
+	// services with the same sharing key share one instance (frontend ip), also instance label
+	SharingKey = "flexlb.flexlet.io/sharingKey"
+	// kubernetes.io/tls secret of the frontend tls termination
+	TLSSecretKey = "flexlb.flexlet.io/tlsSecret"
+	// comma separated ports terminating tls, default all tcp ports
+	TLSPortsKey = "flexlb.flexlet.io/tlsPorts"
+	// comma separated client source cidrs rejected, allowed ones are spec.loadBalancerSourceRanges
+	DeniedSourceRangesKey = "flexlb.flexlet.io/deniedSourceRanges"
+	// proxy protocol version sent to backends: v1 or v2
+	ProxyProtocolKey = "flexlb.flexlet.io/proxyProtocol"
+	// "true" to accept proxy protocol on frontend
+	AcceptProxyProtocolKey = "flexlb.flexlet.io/acceptProxyProtocol"
+	// comma separated ports of proxy protocol, default all tcp ports
+	ProxyProtocolPortsKey = "flexlb.flexlet.io/proxyProtocolPorts"
+	// cookie name of session stickiness on http ports
+	AffinityCookieKey = "flexlb.flexlet.io/affinityCookie"
+	// max concurrent connections per frontend, 0: unlimited
+	MaxConnKey = "flexlb.flexlet.io/maxConn"
+	// max new connections per client source in rate period, 0: unlimited
+	ConnRateKey = "flexlb.flexlet.io/connRate"
+	// max http requests per client source in rate period, 0: unlimited
+	RequestRateKey = "flexlb.flexlet.io/requestRate"
+	// rate period in seconds
+	RatePeriodKey = "flexlb.flexlet.io/ratePeriod"
 )
 
 const (
-	ErrorNoIPPool = "ErrorNoIPPool"
+	ErrorNoIPPool        = "ErrorNoIPPool"
+	ErrorNoTrafficNodeIp = "ErrorNoTrafficNodeIp"
+	ErrorPortConflict    = "ErrorPortConflict"
+	ErrorBackendNotFound = "ErrorBackendNotFound"
 )
 
-func (h *Handler) lock(msg string, kvs ...interface{}) {
+func (h *Handler) lock(key string, msg string, kvs ...interface{}) {
 	log.Log.Info(msg, kvs...)
-	h.Lock()
+	h.locks.Lock(key)
 }
 
-func (h *Handler) unlock(msg string, kvs ...interface{}) {
-	h.Unlock()
+func (h *Handler) unlock(key string, msg string, kvs ...interface{}) {
+	h.locks.Unlock(key)
 	log.Log.Info(msg, kvs...)
 }
 
-func (h *Handler) errorf(object runtime.Object, reason string, err error, msgfmt string, args ...interface{}) error {
-	ref, _ := reference.GetReference(scheme.Scheme, object)
+// lock keys
+func serviceLockKey(namespace string, name string) string {
+	return "service/" + namespace + "/" + name
+}
+
+func instanceLockKey(namespace string, name string) string {
+	return "instance/" + namespace + "/" + name
+}
+
+func clusterLockKey(namespace string, name string) string {
+	return "cluster/" + namespace + "/" + name
+}
+
+func ippoolLockKey(clusterName string, ippoolName string) string {
+	return "ippool/" + clusterName + "/" + ippoolName
+}
+
+func nodeLockKey(name string) string {
+	return "node/" + name
+}
+
+func sharingLockKey(namespace string, sharingKey string) string {
+	return "sharing/" + namespace + "/" + sharingKey
+}
 
+func quotaLockKey(namespace string, clusterName string, ippoolName string) string {
+	return "quota/" + namespace + "/" + clusterName + "/" + ippoolName
+}
+
+func gatewayLockKey(namespace string, name string) string {
+	return "gateway/" + namespace + "/" + name
+}
+
+func ingressClassLockKey(name string) string {
+	return "ingressclass/" + name
+}
+
+// the recorder resolves object reference with the manager scheme
+func (h *Handler) errorf(object runtime.Object, reason string, err error, msgfmt string, args ...interface{}) error {
 	msg := fmt.Sprintf(msgfmt, args...)
 	if err != nil {
 		msg = msg + ": " + err.Error()
 	}
 
-	h.recorder.Event(ref, v1.EventTypeWarning, reason, msg)
+	class, exist := reasonClasses[reason]
+	if !exist {
+		class = ErrorClass(err)
+	}
 
-	return fmt.Errorf(msg)
+	h.warn(object, reason, reason, msg)
+	return &ReconcileError{Class: class, Reason: reason, msg: msg, err: err}
+}
+
+// warning event of a problem not failing the reconcile, aggregated per call site
+func (h *Handler) warnf(object runtime.Object, reason string, msgfmt string, args ...interface{}) {
+	h.warn(object, reason, reason+"/"+msgfmt, fmt.Sprintf(msgfmt, args...))
+}
+
+// warning event aggregated by object and key, repeats on retries are suppressed
+func (h *Handler) warn(object runtime.Object, reason string, key string, msg string) {
+	if accessor, e := meta.Accessor(object); e == nil {
+		key = string(accessor.GetUID()) + "/" + accessor.GetNamespace() + "/" + accessor.GetName() + "/" + key
+	}
+	if event, report := h.events.aggregate(key, msg, time.Now()); report {
+		h.recorder.Event(object, v1.EventTypeWarning, reason, event)
+	}
 }
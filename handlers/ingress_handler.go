@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	utl "github.com/flexlet/utils"
+)
+
+// ingresses of an ingress class controlled by flexlb share one instance (frontend ip) in the flexlb namespace,
+// the ingress rules are rendered to a http endpoint, requests are routed to backend servers by host and path
+
+const (
+	IngressControllerName = "flexlb.flexlet.io/ingress-controller"
+	// frontend port of ingress http endpoint
+	IngressHTTPPort = 80
+	// ingress class annotation before spec.ingressClassName
+	legacyIngressClassKey = "kubernetes.io/ingress.class"
+)
+
+// ingress route of host and path to service backend
+type ingressRoute struct {
+	host     string
+	path     string
+	pathType networkingv1.PathType
+	backend  string // backend id
+}
+
+// check whether ingress class is controlled by flexlb
+func IsFlexLBIngressClass(class *networkingv1.IngressClass) bool {
+	return class.Spec.Controller == IngressControllerName
+}
+
+// get ingress class name of ingress, the default flexlb ingress class if not set
+func IngressClassOfIngress(k8s client.Client, ctx context.Context, ing *networkingv1.Ingress) string {
+	if ing.Spec.IngressClassName != nil {
+		return *ing.Spec.IngressClassName
+	}
+	if className, exist := ing.Annotations[legacyIngressClassKey]; exist {
+		return className
+	}
+	classes := &networkingv1.IngressClassList{}
+	if err := k8s.List(ctx, classes); err != nil {
+		return ""
+	}
+	for i := 0; i < len(classes.Items); i++ {
+		class := &classes.Items[i]
+		if IsFlexLBIngressClass(class) && class.Annotations[networkingv1.AnnotationIsDefaultIngressClass] == "true" {
+			return class.Name
+		}
+	}
+	return ""
+}
+
+// allocate a flexlbinstance for the ingresses of ingress class, their rules are rendered to a http endpoint,
+// returns the duration after which the ingress class should be checked again, when backends are draining
+func (h *Handler) IngressClassChanged(k8s client.Client, ctx context.Context, class *networkingv1.IngressClass) (time.Duration, error) {
+	h.lock(ingressClassLockKey(class.Name), "update ingress class", "handler", "IngressClassChanged", "ingressclass", class.Name)
+	defer h.unlock(ingressClassLockKey(class.Name), "update ingress class end", "handler", "IngressClassChanged", "ingressclass", class.Name)
+
+	// ingress class changed to another controller, release instance
+	if !IsFlexLBIngressClass(class) {
+		return 0, h.deleteInstanceForIngressClass(k8s, ctx, class)
+	}
+
+	// get cluster from ingress class parameters, and ippool annotation
+	cluster, err := h.getIngressClassCluster(k8s, ctx, class)
+	if err != nil {
+		return 0, h.errorf(class, ErrorInvalidConfig, err, "invalid ingress class parameters")
+	}
+	ippoolName, exist := class.Annotations[IPPoolKey]
+	if !exist {
+		ippoolName = h.defaultIPPool
+	}
+	clusterName := h.clusterRef(client.ObjectKeyFromObject(cluster))
+	ippool, err := h.getIPPool(k8s, ctx, clusterName, ippoolName)
+	if err != nil {
+		return 0, h.errorf(class, ErrorNoIPPool, err, "ippool does not exist")
+	}
+
+	// ingresses of the class
+	all := &networkingv1.IngressList{}
+	if err := k8s.List(ctx, all); err != nil {
+		return 0, fmt.Errorf("list ingress failed: %s", err.Error())
+	}
+	ingresses := []*networkingv1.Ingress{}
+	for i := 0; i < len(all.Items); i++ {
+		if IngressClassOfIngress(k8s, ctx, &all.Items[i]) == class.Name {
+			ingresses = append(ingresses, &all.Items[i])
+		}
+	}
+	if len(ingresses) == 0 {
+		// no ingress left, release instance
+		return 0, h.deleteInstanceForIngressClass(k8s, ctx, class)
+	}
+
+	// render ingress rules
+	endpoints, routes, defaultBackend, nodes := h.getIngressEndpoints(k8s, ctx, ingresses, ippool)
+	if len(nodes.excluded) > 0 {
+		h.warnf(class, ErrorNoTrafficNodeIp, "nodes excluded from backends because no traffic ip found: %s",
+			strings.Join(nodes.excluded, ", "))
+	}
+
+	// create or update instance
+	inst := &crdv1.FlexLBInstance{}
+	instName, exist := class.Annotations[InstanceKey]
+	if !exist || k8s.Get(ctx, types.NamespacedName{Name: instName, Namespace: h.namespace}, inst) != nil {
+		inst = nil
+	}
+	draining, requeue := h.drainBackends(k8s, ctx, inst, endpoints, nodes)
+	// route to the backend servers left after drain
+	for _, ep := range endpoints {
+		renderIngressRoutes(ep, routes, defaultBackend)
+	}
+	if inst == nil {
+		inst, err = h.createIntance(k8s, ctx, clusterName, ippoolName, "ingress-"+class.Name, h.namespace,
+			map[string]string{IngressClassKey: class.Name}, map[string]string{}, endpoints, nodes)
+		if err != nil {
+			return 0, h.errorf(class, ErrorInstanceCreateFailed, err, "create instance failed")
+		}
+		if class.Annotations == nil {
+			class.Annotations = map[string]string{}
+		}
+		class.Annotations[InstanceKey] = inst.Name
+		if err := k8s.Update(ctx, class); err != nil {
+			return 0, err
+		}
+	} else if instanceChanged(inst, clusterName, ippoolName, endpoints, nodes) {
+		if inst, err = h.updateIntance(k8s, ctx, inst, clusterName, ippoolName, endpoints, nodes); err != nil {
+			return 0, h.errorf(class, ErrorInstanceModifyFailed, err, "update instance failed")
+		}
+	}
+	if err := updateDrainingStatus(k8s, ctx, inst, draining); err != nil {
+		return 0, err
+	}
+
+	// ingresses of the class get the frontend ip, ingresses moved away release it
+	ip := inst.Spec.Config.FrontendIpaddress
+	for i := 0; i < len(all.Items); i++ {
+		ing := &all.Items[i]
+		if IngressClassOfIngress(k8s, ctx, ing) == class.Name {
+			if err := updateIngressStatus(k8s, ctx, ing, ip); err != nil {
+				return 0, err
+			}
+		} else if ingressHasIp(ing, ip) {
+			if err := updateIngressStatus(k8s, ctx, ing, ""); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return requeue, nil
+}
+
+func (h *Handler) IngressClassDeleted(k8s client.Client, ctx context.Context, class *networkingv1.IngressClass) error {
+	h.lock(ingressClassLockKey(class.Name), "delete ingress class", "handler", "IngressClassDeleted", "ingressclass", class.Name)
+	defer h.unlock(ingressClassLockKey(class.Name), "delete ingress class end", "handler", "IngressClassDeleted", "ingressclass", class.Name)
+
+	return h.deleteInstanceForIngressClass(k8s, ctx, class)
+}
+
+// find flexlbinstance from ingress class annotation and delete it, ingresses release the frontend ip
+func (h *Handler) deleteInstanceForIngressClass(k8s client.Client, ctx context.Context, class *networkingv1.IngressClass) error {
+	instName, exist := class.Annotations[InstanceKey]
+	if !exist {
+		return nil
+	}
+	inst := &crdv1.FlexLBInstance{}
+	if err := k8s.Get(ctx, types.NamespacedName{Name: instName, Namespace: h.namespace}, inst); err == nil {
+		ingresses := &networkingv1.IngressList{}
+		if err := k8s.List(ctx, ingresses); err != nil {
+			return fmt.Errorf("list ingress failed: %s", err.Error())
+		}
+		for i := 0; i < len(ingresses.Items); i++ {
+			if ingressHasIp(&ingresses.Items[i], inst.Spec.Config.FrontendIpaddress) {
+				if err := updateIngressStatus(k8s, ctx, &ingresses.Items[i], ""); err != nil {
+					return err
+				}
+			}
+		}
+		if err := k8s.Delete(ctx, inst); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	delete(class.Annotations, InstanceKey)
+	return k8s.Update(ctx, class)
+}
+
+// get FlexLBCluster referenced by ingress class parameters, default cluster if not set
+func (h *Handler) getIngressClassCluster(k8s client.Client, ctx context.Context, class *networkingv1.IngressClass) (*crdv1.FlexLBCluster, error) {
+	clusterName := h.defaultCluster
+	if ref := class.Spec.Parameters; ref != nil {
+		if ref.APIGroup == nil || *ref.APIGroup != crdv1.GroupVersion.Group || ref.Kind != "FlexLBCluster" {
+			return nil, fmt.Errorf("parameters must be '%s/FlexLBCluster'", crdv1.GroupVersion.Group)
+		}
+		clusterName = ref.Name
+		if ref.Namespace != nil {
+			clusterName = *ref.Namespace + "/" + ref.Name
+		}
+	}
+	return h.getCluster(k8s, ctx, clusterName)
+}
+
+// render ingress rules to the http endpoint, with the backend servers of all routes,
+// returns the routes (most specific first) and the default backend, routing is rendered after drain
+func (h *Handler) getIngressEndpoints(k8s client.Client, ctx context.Context, ingresses []*networkingv1.Ingress,
+	ippool *crdv1.FlexLBIPPool) ([]*models.Endpoint, []ingressRoute, string, *backendNodes) {
+	nodes := newBackendNodes()
+	backendDefaultOptions := defaultBackendServerOptions
+	ep := &models.Endpoint{
+		FrontendPort:         IngressHTTPPort,
+		Mode:                 models.EndpointModeHTTP,
+		Balance:              "roundrobin",
+		BackendOptions:       []string{},
+		BackendDefaultServer: &backendDefaultOptions,
+		BackendServers:       []*models.BackendServer{},
+	}
+
+	// oldest ingress wins the default backend
+	sort.SliceStable(ingresses, func(i, j int) bool {
+		if !ingresses[i].CreationTimestamp.Equal(&ingresses[j].CreationTimestamp) {
+			return ingresses[i].CreationTimestamp.Before(&ingresses[j].CreationTimestamp)
+		}
+		return ingresses[i].Namespace+"/"+ingresses[i].Name < ingresses[j].Namespace+"/"+ingresses[j].Name
+	})
+
+	// resolve backend servers once per service port
+	resolved := map[string]bool{}
+	resolve := func(ing *networkingv1.Ingress, backend *networkingv1.IngressBackend) string {
+		if backend.Service == nil {
+			h.warnf(ing, ErrorBackendNotFound, "resource backend not supported")
+			return ""
+		}
+		id := ingressBackendId(ing.Namespace, backend.Service)
+		if ok, exist := resolved[id]; exist {
+			if !ok {
+				return ""
+			}
+			return id
+		}
+		servers, err := ingressBackendServers(k8s, ctx, ing.Namespace, backend.Service, id, ippool, nodes)
+		resolved[id] = err == nil
+		if err != nil {
+			h.warnf(ing, ErrorBackendNotFound, "backend service '%s' not resolved: %s", backend.Service.Name, err.Error())
+			return ""
+		}
+		ep.BackendServers = append(ep.BackendServers, servers...)
+		return id
+	}
+
+	routes := []ingressRoute{}
+	defaultBackend := ""
+	for _, ing := range ingresses {
+		if ing.Spec.DefaultBackend != nil && defaultBackend == "" {
+			defaultBackend = resolve(ing, ing.Spec.DefaultBackend)
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				id := resolve(ing, &path.Backend)
+				if id == "" {
+					continue
+				}
+				pathType := networkingv1.PathTypePrefix
+				if path.PathType != nil && *path.PathType == networkingv1.PathTypeExact {
+					pathType = networkingv1.PathTypeExact
+				}
+				routes = append(routes, ingressRoute{host: rule.Host, path: path.Path, pathType: pathType, backend: id})
+			}
+		}
+	}
+
+	// most specific route first: exact host, wildcard host, any host, then longer path, exact path
+	hostRank := func(host string) int {
+		if host == "" {
+			return 2
+		} else if strings.HasPrefix(host, "*.") {
+			return 1
+		}
+		return 0
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		if hostRank(routes[i].host) != hostRank(routes[j].host) {
+			return hostRank(routes[i].host) < hostRank(routes[j].host)
+		}
+		if len(routes[i].path) != len(routes[j].path) {
+			return len(routes[i].path) > len(routes[j].path)
+		}
+		return routes[i].pathType == networkingv1.PathTypeExact && routes[j].pathType != networkingv1.PathTypeExact
+	})
+	return []*models.Endpoint{ep}, routes, defaultBackend, nodes
+}
+
+// backend servers of ingress service backend: node ports of the nodes with service endpoints,
+// one server per node, kube-proxy balances the node port to the pods
+func ingressBackendServers(k8s client.Client, ctx context.Context, namespace string, backend *networkingv1.IngressServiceBackend,
+	id string, ippool *crdv1.FlexLBIPPool, nodes *backendNodes) ([]*models.BackendServer, error) {
+	svc := &v1.Service{}
+	if err := k8s.Get(ctx, types.NamespacedName{Namespace: namespace, Name: backend.Name}, svc); err != nil {
+		return nil, fmt.Errorf("service not found")
+	}
+	var svcPort *v1.ServicePort
+	for i := 0; i < len(svc.Spec.Ports); i++ {
+		p := &svc.Spec.Ports[i]
+		if (backend.Port.Name != "" && p.Name == backend.Port.Name) || (backend.Port.Name == "" && p.Port == backend.Port.Number) {
+			svcPort = p
+			break
+		}
+	}
+	if svcPort == nil {
+		return nil, fmt.Errorf("port not found")
+	}
+	if svcPort.NodePort == 0 || svcPort.Protocol != v1.ProtocolTCP {
+		return nil, fmt.Errorf("port %d is not a tcp node port", svcPort.Port)
+	}
+
+	selector, err := getNodeSelector(svc, ippool)
+	if err != nil {
+		return nil, err
+	}
+	svcEndpoints, svcNodes, err := getNodeIpEndpoints(k8s, ctx, svc, ippool, selector)
+	if err != nil {
+		return nil, err
+	}
+	nodes.merge(svcNodes)
+
+	servers := []*models.BackendServer{}
+	ep := findEndpoint(svcEndpoints, uint16(svcPort.Port), serviceEndpointMode(*svcPort))
+	if ep == nil {
+		return servers, nil
+	}
+	for _, server := range ep.BackendServers {
+		server.Name = ingressServerName(id, nodeOfTrafficIp(svcNodes.trafficIps, server.Ipaddress))
+		if !hasBackend(servers, server.Name) {
+			servers = append(servers, server)
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Name < servers[j].Name
+	})
+	return servers, nil
+}
+
+// short stable id of ingress service backend, prefix of its backend server names
+func ingressBackendId(namespace string, backend *networkingv1.IngressServiceBackend) string {
+	port := backend.Port.Name
+	if port == "" {
+		port = fmt.Sprintf("%d", backend.Port.Number)
+	}
+	return shortHash(namespace + "/" + backend.Name + ":" + port)
+}
+
+// backend server name <backend id>-<node>, hashed node name if too long
+func ingressServerName(id string, nodeName string) string {
+	name := id + "-" + nodeName
+	if len(name) > 32 {
+		name = id + "-" + shortHash(nodeName)
+	}
+	return name
+}
+
+func shortHash(s string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(s))
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+func hasBackend(servers []*models.BackendServer, name string) bool {
+	for _, server := range servers {
+		if server.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// render routes as backend options: acls of host and path, and use-server rules to the servers of the route backend,
+// server order is rotated per route to spread the routes over the nodes, draining servers are used last,
+// requests matching no route go to the default backend, or are denied with 404
+func renderIngressRoutes(ep *models.Endpoint, routes []ingressRoute, defaultBackend string) {
+	options := []string{}
+	useServers := []string{}
+	conds := []string{}
+	catchAll := false
+	for i, route := range routes {
+		servers := routeServers(ep, route.backend, i)
+		if len(servers) == 0 {
+			continue
+		}
+		acl := fmt.Sprintf("r%d", i)
+		cond := []string{}
+		if route.host != "" {
+			options = append(options, hostAcl(acl+"_host", route.host))
+			cond = append(cond, acl+"_host")
+		}
+		if paths := pathAcls(acl+"_path", route); len(paths) > 0 {
+			options = append(options, paths...)
+			cond = append(cond, acl+"_path")
+		}
+		if len(cond) == 0 {
+			catchAll = true
+		}
+		for _, server := range servers {
+			if len(cond) == 0 {
+				useServers = append(useServers, "use-server "+server.Name)
+			} else {
+				useServers = append(useServers, fmt.Sprintf("use-server %s if %s", server.Name, strings.Join(cond, " ")))
+			}
+		}
+		conds = append(conds, strings.Join(cond, " "))
+	}
+	if defaultBackend != "" {
+		for _, server := range routeServers(ep, defaultBackend, len(routes)) {
+			useServers = append(useServers, "use-server "+server.Name)
+			catchAll = true
+		}
+	}
+	if !catchAll {
+		// http-request rules run before use-server rules
+		deny := "http-request deny deny_status 404"
+		if len(conds) > 0 {
+			deny += " unless " + strings.Join(conds, " or ")
+		}
+		options = append(options, deny)
+	}
+	ep.BackendOptions = append(options, useServers...)
+}
+
+// backend servers of route backend, rotated by route index, draining servers last
+func routeServers(ep *models.Endpoint, backend string, rotate int) []*models.BackendServer {
+	servers := []*models.BackendServer{}
+	for _, server := range ep.BackendServers {
+		if strings.HasPrefix(server.Name, backend+"-") {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) == 0 {
+		return servers
+	}
+	rotated := append(servers[rotate%len(servers):], servers[:rotate%len(servers)]...)
+	sort.SliceStable(rotated, func(i, j int) bool {
+		return rotated[i].Options == nil && rotated[j].Options != nil
+	})
+	return rotated
+}
+
+// acl of request host, without port, wildcard matches one dns label
+func hostAcl(name string, host string) string {
+	if strings.HasPrefix(host, "*.") {
+		return fmt.Sprintf(`acl %s req.hdr(host),field(1,:) -i -m reg ^[^.]+%s$`, name, strings.ReplaceAll(host[1:], ".", `\.`))
+	}
+	return fmt.Sprintf("acl %s req.hdr(host),field(1,:) -i %s", name, host)
+}
+
+// acls of request path, prefix matches path elements, empty if any path matches
+func pathAcls(name string, route ingressRoute) []string {
+	if route.pathType == networkingv1.PathTypeExact {
+		return []string{fmt.Sprintf("acl %s path %s", name, route.path)}
+	}
+	prefix := strings.TrimSuffix(route.path, "/")
+	if prefix == "" {
+		return []string{}
+	}
+	return []string{
+		fmt.Sprintf("acl %s path %s", name, prefix),
+		fmt.Sprintf("acl %s path_beg %s/", name, prefix),
+	}
+}
+
+// check whether ingress load balancer status is the frontend ip
+func ingressHasIp(ing *networkingv1.Ingress, ip string) bool {
+	lb := ing.Status.LoadBalancer.Ingress
+	return len(lb) == 1 && lb[0].IP == ip
+}
+
+// set ingress load balancer status to the frontend ip, clear it if ip is empty
+func updateIngressStatus(k8s client.Client, ctx context.Context, ing *networkingv1.Ingress, ip string) error {
+	lb := []v1.LoadBalancerIngress{}
+	if ip != "" {
+		lb = append(lb, v1.LoadBalancerIngress{IP: ip})
+	}
+	if cmp.Equal(ing.Status.LoadBalancer.Ingress, lb, cmpopts.EquateEmpty()) {
+		return nil
+	}
+	ing.Status.LoadBalancer.Ingress = lb
+	return k8s.Status().Update(ctx, ing)
+}
+
+// flexlb ingress classes of the ingresses in namespace referencing the service
+func IngressClassesOfService(k8s client.Client, ctx context.Context, namespace string, name string) []string {
+	classes := []string{}
+	ingresses := &networkingv1.IngressList{}
+	if err := k8s.List(ctx, ingresses, client.InNamespace(namespace)); err != nil {
+		return classes
+	}
+	for i := 0; i < len(ingresses.Items); i++ {
+		ing := &ingresses.Items[i]
+		if !ingressReferences(ing, name) {
+			continue
+		}
+		if className := IngressClassOfIngress(k8s, ctx, ing); className != "" && !utl.ListContains(classes, className) {
+			classes = append(classes, className)
+		}
+	}
+	return classes
+}
+
+// check whether ingress references the service
+func ingressReferences(ing *networkingv1.Ingress, name string) bool {
+	if ing.Spec.DefaultBackend != nil && ing.Spec.DefaultBackend.Service != nil && ing.Spec.DefaultBackend.Service.Name == name {
+		return true
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil && path.Backend.Service.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
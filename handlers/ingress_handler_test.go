@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func ingressClass(name string, controller string, isDefault bool) *networkingv1.IngressClass {
+	class := &networkingv1.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}},
+		Spec:       networkingv1.IngressClassSpec{Controller: controller},
+	}
+	if isDefault {
+		class.Annotations[networkingv1.AnnotationIsDefaultIngressClass] = "true"
+	}
+	return class
+}
+
+func TestIngressClassOfIngress(t *testing.T) {
+	className := "explicit"
+	tests := []struct {
+		name    string
+		ing     *networkingv1.Ingress
+		classes []*networkingv1.IngressClass
+		want    string
+	}{
+		{
+			name: "ingress class name",
+			ing: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{legacyIngressClassKey: "legacy"}},
+				Spec:       networkingv1.IngressSpec{IngressClassName: &className},
+			},
+			want: "explicit",
+		},
+		{
+			name: "legacy annotation",
+			ing:  &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{legacyIngressClassKey: "legacy"}}},
+			want: "legacy",
+		},
+		{
+			name: "default flexlb class",
+			ing:  &networkingv1.Ingress{},
+			classes: []*networkingv1.IngressClass{
+				ingressClass("nginx", "k8s.io/ingress-nginx", true),
+				ingressClass("flexlb", IngressControllerName, true),
+			},
+			want: "flexlb",
+		},
+		{
+			name:    "no default class",
+			ing:     &networkingv1.Ingress{},
+			classes: []*networkingv1.IngressClass{ingressClass("flexlb", IngressControllerName, false)},
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8s := newFakeClient()
+			for _, class := range tt.classes {
+				if err := k8s.Create(context.Background(), class); err != nil {
+					t.Fatalf("create ingress class failed: %s", err)
+				}
+			}
+			if got := IngressClassOfIngress(k8s, context.Background(), tt.ing); got != tt.want {
+				t.Errorf("IngressClassOfIngress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngressBackendId(t *testing.T) {
+	byNumber := ingressBackendId("default", &networkingv1.IngressServiceBackend{Name: "web", Port: networkingv1.ServiceBackendPort{Number: 80}})
+	byName := ingressBackendId("default", &networkingv1.IngressServiceBackend{Name: "web", Port: networkingv1.ServiceBackendPort{Name: "http"}})
+	otherNamespace := ingressBackendId("other", &networkingv1.IngressServiceBackend{Name: "web", Port: networkingv1.ServiceBackendPort{Number: 80}})
+
+	if byNumber != shortHash("default/web:80") || byName != shortHash("default/web:http") {
+		t.Errorf("ingressBackendId() = %q, %q, want hashes of namespace/name:port", byNumber, byName)
+	}
+	if len(byNumber) != 8 {
+		t.Errorf("ingressBackendId() = %q, want 8 hex digits", byNumber)
+	}
+	if byNumber == otherNamespace {
+		t.Errorf("ingressBackendId() of namespaces default and other are both %q", byNumber)
+	}
+}
+
+func TestIngressServerName(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		node string
+		want string
+	}{
+		{name: "short node name", id: "0a1b2c3d", node: "node-1", want: "0a1b2c3d-node-1"},
+		{name: "long node name hashed", id: "0a1b2c3d", node: "worker-node-with-a-very-long-name", want: "0a1b2c3d-" + shortHash("worker-node-with-a-very-long-name")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ingressServerName(tt.id, tt.node); got != tt.want {
+				t.Errorf("ingressServerName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostAcl(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{name: "exact host", host: "www.example.com", want: "acl r0_host req.hdr(host),field(1,:) -i www.example.com"},
+		{name: "wildcard host", host: "*.example.com", want: `acl r0_host req.hdr(host),field(1,:) -i -m reg ^[^.]+\.example\.com$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostAcl("r0_host", tt.host); got != tt.want {
+				t.Errorf("hostAcl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathAcls(t *testing.T) {
+	tests := []struct {
+		name  string
+		route ingressRoute
+		want  []string
+	}{
+		{
+			name:  "exact path",
+			route: ingressRoute{path: "/api", pathType: networkingv1.PathTypeExact},
+			want:  []string{"acl r0_path path /api"},
+		},
+		{
+			name:  "prefix path",
+			route: ingressRoute{path: "/api/", pathType: networkingv1.PathTypePrefix},
+			want:  []string{"acl r0_path path /api", "acl r0_path path_beg /api/"},
+		},
+		{
+			name:  "root prefix matches any path",
+			route: ingressRoute{path: "/", pathType: networkingv1.PathTypePrefix},
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pathAcls("r0_path", tt.route); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pathAcls() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteServers(t *testing.T) {
+	drain := "weight 0"
+	ep := &models.Endpoint{BackendServers: []*models.BackendServer{
+		{Name: "aaaa-node-1"},
+		{Name: "aaaa-node-2", Options: &drain},
+		{Name: "aaaa-node-3"},
+		{Name: "bbbb-node-1"},
+	}}
+	tests := []struct {
+		name    string
+		backend string
+		rotate  int
+		want    []string
+	}{
+		{name: "servers of backend, draining last", backend: "aaaa", rotate: 0, want: []string{"aaaa-node-1", "aaaa-node-3", "aaaa-node-2"}},
+		{name: "rotated by route index", backend: "aaaa", rotate: 2, want: []string{"aaaa-node-3", "aaaa-node-1", "aaaa-node-2"}},
+		{name: "other backend", backend: "bbbb", rotate: 1, want: []string{"bbbb-node-1"}},
+		{name: "unknown backend", backend: "cccc", rotate: 0, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, server := range routeServers(ep, tt.backend, tt.rotate) {
+				got = append(got, server.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("routeServers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderIngressRoutes(t *testing.T) {
+	servers := func() []*models.BackendServer {
+		return []*models.BackendServer{{Name: "aaaa-node-1"}, {Name: "bbbb-node-1"}}
+	}
+	tests := []struct {
+		name           string
+		routes         []ingressRoute
+		defaultBackend string
+		want           []string
+	}{
+		{
+			name: "host and path routes, others denied",
+			routes: []ingressRoute{
+				{host: "www.example.com", path: "/api", pathType: networkingv1.PathTypePrefix, backend: "aaaa"},
+				{host: "www.example.com", path: "/", pathType: networkingv1.PathTypePrefix, backend: "bbbb"},
+			},
+			want: []string{
+				"acl r0_host req.hdr(host),field(1,:) -i www.example.com",
+				"acl r0_path path /api",
+				"acl r0_path path_beg /api/",
+				"acl r1_host req.hdr(host),field(1,:) -i www.example.com",
+				"http-request deny deny_status 404 unless r0_host r0_path or r1_host",
+				"use-server aaaa-node-1 if r0_host r0_path",
+				"use-server bbbb-node-1 if r1_host",
+			},
+		},
+		{
+			name:           "default backend catches all",
+			routes:         []ingressRoute{{path: "/api", pathType: networkingv1.PathTypeExact, backend: "aaaa"}},
+			defaultBackend: "bbbb",
+			want: []string{
+				"acl r0_path path /api",
+				"use-server aaaa-node-1 if r0_path",
+				"use-server bbbb-node-1",
+			},
+		},
+		{
+			name:   "route without host and path catches all",
+			routes: []ingressRoute{{path: "/", pathType: networkingv1.PathTypePrefix, backend: "aaaa"}},
+			want:   []string{"use-server aaaa-node-1"},
+		},
+		{
+			name:   "route without servers skipped",
+			routes: []ingressRoute{{host: "www.example.com", path: "/", pathType: networkingv1.PathTypePrefix, backend: "cccc"}},
+			want:   []string{"http-request deny deny_status 404"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ep := &models.Endpoint{BackendServers: servers()}
+			renderIngressRoutes(ep, tt.routes, tt.defaultBackend)
+			if !reflect.DeepEqual(ep.BackendOptions, tt.want) {
+				t.Errorf("renderIngressRoutes() options = %#v, want %#v", ep.BackendOptions, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngressReferences(t *testing.T) {
+	backend := func(name string) networkingv1.IngressBackend {
+		return networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: name}}
+	}
+	defaultBackend := backend("default-web")
+	ing := &networkingv1.Ingress{Spec: networkingv1.IngressSpec{
+		DefaultBackend: &defaultBackend,
+		Rules: []networkingv1.IngressRule{
+			{Host: "no-http.example.com"},
+			{IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{Path: "/", Backend: backend("web")}},
+			}}},
+		},
+	}}
+	tests := []struct {
+		name    string
+		service string
+		want    bool
+	}{
+		{name: "default backend", service: "default-web", want: true},
+		{name: "rule backend", service: "web", want: true},
+		{name: "not referenced", service: "db", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ingressReferences(ing, tt.service); got != tt.want {
+				t.Errorf("ingressReferences(%q) = %v, want %v", tt.service, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	utl "github.com/flexlet/utils"
+)
+
+// check whether objects of namespace may allocate ips of the ippool: all namespaces if the ippool restricts none,
+// otherwise the namespaces listed in allowed_namespaces or matching namespace_selector
+func checkIPPoolAccess(k8s client.Client, ctx context.Context, clusterName string, ippool *crdv1.FlexLBIPPool, namespace string) error {
+	if len(ippool.AllowedNamespaces) == 0 && ippool.NamespaceSelector == nil {
+		return nil
+	}
+	if utl.ListContains(ippool.AllowedNamespaces, namespace) {
+		return nil
+	}
+	if ippool.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(ippool.NamespaceSelector)
+		if err != nil {
+			return classError(crdv1.ErrorClassInvalidConfig, fmt.Errorf("namespace_selector of ippool '%s' in cluster '%s' is invalid: %s",
+				ippool.Name, clusterName, err.Error()))
+		}
+		ns := &v1.Namespace{}
+		if err := k8s.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+			return fmt.Errorf("get namespace '%s' failed: %w", namespace, err)
+		}
+		if selector.Matches(labels.Set(ns.Labels)) {
+			return nil
+		}
+	}
+	return classError(crdv1.ErrorClassAuth, fmt.Errorf("namespace '%s' is not allowed to use ippool '%s' in cluster '%s'",
+		namespace, ippool.Name, clusterName))
+}
+
+// max ips of the ippool allocated to namespace, 0: unlimited
+func ipPoolQuota(ippool *crdv1.FlexLBIPPool, namespace string) int32 {
+	if quota, exist := ippool.NamespaceQuotas[namespace]; exist {
+		return quota
+	}
+	return ippool.NamespaceQuota
+}
+
+// check whether namespace may allocate one more ip of the ippool, the instance exclude is not counted
+func (h *Handler) checkIPPoolQuota(k8s client.Client, ctx context.Context, clusterKey types.NamespacedName, ippool *crdv1.FlexLBIPPool,
+	namespace string, exclude string) error {
+	quota := ipPoolQuota(ippool, namespace)
+	if quota == 0 {
+		return nil
+	}
+	insts := &crdv1.FlexLBInstanceList{}
+	if err := k8s.List(ctx, insts, client.InNamespace(namespace), h.clusterSelector(clusterKey, ippool.Name)); err != nil {
+		return fmt.Errorf("list instances of namespace '%s' failed: %w", namespace, err)
+	}
+	// instances created or moved by the last reconciles may not be in the informer cache yet
+	visible := []string{}
+	for i := range insts.Items {
+		visible = append(visible, insts.Items[i].Name)
+	}
+	used := int32(0)
+	for _, name := range append(visible, h.reservations(quotaLockKey(namespace, h.clusterRef(clusterKey), ippool.Name), visible)...) {
+		if name != exclude {
+			used++
+		}
+	}
+	if used >= quota {
+		// retried, ips may be released by other objects of the namespace
+		return classError(crdv1.ErrorClassConflict, fmt.Errorf("namespace '%s' has %d of %d ips of ippool '%s' in cluster '%s' allocated",
+			namespace, used, quota, ippool.Name, h.clusterRef(clusterKey)))
+	}
+	return nil
+}
+
+// reserve the quota of namespace for the instance created in or moved to the ippool, until visible in the informer cache
+func (h *Handler) reserveQuota(namespace string, clusterName string, ippool *crdv1.FlexLBIPPool, instName string) {
+	if ipPoolQuota(ippool, namespace) > 0 {
+		h.reserve(quotaLockKey(namespace, clusterName, ippool.Name), instName)
+	}
+}
+
+// ValidateService checks on admission that a load balancer service may use its cluster and ippool, and that a new ip
+// is within the quota of its namespace. clusters or ippools not existing yet are left to the reconcile.
+// old is the service before an update, nil on create; services deleted or updated without changing the type, class,
+// cluster, ippool or sharing key are allowed, e.g. the controller removing its annotations and finalizer
+func (h *Handler) ValidateService(k8s client.Client, ctx context.Context, svc *v1.Service, old *v1.Service) error {
+	if svc.Spec.Type != v1.ServiceTypeLoadBalancer || !IsLoadBalancerClass(svc, h.loadBalancerClass) {
+		return nil
+	}
+	if svc.DeletionTimestamp != nil || (old != nil && !allocationChanged(old, svc)) {
+		return nil
+	}
+
+	clusterRef, exist := svc.Annotations[ClusterKey]
+	if !exist {
+		clusterRef = h.defaultCluster
+	}
+	clusterKey, err := h.clusterKey(clusterRef)
+	if err != nil {
+		return err
+	}
+	if err := h.checkClusterAccess(k8s, ctx, clusterKey, svc.Namespace); err != nil {
+		return err
+	}
+	clusterName := h.clusterRef(clusterKey)
+
+	ippoolName, exist := svc.Annotations[IPPoolKey]
+	if !exist {
+		ippoolName = h.defaultIPPool
+	}
+	ippool, err := h.getIPPool(k8s, ctx, clusterName, ippoolName)
+	if err != nil {
+		return nil
+	}
+	if err := checkIPPoolAccess(k8s, ctx, clusterName, ippool, svc.Namespace); err != nil {
+		return err
+	}
+
+	// no new ip: the allocated instance stays in the ippool, or the instance of the sharing key is joined
+	if instName, exist := svc.Annotations[InstanceKey]; exist {
+		inst := &crdv1.FlexLBInstance{}
+		if err := k8s.Get(ctx, types.NamespacedName{Name: instName, Namespace: svc.Namespace}, inst); err == nil &&
+			inst.Spec.Cluster == clusterName && inst.Spec.IPPool == ippoolName {
+			return nil
+		}
+	}
+	if sharingKey, err := getSharingKey(svc); err == nil && sharingKey != "" {
+		if shared, err := h.findSharedInstance(k8s, ctx, svc.Namespace, sharingKey, clusterName, ippoolName); err == nil && shared != nil {
+			return nil
+		}
+	}
+	return h.checkIPPoolQuota(k8s, ctx, clusterKey, ippool, svc.Namespace, "")
+}
+
+// whether the update changes the fields deciding the cluster, ippool and instance of the service
+func allocationChanged(old *v1.Service, svc *v1.Service) bool {
+	if old.Spec.Type != svc.Spec.Type || !reflect.DeepEqual(old.Spec.LoadBalancerClass, svc.Spec.LoadBalancerClass) {
+		return true
+	}
+	for _, key := range []string{ClusterKey, IPPoolKey, SharingKey} {
+		oldValue, oldExist := old.Annotations[key]
+		value, exist := svc.Annotations[key]
+		if oldValue != value || oldExist != exist {
+			return true
+		}
+	}
+	return false
+}
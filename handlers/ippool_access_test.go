@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// instance of namespace default in the default cluster and ippool
+func testPoolInstance(name string) *crdv1.FlexLBInstance {
+	return &crdv1.FlexLBInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{ClusterKey: DefaultClusterName, IPPoolKey: DefaultIPPoolName},
+		},
+		Spec: crdv1.FlexLBInstanceSpec{Cluster: DefaultClusterName, IPPool: DefaultIPPoolName},
+	}
+}
+
+func TestCheckIPPoolAccess(t *testing.T) {
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"tier": "web"}}}
+	tests := []struct {
+		name      string
+		ippool    crdv1.FlexLBIPPool
+		wantClass string
+	}{
+		{name: "not restricted"},
+		{name: "allowed namespace", ippool: crdv1.FlexLBIPPool{AllowedNamespaces: []string{"kube-system", "default"}}},
+		{name: "not allowed namespace", ippool: crdv1.FlexLBIPPool{AllowedNamespaces: []string{"kube-system"}}, wantClass: crdv1.ErrorClassAuth},
+		{
+			name:   "namespace selector",
+			ippool: crdv1.FlexLBIPPool{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "web"}}},
+		},
+		{
+			name:      "namespace selector not matching",
+			ippool:    crdv1.FlexLBIPPool{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "db"}}},
+			wantClass: crdv1.ErrorClassAuth,
+		},
+		{
+			name: "invalid namespace selector",
+			ippool: crdv1.FlexLBIPPool{NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: "Like"}},
+			}},
+			wantClass: crdv1.ErrorClassInvalidConfig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.ippool.Name = DefaultIPPoolName
+			err := checkIPPoolAccess(newFakeClient(ns), context.Background(), DefaultClusterName, &tt.ippool, "default")
+			if tt.wantClass == "" && err != nil {
+				t.Fatalf("checkIPPoolAccess() error = %v", err)
+			}
+			if tt.wantClass != "" && (err == nil || ErrorClass(err) != tt.wantClass) {
+				t.Fatalf("checkIPPoolAccess() error = %v, want class %q", err, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestIPPoolQuota(t *testing.T) {
+	ippool := &crdv1.FlexLBIPPool{NamespaceQuota: 2, NamespaceQuotas: map[string]int32{"tenant-a": 5}}
+	if got := ipPoolQuota(ippool, "default"); got != 2 {
+		t.Errorf("ipPoolQuota() = %d, want the namespace quota 2", got)
+	}
+	if got := ipPoolQuota(ippool, "tenant-a"); got != 5 {
+		t.Errorf("ipPoolQuota() = %d, want the quota of the namespace 5", got)
+	}
+}
+
+func TestCheckIPPoolQuota(t *testing.T) {
+	ctx := context.Background()
+	clusterKey := types.NamespacedName{Name: DefaultClusterName, Namespace: testNamespace}
+	ippool := &crdv1.FlexLBIPPool{Name: DefaultIPPoolName, NamespaceQuota: 2}
+	tests := []struct {
+		name      string
+		instances []string
+		reserved  []string
+		exclude   string
+		wantErr   bool
+	}{
+		{name: "under quota", instances: []string{"web-1a2b"}},
+		{name: "quota exceeded", instances: []string{"web-1a2b", "api-1a2b"}, wantErr: true},
+		{name: "instance left not counted", instances: []string{"web-1a2b", "api-1a2b"}, exclude: "api-1a2b"},
+		{name: "instance not in the cache counted", instances: []string{"web-1a2b"}, reserved: []string{"api-1a2b"}, wantErr: true},
+		{name: "reserved instance in the cache counted once", instances: []string{"web-1a2b"}, reserved: []string{"web-1a2b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler()
+			objs := []client.Object{}
+			for _, name := range tt.instances {
+				objs = append(objs, testPoolInstance(name))
+			}
+			for _, name := range tt.reserved {
+				h.reserveQuota("default", DefaultClusterName, ippool, name)
+			}
+			err := h.checkIPPoolQuota(newFakeClient(objs...), ctx, clusterKey, ippool, "default", tt.exclude)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkIPPoolQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && ErrorClass(err) != crdv1.ErrorClassConflict {
+				t.Errorf("checkIPPoolQuota() error class = %q, want conflict", ErrorClass(err))
+			}
+		})
+	}
+
+	t.Run("no quota, nothing reserved", func(t *testing.T) {
+		h, _ := newTestHandler()
+		h.reserveQuota("default", DefaultClusterName, &crdv1.FlexLBIPPool{Name: DefaultIPPoolName}, "web-1a2b")
+		if reserved := h.reservations(quotaLockKey("default", DefaultClusterName, DefaultIPPoolName), nil); len(reserved) != 0 {
+			t.Errorf("reservations of an ippool without quota = %v, want none", reserved)
+		}
+	})
+}
+
+func TestValidateService(t *testing.T) {
+	ctx := context.Background()
+	withCluster := func(svc *v1.Service, clusterRef string) *v1.Service {
+		svc.Annotations[ClusterKey] = clusterRef
+		return svc
+	}
+	deleted := func(svc *v1.Service) *v1.Service {
+		now := metav1.Now()
+		svc.DeletionTimestamp = &now
+		return svc
+	}
+	tests := []struct {
+		name      string
+		ippool    crdv1.FlexLBIPPool
+		instances []string
+		svc       *v1.Service
+		old       *v1.Service
+		wantClass string
+	}{
+		{name: "allowed", svc: testService(map[string]string{})},
+		{name: "not a load balancer", ippool: crdv1.FlexLBIPPool{AllowedNamespaces: []string{"kube-system"}}, svc: func() *v1.Service {
+			svc := testService(map[string]string{})
+			svc.Spec.Type = v1.ServiceTypeClusterIP
+			return svc
+		}()},
+		{name: "ippool not allowed", ippool: crdv1.FlexLBIPPool{AllowedNamespaces: []string{"kube-system"}}, svc: testService(map[string]string{}), wantClass: crdv1.ErrorClassAuth},
+		{name: "cluster not granted", svc: withCluster(testService(map[string]string{}), "tenant-a/edge"), wantClass: crdv1.ErrorClassAuth},
+		{name: "cluster namespace not watched", svc: withCluster(testService(map[string]string{}), "tenant-b/edge"), wantClass: crdv1.ErrorClassInvalidConfig},
+		{
+			name:      "quota exceeded",
+			ippool:    crdv1.FlexLBIPPool{NamespaceQuota: 1},
+			instances: []string{"api-1a2b"},
+			svc:       testService(map[string]string{}),
+			wantClass: crdv1.ErrorClassConflict,
+		},
+		{
+			name:      "allocated instance kept",
+			ippool:    crdv1.FlexLBIPPool{NamespaceQuota: 1},
+			instances: []string{"web-1a2b"},
+			svc:       testService(map[string]string{InstanceKey: "web-1a2b"}),
+		},
+		{
+			name:   "deleted",
+			ippool: crdv1.FlexLBIPPool{AllowedNamespaces: []string{"kube-system"}},
+			svc:    deleted(withCluster(testService(map[string]string{}), "tenant-a/edge")),
+		},
+		{
+			name:   "update of the controller",
+			ippool: crdv1.FlexLBIPPool{AllowedNamespaces: []string{"kube-system"}},
+			svc:    testService(map[string]string{}),
+			old:    testService(map[string]string{InstanceKey: "web-1a2b"}),
+		},
+		{
+			name:      "update changing the ippool",
+			ippool:    crdv1.FlexLBIPPool{AllowedNamespaces: []string{"kube-system"}},
+			svc:       testService(map[string]string{IPPoolKey: DefaultIPPoolName}),
+			old:       testService(map[string]string{}),
+			wantClass: crdv1.ErrorClassAuth,
+		},
+		{
+			name:   "update changing the type",
+			ippool: crdv1.FlexLBIPPool{AllowedNamespaces: []string{"kube-system"}},
+			svc:    testService(map[string]string{}),
+			old: func() *v1.Service {
+				svc := testService(map[string]string{})
+				svc.Spec.Type = v1.ServiceTypeNodePort
+				return svc
+			}(),
+			wantClass: crdv1.ErrorClassAuth,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler()
+			h.SetClusterNamespaces([]string{"tenant-a"})
+			objs := []client.Object{testCluster(tt.ippool)}
+			for _, name := range tt.instances {
+				objs = append(objs, testPoolInstance(name))
+			}
+			k8s := &reviewClient{Client: newFakeClient(objs...)}
+
+			err := h.ValidateService(k8s, ctx, tt.svc, tt.old)
+			if tt.wantClass == "" && err != nil {
+				t.Fatalf("ValidateService() error = %v", err)
+			}
+			if tt.wantClass != "" && (err == nil || ErrorClass(err) != tt.wantClass) {
+				t.Fatalf("ValidateService() error = %v, want class %q", err, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestAllocationChanged(t *testing.T) {
+	class := "flexlb.flexlet.io/flexlb"
+	tests := []struct {
+		name   string
+		modify func(svc *v1.Service)
+		want   bool
+	}{
+		{name: "instance annotation removed", modify: func(svc *v1.Service) { delete(svc.Annotations, InstanceKey) }},
+		{name: "ports changed", modify: func(svc *v1.Service) { svc.Spec.Ports = append(svc.Spec.Ports, servicePort(v1.ProtocolTCP, 443)) }},
+		{name: "cluster changed", modify: func(svc *v1.Service) { svc.Annotations[ClusterKey] = "tenant-a/edge" }, want: true},
+		{name: "ippool set to its default", modify: func(svc *v1.Service) { svc.Annotations[IPPoolKey] = DefaultIPPoolName }, want: true},
+		{name: "sharing key removed", modify: func(svc *v1.Service) { delete(svc.Annotations, SharingKey) }, want: true},
+		{name: "class set", modify: func(svc *v1.Service) { svc.Spec.LoadBalancerClass = &class }, want: true},
+		{name: "type changed", modify: func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeNodePort }, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := testService(map[string]string{InstanceKey: "web-1a2b", SharingKey: "web"}, servicePort(v1.ProtocolTCP, 80))
+			svc := old.DeepCopy()
+			tt.modify(svc)
+			if got := allocationChanged(old, svc); got != tt.want {
+				t.Errorf("allocationChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// connection limits are rendered as frontend maxconn, rate limits as counters of the client source in the
+// stick table of the endpoint, connections and http requests over the rate are rejected
+
+const (
+	defaultRatePeriod int32 = 10
+	maxRatePeriod     int32 = 3600
+	maxMaxConn        int32 = 1000000
+)
+
+const stickTablePrefix = "stick-table "
+
+// get limits of service, annotations override the defaults of cluster
+func getServiceLimits(k8s client.Client, ctx context.Context, clusterKey types.NamespacedName, svc *v1.Service) (*crdv1.FlexLBLimits, error) {
+	cluster := &crdv1.FlexLBCluster{}
+	if err := k8s.Get(ctx, clusterKey, cluster); err != nil {
+		return nil, fmt.Errorf("cluster '%s' does not exist", clusterKey)
+	}
+	limits := &crdv1.FlexLBLimits{}
+	if cluster.Spec.Limits != nil {
+		*limits = *cluster.Spec.Limits
+		if err := validateLimits(limits); err != nil {
+			return nil, fmt.Errorf("cluster '%s' limits are invalid: %s", clusterKey, err.Error())
+		}
+	}
+	keys := []string{MaxConnKey, ConnRateKey, RequestRateKey, RatePeriodKey}
+	values := []*int32{&limits.MaxConn, &limits.ConnRate, &limits.RequestRate, &limits.RatePeriod}
+	for i, key := range keys {
+		if err := limitAnnotation(svc, key, values[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateLimits(limits); err != nil {
+		return nil, err
+	}
+	if limits.RatePeriod == 0 {
+		limits.RatePeriod = defaultRatePeriod
+	}
+	return limits, nil
+}
+
+// check whether service sets limits, cluster defaults not enforced on some ports are not reported
+func hasLimitAnnotations(svc *v1.Service) bool {
+	for _, key := range []string{MaxConnKey, ConnRateKey, RequestRateKey, RatePeriodKey} {
+		if _, exist := svc.Annotations[key]; exist {
+			return true
+		}
+	}
+	return false
+}
+
+// set limit from service annotation if exist
+func limitAnnotation(svc *v1.Service, key string, limit *int32) error {
+	value, exist := svc.Annotations[key]
+	if !exist {
+		return nil
+	}
+	parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return fmt.Errorf("service annotation '%s' is invalid: '%s' is not a number", key, value)
+	}
+	*limit = int32(parsed)
+	return nil
+}
+
+func validateLimits(limits *crdv1.FlexLBLimits) error {
+	if limits.MaxConn < 0 || limits.MaxConn > maxMaxConn {
+		return fmt.Errorf("max connections %d not in range [0, %d]", limits.MaxConn, maxMaxConn)
+	}
+	if limits.ConnRate < 0 {
+		return fmt.Errorf("connection rate %d is negative", limits.ConnRate)
+	}
+	if limits.RequestRate < 0 {
+		return fmt.Errorf("request rate %d is negative", limits.RequestRate)
+	}
+	if limits.RatePeriod < 0 || limits.RatePeriod > maxRatePeriod {
+		return fmt.Errorf("rate period %d not in range [0, %d]", limits.RatePeriod, maxRatePeriod)
+	}
+	return nil
+}
+
+// limit connections and rates of endpoints, returns ports the limits are not fully enforced on:
+// udp ports, and non http ports with request rate
+func applyLimits(endpoints []*models.Endpoint, limits *crdv1.FlexLBLimits) []string {
+	skipped := []string{}
+	if limits.MaxConn == 0 && limits.ConnRate == 0 && limits.RequestRate == 0 {
+		return skipped
+	}
+	for _, ep := range endpoints {
+		if ep.Mode == models.EndpointModeUDP {
+			skipped = append(skipped, portKey(ep.FrontendPort, ep.Mode))
+			continue
+		}
+		if limits.MaxConn > 0 {
+			addFrontendOption(ep, fmt.Sprintf("maxconn %d", limits.MaxConn))
+		}
+
+		requestRate := limits.RequestRate
+		if requestRate > 0 && ep.Mode != models.EndpointModeHTTP {
+			skipped = append(skipped, portKey(ep.FrontendPort, ep.Mode))
+			requestRate = 0
+		}
+		if limits.ConnRate == 0 && requestRate == 0 {
+			continue
+		}
+		stores := []string{}
+		options := []string{"tcp-request content track-sc0 src"}
+		if limits.ConnRate > 0 {
+			stores = append(stores, fmt.Sprintf("conn_rate(%ds)", limits.RatePeriod))
+			options = append(options, fmt.Sprintf("tcp-request content reject if { sc0_conn_rate gt %d }", limits.ConnRate))
+		}
+		if requestRate > 0 {
+			stores = append(stores, fmt.Sprintf("http_req_rate(%ds)", limits.RatePeriod))
+			options = append(options, fmt.Sprintf("http-request deny deny_status 429 if { sc0_http_req_rate gt %d }", requestRate))
+		}
+		setStickTable(ep, limits.RatePeriod, stores...)
+		ep.BackendOptions = append(ep.BackendOptions, options...)
+	}
+	return skipped
+}
+
+// set the stick table of endpoint keyed by client source, merged with the existing one,
+// as a backend has only one stick table
+func setStickTable(ep *models.Endpoint, expire int32, stores ...string) {
+	index := -1
+	for i, option := range ep.BackendOptions {
+		if strings.HasPrefix(option, stickTablePrefix) {
+			index = i
+			fields := strings.Fields(option)
+			for j := 0; j < len(fields)-1; j++ {
+				switch fields[j] {
+				case "expire":
+					if last, err := strconv.Atoi(strings.TrimSuffix(fields[j+1], "s")); err == nil && int32(last) > expire {
+						expire = int32(last)
+					}
+				case "store":
+					stores = append(strings.Split(fields[j+1], ","), stores...)
+				}
+			}
+			break
+		}
+	}
+
+	option := fmt.Sprintf("%stype ipv6 size %s expire %ds", stickTablePrefix, stickTableSize, expire)
+	if len(stores) > 0 {
+		option += " store " + strings.Join(stores, ",")
+	}
+	if index < 0 {
+		ep.BackendOptions = append(ep.BackendOptions, option)
+	} else {
+		ep.BackendOptions[index] = option
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestValidateLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  crdv1.FlexLBLimits
+		wantErr bool
+	}{
+		{name: "no limits", limits: crdv1.FlexLBLimits{}},
+		{name: "all limits", limits: crdv1.FlexLBLimits{MaxConn: maxMaxConn, ConnRate: 100, RequestRate: 1000, RatePeriod: maxRatePeriod}},
+		{name: "max connections over max", limits: crdv1.FlexLBLimits{MaxConn: maxMaxConn + 1}, wantErr: true},
+		{name: "negative max connections", limits: crdv1.FlexLBLimits{MaxConn: -1}, wantErr: true},
+		{name: "negative connection rate", limits: crdv1.FlexLBLimits{ConnRate: -1}, wantErr: true},
+		{name: "negative request rate", limits: crdv1.FlexLBLimits{RequestRate: -1}, wantErr: true},
+		{name: "rate period over max", limits: crdv1.FlexLBLimits{RatePeriod: maxRatePeriod + 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateLimits(&tt.limits); (err != nil) != tt.wantErr {
+				t.Errorf("validateLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetServiceLimits(t *testing.T) {
+	clusterKey := types.NamespacedName{Name: DefaultClusterName, Namespace: testNamespace}
+	tests := []struct {
+		name          string
+		clusterLimits *crdv1.FlexLBLimits
+		annotations   map[string]string
+		want          *crdv1.FlexLBLimits
+		wantErr       bool
+	}{
+		{name: "no limits", want: &crdv1.FlexLBLimits{RatePeriod: defaultRatePeriod}},
+		{
+			name:          "cluster defaults",
+			clusterLimits: &crdv1.FlexLBLimits{MaxConn: 1000, ConnRate: 50},
+			want:          &crdv1.FlexLBLimits{MaxConn: 1000, ConnRate: 50, RatePeriod: defaultRatePeriod},
+		},
+		{
+			name:          "annotations override cluster defaults",
+			clusterLimits: &crdv1.FlexLBLimits{MaxConn: 1000, ConnRate: 50, RatePeriod: 60},
+			annotations:   map[string]string{MaxConnKey: " 200 ", RequestRateKey: "100", ConnRateKey: "0"},
+			want:          &crdv1.FlexLBLimits{MaxConn: 200, RequestRate: 100, RatePeriod: 60},
+		},
+		{name: "not a number", annotations: map[string]string{ConnRateKey: "fast"}, wantErr: true},
+		{name: "invalid annotation", annotations: map[string]string{RatePeriodKey: "7200"}, wantErr: true},
+		{name: "invalid cluster defaults", clusterLimits: &crdv1.FlexLBLimits{MaxConn: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k8s := newFakeClient(&crdv1.FlexLBCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: clusterKey.Name, Namespace: clusterKey.Namespace},
+				Spec:       crdv1.FlexLBClusterSpec{Limits: tt.clusterLimits},
+			})
+			got, err := getServiceLimits(k8s, context.Background(), clusterKey, testService(tt.annotations, servicePort(v1.ProtocolTCP, 80)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getServiceLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getServiceLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cluster not found", func(t *testing.T) {
+		if _, err := getServiceLimits(newFakeClient(), context.Background(), clusterKey, testService(nil)); err == nil {
+			t.Errorf("getServiceLimits() error = nil, want cluster not found")
+		}
+	})
+}
+
+func TestHasLimitAnnotations(t *testing.T) {
+	if hasLimitAnnotations(testService(map[string]string{TLSSecretKey: "web-tls"})) {
+		t.Errorf("hasLimitAnnotations() = true without limit annotations")
+	}
+	if !hasLimitAnnotations(testService(map[string]string{RatePeriodKey: "60"})) {
+		t.Errorf("hasLimitAnnotations() = false with rate period annotation")
+	}
+}
+
+func TestApplyLimits(t *testing.T) {
+	optionsOf := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	tests := []struct {
+		name         string
+		limits       *crdv1.FlexLBLimits
+		wantFrontend []string
+		wantOptions  [][]string
+		wantSkipped  []string
+	}{
+		{
+			name:         "no limits",
+			limits:       &crdv1.FlexLBLimits{RatePeriod: defaultRatePeriod},
+			wantFrontend: []string{"", "", ""},
+			wantOptions:  [][]string{{}, {}, {}},
+			wantSkipped:  []string{},
+		},
+		{
+			name:         "max connections",
+			limits:       &crdv1.FlexLBLimits{MaxConn: 100, RatePeriod: defaultRatePeriod},
+			wantFrontend: []string{"maxconn 100", "maxconn 100", ""},
+			wantOptions:  [][]string{{}, {}, {}},
+			wantSkipped:  []string{"udp/53"},
+		},
+		{
+			name:         "connection and request rates",
+			limits:       &crdv1.FlexLBLimits{ConnRate: 20, RequestRate: 100, RatePeriod: 30},
+			wantFrontend: []string{"", "", ""},
+			wantOptions: [][]string{
+				{
+					"stick-table type ipv6 size 100k expire 30s store conn_rate(30s)",
+					"tcp-request content track-sc0 src",
+					"tcp-request content reject if { sc0_conn_rate gt 20 }",
+				},
+				{
+					"stick-table type ipv6 size 100k expire 30s store conn_rate(30s),http_req_rate(30s)",
+					"tcp-request content track-sc0 src",
+					"tcp-request content reject if { sc0_conn_rate gt 20 }",
+					"http-request deny deny_status 429 if { sc0_http_req_rate gt 100 }",
+				},
+				{},
+			},
+			wantSkipped: []string{"tcp/22", "udp/53"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoints := []*models.Endpoint{
+				{FrontendPort: 22, Mode: models.EndpointModeTCP, BackendOptions: []string{}},
+				{FrontendPort: 80, Mode: models.EndpointModeHTTP, BackendOptions: []string{}},
+				{FrontendPort: 53, Mode: models.EndpointModeUDP, BackendOptions: []string{}},
+			}
+			skipped := applyLimits(endpoints, tt.limits)
+			if !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("applyLimits() skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+			for i, ep := range endpoints {
+				if got := optionsOf(ep.FrontendOptions); got != tt.wantFrontend[i] {
+					t.Errorf("frontend options of port %d = %q, want %q", ep.FrontendPort, got, tt.wantFrontend[i])
+				}
+				if !reflect.DeepEqual(ep.BackendOptions, tt.wantOptions[i]) {
+					t.Errorf("backend options of port %d = %v, want %v", ep.FrontendPort, ep.BackendOptions, tt.wantOptions[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSetStickTable(t *testing.T) {
+	// the stick table of client ip affinity is merged with the rate counters, the longer expiry is kept
+	ep := &models.Endpoint{BackendOptions: []string{}}
+	setStickTable(ep, 10800)
+	ep.BackendOptions = append(ep.BackendOptions, "stick on src")
+	setStickTable(ep, 30, "conn_rate(30s)")
+	setStickTable(ep, 30, "http_req_rate(30s)")
+
+	want := []string{"stick-table type ipv6 size 100k expire 10800s store conn_rate(30s),http_req_rate(30s)", "stick on src"}
+	if !reflect.DeepEqual(ep.BackendOptions, want) {
+		t.Errorf("setStickTable() options = %v, want %v", ep.BackendOptions, want)
+	}
+}
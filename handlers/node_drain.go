@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	utl "github.com/flexlet/utils"
+)
+
+// backend nodes of service endpoints
+type backendNodes struct {
+	trafficIps map[string]string // node name -> traffic ip
+	draining   map[string]string // node name -> drain mode
+	excluded   []string          // nodes without traffic ip
+}
+
+func newBackendNodes() *backendNodes {
+	return &backendNodes{
+		trafficIps: map[string]string{},
+		draining:   map[string]string{},
+		excluded:   []string{},
+	}
+}
+
+// merge backend nodes of another service
+func (n *backendNodes) merge(other *backendNodes) {
+	for nodeName, ip := range other.trafficIps {
+		n.trafficIps[nodeName] = ip
+	}
+	for nodeName, mode := range other.draining {
+		n.draining[nodeName] = mode
+	}
+	for _, nodeName := range other.excluded {
+		if !utl.ListContains(n.excluded, nodeName) {
+			n.excluded = append(n.excluded, nodeName)
+		}
+	}
+}
+
+// backend nodes annotation of instance, json of {<node>: <traffic ip>}
+func (n *backendNodes) annotation() string {
+	data, _ := json.Marshal(n.trafficIps)
+	return string(data)
+}
+
+// get backend nodes recorded in instance annotation
+func getInstanceBackendNodes(inst *crdv1.FlexLBInstance) map[string]string {
+	trafficIps := map[string]string{}
+	if data, exist := inst.Annotations[BackendNodesKey]; exist {
+		json.Unmarshal([]byte(data), &trafficIps)
+	}
+	return trafficIps
+}
+
+// check whether node backends should be drained: drain annotation, cordoned or deleting
+func nodeDraining(node *v1.Node) (string, bool) {
+	if mode, exist := node.Annotations[DrainKey]; exist {
+		if mode == crdv1.DrainModeMaint {
+			return crdv1.DrainModeMaint, true
+		}
+		return crdv1.DrainModeDrain, true
+	}
+	if node.Spec.Unschedulable || !node.DeletionTimestamp.IsZero() {
+		return crdv1.DrainModeDrain, true
+	}
+	return "", false
+}
+
+// backend server options of drain mode
+func drainOptions(mode string) *string {
+	options := "weight 0"
+	if mode == crdv1.DrainModeMaint {
+		options = "disabled"
+	}
+	return &options
+}
+
+// keep backends of draining nodes for the grace period, then remove them
+// backends already gone from the endpoint slice (pods evicted) are kept from the last rendered config,
+// returns the draining nodes for instance status, and the duration until the next removal
+func (h *Handler) drainBackends(k8s client.Client, ctx context.Context, inst *crdv1.FlexLBInstance,
+	endpoints []*models.Endpoint, nodes *backendNodes) ([]crdv1.DrainingNode, time.Duration) {
+	since := map[string]metav1.Time{}
+	if inst != nil {
+		for _, d := range inst.Status.Draining {
+			since[d.Node] = d.Since
+		}
+		keepVanishedBackends(k8s, ctx, inst, endpoints, nodes)
+	}
+
+	nodeNames := []string{}
+	for nodeName := range nodes.draining {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+	draining := []crdv1.DrainingNode{}
+	requeue := time.Duration(0)
+	for _, nodeName := range nodeNames {
+		start, exist := since[nodeName]
+		if !exist {
+			start = now
+		}
+		ip := nodes.trafficIps[nodeName]
+		left := h.currentSettings().DrainGrace - now.Sub(start.Time)
+		if left <= 0 {
+			// grace period passed, remove backends but remember the node as drained
+			removeBackends(endpoints, ip)
+			delete(nodes.trafficIps, nodeName)
+		} else if requeue == 0 || left < requeue {
+			requeue = left
+		}
+		draining = append(draining, crdv1.DrainingNode{
+			Node:      nodeName,
+			Ipaddress: ip,
+			Mode:      nodes.draining[nodeName],
+			Since:     start,
+			Backends:  backendNames(endpoints, ip),
+		})
+	}
+	return draining, requeue
+}
+
+// add backends of the last config back, if they vanished because their node is draining or deleted
+func keepVanishedBackends(k8s client.Client, ctx context.Context, inst *crdv1.FlexLBInstance, endpoints []*models.Endpoint, nodes *backendNodes) {
+	lastNodes := getInstanceBackendNodes(inst)
+	for _, last := range inst.Spec.Config.Endpoints {
+		current := findEndpoint(endpoints, last.FrontendPort, last.Mode)
+		if current == nil {
+			// port removed from service
+			continue
+		}
+		for _, server := range last.BackendServers {
+			if findBackend(current, server.Name) != nil {
+				continue
+			}
+			nodeName := nodeOfTrafficIp(lastNodes, server.Ipaddress)
+			if nodeName == "" {
+				continue
+			}
+			mode, draining := nodes.draining[nodeName]
+			if !draining {
+				if _, serving := nodes.trafficIps[nodeName]; serving {
+					// node still serving, backend moved away normally
+					continue
+				}
+				if mode, draining = getNodeDrainMode(k8s, ctx, nodeName); !draining {
+					continue
+				}
+				nodes.draining[nodeName] = mode
+				nodes.trafficIps[nodeName] = server.Ipaddress
+			}
+			kept := *server
+			kept.Options = drainOptions(mode)
+			current.BackendServers = append(current.BackendServers, &kept)
+		}
+	}
+}
+
+// drain mode of node by name, deleted node is drained
+func getNodeDrainMode(k8s client.Client, ctx context.Context, nodeName string) (string, bool) {
+	node := &v1.Node{}
+	if err := k8s.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return crdv1.DrainModeDrain, true
+		}
+		return "", false
+	}
+	return nodeDraining(node)
+}
+
+// find node by traffic ip
+func nodeOfTrafficIp(trafficIps map[string]string, ip string) string {
+	for nodeName, trafficIp := range trafficIps {
+		if trafficIp == ip {
+			return nodeName
+		}
+	}
+	return ""
+}
+
+// find endpoint by frontend port and mode
+func findEndpoint(endpoints []*models.Endpoint, port uint16, mode string) *models.Endpoint {
+	for _, ep := range endpoints {
+		if ep.FrontendPort == port && ep.Mode == mode {
+			return ep
+		}
+	}
+	return nil
+}
+
+// find backend server of endpoint by name
+func findBackend(ep *models.Endpoint, name string) *models.BackendServer {
+	for _, server := range ep.BackendServers {
+		if server.Name == name {
+			return server
+		}
+	}
+	return nil
+}
+
+// remove backend servers with ip from all endpoints
+func removeBackends(endpoints []*models.Endpoint, ip string) {
+	for _, ep := range endpoints {
+		servers := []*models.BackendServer{}
+		for _, server := range ep.BackendServers {
+			if server.Ipaddress != ip {
+				servers = append(servers, server)
+			}
+		}
+		ep.BackendServers = servers
+	}
+}
+
+// names of backend servers with ip
+func backendNames(endpoints []*models.Endpoint, ip string) []string {
+	names := []string{}
+	for _, ep := range endpoints {
+		for _, server := range ep.BackendServers {
+			if server.Ipaddress == ip && !utl.ListContains(names, server.Name) {
+				names = append(names, server.Name)
+			}
+		}
+	}
+	return names
+}
+
+// update draining nodes of instance status if changed
+func updateDrainingStatus(k8s client.Client, ctx context.Context, inst *crdv1.FlexLBInstance, draining []crdv1.DrainingNode) error {
+	if cmp.Equal(inst.Status.Draining, draining, cmpopts.EquateEmpty()) {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &crdv1.FlexLBInstance{}
+		if err := k8s.Get(ctx, types.NamespacedName{Namespace: inst.Namespace, Name: inst.Name}, latest); err != nil {
+			return err
+		}
+		latest.Status.Draining = draining
+		if err := k8s.Status().Update(ctx, latest); err != nil {
+			return err
+		}
+		latest.DeepCopyInto(inst)
+		return nil
+	})
+}
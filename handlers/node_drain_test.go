@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestNodeDraining(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name     string
+		node     v1.Node
+		mode     string
+		draining bool
+	}{
+		{name: "serving node"},
+		{name: "drain annotation", node: v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{DrainKey: ""}}}, mode: crdv1.DrainModeDrain, draining: true},
+		{
+			name:     "maint annotation",
+			node:     v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{DrainKey: crdv1.DrainModeMaint}}},
+			mode:     crdv1.DrainModeMaint,
+			draining: true,
+		},
+		{name: "cordoned", node: v1.Node{Spec: v1.NodeSpec{Unschedulable: true}}, mode: crdv1.DrainModeDrain, draining: true},
+		{name: "deleting", node: v1.Node{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now}}, mode: crdv1.DrainModeDrain, draining: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, draining := nodeDraining(&tt.node)
+			if mode != tt.mode || draining != tt.draining {
+				t.Errorf("got %q %t, want %q %t", mode, draining, tt.mode, tt.draining)
+			}
+		})
+	}
+	if *drainOptions(crdv1.DrainModeDrain) != "weight 0" || *drainOptions(crdv1.DrainModeMaint) != "disabled" {
+		t.Errorf("unexpected drain options")
+	}
+}
+
+// one tcp endpoint on port 80 with backends of ips
+func drainEndpoints(ips ...string) []*models.Endpoint {
+	ep := &models.Endpoint{FrontendPort: 80, Mode: models.EndpointModeTCP}
+	for _, ip := range ips {
+		ep.BackendServers = append(ep.BackendServers, &models.BackendServer{Name: "pod-" + ip, Ipaddress: ip, Port: 30080})
+	}
+	return []*models.Endpoint{ep}
+}
+
+func TestDrainBackends(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("draining node kept within grace period", func(t *testing.T) {
+		h, _ := newTestHandler()
+		h.settings.DrainGrace = time.Minute
+		nodes := newBackendNodes()
+		nodes.trafficIps["node-1"] = "10.0.0.1"
+		nodes.trafficIps["node-2"] = "10.0.0.2"
+		nodes.draining["node-2"] = crdv1.DrainModeDrain
+		endpoints := drainEndpoints("10.0.0.1", "10.0.0.2")
+
+		draining, requeue := h.drainBackends(newFakeClient(), ctx, nil, endpoints, nodes)
+		if len(draining) != 1 || draining[0].Node != "node-2" || draining[0].Ipaddress != "10.0.0.2" ||
+			len(draining[0].Backends) != 1 || draining[0].Backends[0] != "pod-10.0.0.2" {
+			t.Fatalf("unexpected draining nodes: %+v", draining)
+		}
+		if requeue <= 0 || requeue > time.Minute {
+			t.Errorf("unexpected requeue %s", requeue)
+		}
+		if len(endpoints[0].BackendServers) != 2 {
+			t.Errorf("draining backend removed within grace period")
+		}
+	})
+
+	t.Run("draining node removed after grace period", func(t *testing.T) {
+		h, _ := newTestHandler()
+		h.settings.DrainGrace = time.Minute
+		inst := &crdv1.FlexLBInstance{Status: crdv1.FlexLBInstanceStatus{Draining: []crdv1.DrainingNode{
+			{Node: "node-2", Since: metav1.NewTime(time.Now().Add(-2 * time.Minute))},
+		}}}
+		nodes := newBackendNodes()
+		nodes.trafficIps["node-1"] = "10.0.0.1"
+		nodes.trafficIps["node-2"] = "10.0.0.2"
+		nodes.draining["node-2"] = crdv1.DrainModeDrain
+		endpoints := drainEndpoints("10.0.0.1", "10.0.0.2")
+
+		draining, requeue := h.drainBackends(newFakeClient(), ctx, inst, endpoints, nodes)
+		if len(draining) != 1 || len(draining[0].Backends) != 0 || requeue != 0 {
+			t.Fatalf("unexpected draining nodes %+v, requeue %s", draining, requeue)
+		}
+		if len(endpoints[0].BackendServers) != 1 || endpoints[0].BackendServers[0].Ipaddress != "10.0.0.1" {
+			t.Errorf("drained backend not removed: %+v", endpoints[0].BackendServers)
+		}
+		if _, exist := nodes.trafficIps["node-2"]; exist {
+			t.Errorf("drained node still has traffic ip")
+		}
+	})
+
+	t.Run("vanished backends of deleted node kept", func(t *testing.T) {
+		h, _ := newTestHandler()
+		h.settings.DrainGrace = time.Minute
+		inst := &crdv1.FlexLBInstance{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{BackendNodesKey: `{"node-1":"10.0.0.1","node-2":"10.0.0.2"}`}},
+			Spec:       crdv1.FlexLBInstanceSpec{Config: models.InstanceConfig{Endpoints: drainEndpoints("10.0.0.1", "10.0.0.2")}},
+		}
+		nodes := newBackendNodes()
+		nodes.trafficIps["node-1"] = "10.0.0.1"
+		endpoints := drainEndpoints("10.0.0.1")
+
+		// node-2 does not exist anymore
+		draining, _ := h.drainBackends(newFakeClient(), ctx, inst, endpoints, nodes)
+		if len(draining) != 1 || draining[0].Node != "node-2" || draining[0].Mode != crdv1.DrainModeDrain {
+			t.Fatalf("unexpected draining nodes: %+v", draining)
+		}
+		if len(endpoints[0].BackendServers) != 2 || *endpoints[0].BackendServers[1].Options != "weight 0" {
+			t.Errorf("vanished backend not kept with drain options: %+v", endpoints[0].BackendServers)
+		}
+	})
+
+	t.Run("backends moved away from serving node not kept", func(t *testing.T) {
+		h, _ := newTestHandler()
+		inst := &crdv1.FlexLBInstance{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{BackendNodesKey: `{"node-1":"10.0.0.1","node-2":"10.0.0.2"}`}},
+			Spec:       crdv1.FlexLBInstanceSpec{Config: models.InstanceConfig{Endpoints: drainEndpoints("10.0.0.1", "10.0.0.2")}},
+		}
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}
+		nodes := newBackendNodes()
+		nodes.trafficIps["node-1"] = "10.0.0.1"
+		endpoints := drainEndpoints("10.0.0.1")
+
+		draining, _ := h.drainBackends(newFakeClient(node), ctx, inst, endpoints, nodes)
+		if len(draining) != 0 || len(endpoints[0].BackendServers) != 1 {
+			t.Errorf("unexpected draining nodes %+v, backends %+v", draining, endpoints[0].BackendServers)
+		}
+	})
+}
@@ -7,22 +7,147 @@ import (
 	"strings"
 	"time"
 
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
 	"github.com/flexlet/flexlb-kube-controller/utils"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 // add node network annotation
-func (h *Handler) NodeChanged(k8s client.Client, ctx context.Context, node *v1.Node) error {
-	nodeNetwork, err := getNodeNetwork(k8s, ctx, node.Name, h.namespace, h.probePodImage)
+// probing is driven by probe pod events: create the pod and return, harvest the result once the pod terminated,
+// returns the duration after which the node should be checked again
+func (h *Handler) NodeChanged(k8s client.Client, ctx context.Context, node *v1.Node) (time.Duration, error) {
+	h.lock(nodeLockKey(node.Name), "update node network", "handler", "NodeChanged", "node", node.Name)
+	defer h.unlock(nodeLockKey(node.Name), "update node network end", "handler", "NodeChanged", "node", node.Name)
+
+	probePodKey := types.NamespacedName{Name: probePodNamePrefix + node.Name, Namespace: h.namespace}
+
+	// already probed, remove probe pod if left behind
+	if _, exist := node.Annotations[NodeNetworkKey]; exist {
+		delPodIfExist(k8s, ctx, probePodKey)
+		return 0, nil
+	}
+
+	settings := h.currentSettings()
+	probePod := &v1.Pod{}
+	if err := k8s.Get(ctx, probePodKey, probePod); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return 0, err
+		}
+		// not probing yet, create probe pod and wait for it to terminate
+		if err := k8s.Create(ctx, newProbePod(node.Name, h.namespace, settings.ProbePodImage)); err != nil {
+			return 0, h.errorf(node, ErrorProbeTrafficNodeIp, err, "create probe pod failed")
+		}
+		h.recorder.Eventf(node, v1.EventTypeNormal, ProbeTrafficNodeIpStarted, "probe pod '%s/%s' created", probePodKey.Namespace, probePodKey.Name)
+		return settings.ProbeTimeout, nil
+	}
+
+	switch probePod.Status.Phase {
+	case v1.PodSucceeded:
+		// probe done, parse logs and update node annotation
+		defer delPodIfExist(k8s, ctx, probePodKey)
+		podLogs, err := utils.GetPodLogs(ctx, probePod.Namespace, probePod.Name)
+		if err != nil {
+			return 0, h.errorf(node, ErrorProbeTrafficNodeIp, err, "get probe pod logs failed")
+		}
+		nodeNetwork, err := parseNodeNetwork(*podLogs)
+		if err != nil {
+			return 0, h.errorf(node, ErrorProbeTrafficNodeIp, err, "probe node network failed, logs: %s", truncateLogs(*podLogs))
+		}
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		node.Annotations[NodeNetworkKey] = *nodeNetwork
+		if err := k8s.Update(ctx, node); err != nil {
+			return 0, h.errorf(node, ErrorUpdateTrafficNodeIp, err, "update node annotation failed")
+		}
+		return 0, nil
+
+	case v1.PodFailed:
+		// probe failed, report reason and logs, retry with backoff
+		defer delPodIfExist(k8s, ctx, probePodKey)
+		return 0, h.errorf(node, ErrorProbeTrafficNodeIp, nil, "probe pod failed: %s, logs: %s",
+			probePodFailure(probePod), probePodLogs(ctx, probePod))
+	}
+
+	// still pending or running, check timeout
+	elapsed := time.Since(probePod.CreationTimestamp.Time)
+	if elapsed < settings.ProbeTimeout {
+		return settings.ProbeTimeout - elapsed, nil
+	}
+	defer delPodIfExist(k8s, ctx, probePodKey)
+	return 0, h.errorf(node, ErrorProbeTrafficNodeIp, nil, "probe pod timeout after %s: %s, logs: %s",
+		settings.ProbeTimeout, probePodFailure(probePod), probePodLogs(ctx, probePod))
+}
+
+// node deleted: remove its probe pod, and report instances with backends on the node
+// the instances are re-rendered by service reconciler watching node deletion
+func (h *Handler) NodeDeleted(k8s client.Client, ctx context.Context, node *v1.Node) error {
+	h.lock(nodeLockKey(node.Name), "cleanup node", "handler", "NodeDeleted", "node", node.Name)
+	defer h.unlock(nodeLockKey(node.Name), "cleanup node end", "handler", "NodeDeleted", "node", node.Name)
+
+	delPodIfExist(k8s, ctx, types.NamespacedName{Name: probePodNamePrefix + node.Name, Namespace: h.namespace})
+
+	insts, err := GetInstancesOfNode(k8s, ctx, node.Name)
 	if err != nil {
-		return h.errorf(node, ErrorProbeTrafficNodeIp, err, "probe node network failed")
+		return err
+	}
+	names := []string{}
+	for i := 0; i < len(insts); i++ {
+		h.recorder.Eventf(&insts[i], v1.EventTypeNormal, BackendNodeDeleted, "backend node '%s' deleted", node.Name)
+		names = append(names, insts[i].Namespace+"/"+insts[i].Name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	h.recorder.Eventf(node, v1.EventTypeNormal, BackendNodeDeleted, "node deleted, %d instances with backends on the node re-rendered: %s",
+		len(names), strings.Join(names, ", "))
+	return nil
+}
+
+// list instances with backends on node, recorded in backend nodes annotation
+func GetInstancesOfNode(k8s client.Client, ctx context.Context, nodeName string) ([]crdv1.FlexLBInstance, error) {
+	insts := &crdv1.FlexLBInstanceList{}
+	if err := k8s.List(ctx, insts); err != nil {
+		return nil, fmt.Errorf("list instances failed: %s", err.Error())
+	}
+	found := []crdv1.FlexLBInstance{}
+	for i := 0; i < len(insts.Items); i++ {
+		if _, exist := getInstanceBackendNodes(&insts.Items[i])[nodeName]; exist {
+			found = append(found, insts.Items[i])
+		}
+	}
+	return found, nil
+}
+
+// delete probe pods left behind by last run: node deleted, node already probed, or probe timeout
+func (h *Handler) CleanupProbePods(k8s client.Client, ctx context.Context) error {
+	pods := &v1.PodList{}
+	if err := k8s.List(ctx, pods, client.InNamespace(h.namespace), client.MatchingLabels{ProbePodLabel: "true"}); err != nil {
+		return fmt.Errorf("list probe pods failed: %s", err.Error())
 	}
-	node.Annotations[NodeNetworkKey] = *nodeNetwork
-	if err := k8s.Update(ctx, node); err != nil {
-		return h.errorf(node, ErrorUpdateTrafficNodeIp, err, "update node annotation failed")
+
+	for i := 0; i < len(pods.Items); i++ {
+		pod := &pods.Items[i]
+		node := &v1.Node{}
+		stale := false
+		if err := k8s.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+			stale = apierrors.IsNotFound(err)
+		} else if _, exist := node.Annotations[NodeNetworkKey]; exist {
+			stale = true
+		} else if time.Since(pod.CreationTimestamp.Time) >= h.currentSettings().ProbeTimeout {
+			stale = true
+		}
+		if stale {
+			log.Log.Info("delete stale probe pod", "handler", "CleanupProbePods", "pod", pod.Name, "namespace", pod.Namespace)
+			if err := k8s.Delete(ctx, pod); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("delete stale probe pod '%s/%s' failed: %s", pod.Namespace, pod.Name, err.Error())
+			}
+		}
 	}
 	return nil
 }
@@ -31,7 +156,12 @@ func (h *Handler) NodeChanged(k8s client.Client, ctx context.Context, node *v1.N
 const (
 	probePodNamePrefix = "flexlb-node-probe-"
 	probePodCommand    = "ip route | awk \"/dev.*src/{print \\$1,\\$3,\\$(NF-2)}\""
-	probePodTimeout    = 10
+	probePodLogsLimit  = 1024
+)
+
+// probe pod label, used to watch and cleanup probe pods
+const (
+	ProbePodLabel = "flexlb.flexlet.io/nodeProbe"
 )
 
 // delete pod if exist
@@ -42,19 +172,19 @@ func delPodIfExist(k8s client.Client, ctx context.Context, podKey types.Namespac
 	}
 }
 
-// get node network (json string of [{'network':<cidr>,'device':<dev>,'ip_address':<ip>}])
-func getNodeNetwork(k8s client.Client, ctx context.Context, nodeName string, namespace string, probePodImage string) (*string, error) {
-	// create a pod on target node (use host network)
+// probe pod runs once on target node (use host network)
+func newProbePod(nodeName string, namespace string, probePodImage string) *v1.Pod {
 	automountServiceAccountToken := false
-	probePod := &v1.Pod{
+	return &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      probePodNamePrefix + nodeName,
 			Namespace: namespace,
+			Labels:    map[string]string{ProbePodLabel: "true"},
 		},
 		Spec: v1.PodSpec{
 			NodeName:                     nodeName,
 			HostNetwork:                  true,
-			RestartPolicy:                v1.RestartPolicyOnFailure,
+			RestartPolicy:                v1.RestartPolicyNever,
 			AutomountServiceAccountToken: &automountServiceAccountToken,
 			Containers: []v1.Container{
 				{
@@ -66,62 +196,46 @@ func getNodeNetwork(k8s client.Client, ctx context.Context, nodeName string, nam
 			},
 		},
 	}
+}
 
-	probePodKey := types.NamespacedName{
-		Name:      probePodNamePrefix + nodeName,
-		Namespace: namespace,
-	}
-
-	// delete probe pod if exist (last time failed in middle of reconcile)
-	delPodIfExist(k8s, ctx, probePodKey)
-
-	// create probe pod
-	if err := k8s.Create(ctx, probePod); err != nil {
-		return nil, err
-	}
-	defer k8s.Delete(ctx, probePod)
-
-	// wait util success
-	execSuccess := false
-	for i := 0; i < probePodTimeout*100; i++ {
-		time.Sleep(time.Second / 100)
-		if err := k8s.Get(ctx, probePodKey, probePod); err != nil {
-			// not created yet
-			continue
+// describe why probe pod did not succeed
+func probePodFailure(pod *v1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil {
+			return fmt.Sprintf("exit with code %d, terminate reason: %s %s", status.State.Terminated.ExitCode,
+				status.State.Terminated.Reason, status.State.Terminated.Message)
 		}
-		if len(probePod.Status.ContainerStatuses) == 0 {
-			// container not created yet
-			continue
-		}
-		doneState := probePod.Status.ContainerStatuses[0].State.Terminated
-		if doneState == nil {
-			// not complate yet
-			continue
-		}
-
-		if doneState.ExitCode != 0 {
-			// exec failed
-			return nil, fmt.Errorf("probe pod exit with code %d, terminate reason: %s", doneState.ExitCode, doneState.Reason)
-		} else {
-			execSuccess = true
-			break
+		if status.State.Waiting != nil {
+			return fmt.Sprintf("waiting reason: %s %s", status.State.Waiting.Reason, status.State.Waiting.Message)
 		}
 	}
+	if pod.Status.Reason != "" || pod.Status.Message != "" {
+		return fmt.Sprintf("pod phase: %s, reason: %s %s", pod.Status.Phase, pod.Status.Reason, pod.Status.Message)
+	}
+	return fmt.Sprintf("pod phase: %s", pod.Status.Phase)
+}
 
-	// wait timeout
-	if !execSuccess {
-		return nil, fmt.Errorf("probe pod exec timeout")
+// get probe pod logs for events, ignore errors
+func probePodLogs(ctx context.Context, pod *v1.Pod) string {
+	podLogs, err := utils.GetPodLogs(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		return "<" + err.Error() + ">"
 	}
+	return truncateLogs(*podLogs)
+}
 
-	// get logs
-	podLogs, err := utils.GetPodLogs(ctx, probePod.Namespace, probePod.Name)
-	if err != nil || *podLogs == "" {
-		return nil, fmt.Errorf("get probe pod logs failed: %s", err.Error())
+// keep the tail of logs
+func truncateLogs(logs string) string {
+	if len(logs) > probePodLogsLimit {
+		return "..." + logs[len(logs)-probePodLogsLimit:]
 	}
+	return logs
+}
 
-	// parse logs
+// parse node network (json string of [{'network':<cidr>,'device':<dev>,'ip_address':<ip>}]) from probe pod logs
+func parseNodeNetwork(podLogs string) (*string, error) {
 	nodeNetworks := []NodeNetwork{}
-	lines := strings.Split(*podLogs, "\n")
+	lines := strings.Split(podLogs, "\n")
 	for _, line := range lines {
 		entries := strings.Split(line, " ")
 		if len(entries) != 3 {
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestParseNodeNetwork(t *testing.T) {
+	tests := []struct {
+		name    string
+		logs    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "one network",
+			logs: "10.0.0.0/24 eth0 10.0.0.1",
+			want: `[{"network":"10.0.0.0/24","device":"eth0","ip_address":"10.0.0.1"}]`,
+		},
+		{
+			name: "several networks, other lines skipped",
+			logs: "10.0.0.0/24 eth0 10.0.0.1\nwarning: something\n\n172.17.0.0/16 docker0 172.17.0.1",
+			want: `[{"network":"10.0.0.0/24","device":"eth0","ip_address":"10.0.0.1"},` +
+				`{"network":"172.17.0.0/16","device":"docker0","ip_address":"172.17.0.1"}]`,
+		},
+		{name: "empty logs", logs: "", wantErr: true},
+		{name: "no network lines", logs: "sh: ip: not found", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNodeNetwork(tt.logs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", *got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if *got != tt.want {
+				t.Errorf("got %s, want %s", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateLogs(t *testing.T) {
+	short := "short logs"
+	if got := truncateLogs(short); got != short {
+		t.Errorf("short logs truncated: %s", got)
+	}
+	long := strings.Repeat("a", probePodLogsLimit) + "tail"
+	got := truncateLogs(long)
+	if !strings.HasPrefix(got, "...") || !strings.HasSuffix(got, "tail") || len(got) != probePodLogsLimit+3 {
+		t.Errorf("unexpected truncated logs of length %d: %s", len(got), got[:10])
+	}
+}
+
+func TestProbePodFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		status v1.PodStatus
+		want   string
+	}{
+		{
+			name: "container terminated",
+			status: v1.PodStatus{Phase: v1.PodFailed, ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 127, Reason: "Error", Message: "not found"}},
+			}}},
+			want: "exit with code 127, terminate reason: Error not found",
+		},
+		{
+			name: "container waiting",
+			status: v1.PodStatus{Phase: v1.PodPending, ContainerStatuses: []v1.ContainerStatus{{
+				State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "pull failed"}},
+			}}},
+			want: "waiting reason: ImagePullBackOff pull failed",
+		},
+		{
+			name:   "pod reason",
+			status: v1.PodStatus{Phase: v1.PodFailed, Reason: "Evicted", Message: "low memory"},
+			want:   "pod phase: Failed, reason: Evicted low memory",
+		},
+		{
+			name:   "phase only",
+			status: v1.PodStatus{Phase: v1.PodPending},
+			want:   "pod phase: Pending",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := probePodFailure(&v1.Pod{Status: tt.status}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeChangedProbePod(t *testing.T) {
+	ctx := context.TODO()
+	podKey := types.NamespacedName{Name: probePodNamePrefix + "node-1", Namespace: testNamespace}
+
+	t.Run("probe pod created for node without network", func(t *testing.T) {
+		h, recorder := newTestHandler()
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+		k8s := newFakeClient(node)
+
+		requeueAfter, err := h.NodeChanged(k8s, ctx, node)
+		if err != nil || requeueAfter != time.Minute {
+			t.Fatalf("unexpected result %s: %v", requeueAfter, err)
+		}
+		pod := &v1.Pod{}
+		if err := k8s.Get(ctx, podKey, pod); err != nil {
+			t.Fatalf("probe pod not created: %s", err.Error())
+		}
+		if pod.Spec.NodeName != "node-1" || !pod.Spec.HostNetwork || pod.Labels[ProbePodLabel] != "true" ||
+			pod.Spec.Containers[0].Image != "busybox" {
+			t.Errorf("unexpected probe pod: %+v", pod.Spec)
+		}
+		if events := recordedEvents(recorder); len(events) != 1 || !strings.Contains(events[0], ProbeTrafficNodeIpStarted) {
+			t.Errorf("unexpected events: %v", events)
+		}
+	})
+
+	t.Run("running probe pod waited for until timeout", func(t *testing.T) {
+		h, _ := newTestHandler()
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+		pod := newProbePod("node-1", testNamespace, "busybox")
+		pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-20 * time.Second))
+		pod.Status.Phase = v1.PodRunning
+		k8s := newFakeClient(node, pod)
+
+		requeueAfter, err := h.NodeChanged(k8s, ctx, node)
+		if err != nil || requeueAfter <= 0 || requeueAfter > 40*time.Second {
+			t.Fatalf("unexpected result %s: %v", requeueAfter, err)
+		}
+		if err := k8s.Get(ctx, podKey, &v1.Pod{}); err != nil {
+			t.Errorf("running probe pod deleted: %v", err)
+		}
+	})
+
+	t.Run("probe pod left behind removed once node network annotated", func(t *testing.T) {
+		h, _ := newTestHandler()
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: map[string]string{NodeNetworkKey: "[]"}}}
+		k8s := newFakeClient(node, newProbePod("node-1", testNamespace, "busybox"))
+
+		requeueAfter, err := h.NodeChanged(k8s, ctx, node)
+		if err != nil || requeueAfter != 0 {
+			t.Fatalf("unexpected result %s: %v", requeueAfter, err)
+		}
+		if err := k8s.Get(ctx, podKey, &v1.Pod{}); !apierrors.IsNotFound(err) {
+			t.Errorf("probe pod not deleted: %v", err)
+		}
+	})
+}
+
+func TestNodeDeleted(t *testing.T) {
+	ctx := context.TODO()
+	h, recorder := newTestHandler()
+	onNode := &crdv1.FlexLBInstance{ObjectMeta: metav1.ObjectMeta{Name: "inst-1", Namespace: "default",
+		Annotations: map[string]string{BackendNodesKey: `{"node-1":"10.0.0.1","node-2":"10.0.0.2"}`}}}
+	otherNode := &crdv1.FlexLBInstance{ObjectMeta: metav1.ObjectMeta{Name: "inst-2", Namespace: "default",
+		Annotations: map[string]string{BackendNodesKey: `{"node-2":"10.0.0.2"}`}}}
+	k8s := newFakeClient(onNode, otherNode, newProbePod("node-1", testNamespace, "busybox"))
+
+	insts, err := GetInstancesOfNode(k8s, ctx, "node-1")
+	if err != nil || len(insts) != 1 || insts[0].Name != "inst-1" {
+		t.Fatalf("unexpected instances of node: %v %v", insts, err)
+	}
+
+	if err := h.NodeDeleted(k8s, ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}); err != nil {
+		t.Fatalf("node deleted failed: %s", err.Error())
+	}
+	if err := k8s.Get(ctx, types.NamespacedName{Name: probePodNamePrefix + "node-1", Namespace: testNamespace}, &v1.Pod{}); !apierrors.IsNotFound(err) {
+		t.Errorf("probe pod of deleted node not removed: %v", err)
+	}
+	events := recordedEvents(recorder)
+	if len(events) != 2 || !strings.Contains(events[0], BackendNodeDeleted) || !strings.Contains(events[1], "default/inst-1") {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
+
+func TestCleanupProbePods(t *testing.T) {
+	ctx := context.TODO()
+	h, _ := newTestHandler()
+	probing := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "probing"}}
+	probed := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "probed", Annotations: map[string]string{NodeNetworkKey: "[]"}}}
+	timedOut := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "timed-out"}}
+
+	pods := map[string]bool{"probing": false, "probed": true, "timed-out": true, "deleted": true}
+	objs := []client.Object{probing, probed, timedOut}
+	for nodeName := range pods {
+		pod := newProbePod(nodeName, testNamespace, "busybox")
+		pod.CreationTimestamp = metav1.Now()
+		if nodeName == "timed-out" {
+			pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		}
+		objs = append(objs, pod)
+	}
+	k8s := newFakeClient(objs...)
+
+	if err := h.CleanupProbePods(k8s, ctx); err != nil {
+		t.Fatalf("cleanup failed: %s", err.Error())
+	}
+	for nodeName, stale := range pods {
+		err := k8s.Get(ctx, types.NamespacedName{Name: probePodNamePrefix + nodeName, Namespace: testNamespace}, &v1.Pod{})
+		if stale != apierrors.IsNotFound(err) {
+			t.Errorf("probe pod of node '%s': stale %t, get error %v", nodeName, stale, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// node status of instance: flexlb reports the status of an instance per load balancer node (up, down, pending),
+// it does not expose the health checks of backend servers. The node status is summarized in the NodesRunning
+// condition of the instance and of its services, a warning event is reported while a node is not running.
+
+// condition of the services of instance
+const ServiceConditionNodesRunning = "flexlb.flexlet.io/" + crdv1.InstanceConditionNodesRunning
+
+// instance events
+const (
+	InstanceNodeNotRunning = "InstanceNodeNotRunning"
+)
+
+// summary of the node status, unknown if flexlb reported no status
+func nodeStatusCondition(nodeStatus map[string]string) metav1.Condition {
+	cond := metav1.Condition{Type: crdv1.InstanceConditionNodesRunning}
+	if len(nodeStatus) == 0 {
+		cond.Status = metav1.ConditionUnknown
+		cond.Reason = crdv1.InstanceReasonNoNodeStatus
+		cond.Message = "no node status reported by flexlb"
+		return cond
+	}
+
+	notRunning := []string{}
+	for node, status := range nodeStatus {
+		if status != crdv1.InstanceStatusUp {
+			notRunning = append(notRunning, node+": "+status)
+		}
+	}
+	if len(notRunning) == 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = crdv1.InstanceReasonNodesRunning
+		cond.Message = fmt.Sprintf("running on all %d nodes", len(nodeStatus))
+		return cond
+	}
+	sort.Strings(notRunning)
+	cond.Status = metav1.ConditionFalse
+	cond.Reason = crdv1.InstanceReasonNodesNotRunning
+	cond.Message = fmt.Sprintf("not running on %d of %d nodes: %s", len(notRunning), len(nodeStatus), strings.Join(notRunning, ", "))
+	return cond
+}
+
+// set the node status condition of instance, the status is updated by the caller
+func setNodeStatusCondition(instance *crdv1.FlexLBInstance) metav1.Condition {
+	cond := nodeStatusCondition(instance.Status.NodeStatus)
+	cond.ObservedGeneration = instance.Generation
+	setCondition(&instance.Status.Conditions, cond)
+	return cond
+}
+
+// copy the node status condition of instance to its services, warn while a node is not running
+func (h *Handler) reportNodeStatus(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance, cond metav1.Condition) {
+	if cond.Status == metav1.ConditionFalse {
+		h.warnf(instance, InstanceNodeNotRunning, "instance %s", cond.Message)
+	}
+	for _, name := range GetInstanceServices(instance) {
+		svc := &v1.Service{}
+		if err := k8s.Get(ctx, types.NamespacedName{Namespace: instance.Namespace, Name: name}, svc); err != nil {
+			continue
+		}
+		if cond.Status == metav1.ConditionFalse {
+			h.warnf(svc, InstanceNodeNotRunning, "instance '%s' %s", instance.Name, cond.Message)
+		}
+		svcCond := cond
+		svcCond.Type = ServiceConditionNodesRunning
+		svcCond.ObservedGeneration = svc.Generation
+		if !setCondition(&svc.Status.Conditions, svcCond) {
+			continue
+		}
+		if err := k8s.Status().Update(ctx, svc); err != nil {
+			log.Log.Info("update service condition failed", "service", name, "namespace", instance.Namespace, "error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestNodeStatusCondition(t *testing.T) {
+	tests := []struct {
+		name        string
+		nodeStatus  map[string]string
+		wantStatus  metav1.ConditionStatus
+		wantReason  string
+		wantMessage string
+	}{
+		{name: "no status", wantStatus: metav1.ConditionUnknown, wantReason: crdv1.InstanceReasonNoNodeStatus},
+		{
+			name:        "all running",
+			nodeStatus:  map[string]string{"flexlb-1": crdv1.InstanceStatusUp, "flexlb-2": crdv1.InstanceStatusUp},
+			wantStatus:  metav1.ConditionTrue,
+			wantReason:  crdv1.InstanceReasonNodesRunning,
+			wantMessage: "running on all 2 nodes",
+		},
+		{
+			name: "nodes not running",
+			nodeStatus: map[string]string{"flexlb-1": crdv1.InstanceStatusUp, "flexlb-3": crdv1.InstanceStatusPending,
+				"flexlb-2": crdv1.InstanceStatusDown},
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  crdv1.InstanceReasonNodesNotRunning,
+			wantMessage: "not running on 2 of 3 nodes: flexlb-2: down, flexlb-3: pending",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nodeStatusCondition(tt.nodeStatus)
+			if got.Type != crdv1.InstanceConditionNodesRunning || got.Status != tt.wantStatus || got.Reason != tt.wantReason {
+				t.Errorf("nodeStatusCondition() = %+v, want status %s reason %s", got, tt.wantStatus, tt.wantReason)
+			}
+			if tt.wantMessage != "" && got.Message != tt.wantMessage {
+				t.Errorf("nodeStatusCondition() message = %q, want %q", got.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestUpdateInstanceStatusReportsNodeStatus(t *testing.T) {
+	ctx := context.Background()
+	h, recorder := newTestHandler()
+	inst := &crdv1.FlexLBInstance{ObjectMeta: metav1.ObjectMeta{Name: "web-1a2b", Namespace: "default",
+		Annotations: map[string]string{ServiceKey: "web"}}}
+	k8s := newFakeClient(inst, testService(map[string]string{}))
+
+	serviceCondition := func() *metav1.Condition {
+		svc := &v1.Service{}
+		if err := k8s.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, svc); err != nil {
+			t.Fatalf("get service failed: %s", err.Error())
+		}
+		return meta.FindStatusCondition(svc.Status.Conditions, ServiceConditionNodesRunning)
+	}
+
+	// node status transitions of the instance, as reported by flexlb
+	steps := []struct {
+		name       string
+		nodeStatus map[string]string
+		wantStatus metav1.ConditionStatus
+		wantEvents int
+	}{
+		{name: "running", nodeStatus: map[string]string{"flexlb-1": "up", "flexlb-2": "up"}, wantStatus: metav1.ConditionTrue},
+		{name: "node down", nodeStatus: map[string]string{"flexlb-1": "up", "flexlb-2": "down"}, wantStatus: metav1.ConditionFalse, wantEvents: 2},
+		{name: "node still down", nodeStatus: map[string]string{"flexlb-1": "up", "flexlb-2": "down"}, wantStatus: metav1.ConditionFalse},
+		{name: "recovered", nodeStatus: map[string]string{"flexlb-1": "up", "flexlb-2": "up"}, wantStatus: metav1.ConditionTrue},
+		{name: "cluster not ready", wantStatus: metav1.ConditionUnknown},
+	}
+	for _, step := range steps {
+		var nodeStatus *map[string]string
+		if step.nodeStatus != nil {
+			nodeStatus = &step.nodeStatus
+		}
+		if err := h.updateInstanceStatus(k8s, ctx, inst, crdv1.InstancePhaseReady, nodeStatus); err != nil {
+			t.Fatalf("%s: updateInstanceStatus() error = %v", step.name, err)
+		}
+		if cond := meta.FindStatusCondition(inst.Status.Conditions, crdv1.InstanceConditionNodesRunning); cond == nil || cond.Status != step.wantStatus {
+			t.Errorf("%s: instance condition = %+v, want %s", step.name, cond, step.wantStatus)
+		}
+		if cond := serviceCondition(); cond == nil || cond.Status != step.wantStatus {
+			t.Errorf("%s: service condition = %+v, want %s", step.name, cond, step.wantStatus)
+		}
+		events := recordedEvents(recorder)
+		if len(events) != step.wantEvents {
+			t.Errorf("%s: events = %v, want %d", step.name, events, step.wantEvents)
+		}
+		for _, event := range events {
+			if !strings.Contains(event, "Warning "+InstanceNodeNotRunning) || !strings.Contains(event, "flexlb-2: down") {
+				t.Errorf("%s: event = %q, want a %s warning naming the node", step.name, event, InstanceNodeNotRunning)
+			}
+		}
+	}
+}
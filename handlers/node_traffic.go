@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// get node traffic ip, try the backend ip sources of ippool in order
+func getNodeTrafficIp(node *v1.Node, ippool *crdv1.FlexLBIPPool) (*string, error) {
+	sources := ippool.BackendIPSources
+	if len(sources) == 0 {
+		sources = []string{crdv1.BackendIPSourceNetwork}
+	}
+
+	for _, source := range sources {
+		var ip *string
+		switch source {
+		case crdv1.BackendIPSourceNetwork:
+			ip = trafficIpFromNetwork(node, ippool.BackendNetwork)
+		case crdv1.BackendIPSourceNodeAddress:
+			ip = trafficIpFromNodeAddress(node, ippool.BackendNetwork)
+		case crdv1.BackendIPSourceAnnotation:
+			ip = trafficIpFromAnnotation(node)
+		case crdv1.BackendIPSourceInterface:
+			ip = trafficIpFromInterface(node)
+		default:
+			return nil, fmt.Errorf("ippool '%s' has unknown backend ip source '%s'", ippool.Name, source)
+		}
+		if ip != nil {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("node '%s' has no traffic network", node.Name)
+}
+
+// get node selector of service annotation and ippool, both must match
+func getNodeSelector(svc *v1.Service, ippool *crdv1.FlexLBIPPool) (labels.Selector, error) {
+	selector := labels.Everything()
+	if ippool.NodeSelector != nil {
+		poolSelector, err := metav1.LabelSelectorAsSelector(ippool.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("ippool '%s' has invalid node selector: %s", ippool.Name, err.Error())
+		}
+		selector = poolSelector
+	}
+
+	data, exist := svc.Annotations[NodeSelectorKey]
+	if !exist || strings.TrimSpace(data) == "" {
+		return selector, nil
+	}
+	svcSelector, err := labels.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("service annotation '%s' is invalid: %s", NodeSelectorKey, err.Error())
+	}
+	requirements, _ := svcSelector.Requirements()
+	return selector.Add(requirements...), nil
+}
+
+// check whether node may receive load balancer traffic, returns the reason if not
+func nodeEligible(node *v1.Node, selector labels.Selector) (bool, string) {
+	if _, exist := node.Labels[v1.LabelNodeExcludeBalancers]; exist {
+		return false, "labeled " + v1.LabelNodeExcludeBalancers
+	}
+	if node.Spec.Unschedulable {
+		return false, "cordoned"
+	}
+	if !NodeReady(node) {
+		return false, "not ready"
+	}
+	if !selector.Matches(labels.Set(node.Labels)) {
+		return false, "not match node selector"
+	}
+	return true, ""
+}
+
+// node ready condition is true
+func NodeReady(node *v1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// get node networks from the node network annotation
+func getNodeNetworks(node *v1.Node) []NodeNetwork {
+	nodeNets := []NodeNetwork{}
+	data, exist := node.Annotations[NodeNetworkKey]
+	if !exist {
+		return nodeNets
+	}
+	json.Unmarshal([]byte(data), &nodeNets)
+	return nodeNets
+}
+
+// probed network equals backend network
+func trafficIpFromNetwork(node *v1.Node, trafficNetwork string) *string {
+	for _, nodeNet := range getNodeNetworks(node) {
+		if nodeNet.Network == trafficNetwork {
+			ip := nodeNet.IPAddress
+			return &ip
+		}
+	}
+	return nil
+}
+
+// node InternalIP or ExternalIP inside backend network, any InternalIP if backend network not set
+func trafficIpFromNodeAddress(node *v1.Node, trafficNetwork string) *string {
+	var cidr *net.IPNet
+	if trafficNetwork != "" {
+		if _, n, err := net.ParseCIDR(trafficNetwork); err == nil {
+			cidr = n
+		} else {
+			return nil
+		}
+	}
+
+	for _, addrType := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP} {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type != addrType {
+				continue
+			}
+			ip := net.ParseIP(addr.Address)
+			if ip == nil {
+				continue
+			}
+			if cidr == nil && addrType == v1.NodeInternalIP || cidr != nil && cidr.Contains(ip) {
+				address := addr.Address
+				return &address
+			}
+		}
+	}
+	return nil
+}
+
+// user set traffic ip annotation
+func trafficIpFromAnnotation(node *v1.Node) *string {
+	data, exist := node.Annotations[TrafficIPKey]
+	if !exist || net.ParseIP(data) == nil {
+		return nil
+	}
+	return &data
+}
+
+// probed network of the device selected by traffic interface label
+func trafficIpFromInterface(node *v1.Node) *string {
+	device, exist := node.Labels[TrafficInterfaceKey]
+	if !exist || device == "" {
+		return nil
+	}
+	for _, nodeNet := range getNodeNetworks(node) {
+		if nodeNet.Device == device {
+			ip := nodeNet.IPAddress
+			return &ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestGetNodeTrafficIp(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Annotations: map[string]string{
+				NodeNetworkKey: `[{"network":"10.0.0.0/24","device":"eth0","ip_address":"10.0.0.1"},` +
+					`{"network":"10.1.0.0/24","device":"eth1","ip_address":"10.1.0.1"}]`,
+				TrafficIPKey: "10.2.0.1",
+			},
+			Labels: map[string]string{TrafficInterfaceKey: "eth1"},
+		},
+		Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+			{Type: v1.NodeExternalIP, Address: "172.16.0.1"},
+			{Type: v1.NodeInternalIP, Address: "192.168.0.1"},
+		}},
+	}
+	tests := []struct {
+		name    string
+		network string
+		sources []string
+		want    string
+		wantErr bool
+	}{
+		{name: "network by default", network: "10.0.0.0/24", want: "10.0.0.1"},
+		{name: "network not probed", network: "10.9.0.0/24", wantErr: true},
+		{name: "internal ip without backend network", sources: []string{crdv1.BackendIPSourceNodeAddress}, want: "192.168.0.1"},
+		{name: "node address in backend network", network: "172.16.0.0/16", sources: []string{crdv1.BackendIPSourceNodeAddress}, want: "172.16.0.1"},
+		{name: "no node address in backend network", network: "10.9.0.0/24", sources: []string{crdv1.BackendIPSourceNodeAddress}, wantErr: true},
+		{name: "annotation", sources: []string{crdv1.BackendIPSourceAnnotation}, want: "10.2.0.1"},
+		{name: "interface", sources: []string{crdv1.BackendIPSourceInterface}, want: "10.1.0.1"},
+		{
+			name:    "first source resolved",
+			network: "10.9.0.0/24",
+			sources: []string{crdv1.BackendIPSourceNetwork, crdv1.BackendIPSourceInterface, crdv1.BackendIPSourceAnnotation},
+			want:    "10.1.0.1",
+		},
+		{name: "unknown source", sources: []string{"dns"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ippool := &crdv1.FlexLBIPPool{Name: "default", BackendNetwork: tt.network, BackendIPSources: tt.sources}
+			got, err := getNodeTrafficIp(node, ippool)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", *got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if *got != tt.want {
+				t.Errorf("got %s, want %s", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrafficIpFromAnnotation(t *testing.T) {
+	for value, want := range map[string]bool{"10.0.0.1": true, "not-an-ip": false, "": false} {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{TrafficIPKey: value}}}
+		if got := trafficIpFromAnnotation(node); (got != nil) != want {
+			t.Errorf("annotation %q: got %v, want resolved %t", value, got, want)
+		}
+	}
+}
+
+func TestGetNodeSelector(t *testing.T) {
+	nodeLabels := map[string]string{"zone": "a", "role": "edge"}
+	tests := []struct {
+		name         string
+		poolSelector *metav1.LabelSelector
+		annotation   string
+		match        bool
+		wantErr      bool
+	}{
+		{name: "no selector", match: true},
+		{name: "ippool selector", poolSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a"}}, match: true},
+		{name: "ippool selector not matched", poolSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "b"}}},
+		{name: "service selector", annotation: "role=edge", match: true},
+		{name: "blank service selector", annotation: "  ", match: true},
+		{
+			name:         "both selectors must match",
+			poolSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "a"}},
+			annotation:   "role!=edge",
+		},
+		{name: "invalid service selector", annotation: "role in (edge", wantErr: true},
+		{
+			name: "invalid ippool selector",
+			poolSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "zone", Operator: "Near"},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+			if tt.annotation != "" {
+				svc.Annotations[NodeSelectorKey] = tt.annotation
+			}
+			selector, err := getNodeSelector(svc, &crdv1.FlexLBIPPool{Name: "default", NodeSelector: tt.poolSelector})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got selector %s", selector)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got := selector.Matches(labels.Set(nodeLabels)); got != tt.match {
+				t.Errorf("selector %s matches: %t, want %t", selector, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestNodeEligible(t *testing.T) {
+	ready := v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}}
+	tests := []struct {
+		name     string
+		node     v1.Node
+		selector string
+		reason   string
+	}{
+		{name: "ready node", node: v1.Node{Status: ready}},
+		{
+			name:   "excluded from load balancers",
+			node:   v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{v1.LabelNodeExcludeBalancers: ""}}, Status: ready},
+			reason: "labeled " + v1.LabelNodeExcludeBalancers,
+		},
+		{name: "cordoned", node: v1.Node{Spec: v1.NodeSpec{Unschedulable: true}, Status: ready}, reason: "cordoned"},
+		{
+			name:   "not ready",
+			node:   v1.Node{Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionUnknown}}}},
+			reason: "not ready",
+		},
+		{name: "no ready condition", node: v1.Node{}, reason: "not ready"},
+		{name: "selector not matched", node: v1.Node{Status: ready}, selector: "zone=a", reason: "not match node selector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, _ := labels.Parse(tt.selector)
+			eligible, reason := nodeEligible(&tt.node, selector)
+			if eligible != (tt.reason == "") || reason != tt.reason {
+				t.Errorf("got %t %q, want reason %q", eligible, reason, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+)
+
+// proxy protocol versions sent to backends
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// backend server options of proxy protocol versions
+var sendProxyOptions = map[string]string{
+	ProxyProtocolV1: "send-proxy",
+	ProxyProtocolV2: "send-proxy-v2",
+}
+
+// frontend options of endpoint accepting proxy protocol
+const acceptProxyOptions = "accept-proxy"
+
+// proxy protocol of service ports
+type proxyProtocol struct {
+	version string // sent to backends, empty if not sent
+	accept  bool   // accepted on frontend
+	ports   map[uint16]bool
+}
+
+// get proxy protocol of service, only for tcp ports
+func getServiceProxyProtocol(svc *v1.Service) (*proxyProtocol, error) {
+	proxy := &proxyProtocol{
+		version: strings.TrimSpace(svc.Annotations[ProxyProtocolKey]),
+		accept:  svc.Annotations[AcceptProxyProtocolKey] == "true",
+	}
+	if proxy.version == "" && !proxy.accept {
+		return proxy, nil
+	}
+	if _, exist := sendProxyOptions[proxy.version]; proxy.version != "" && !exist {
+		return nil, fmt.Errorf("service annotation '%s' is invalid: version must be '%s' or '%s'", ProxyProtocolKey,
+			ProxyProtocolV1, ProxyProtocolV2)
+	}
+	ports, err := selectTCPPorts(svc, ProxyProtocolPortsKey)
+	if err != nil {
+		return nil, err
+	}
+	proxy.ports = map[uint16]bool{}
+	for _, port := range ports {
+		proxy.ports[uint16(port)] = true
+	}
+	return proxy, nil
+}
+
+// send proxy protocol to backends, and accept it on frontend, of the tcp endpoints of selected ports
+func applyProxyProtocol(endpoints []*models.Endpoint, proxy *proxyProtocol) {
+	for _, ep := range endpoints {
+		if ep.Mode == models.EndpointModeUDP || !proxy.ports[ep.FrontendPort] {
+			continue
+		}
+		if proxy.version != "" {
+			// default server options may be shared by endpoints
+			options := sendProxyOptions[proxy.version]
+			if ep.BackendDefaultServer != nil && *ep.BackendDefaultServer != "" {
+				options = *ep.BackendDefaultServer + " " + options
+			}
+			ep.BackendDefaultServer = &options
+		}
+		if proxy.accept {
+			addFrontendOption(ep, acceptProxyOptions)
+		}
+	}
+}
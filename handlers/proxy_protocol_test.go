@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestGetServiceProxyProtocol(t *testing.T) {
+	ports := []v1.ServicePort{servicePort(v1.ProtocolTCP, 80), servicePort(v1.ProtocolTCP, 443), servicePort(v1.ProtocolUDP, 53)}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *proxyProtocol
+		wantErr     bool
+	}{
+		{name: "not set", annotations: map[string]string{}, want: &proxyProtocol{}},
+		{
+			name:        "send to backends of all tcp ports",
+			annotations: map[string]string{ProxyProtocolKey: " v2 "},
+			want:        &proxyProtocol{version: ProxyProtocolV2, ports: map[uint16]bool{80: true, 443: true}},
+		},
+		{
+			name:        "accept on listed ports",
+			annotations: map[string]string{AcceptProxyProtocolKey: "true", ProxyProtocolPortsKey: "443"},
+			want:        &proxyProtocol{accept: true, ports: map[uint16]bool{443: true}},
+		},
+		{name: "invalid version", annotations: map[string]string{ProxyProtocolKey: "v3"}, wantErr: true},
+		{name: "udp port listed", annotations: map[string]string{ProxyProtocolKey: "v1", ProxyProtocolPortsKey: "53"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getServiceProxyProtocol(testService(tt.annotations, ports...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getServiceProxyProtocol() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getServiceProxyProtocol() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyProxyProtocol(t *testing.T) {
+	optionsOf := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	tests := []struct {
+		name            string
+		proxy           *proxyProtocol
+		wantDefault     []string
+		wantFrontendOpt []string
+	}{
+		{
+			name:            "send v1",
+			proxy:           &proxyProtocol{version: ProxyProtocolV1, ports: map[uint16]bool{80: true, 53: true}},
+			wantDefault:     []string{"check send-proxy", "check", "check"},
+			wantFrontendOpt: []string{"", "", ""},
+		},
+		{
+			name:            "send v2 and accept",
+			proxy:           &proxyProtocol{version: ProxyProtocolV2, accept: true, ports: map[uint16]bool{80: true, 443: true}},
+			wantDefault:     []string{"check send-proxy-v2", "check send-proxy-v2", "check"},
+			wantFrontendOpt: []string{"accept-proxy", "accept-proxy", ""},
+		},
+		{
+			name:            "accept only",
+			proxy:           &proxyProtocol{accept: true, ports: map[uint16]bool{443: true}},
+			wantDefault:     []string{"check", "check", "check"},
+			wantFrontendOpt: []string{"", "accept-proxy", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// default server options are shared by the endpoints, as rendered
+			shared := "check"
+			endpoints := []*models.Endpoint{
+				{FrontendPort: 80, Mode: models.EndpointModeTCP, BackendDefaultServer: &shared},
+				{FrontendPort: 443, Mode: models.EndpointModeTCP, BackendDefaultServer: &shared},
+				{FrontendPort: 53, Mode: models.EndpointModeUDP, BackendDefaultServer: &shared},
+			}
+			applyProxyProtocol(endpoints, tt.proxy)
+			for i, ep := range endpoints {
+				if got := optionsOf(ep.BackendDefaultServer); got != tt.wantDefault[i] {
+					t.Errorf("default server options of port %d = %q, want %q", ep.FrontendPort, got, tt.wantDefault[i])
+				}
+				if got := optionsOf(ep.FrontendOptions); got != tt.wantFrontendOpt[i] {
+					t.Errorf("frontend options of port %d = %q, want %q", ep.FrontendPort, got, tt.wantFrontendOpt[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+const (
+	defaultJitterPercent = 10
+	// ready refreshes before an instance is stable
+	stableRefreshes     = 3
+	minNotReadyInterval = time.Second
+)
+
+// RefreshPolicy are the refresh intervals of an instance
+type RefreshPolicy struct {
+	Interval         time.Duration
+	NotReadyInterval time.Duration
+	StableInterval   time.Duration
+	// random spread, fraction of interval
+	Jitter float64
+}
+
+// jittered refresh interval of instance, by ready status and consecutive ready refreshes
+func (p RefreshPolicy) Next(ready bool, readyRefreshes int) time.Duration {
+	interval := p.Interval
+	if !ready {
+		interval = p.NotReadyInterval
+	} else if readyRefreshes >= stableRefreshes {
+		interval = p.StableInterval
+	}
+	if p.Jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration((rand.Float64()*2-1)*p.Jitter*float64(interval))
+}
+
+// refresh policy of instance: refresh of the owned cluster, interval overridden by instance annotation,
+// intervals not set derived from the interval; the default interval is replaced by the reloaded one
+func (h *Handler) InstanceRefreshPolicy(k8s client.Client, ctx context.Context, instance *crdv1.FlexLBInstance,
+	defaultInterval time.Duration) RefreshPolicy {
+	if reloaded := h.currentSettings().RefreshInterval; reloaded > 0 {
+		defaultInterval = reloaded
+	}
+	refresh := crdv1.FlexLBRefresh{}
+	if cluster, err := h.getOwnedCluster(k8s, ctx, instance); err == nil && cluster.Spec.Refresh != nil {
+		refresh = *cluster.Spec.Refresh
+	}
+
+	policy := RefreshPolicy{Interval: defaultInterval, Jitter: defaultJitterPercent / 100.0}
+	if refresh.Interval > 0 {
+		policy.Interval = time.Duration(refresh.Interval) * time.Second
+	}
+	if value, exist := instance.Annotations[RefreshIntervalKey]; exist {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			policy.Interval = time.Duration(seconds) * time.Second
+		} else {
+			log.Log.Info("invalid refresh interval ignored", "instance", instance.Name, "namespace", instance.Namespace, "value", value)
+		}
+	}
+
+	// not ready: faster, but at most every second
+	policy.NotReadyInterval = policy.Interval / 4
+	if refresh.NotReadyInterval > 0 {
+		policy.NotReadyInterval = time.Duration(refresh.NotReadyInterval) * time.Second
+	}
+	if policy.NotReadyInterval < minNotReadyInterval {
+		policy.NotReadyInterval = minNotReadyInterval
+	}
+	if policy.NotReadyInterval > policy.Interval {
+		policy.NotReadyInterval = policy.Interval
+	}
+
+	// stable: slower
+	policy.StableInterval = 2 * policy.Interval
+	if refresh.StableInterval > 0 {
+		policy.StableInterval = time.Duration(refresh.StableInterval) * time.Second
+	}
+	if policy.StableInterval < policy.Interval {
+		policy.StableInterval = policy.Interval
+	}
+
+	if refresh.JitterPercent != nil {
+		policy.Jitter = float64(*refresh.JitterPercent) / 100
+	}
+	return policy
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestRefreshPolicyNext(t *testing.T) {
+	policy := RefreshPolicy{Interval: 30 * time.Second, NotReadyInterval: 5 * time.Second, StableInterval: time.Minute}
+	tests := []struct {
+		name           string
+		ready          bool
+		readyRefreshes int
+		want           time.Duration
+	}{
+		{name: "not ready", ready: false, readyRefreshes: 5, want: 5 * time.Second},
+		{name: "ready", ready: true, readyRefreshes: stableRefreshes - 1, want: 30 * time.Second},
+		{name: "stable", ready: true, readyRefreshes: stableRefreshes, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Next(tt.ready, tt.readyRefreshes); got != tt.want {
+				t.Errorf("Next() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("jitter", func(t *testing.T) {
+		jittered := policy
+		jittered.Jitter = 0.1
+		min, max := 27*time.Second, 33*time.Second
+		spread := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			got := jittered.Next(true, 0)
+			if got < min || got > max {
+				t.Fatalf("Next() = %s, want within [%s, %s]", got, min, max)
+			}
+			spread[got] = true
+		}
+		if len(spread) < 2 {
+			t.Errorf("Next() not spread: %v", spread)
+		}
+	})
+}
+
+func TestInstanceRefreshPolicy(t *testing.T) {
+	percent := func(p int32) *int32 { return &p }
+	tests := []struct {
+		name        string
+		refresh     *crdv1.FlexLBRefresh
+		annotations map[string]string
+		reloaded    time.Duration
+		want        RefreshPolicy
+	}{
+		{
+			name: "defaults",
+			want: RefreshPolicy{Interval: 30 * time.Second, NotReadyInterval: 7500 * time.Millisecond, StableInterval: time.Minute, Jitter: 0.1},
+		},
+		{
+			name:     "reloaded default interval",
+			reloaded: time.Minute,
+			want:     RefreshPolicy{Interval: time.Minute, NotReadyInterval: 15 * time.Second, StableInterval: 2 * time.Minute, Jitter: 0.1},
+		},
+		{
+			name:    "cluster refresh",
+			refresh: &crdv1.FlexLBRefresh{Interval: 20, NotReadyInterval: 2, StableInterval: 120, JitterPercent: percent(0)},
+			want:    RefreshPolicy{Interval: 20 * time.Second, NotReadyInterval: 2 * time.Second, StableInterval: 2 * time.Minute},
+		},
+		{
+			name:        "instance annotation overrides cluster interval",
+			refresh:     &crdv1.FlexLBRefresh{Interval: 20},
+			annotations: map[string]string{RefreshIntervalKey: "8"},
+			want:        RefreshPolicy{Interval: 8 * time.Second, NotReadyInterval: 2 * time.Second, StableInterval: 16 * time.Second, Jitter: 0.1},
+		},
+		{
+			name:        "invalid annotation ignored",
+			annotations: map[string]string{RefreshIntervalKey: "soon"},
+			want:        RefreshPolicy{Interval: 30 * time.Second, NotReadyInterval: 7500 * time.Millisecond, StableInterval: time.Minute, Jitter: 0.1},
+		},
+		{
+			name:        "bounded intervals",
+			refresh:     &crdv1.FlexLBRefresh{NotReadyInterval: 60, StableInterval: 1},
+			annotations: map[string]string{RefreshIntervalKey: "2"},
+			want:        RefreshPolicy{Interval: 2 * time.Second, NotReadyInterval: 2 * time.Second, StableInterval: 2 * time.Second, Jitter: 0.1},
+		},
+		{
+			name:        "not ready at most every second",
+			annotations: map[string]string{RefreshIntervalKey: "2"},
+			want:        RefreshPolicy{Interval: 2 * time.Second, NotReadyInterval: time.Second, StableInterval: 4 * time.Second, Jitter: 0.1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler()
+			settings := h.currentSettings()
+			settings.RefreshInterval = tt.reloaded
+			h.Reconfigure(settings)
+			k8s := newFakeClient(&crdv1.FlexLBCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultClusterName, Namespace: testNamespace},
+				Spec:       crdv1.FlexLBClusterSpec{Refresh: tt.refresh},
+			})
+			inst := &crdv1.FlexLBInstance{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: tt.annotations}}
+			if got := h.InstanceRefreshPolicy(k8s, context.Background(), inst, 30*time.Second); got != tt.want {
+				t.Errorf("InstanceRefreshPolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
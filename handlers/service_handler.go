@@ -2,74 +2,249 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	models "github.com/flexlet/flexlb-client-go/models"
 	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
 	"github.com/flexlet/flexlb-kube-controller/utils"
+	utl "github.com/flexlet/utils"
 	"github.com/google/go-cmp/cmp"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
-	utl "github.com/flexlet/utils"
 )
 
 // allocate flexlbinstance for load balancer type of service
-func (h *Handler) ServiceChanged(k8s client.Client, ctx context.Context, svc *v1.Service) error {
-	// old one is loadbalancer, but new one is not
-	if svc.Spec.Type != v1.ServiceTypeLoadBalancer {
+// returns the duration after which the service should be checked again, when backends are draining
+func (h *Handler) ServiceChanged(k8s client.Client, ctx context.Context, svc *v1.Service) (time.Duration, error) {
+	h.lock(serviceLockKey(svc.Namespace, svc.Name), "update balancer for service", "handler", "ServiceChanged", "service", svc.Name, "namespace", svc.Namespace)
+	defer h.unlock(serviceLockKey(svc.Namespace, svc.Name), "update balancer for service end", "handler", "ServiceChanged", "service", svc.Name, "namespace", svc.Namespace)
+
+	// old one is loadbalancer, but new one is not; or a service of another load balancer class
+	if svc.Spec.Type != v1.ServiceTypeLoadBalancer || !IsLoadBalancerClass(svc, h.loadBalancerClass) {
 		// delete instance recorded in annotation
-		return h.deleteInstanceForService(k8s, ctx, svc)
+		return 0, h.deleteInstanceForService(k8s, ctx, svc)
 	}
 
-	// get cluster name annotation, set to default if not exist
-	clusterName, exist := svc.Annotations[ClusterKey]
+	// get cluster annotation, set to default if not exist
+	clusterRef, exist := svc.Annotations[ClusterKey]
 	if !exist {
-		clusterName = DefaultClusterName
+		clusterRef = h.defaultCluster
+	}
+	clusterKey, err := h.clusterKey(clusterRef)
+	if err != nil {
+		return 0, h.errorf(svc, ErrorInvalidConfig, err, "invalid cluster")
+	}
+	if err := h.checkClusterAccess(k8s, ctx, clusterKey, svc.Namespace); err != nil {
+		return 0, h.errorf(svc, ErrorClusterAccessDenied, err, "cluster access denied")
 	}
+	// canonical reference: name of clusters in the flexlb namespace
+	clusterName := h.clusterRef(clusterKey)
 
 	// get ippool annotation, set to default if not exist
 	ippoolName, exist := svc.Annotations[IPPoolKey]
 	if !exist {
-		ippoolName = DefaultIPPoolName
+		ippoolName = h.defaultIPPool
 	}
 
-	ippool, err := getIPPool(k8s, ctx, h.namespace, clusterName, ippoolName)
+	ippool, err := h.getIPPool(k8s, ctx, clusterName, ippoolName)
 	if err != nil {
-		return h.errorf(svc, ErrorNoIPPool, err, "ippool does not exist")
+		return 0, h.errorf(svc, ErrorNoIPPool, err, "ippool does not exist")
+	}
+	if err := checkIPPoolAccess(k8s, ctx, clusterName, ippool, svc.Namespace); err != nil {
+		return 0, h.errorf(svc, ErrorIPPoolAccessDenied, err, "ippool access denied")
+	}
+
+	// nodes allowed to receive traffic
+	selector, err := getNodeSelector(svc, ippool)
+	if err != nil {
+		return 0, h.errorf(svc, ErrorInvalidConfig, err, "invalid node selector")
 	}
 
 	// get service nodeIp endpoints
-	endpoints, err := getNodeIpEndpoints(k8s, ctx, svc, ippool.BackendNetwork)
+	endpoints, nodes, err := getNodeIpEndpoints(k8s, ctx, svc, ippool, selector)
+	if len(nodes.excluded) > 0 {
+		h.warnf(svc, ErrorNoTrafficNodeIp, "nodes excluded from backends because no traffic ip found: %s",
+			strings.Join(nodes.excluded, ", "))
+	}
 	if err != nil || len(endpoints) == 0 {
-		return fmt.Errorf("not found node ip endpoint")
+		return 0, h.errorf(svc, ErrorNoTrafficNodeIp, err, "not found node ip endpoint")
 	}
 
-	// get instance annotation
+	// terminate tls on the frontend of ports with tls secret
+	tlsSecrets, err := getServiceTLSSecrets(svc)
+	if err != nil {
+		return 0, h.errorf(svc, ErrorInvalidConfig, err, "invalid tls config")
+	}
+	applyTLSSecrets(endpoints, tlsSecrets)
+
+	// accept clients of the source ranges only
+	allowed, denied, err := getServiceSourceRanges(svc)
+	if err != nil {
+		return 0, h.errorf(svc, ErrorInvalidConfig, err, "invalid source ranges")
+	}
+	if skipped := applySourceRanges(endpoints, allowed, denied); len(skipped) > 0 {
+		h.warnf(svc, ErrorInvalidConfig, "source ranges not enforced on udp ports: %s",
+			strings.Join(skipped, ", "))
+	}
+
+	// proxy protocol toward backends and on frontend
+	proxy, err := getServiceProxyProtocol(svc)
+	if err != nil {
+		return 0, h.errorf(svc, ErrorInvalidConfig, err, "invalid proxy protocol")
+	}
+	applyProxyProtocol(endpoints, proxy)
+
+	// client ip and cookie session affinity
+	affinity, err := getServiceAffinity(svc)
+	if err != nil {
+		return 0, h.errorf(svc, ErrorInvalidConfig, err, "invalid session affinity")
+	}
+	if skipped := applySessionAffinity(endpoints, affinity); len(skipped) > 0 {
+		h.warnf(svc, ErrorInvalidConfig, "affinity cookie not set on non http ports: %s",
+			strings.Join(skipped, ", "))
+	}
+
+	// connection and rate limits, defaults from cluster
+	limits, err := getServiceLimits(k8s, ctx, clusterKey, svc)
+	if err != nil {
+		return 0, h.errorf(svc, ErrorInvalidConfig, err, "invalid limits")
+	}
+	if skipped := applyLimits(endpoints, limits); len(skipped) > 0 && hasLimitAnnotations(svc) {
+		h.warnf(svc, ErrorInvalidConfig, "limits not fully enforced on ports: %s",
+			strings.Join(skipped, ", "))
+	}
+
+	// services with the same sharing key share one instance
+	sharingKey, err := getSharingKey(svc)
+	if err != nil {
+		return 0, h.errorf(svc, ErrorInvalidConfig, err, "invalid sharing key")
+	}
+	if sharingKey != "" {
+		key := sharingLockKey(svc.Namespace, sharingKey)
+		h.lock(key, "share instance", "service", svc.Name, "namespace", svc.Namespace, "sharingKey", sharingKey)
+		defer h.unlock(key, "share instance end", "service", svc.Name, "namespace", svc.Namespace, "sharingKey", sharingKey)
+	}
+
+	// get allocated or shared instance, and the instance to leave if service moves
+	inst, leave, err := h.getInstanceForService(k8s, ctx, svc, sharingKey, clusterName, ippoolName)
+	if err != nil {
+		return 0, err
+	}
+
+	// a new ip is allocated for a new instance, or an instance moving to another cluster or ippool
+	if inst == nil || inst.Spec.Cluster != clusterName || inst.Spec.IPPool != ippoolName {
+		if ipPoolQuota(ippool, svc.Namespace) > 0 {
+			key := quotaLockKey(svc.Namespace, clusterName, ippoolName)
+			h.lock(key, "check ippool quota", "service", svc.Name, "namespace", svc.Namespace, "ippool", ippoolName)
+			defer h.unlock(key, "check ippool quota end", "service", svc.Name, "namespace", svc.Namespace, "ippool", ippoolName)
+		}
+		// the instance left is deleted, unless shared with other services
+		exclude := ""
+		if leave != nil && len(GetInstanceServices(leave)) <= 1 {
+			exclude = leave.Name
+		}
+		if err := h.checkIPPoolQuota(k8s, ctx, clusterKey, ippool, svc.Namespace, exclude); err != nil {
+			return 0, h.errorf(svc, ErrorIPPoolQuotaExceeded, err, "ippool quota exceeded")
+		}
+	}
+	if inst == nil {
+		// create instance and update service
+		requeue, err := h.createIntanceForService(k8s, ctx, svc, sharingKey, clusterName, ippoolName, endpoints, nodes, tlsSecrets)
+		if err == nil && leave != nil {
+			err = h.detachService(k8s, ctx, svc, leave)
+		}
+		return requeue, err
+	}
+
+	// shared with other services, check port conflicts and merge their endpoints
+	if sharingKey != "" {
+		sharedPorts, err := sharedPortKeys(k8s, ctx, inst, svc)
+		if err == nil {
+			err = checkPortConflict(svc, sharedPorts)
+		}
+		if err != nil {
+			return 0, h.errorf(svc, ErrorPortConflict, err, "can not share ip of sharing key '%s'", sharingKey)
+		}
+		endpoints = mergeSharedEndpoints(inst, endpoints, nodes, sharedPorts)
+		tlsSecrets = mergeSharedTLSSecrets(inst, tlsSecrets, sharedPorts)
+	}
+
+	// keep backends of draining nodes until grace period passed
+	draining, requeue := h.drainBackends(k8s, ctx, inst, endpoints, nodes)
+	// got the instance, check whether need update
+	if needUpdate(inst, svc, sharingKey, clusterName, ippoolName, endpoints, nodes, tlsSecrets) {
+		// update instance and service
+		if err := h.updateIntanceForService(k8s, ctx, svc, inst, sharingKey, clusterName, ippoolName, endpoints, nodes, tlsSecrets); err != nil {
+			return 0, err
+		}
+	}
+	if leave != nil {
+		if err := h.detachService(k8s, ctx, svc, leave); err != nil {
+			return 0, err
+		}
+		// left a duplicate instance of the sharing key, its other services join as well
+		if sharingKey != "" && leave.Labels[SharingKey] == sharingKey {
+			h.resyncServices(k8s, ctx, leave)
+		}
+	}
+	return requeue, updateDrainingStatus(k8s, ctx, inst, draining)
+}
+
+// get the instance of service: the allocated one, or the one shared by sharing key
+// returns the instance to leave as well, if the service moves to another instance
+func (h *Handler) getInstanceForService(k8s client.Client, ctx context.Context, svc *v1.Service, sharingKey string,
+	clusterName string, ippoolName string) (*crdv1.FlexLBInstance, *crdv1.FlexLBInstance, error) {
+	var current *crdv1.FlexLBInstance
 	if instName, exist := svc.Annotations[InstanceKey]; exist {
-		// already allocated an instance, need to fetch and update
+		// instance allocated but not exist in system, a new one is needed
 		inst := &crdv1.FlexLBInstance{}
-		if err := k8s.Get(ctx, types.NamespacedName{Name: instName, Namespace: svc.Namespace}, inst); err != nil {
-			// instance allocated but not exist in system, create a new one
-			return h.createIntanceForService(k8s, ctx, svc, clusterName, ippoolName, endpoints)
+		if err := k8s.Get(ctx, types.NamespacedName{Name: instName, Namespace: svc.Namespace}, inst); err == nil {
+			current = inst
+		}
+	}
+
+	// not shared
+	if current != nil && sharingKey == "" && current.Labels[SharingKey] == "" {
+		return current, nil, nil
+	}
+
+	// join the instance shared by sharing key, also when the current one is a duplicate of the sharing key
+	if sharingKey != "" {
+		shared, err := h.findSharedInstance(k8s, ctx, svc.Namespace, sharingKey, clusterName, ippoolName)
+		if err != nil {
+			return nil, nil, err
 		}
-		// got the instance, check whether need update
-		if needUpdate(inst, clusterName, ippoolName, endpoints) {
-			// update instance and service
-			return h.updateIntanceForService(k8s, ctx, svc, inst, clusterName, ippoolName, endpoints)
+		if shared != nil && current != nil && shared.Name == current.Name {
+			return current, nil, nil
 		}
-		// no need of update
-		return nil
+		if shared != nil {
+			return shared, current, nil
+		}
+	}
+	if current == nil {
+		return nil, nil, nil
 	}
 
-	// create instance and update service
-	return h.createIntanceForService(k8s, ctx, svc, clusterName, ippoolName, endpoints)
+	// nothing to join, keep current instance if not shared with other services
+	services := GetInstanceServices(current)
+	if len(services) == 1 && services[0] == svc.Name {
+		return current, nil, nil
+	}
+	return nil, current, nil
 }
 
 func (h *Handler) ServiceDeleted(k8s client.Client, ctx context.Context, svc *v1.Service) error {
+	h.lock(serviceLockKey(svc.Namespace, svc.Name), "delete balancer for service", "handler", "ServiceDeleted", "service", svc.Name, "namespace", svc.Namespace)
+	defer h.unlock(serviceLockKey(svc.Namespace, svc.Name), "delete balancer for service end", "handler", "ServiceDeleted", "service", svc.Name, "namespace", svc.Namespace)
+
 	return h.deleteInstanceForService(k8s, ctx, svc)
 }
 
@@ -77,16 +252,18 @@ func (h *Handler) ServiceDeleted(k8s client.Client, ctx context.Context, svc *v1
 // restriction: annotation should not be cleared manually
 // another way is list instance with service annnotation, but has same restriction
 func (h *Handler) deleteInstanceForService(k8s client.Client, ctx context.Context, svc *v1.Service) error {
-	h.lock("delete balancer for service", "handler", "ServiceDeleted", "service", svc.Name, "namespace", svc.Namespace)
-	defer h.unlock("delete balancer for service end", "handler", "ServiceDeleted", "service", svc.Name, "namespace", svc.Namespace)
-
 	// get instance annotation
 	if instName, exist := svc.Annotations[InstanceKey]; exist {
 		// get and delete instance
 		inst := &crdv1.FlexLBInstance{}
 		if err := k8s.Get(ctx, types.NamespacedName{Name: instName, Namespace: svc.Namespace}, inst); err == nil {
-			// instance exist, delete it
-			if err1 := k8s.Delete(ctx, inst); err1 != nil {
+			// instance exist, delete it, or leave it if shared with other services
+			if sharingKey, shared := inst.Labels[SharingKey]; shared {
+				key := sharingLockKey(inst.Namespace, sharingKey)
+				h.lock(key, "leave instance", "service", svc.Name, "namespace", svc.Namespace, "sharingKey", sharingKey)
+				defer h.unlock(key, "leave instance end", "service", svc.Name, "namespace", svc.Namespace, "sharingKey", sharingKey)
+			}
+			if err1 := h.detachService(k8s, ctx, svc, inst); err1 != nil {
 				return err1
 			}
 		}
@@ -98,43 +275,91 @@ func (h *Handler) deleteInstanceForService(k8s client.Client, ctx context.Contex
 }
 
 // check whether instance need update
-func needUpdate(inst *crdv1.FlexLBInstance, clusterName string, ippoolName string, endpoints []*models.Endpoint) bool {
+func needUpdate(inst *crdv1.FlexLBInstance, svc *v1.Service, sharingKey string, clusterName string, ippoolName string,
+	endpoints []*models.Endpoint, nodes *backendNodes, tlsSecrets map[string]string) bool {
+	return (svc.Annotations[InstanceKey] != inst.Name ||
+		inst.Labels[SharingKey] != sharingKey ||
+		inst.Annotations[TLSSecretsKey] != tlsSecretsAnnotation(tlsSecrets) ||
+		!utl.ListContains(GetInstanceServices(inst), svc.Name) ||
+		instanceChanged(inst, clusterName, ippoolName, endpoints, nodes))
+}
+
+// check whether instance cluster, ippool or endpoints changed
+func instanceChanged(inst *crdv1.FlexLBInstance, clusterName string, ippoolName string, endpoints []*models.Endpoint, nodes *backendNodes) bool {
 	return (inst.Spec.Cluster != clusterName ||
 		inst.Spec.IPPool != ippoolName ||
+		inst.Annotations[BackendNodesKey] != nodes.annotation() ||
 		!cmp.Equal(inst.Spec.Config.Endpoints, endpoints))
 }
 
-// get pod residents node's ip:port endpoints
-func getNodeIpEndpoints(k8s client.Client, ctx context.Context, svc *v1.Service, trafficNetwork string) ([]*models.Endpoint, error) {
+// backend default server options of rendered endpoints
+const defaultBackendServerOptions = "inter 2s downinter 5s rise 2 fall 2 slowstart 60s maxconn 2000 maxqueue 2000 weight 100 check"
+
+// get pod residents node's ip:port endpoints, and their backend nodes
+// nodes not eligible for load balancer traffic (excluded, not ready, not selected) are skipped,
+// backends on draining nodes are rendered in drain mode
+func getNodeIpEndpoints(k8s client.Client, ctx context.Context, svc *v1.Service, ippool *crdv1.FlexLBIPPool,
+	selector labels.Selector) ([]*models.Endpoint, *backendNodes, error) {
 	flexlbEndpoints := []*models.Endpoint{}
+	nodes := newBackendNodes()
 	eps, err := utils.GetEndpointSliceOfService(k8s, ctx, svc)
 	if err != nil {
-		return flexlbEndpoints, err
+		return flexlbEndpoints, nodes, err
 	}
-	backendDefaultOptions := "inter 2s downinter 5s rise 2 fall 2 slowstart 60s maxconn 2000 maxqueue 2000 weight 100 check"
+
+	// check and resolve traffic ip once per node
+	checked := map[string]bool{}
+	for _, ep := range eps.Endpoints {
+		if ep.NodeName == nil || checked[*ep.NodeName] {
+			continue
+		}
+		checked[*ep.NodeName] = true
+		node := &v1.Node{}
+		if err := k8s.Get(ctx, types.NamespacedName{Name: *ep.NodeName}, node); err != nil {
+			log.Log.Info("skip backend node", "service", svc.Name, "namespace", svc.Namespace, "node", *ep.NodeName, "reason", err.Error())
+			continue
+		}
+		if eligible, reason := nodeEligible(node, selector); !eligible {
+			log.Log.Info("skip backend node", "service", svc.Name, "namespace", svc.Namespace, "node", node.Name, "reason", reason)
+			continue
+		}
+		trafficNodeIp, err := getNodeTrafficIp(node, ippool)
+		if err != nil {
+			// by pass node with no traffic node ip
+			nodes.excluded = append(nodes.excluded, node.Name)
+			continue
+		}
+		nodes.trafficIps[node.Name] = *trafficNodeIp
+		if mode, draining := nodeDraining(node); draining {
+			nodes.draining[node.Name] = mode
+		}
+	}
+
+	backendDefaultOptions := defaultBackendServerOptions
 	for _, port := range svc.Spec.Ports {
 		backends := []*models.BackendServer{}
 		for _, ep := range eps.Endpoints {
-			trafficNodeIp, err := getNodeTrafficIp(k8s, ctx, *ep.NodeName, trafficNetwork)
-			if err != nil {
-				// by pass node with no traffic node ip
+			if ep.NodeName == nil || ep.TargetRef == nil {
+				continue
+			}
+			trafficNodeIp, exist := nodes.trafficIps[*ep.NodeName]
+			if !exist {
 				continue
 			}
 			backend := &models.BackendServer{
 				Name:      ep.TargetRef.Name,
-				Ipaddress: *trafficNodeIp,
+				Ipaddress: trafficNodeIp,
 				Port:      uint16(port.NodePort),
 			}
+			if mode, draining := nodes.draining[*ep.NodeName]; draining {
+				backend.Options = drainOptions(mode)
+			}
 			backends = append(backends, backend)
 		}
 
 		// get protocol
-		var mode string
-		if port.Protocol == v1.ProtocolUDP {
-			mode = models.EndpointModeUDP
-		} else if port.Protocol == v1.ProtocolTCP {
-			mode = models.EndpointModeTCP
-		} else {
+		mode := serviceEndpointMode(port)
+		if mode == "" {
 			// v1.ProtocolSCTP not support
 			log.Log.Info("Service '%s' protocol not support", svc.Name)
 			continue
@@ -150,46 +375,33 @@ func getNodeIpEndpoints(k8s client.Client, ctx context.Context, svc *v1.Service,
 		}
 		flexlbEndpoints = append(flexlbEndpoints, flexlbEndpoint)
 	}
-	return flexlbEndpoints, nil
-}
-
-// get node traffic ip from the node network annotation
-func getNodeTrafficIp(k8s client.Client, ctx context.Context, nodeName string, trafficNetwork string) (*string, error) {
-	node := &v1.Node{}
-	if err := k8s.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
-		return nil, err
-	}
-	data, exist := node.Annotations[NodeNetworkKey]
-	if !exist {
-		return nil, fmt.Errorf("node '%s' has no traffic network", nodeName)
-	}
-
-	nodeNets := []NodeNetwork{}
-
-	if err := json.Unmarshal([]byte(data), &nodeNets); err != nil {
-		return nil, fmt.Errorf("node '%s' has no traffic network", nodeName)
-	}
-
-	for _, nodeNet := range nodeNets {
-		if nodeNet.Network == trafficNetwork {
-			return &nodeNet.IPAddress, nil
-		}
-	}
-
-	return nil, fmt.Errorf("node '%s' has no traffic network", nodeName)
+	return flexlbEndpoints, nodes, nil
 }
 
 // create instance and update service
-func (h *Handler) createIntanceForService(k8s client.Client, ctx context.Context, svc *v1.Service,
-	clusterName string, ippoolName string, endpoints []*models.Endpoint) error {
+func (h *Handler) createIntanceForService(k8s client.Client, ctx context.Context, svc *v1.Service, sharingKey string,
+	clusterName string, ippoolName string, endpoints []*models.Endpoint, nodes *backendNodes, tlsSecrets map[string]string) (time.Duration, error) {
 
-	h.lock("set balancer for service", "handler", "ServiceChanged", "service", svc.Name, "namespace", svc.Namespace)
-	defer h.unlock("set balancer for service end", "handler", "ServiceChanged", "service", svc.Name, "namespace", svc.Namespace)
+	// backends of draining nodes are drained from now on
+	draining, requeue := h.drainBackends(k8s, ctx, nil, endpoints, nodes)
 
 	// create instance
-	inst, err := createIntance(k8s, ctx, h.namespace, clusterName, ippoolName, svc.Name, svc.Namespace, endpoints)
+	instLabels := map[string]string{}
+	if sharingKey != "" {
+		instLabels[SharingKey] = sharingKey
+	}
+	instAnnotations := map[string]string{ServiceKey: svc.Name}
+	if len(tlsSecrets) > 0 {
+		instAnnotations[TLSSecretsKey] = tlsSecretsAnnotation(tlsSecrets)
+	}
+	inst, err := h.createIntance(k8s, ctx, clusterName, ippoolName, svc.Name, svc.Namespace,
+		instAnnotations, instLabels, endpoints, nodes)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if sharingKey != "" {
+		// joined by services of the sharing key, before the instance is visible in the informer cache
+		h.reserve(sharedInstanceKey(svc.Namespace, sharingKey, clusterName, ippoolName), inst.Name)
 	}
 
 	// update service annotaion
@@ -198,68 +410,89 @@ func (h *Handler) createIntanceForService(k8s client.Client, ctx context.Context
 	}
 	svc.Annotations[InstanceKey] = inst.Name
 	if err := k8s.Update(ctx, svc); err != nil {
-		return err
+		return 0, err
 	}
 
 	// update service loadbalancer
 	ingress := v1.LoadBalancerIngress{IP: inst.Spec.Config.FrontendIpaddress}
 	svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{ingress}
-	return k8s.Status().Update(ctx, svc)
+	if err := k8s.Status().Update(ctx, svc); err != nil {
+		return 0, err
+	}
+	return requeue, updateDrainingStatus(k8s, ctx, inst, draining)
 }
 
 // update instance and update service
 func (h *Handler) updateIntanceForService(k8s client.Client, ctx context.Context, svc *v1.Service, inst *crdv1.FlexLBInstance,
-	clusterName string, ippoolName string, endpoints []*models.Endpoint) error {
+	sharingKey string, clusterName string, ippoolName string, endpoints []*models.Endpoint, nodes *backendNodes,
+	tlsSecrets map[string]string) error {
 
-	h.lock("update balancer for service", "handler", "ServiceChanged", "service", svc.Name, "namespace", svc.Namespace)
-	defer h.unlock("update balancer for service end", "handler", "ServiceChanged", "service", svc.Name, "namespace", svc.Namespace)
+	// join the instance
+	if services := GetInstanceServices(inst); !utl.ListContains(services, svc.Name) {
+		setInstanceServices(inst, append(services, svc.Name))
+	}
+	if inst.Labels == nil {
+		inst.Labels = map[string]string{}
+	}
+	if sharingKey != "" {
+		inst.Labels[SharingKey] = sharingKey
+	} else {
+		delete(inst.Labels, SharingKey)
+	}
+	setInstanceTLSSecrets(inst, tlsSecrets)
 
 	// update instance
-	inst, err := updateIntance(k8s, ctx, inst, h.namespace, clusterName, ippoolName, endpoints)
+	inst, err := h.updateIntance(k8s, ctx, inst, clusterName, ippoolName, endpoints, nodes)
 	if err != nil {
 		return err
 	}
 
+	// update service annotation, if moved to a shared instance
+	if svc.Annotations[InstanceKey] != inst.Name {
+		if svc.Annotations == nil {
+			svc.Annotations = map[string]string{}
+		}
+		svc.Annotations[InstanceKey] = inst.Name
+		if err := k8s.Update(ctx, svc); err != nil {
+			return err
+		}
+	}
+
 	// update service loadbalancer
 	ingress := v1.LoadBalancerIngress{IP: inst.Spec.Config.FrontendIpaddress}
 	svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{ingress}
 	return k8s.Status().Update(ctx, svc)
 }
 
-// create flexlbinstance for service
-func createIntance(k8s client.Client, ctx context.Context, flexlbNamespace string, clusterName string, ippoolName string,
-	serviceName string, serviceNamespace string, endpoints []*models.Endpoint) (*crdv1.FlexLBInstance, error) {
-	cluster := &crdv1.FlexLBCluster{}
-	if err := k8s.Get(ctx, types.NamespacedName{Name: clusterName, Namespace: flexlbNamespace}, cluster); err != nil {
-		return nil, fmt.Errorf("cluster '%s' does not exist", clusterName)
-	}
-	var ippool *crdv1.FlexLBIPPool
-	for i := 0; i < len(cluster.Spec.IPPools); i++ {
-		if cluster.Spec.IPPools[i].Name == ippoolName {
-			ippool = &cluster.Spec.IPPools[i]
-			break
-		}
-	}
-	if ippool == nil {
-		return nil, fmt.Errorf("ippool '%s' in cluster '%s' does not exist", ippoolName, clusterName)
+// create flexlbinstance named after its owner (service or gateway), owner is recorded in annotations
+// clusterName is the cluster reference
+func (h *Handler) createIntance(k8s client.Client, ctx context.Context, clusterName string, ippoolName string,
+	ownerName string, namespace string, annotations map[string]string, labels map[string]string,
+	endpoints []*models.Endpoint, nodes *backendNodes) (*crdv1.FlexLBInstance, error) {
+	clusterKey, err := h.clusterKey(clusterName)
+	if err != nil {
+		return nil, err
 	}
-
-	allocated, err := getAllocatedIp(k8s, ctx, clusterName, ippoolName)
+	ippool, err := h.getIPPool(k8s, ctx, clusterName, ippoolName)
 	if err != nil {
 		return nil, err
 	}
 
-	frontendIpaddress, err := utl.AllocIPFromRange(ippool.Start, ippool.End, allocated)
+	frontendIpaddress, err := h.allocateIp(k8s, ctx, clusterName, ippoolName, ippool)
 	if err != nil {
 		return nil, err
 	}
 
-	instName := fmt.Sprintf("%s-%s", serviceName, utl.RandomString(4))
+	annotations[BackendNodesKey] = nodes.annotation()
+	h.setClusterLabels(labels, clusterKey, ippoolName)
+
+	instName := fmt.Sprintf("%s-%s", ownerName, utl.RandomString(4))
 	inst := &crdv1.FlexLBInstance{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        instName,
-			Namespace:   serviceNamespace,
-			Annotations: map[string]string{ServiceKey: serviceName},
+			Namespace:   namespace,
+			Annotations: annotations,
+			Labels:      labels,
 		},
 		Spec: crdv1.FlexLBInstanceSpec{
 			Cluster: clusterName,
@@ -273,14 +506,19 @@ func createIntance(k8s client.Client, ctx context.Context, flexlbNamespace strin
 			},
 		},
 	}
-	return inst, k8s.Create(ctx, inst)
+	if err := k8s.Create(ctx, inst); err != nil {
+		h.releaseIp(clusterName, ippoolName, *frontendIpaddress)
+		return nil, err
+	}
+	h.reserveQuota(namespace, clusterName, ippool, instName)
+	return inst, nil
 }
 
-// get ippool object by cluster name and ippool name
-func getIPPool(k8s client.Client, ctx context.Context, flexlbNamespace string, clusterName string, ippoolName string) (*crdv1.FlexLBIPPool, error) {
-	cluster := &crdv1.FlexLBCluster{}
-	if err := k8s.Get(ctx, types.NamespacedName{Name: clusterName, Namespace: flexlbNamespace}, cluster); err != nil {
-		return nil, fmt.Errorf("cluster '%s' does not exist", clusterName)
+// get ippool object by cluster reference and ippool name
+func (h *Handler) getIPPool(k8s client.Client, ctx context.Context, clusterName string, ippoolName string) (*crdv1.FlexLBIPPool, error) {
+	cluster, err := h.getCluster(k8s, ctx, clusterName)
+	if err != nil {
+		return nil, err
 	}
 	var ippool *crdv1.FlexLBIPPool
 	for i := 0; i < len(cluster.Spec.IPPools); i++ {
@@ -296,46 +534,63 @@ func getIPPool(k8s client.Client, ctx context.Context, flexlbNamespace string, c
 }
 
 // update flexlbinstance for service
-func updateIntance(k8s client.Client, ctx context.Context, inst *crdv1.FlexLBInstance, flexlbNamespace string,
-	clusterName string, ippoolName string, endpoints []*models.Endpoint) (*crdv1.FlexLBInstance, error) {
+func (h *Handler) updateIntance(k8s client.Client, ctx context.Context, inst *crdv1.FlexLBInstance,
+	clusterName string, ippoolName string, endpoints []*models.Endpoint, nodes *backendNodes) (*crdv1.FlexLBInstance, error) {
+	var moved *crdv1.FlexLBIPPool
 	if inst.Spec.Cluster != clusterName || inst.Spec.IPPool != ippoolName {
 		// cluster or ip pool changed, need to allocate new ip
-		ippool, err := getIPPool(k8s, ctx, flexlbNamespace, clusterName, ippoolName)
+		clusterKey, err := h.clusterKey(clusterName)
 		if err != nil {
-			return nil, fmt.Errorf("ippool '%s' in cluster '%s' does not exist", ippoolName, clusterName)
+			return nil, err
 		}
-
-		allocated, err := getAllocatedIp(k8s, ctx, clusterName, ippoolName)
+		ippool, err := h.getIPPool(k8s, ctx, clusterName, ippoolName)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("ippool '%s' in cluster '%s' does not exist", ippoolName, clusterName)
 		}
 
-		frontendIpaddress, err := utl.AllocIPFromRange(ippool.Start, ippool.End, allocated)
+		frontendIpaddress, err := h.allocateIp(k8s, ctx, clusterName, ippoolName, ippool)
 		if err != nil {
 			return nil, err
 		}
+		if inst.Labels == nil {
+			inst.Labels = map[string]string{}
+		}
+		h.setClusterLabels(inst.Labels, clusterKey, ippoolName)
 		inst.Spec.Cluster = clusterName
 		inst.Spec.IPPool = ippoolName
 		inst.Spec.Config.FrontendInterface = ippool.Interface
 		inst.Spec.Config.FrontendNetPrefix = ippool.NetPrefix
 		inst.Spec.Config.FrontendIpaddress = *frontendIpaddress
+		moved = ippool
 	}
 
+	if inst.Annotations == nil {
+		inst.Annotations = map[string]string{}
+	}
+	inst.Annotations[BackendNodesKey] = nodes.annotation()
 	inst.Spec.Config.Endpoints = endpoints
-	return inst, k8s.Update(ctx, inst)
+	if err := k8s.Update(ctx, inst); err != nil {
+		return inst, err
+	}
+	if moved != nil {
+		h.reserveQuota(inst.Namespace, clusterName, moved, inst.Name)
+	}
+	return inst, nil
 }
 
+// how long an allocated ip or a created instance is kept reserved, waiting for the informer cache
+const reservedTimeout = time.Minute
+
 // list instance, find allocated ip
-func getAllocatedIp(k8s client.Client, ctx context.Context, clusterName string, ippoolName string) ([]string, error) {
+func (h *Handler) getAllocatedIp(k8s client.Client, ctx context.Context, clusterName string, ippoolName string) ([]string, error) {
 	allocated := []string{}
 
-	insts := crdv1.FlexLBInstanceList{}
-	instLabels := map[string]string{
-		ClusterKey: clusterName,
-		IPPoolKey:  ippoolName,
+	clusterKey, err := h.clusterKey(clusterName)
+	if err != nil {
+		return allocated, err
 	}
-
-	if err := k8s.List(ctx, &insts, client.MatchingLabels(instLabels)); err != nil {
+	insts := crdv1.FlexLBInstanceList{}
+	if err := k8s.List(ctx, &insts, h.clusterSelector(clusterKey, ippoolName)); err != nil {
 		return allocated, fmt.Errorf("list exist instance failed: %s", err.Error())
 	}
 
@@ -344,3 +599,106 @@ func getAllocatedIp(k8s client.Client, ctx context.Context, clusterName string,
 	}
 	return allocated, nil
 }
+
+// allocate a free ip from ippool, serialized per ippool
+func (h *Handler) allocateIp(k8s client.Client, ctx context.Context, clusterName string, ippoolName string, ippool *crdv1.FlexLBIPPool) (*string, error) {
+	key := ippoolLockKey(clusterName, ippoolName)
+	h.lock(key, "allocate ip", "cluster", clusterName, "ippool", ippoolName)
+	defer h.unlock(key, "allocate ip end", "cluster", clusterName, "ippool", ippoolName)
+
+	allocated, err := h.getAllocatedIp(k8s, ctx, clusterName, ippoolName)
+	if err != nil {
+		return nil, err
+	}
+
+	frontendIpaddress, err := utl.AllocIPFromRange(ippool.Start, ippool.End, append(allocated, h.reservations(key, allocated)...))
+	if err != nil {
+		return nil, err
+	}
+	h.reserve(key, *frontendIpaddress)
+	return frontendIpaddress, nil
+}
+
+// release an ip reserved by allocateIp, when the instance was not created
+func (h *Handler) releaseIp(clusterName string, ippoolName string, ip string) {
+	h.release(ippoolLockKey(clusterName, ippoolName), ip)
+}
+
+// reserve value of key (an ip or an instance name), until visible in the informer cache
+func (h *Handler) reserve(key string, value string) {
+	h.reservedLock.Lock()
+	defer h.reservedLock.Unlock()
+	if _, exist := h.reserved[key]; !exist {
+		h.reserved[key] = map[string]time.Time{}
+	}
+	h.reserved[key][value] = time.Now()
+}
+
+func (h *Handler) release(key string, value string) {
+	h.reservedLock.Lock()
+	defer h.reservedLock.Unlock()
+	delete(h.reserved[key], value)
+}
+
+// reserved values of key not yet visible in the informer cache, expired or visible ones are dropped
+func (h *Handler) reservations(key string, visible []string) []string {
+	h.reservedLock.Lock()
+	defer h.reservedLock.Unlock()
+
+	reserved := []string{}
+	for value, at := range h.reserved[key] {
+		if utl.ListContains(visible, value) || time.Since(at) > reservedTimeout {
+			delete(h.reserved[key], value)
+			continue
+		}
+		reserved = append(reserved, value)
+	}
+	sort.Strings(reserved)
+	return reserved
+}
+
+// tcp ports of service selected by comma separated ports annotation, all tcp ports if not set
+func selectTCPPorts(svc *v1.Service, key string) ([]int32, error) {
+	listed := map[int32]bool{}
+	for _, value := range strings.Split(svc.Annotations[key], ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("service annotation '%s' is invalid: port '%s' is not a number", key, value)
+		}
+		listed[int32(port)] = false
+	}
+
+	selected := len(listed) > 0
+	ports := []int32{}
+	for _, port := range svc.Spec.Ports {
+		if _, exist := listed[port.Port]; selected && !exist {
+			continue
+		}
+		if port.Protocol != v1.ProtocolTCP {
+			if selected {
+				return nil, fmt.Errorf("service annotation '%s' is invalid: port %d is not tcp", key, port.Port)
+			}
+			continue
+		}
+		listed[port.Port] = true
+		ports = append(ports, port.Port)
+	}
+	for port, found := range listed {
+		if !found {
+			return nil, fmt.Errorf("service annotation '%s' is invalid: port %d not found", key, port)
+		}
+	}
+	return ports, nil
+}
+
+// append option to the frontend options of endpoint
+func addFrontendOption(ep *models.Endpoint, option string) {
+	options := option
+	if ep.FrontendOptions != nil && *ep.FrontendOptions != "" {
+		options = *ep.FrontendOptions + " " + option
+	}
+	ep.FrontendOptions = &options
+}
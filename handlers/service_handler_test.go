@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestServiceChangedWithoutNodeIpEndpoint(t *testing.T) {
+	h, recorder := newTestHandler()
+	h.SetDefaults(DefaultClusterName, DefaultIPPoolName)
+	k8s := newFakeClient(testCluster(crdv1.FlexLBIPPool{}))
+
+	_, err := h.ServiceChanged(k8s, context.Background(), testService(map[string]string{}, servicePort(v1.ProtocolTCP, 80)))
+	if ErrorReason(err) != ErrorNoTrafficNodeIp || ErrorClass(err) != crdv1.ErrorClassTransient {
+		t.Fatalf("ServiceChanged() error = %v, want %s retried as transient", err, ErrorNoTrafficNodeIp)
+	}
+	if events := recordedEvents(recorder); len(events) != 1 || !strings.Contains(events[0], ErrorNoTrafficNodeIp) {
+		t.Errorf("events = %v, want one %s event", events, ErrorNoTrafficNodeIp)
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// services sharing one instance: services of the same namespace, cluster and ippool with the same sharing key
+// annotation are merged onto one instance, the instance service annotation lists all of them
+
+// get sharing key of service, empty if not shared
+func getSharingKey(svc *v1.Service) (string, error) {
+	key := strings.TrimSpace(svc.Annotations[SharingKey])
+	if errs := validation.IsValidLabelValue(key); len(errs) > 0 {
+		return "", fmt.Errorf("service annotation '%s' is invalid: %s", SharingKey, strings.Join(errs, ", "))
+	}
+	return key, nil
+}
+
+// get services of instance
+func GetInstanceServices(inst *crdv1.FlexLBInstance) []string {
+	services := []string{}
+	for _, name := range strings.Split(inst.Annotations[ServiceKey], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			services = append(services, name)
+		}
+	}
+	return services
+}
+
+// set services of instance
+func setInstanceServices(inst *crdv1.FlexLBInstance, services []string) {
+	if inst.Annotations == nil {
+		inst.Annotations = map[string]string{}
+	}
+	sort.Strings(services)
+	inst.Annotations[ServiceKey] = strings.Join(services, ",")
+}
+
+// reservation key of instances created for sharing key
+func sharedInstanceKey(namespace string, sharingKey string, clusterName string, ippoolName string) string {
+	return sharingLockKey(namespace, sharingKey) + "/" + clusterName + "/" + ippoolName
+}
+
+// find the instance shared by sharing key, the oldest one if several were created concurrently
+// an instance created recently but not in the informer cache yet is a conflict, retried until visible
+func (h *Handler) findSharedInstance(k8s client.Client, ctx context.Context, namespace string, sharingKey string,
+	clusterName string, ippoolName string) (*crdv1.FlexLBInstance, error) {
+	clusterKey, err := h.clusterKey(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	selector := ClusterInstanceSelector(clusterKey, ippoolName, h.namespace)
+	req, _ := labels.NewRequirement(SharingKey, selection.Equals, []string{sharingKey})
+	insts := &crdv1.FlexLBInstanceList{}
+	if err := k8s.List(ctx, insts, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector.Add(*req)}); err != nil {
+		return nil, fmt.Errorf("list shared instance failed: %s", err.Error())
+	}
+
+	names := []string{}
+	for i := range insts.Items {
+		names = append(names, insts.Items[i].Name)
+	}
+	reserved := h.reservations(sharedInstanceKey(namespace, sharingKey, clusterName, ippoolName), names)
+	if len(insts.Items) == 0 {
+		if len(reserved) > 0 {
+			return nil, classError(crdv1.ErrorClassConflict, fmt.Errorf("instance '%s' of sharing key '%s' is not in the cache yet",
+				reserved[0], sharingKey))
+		}
+		return nil, nil
+	}
+
+	sort.Slice(insts.Items, func(i, j int) bool {
+		ti, tj := insts.Items[i].CreationTimestamp, insts.Items[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return insts.Items[i].Name < insts.Items[j].Name
+	})
+	return &insts.Items[0], nil
+}
+
+// force reconcile of the services of instance
+func (h *Handler) resyncServices(k8s client.Client, ctx context.Context, inst *crdv1.FlexLBInstance) {
+	now := time.Now().String()
+	for _, name := range GetInstanceServices(inst) {
+		svc := &v1.Service{}
+		if err := k8s.Get(ctx, types.NamespacedName{Namespace: inst.Namespace, Name: name}, svc); err != nil {
+			continue
+		}
+		patch := client.MergeFrom(svc.DeepCopy())
+		if svc.Annotations == nil {
+			svc.Annotations = map[string]string{}
+		}
+		svc.Annotations[ResyncKey] = now
+		if err := k8s.Patch(ctx, svc, patch); err != nil {
+			log.Log.Info("resync service failed", "service", name, "namespace", inst.Namespace, "error", err.Error())
+		}
+	}
+}
+
+// frontend port key of endpoint, http endpoints listen on tcp ports
+func portKey(port uint16, mode string) string {
+	if mode == models.EndpointModeHTTP {
+		mode = models.EndpointModeTCP
+	}
+	return fmt.Sprintf("%s/%d", mode, port)
+}
+
+// frontend port keys of service
+func servicePortKeys(svc *v1.Service) []string {
+	keys := []string{}
+	for _, port := range svc.Spec.Ports {
+		if mode := serviceEndpointMode(port); mode != "" {
+			keys = append(keys, portKey(uint16(port.Port), mode))
+		}
+	}
+	return keys
+}
+
+// frontend port keys of the other services sharing the instance, service not exist is ignored
+func sharedPortKeys(k8s client.Client, ctx context.Context, inst *crdv1.FlexLBInstance, svc *v1.Service) (map[string]string, error) {
+	ports := map[string]string{}
+	for _, name := range GetInstanceServices(inst) {
+		if name == svc.Name {
+			continue
+		}
+		other := &v1.Service{}
+		if err := k8s.Get(ctx, types.NamespacedName{Namespace: inst.Namespace, Name: name}, other); err != nil {
+			continue
+		}
+		for _, key := range servicePortKeys(other) {
+			if owner, exist := ports[key]; exist {
+				return nil, fmt.Errorf("port '%s' used by both service '%s' and '%s'", key, owner, name)
+			}
+			ports[key] = name
+		}
+	}
+	return ports, nil
+}
+
+// check the service ports do not conflict with the other services sharing the instance
+func checkPortConflict(svc *v1.Service, sharedPorts map[string]string) error {
+	for _, key := range servicePortKeys(svc) {
+		if owner, exist := sharedPorts[key]; exist {
+			return fmt.Errorf("port '%s' already used by service '%s' sharing ip", key, owner)
+		}
+	}
+	return nil
+}
+
+// merge endpoints of the service with the endpoints of the other services sharing the instance
+// backend nodes of the other services are kept, also their draining nodes
+func mergeSharedEndpoints(inst *crdv1.FlexLBInstance, endpoints []*models.Endpoint, nodes *backendNodes,
+	sharedPorts map[string]string) []*models.Endpoint {
+	lastNodes := getInstanceBackendNodes(inst)
+	lastDraining := map[string]string{}
+	for _, d := range inst.Status.Draining {
+		lastDraining[d.Node] = d.Mode
+	}
+
+	merged := []*models.Endpoint{}
+	for _, ep := range inst.Spec.Config.Endpoints {
+		if _, shared := sharedPorts[portKey(ep.FrontendPort, ep.Mode)]; !shared {
+			continue
+		}
+		// copy, backends of draining nodes may be removed from merged endpoints
+		copied := *ep
+		merged = append(merged, &copied)
+		for _, server := range ep.BackendServers {
+			nodeName := nodeOfTrafficIp(lastNodes, server.Ipaddress)
+			if nodeName == "" {
+				continue
+			}
+			if _, exist := nodes.trafficIps[nodeName]; !exist {
+				nodes.trafficIps[nodeName] = server.Ipaddress
+			}
+			if mode, draining := lastDraining[nodeName]; draining {
+				if _, exist := nodes.draining[nodeName]; !exist {
+					nodes.draining[nodeName] = mode
+				}
+			}
+		}
+	}
+	merged = append(merged, endpoints...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].FrontendPort < merged[j].FrontendPort
+	})
+	return merged
+}
+
+// remove service from the shared instance, delete the instance if no service left
+func (h *Handler) detachService(k8s client.Client, ctx context.Context, svc *v1.Service, inst *crdv1.FlexLBInstance) error {
+	services := []string{}
+	for _, name := range GetInstanceServices(inst) {
+		if name != svc.Name {
+			services = append(services, name)
+		}
+	}
+	if len(services) == 0 {
+		if sharingKey, shared := inst.Labels[SharingKey]; shared {
+			h.release(sharedInstanceKey(inst.Namespace, sharingKey, inst.Spec.Cluster, inst.Spec.IPPool), inst.Name)
+		}
+		return client.IgnoreNotFound(k8s.Delete(ctx, inst))
+	}
+
+	// keep endpoints of the remaining services, the ports of the service may have changed since it was merged
+	sharedPorts, err := sharedPortKeys(k8s, ctx, inst, svc)
+	if err != nil {
+		return err
+	}
+	endpoints := []*models.Endpoint{}
+	for _, ep := range inst.Spec.Config.Endpoints {
+		if _, shared := sharedPorts[portKey(ep.FrontendPort, ep.Mode)]; shared {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	tlsSecrets := mergeSharedTLSSecrets(inst, map[string]string{}, sharedPorts)
+	setInstanceServices(inst, services)
+	setInstanceTLSSecrets(inst, tlsSecrets)
+	inst.Spec.Config.Endpoints = endpoints
+	return k8s.Update(ctx, inst)
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestGetSharingKey(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: " web ", want: "web"},
+		{value: "web-1.a_b", want: "web-1.a_b"},
+		{value: "web/1", wantErr: true},
+		{value: "-web", wantErr: true},
+	}
+	for _, tt := range tests {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{SharingKey: tt.value}}}
+		got, err := getSharingKey(svc)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("sharing key %q: got %q %v, want %q error %t", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckPortConflict(t *testing.T) {
+	http := "HTTP"
+	svc := &v1.Service{Spec: v1.ServiceSpec{Ports: []v1.ServicePort{
+		{Port: 80, Protocol: v1.ProtocolTCP, AppProtocol: &http},
+		{Port: 53, Protocol: v1.ProtocolUDP},
+	}}}
+	tests := []struct {
+		name    string
+		shared  map[string]string
+		wantErr bool
+	}{
+		{name: "nothing shared", shared: map[string]string{}},
+		{name: "other ports", shared: map[string]string{"tcp/443": "other", "udp/80": "other"}},
+		{name: "same tcp port of http endpoint", shared: map[string]string{"tcp/80": "other"}, wantErr: true},
+		{name: "same udp port", shared: map[string]string{"udp/53": "other"}, wantErr: true},
+		{name: "tcp port of udp port", shared: map[string]string{"tcp/53": "other"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPortConflict(svc, tt.shared); (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+	if key := portKey(8080, models.EndpointModeHTTP); key != "tcp/8080" {
+		t.Errorf("unexpected port key of http endpoint: %s", key)
+	}
+}
+
+func TestSharedPortKeys(t *testing.T) {
+	ctx := context.TODO()
+	tcpService := func(name string, ports ...int32) *v1.Service {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		for _, port := range ports {
+			svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Port: port, Protocol: v1.ProtocolTCP})
+		}
+		return svc
+	}
+	inst := &crdv1.FlexLBInstance{ObjectMeta: metav1.ObjectMeta{Name: "inst-1", Namespace: "default",
+		Annotations: map[string]string{ServiceKey: "web,api,gone"}}}
+
+	k8s := newFakeClient(tcpService("web", 80), tcpService("api", 8080, 8443))
+	ports, err := sharedPortKeys(k8s, ctx, inst, tcpService("web", 80))
+	if err != nil || len(ports) != 2 || ports["tcp/8080"] != "api" || ports["tcp/8443"] != "api" {
+		t.Errorf("unexpected shared ports %v: %v", ports, err)
+	}
+
+	k8s = newFakeClient(tcpService("web", 80), tcpService("api", 80))
+	if _, err := sharedPortKeys(k8s, ctx, inst, tcpService("other", 443)); err == nil {
+		t.Errorf("conflict between sharing services not reported")
+	}
+}
+
+// instance of the default cluster and ippool with the sharing key
+func sharedInstance(h *Handler, name string, sharingKey string, created time.Time, services string) *crdv1.FlexLBInstance {
+	inst := &crdv1.FlexLBInstance{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "default",
+		CreationTimestamp: metav1.NewTime(created),
+		Labels:            map[string]string{SharingKey: sharingKey},
+		Annotations:       map[string]string{ServiceKey: services},
+	}}
+	h.setClusterLabels(inst.Labels, types.NamespacedName{Name: DefaultClusterName, Namespace: testNamespace}, DefaultIPPoolName)
+	inst.Spec.Cluster = DefaultClusterName
+	inst.Spec.IPPool = DefaultIPPoolName
+	return inst
+}
+
+func TestFindSharedInstance(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now().Truncate(time.Second)
+
+	t.Run("oldest of duplicates", func(t *testing.T) {
+		h, _ := newTestHandler()
+		k8s := newFakeClient(
+			sharedInstance(h, "inst-b", "web", now.Add(-time.Hour), "a"),
+			sharedInstance(h, "inst-c", "web", now, "b"),
+			sharedInstance(h, "inst-a", "web", now, "c"),
+			sharedInstance(h, "inst-d", "api", now.Add(-2*time.Hour), "d"),
+		)
+		shared, err := h.findSharedInstance(k8s, ctx, "default", "web", DefaultClusterName, DefaultIPPoolName)
+		if err != nil || shared == nil || shared.Name != "inst-b" {
+			t.Errorf("unexpected shared instance %v: %v", shared, err)
+		}
+		shared, err = h.findSharedInstance(k8s, ctx, "default", "none", DefaultClusterName, DefaultIPPoolName)
+		if err != nil || shared != nil {
+			t.Errorf("unexpected shared instance %v: %v", shared, err)
+		}
+	})
+
+	t.Run("created instance not in cache yet", func(t *testing.T) {
+		h, _ := newTestHandler()
+		h.reserve(sharedInstanceKey("default", "web", DefaultClusterName, DefaultIPPoolName), "inst-a")
+		_, err := h.findSharedInstance(newFakeClient(), ctx, "default", "web", DefaultClusterName, DefaultIPPoolName)
+		if ErrorClass(err) != crdv1.ErrorClassConflict {
+			t.Fatalf("expected conflict, got %v", err)
+		}
+
+		// visible now, reservation dropped
+		k8s := newFakeClient(sharedInstance(h, "inst-a", "web", now, "a"))
+		if shared, err := h.findSharedInstance(k8s, ctx, "default", "web", DefaultClusterName, DefaultIPPoolName); err != nil || shared.Name != "inst-a" {
+			t.Fatalf("unexpected shared instance %v: %v", shared, err)
+		}
+		if reserved := h.reservations(sharedInstanceKey("default", "web", DefaultClusterName, DefaultIPPoolName), nil); len(reserved) != 0 {
+			t.Errorf("reservation of visible instance kept: %v", reserved)
+		}
+	})
+}
+
+func TestGetInstanceForService(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Now().Truncate(time.Second)
+	h, _ := newTestHandler()
+	oldest := sharedInstance(h, "inst-a", "web", now.Add(-time.Hour), "svc-a")
+	duplicate := sharedInstance(h, "inst-b", "web", now, "svc-b,svc-c")
+	plain := &crdv1.FlexLBInstance{ObjectMeta: metav1.ObjectMeta{Name: "inst-c", Namespace: "default",
+		Annotations: map[string]string{ServiceKey: "svc-d"}}}
+	k8s := newFakeClient(oldest, duplicate, plain)
+
+	service := func(name string, instName string, sharingKey string) *v1.Service {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{}}}
+		if instName != "" {
+			svc.Annotations[InstanceKey] = instName
+		}
+		return svc
+	}
+	tests := []struct {
+		name       string
+		svc        *v1.Service
+		sharingKey string
+		inst       string
+		leave      string
+	}{
+		{name: "new service joins the shared instance", svc: service("svc-x", "", "web"), sharingKey: "web", inst: "inst-a"},
+		{name: "service of the shared instance stays", svc: service("svc-a", "inst-a", "web"), sharingKey: "web", inst: "inst-a"},
+		{name: "service of a duplicate moves to the oldest", svc: service("svc-b", "inst-b", "web"), sharingKey: "web", inst: "inst-a", leave: "inst-b"},
+		{name: "service stops sharing", svc: service("svc-b", "inst-b", ""), leave: "inst-b"},
+		{name: "service not shared stays", svc: service("svc-d", "inst-c", ""), inst: "inst-c"},
+		{name: "sharing key of new instance", svc: service("svc-d", "inst-c", "api"), inst: "inst-c"},
+		{name: "new service", svc: service("svc-y", "", "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst, leave, err := h.getInstanceForService(k8s, ctx, tt.svc, tt.sharingKey, DefaultClusterName, DefaultIPPoolName)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if name := instanceName(inst); name != tt.inst {
+				t.Errorf("got instance %q, want %q", name, tt.inst)
+			}
+			if name := instanceName(leave); name != tt.leave {
+				t.Errorf("got instance to leave %q, want %q", name, tt.leave)
+			}
+		})
+	}
+}
+
+func instanceName(inst *crdv1.FlexLBInstance) string {
+	if inst == nil {
+		return ""
+	}
+	return inst.Name
+}
+
+func TestResyncServices(t *testing.T) {
+	ctx := context.TODO()
+	h, _ := newTestHandler()
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc-a", Namespace: "default"}}
+	inst := &crdv1.FlexLBInstance{ObjectMeta: metav1.ObjectMeta{Name: "inst-a", Namespace: "default",
+		Annotations: map[string]string{ServiceKey: "svc-a,gone"}}}
+	k8s := newFakeClient(svc)
+
+	h.resyncServices(k8s, ctx, inst)
+	latest := &v1.Service{}
+	if err := k8s.Get(ctx, types.NamespacedName{Name: "svc-a", Namespace: "default"}, latest); err != nil || latest.Annotations[ResyncKey] == "" {
+		t.Errorf("service not resynced: %v %v", latest.Annotations, err)
+	}
+}
+
+func TestDetachService(t *testing.T) {
+	ctx := context.TODO()
+	h, _ := newTestHandler()
+	tcpService := func(name string, port int32) *v1.Service {
+		svc := testService(map[string]string{}, servicePort(v1.ProtocolTCP, port))
+		svc.Name = name
+		return svc
+	}
+	endpoint := func(port uint16) *models.Endpoint {
+		return &models.Endpoint{FrontendPort: port, Mode: models.EndpointModeTCP}
+	}
+	inst := sharedInstance(h, "inst-a", "web", time.Now(), "web,api")
+	// web listened on 8080 when merged, its spec moved to port 80 since
+	inst.Spec.Config.Endpoints = []*models.Endpoint{endpoint(443), endpoint(8080)}
+	web := tcpService("web", 80)
+	k8s := newFakeClient(inst, web, tcpService("api", 443))
+
+	if err := h.detachService(k8s, ctx, web, inst); err != nil {
+		t.Fatalf("detachService() error = %v", err)
+	}
+	latest := &crdv1.FlexLBInstance{}
+	if err := k8s.Get(ctx, types.NamespacedName{Name: "inst-a", Namespace: "default"}, latest); err != nil {
+		t.Fatalf("get instance failed: %s", err.Error())
+	}
+	if services := GetInstanceServices(latest); len(services) != 1 || services[0] != "api" {
+		t.Errorf("services of instance = %v, want [api]", services)
+	}
+	if eps := latest.Spec.Config.Endpoints; len(eps) != 1 || eps[0].FrontendPort != 443 {
+		t.Errorf("endpoints of instance = %v, want only the port of api", eps)
+	}
+
+	// last service leaving deletes the instance
+	if err := h.detachService(k8s, ctx, tcpService("api", 443), latest); err != nil {
+		t.Fatalf("detachService() of the last service error = %v", err)
+	}
+	if err := k8s.Get(ctx, types.NamespacedName{Name: "inst-a", Namespace: "default"}, latest); err == nil {
+		t.Errorf("instance without services not deleted")
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+)
+
+// client ip affinity is rendered as source balance on udp endpoints, and as a stick table keyed by
+// client source on tcp and http endpoints, expiring after the affinity timeout; cookie affinity is only
+// rendered on http endpoints, where it takes the place of client ip affinity
+
+// default client ip affinity timeout, the same as kube-proxy
+const defaultAffinityTimeout int32 = 10800
+
+// size of the stick table of client ip affinity
+const stickTableSize = "100k"
+
+// application protocol of service ports load balanced in http mode
+const httpAppProtocol = "http"
+
+var cookieNamePattern = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+\-.^_|~]{1,64}$`)
+
+// session affinity of service
+type sessionAffinity struct {
+	timeout int32  // client ip affinity timeout in seconds, 0 if no client ip affinity
+	cookie  string // cookie name, empty if no cookie affinity
+	key     string // dynamic cookie key
+}
+
+// endpoint mode of service port, empty if protocol not supported
+func serviceEndpointMode(port v1.ServicePort) string {
+	switch port.Protocol {
+	case v1.ProtocolUDP:
+		return models.EndpointModeUDP
+	case v1.ProtocolTCP:
+		if port.AppProtocol != nil && strings.ToLower(*port.AppProtocol) == httpAppProtocol {
+			return models.EndpointModeHTTP
+		}
+		return models.EndpointModeTCP
+	}
+	return ""
+}
+
+// get session affinity of service
+func getServiceAffinity(svc *v1.Service) (*sessionAffinity, error) {
+	affinity := &sessionAffinity{}
+	if svc.Spec.SessionAffinity == v1.ServiceAffinityClientIP {
+		affinity.timeout = defaultAffinityTimeout
+		if config := svc.Spec.SessionAffinityConfig; config != nil && config.ClientIP != nil && config.ClientIP.TimeoutSeconds != nil {
+			affinity.timeout = *config.ClientIP.TimeoutSeconds
+		}
+		if affinity.timeout <= 0 {
+			return nil, fmt.Errorf("client ip affinity timeout %d is invalid", affinity.timeout)
+		}
+	}
+	if cookie, exist := svc.Annotations[AffinityCookieKey]; exist {
+		affinity.cookie = strings.TrimSpace(cookie)
+		if !cookieNamePattern.MatchString(affinity.cookie) {
+			return nil, fmt.Errorf("service annotation '%s' is invalid: '%s' is not a cookie name", AffinityCookieKey, cookie)
+		}
+		// same key for all instances of service, cookies stay valid across reconciles
+		affinity.key = string(svc.UID)
+	}
+	return affinity, nil
+}
+
+// stick sessions of endpoints to backends, returns non http ports the affinity cookie is not set on
+func applySessionAffinity(endpoints []*models.Endpoint, affinity *sessionAffinity) []string {
+	skipped := []string{}
+	for _, ep := range endpoints {
+		if affinity.cookie != "" {
+			if ep.Mode == models.EndpointModeHTTP {
+				ep.BackendOptions = append(ep.BackendOptions,
+					fmt.Sprintf("cookie %s insert indirect nocache dynamic", affinity.cookie),
+					"dynamic-cookie-key "+affinity.key)
+				continue
+			}
+			skipped = append(skipped, portKey(ep.FrontendPort, ep.Mode))
+		}
+		if affinity.timeout == 0 {
+			continue
+		}
+		if ep.Mode == models.EndpointModeUDP {
+			ep.Balance = "source"
+			continue
+		}
+		setStickTable(ep, affinity.timeout)
+		ep.BackendOptions = append(ep.BackendOptions, "stick on src")
+	}
+	return skipped
+}
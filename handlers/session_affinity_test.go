@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestServiceEndpointMode(t *testing.T) {
+	http, grpc := "HTTP", "grpc"
+	tests := []struct {
+		name string
+		port v1.ServicePort
+		want string
+	}{
+		{name: "tcp", port: v1.ServicePort{Protocol: v1.ProtocolTCP}, want: models.EndpointModeTCP},
+		{name: "http app protocol", port: v1.ServicePort{Protocol: v1.ProtocolTCP, AppProtocol: &http}, want: models.EndpointModeHTTP},
+		{name: "other app protocol", port: v1.ServicePort{Protocol: v1.ProtocolTCP, AppProtocol: &grpc}, want: models.EndpointModeTCP},
+		{name: "udp", port: v1.ServicePort{Protocol: v1.ProtocolUDP}, want: models.EndpointModeUDP},
+		{name: "sctp not supported", port: v1.ServicePort{Protocol: v1.ProtocolSCTP}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serviceEndpointMode(tt.port); got != tt.want {
+				t.Errorf("serviceEndpointMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetServiceAffinity(t *testing.T) {
+	timeout := func(seconds int32) *v1.SessionAffinityConfig {
+		return &v1.SessionAffinityConfig{ClientIP: &v1.ClientIPConfig{TimeoutSeconds: &seconds}}
+	}
+	tests := []struct {
+		name        string
+		affinity    v1.ServiceAffinity
+		config      *v1.SessionAffinityConfig
+		annotations map[string]string
+		want        *sessionAffinity
+		wantErr     bool
+	}{
+		{name: "none", affinity: v1.ServiceAffinityNone, want: &sessionAffinity{}},
+		{name: "client ip default timeout", affinity: v1.ServiceAffinityClientIP, want: &sessionAffinity{timeout: defaultAffinityTimeout}},
+		{name: "client ip timeout", affinity: v1.ServiceAffinityClientIP, config: timeout(60), want: &sessionAffinity{timeout: 60}},
+		{name: "invalid timeout", affinity: v1.ServiceAffinityClientIP, config: timeout(0), wantErr: true},
+		{
+			name:        "cookie",
+			affinity:    v1.ServiceAffinityNone,
+			annotations: map[string]string{AffinityCookieKey: " SERVERID "},
+			want:        &sessionAffinity{cookie: "SERVERID", key: "uid-1"},
+		},
+		{name: "invalid cookie", affinity: v1.ServiceAffinityNone, annotations: map[string]string{AffinityCookieKey: "server id"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := testService(tt.annotations, servicePort(v1.ProtocolTCP, 80))
+			svc.UID = "uid-1"
+			svc.Spec.SessionAffinity = tt.affinity
+			svc.Spec.SessionAffinityConfig = tt.config
+			got, err := getServiceAffinity(svc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getServiceAffinity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getServiceAffinity() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplySessionAffinity(t *testing.T) {
+	stickTable := "stick-table type ipv6 size 100k expire 60s"
+	tests := []struct {
+		name        string
+		affinity    *sessionAffinity
+		wantOptions [][]string
+		wantBalance []string
+		wantSkipped []string
+	}{
+		{
+			name:        "none",
+			affinity:    &sessionAffinity{},
+			wantOptions: [][]string{{}, {}, {}},
+			wantBalance: []string{"roundrobin", "roundrobin", "roundrobin"},
+			wantSkipped: []string{},
+		},
+		{
+			name:        "client ip",
+			affinity:    &sessionAffinity{timeout: 60},
+			wantOptions: [][]string{{stickTable, "stick on src"}, {stickTable, "stick on src"}, {}},
+			wantBalance: []string{"roundrobin", "roundrobin", "source"},
+			wantSkipped: []string{},
+		},
+		{
+			name:     "cookie replaces client ip on http",
+			affinity: &sessionAffinity{timeout: 60, cookie: "SERVERID", key: "uid-1"},
+			wantOptions: [][]string{
+				{stickTable, "stick on src"},
+				{"cookie SERVERID insert indirect nocache dynamic", "dynamic-cookie-key uid-1"},
+				{},
+			},
+			wantBalance: []string{"roundrobin", "roundrobin", "source"},
+			wantSkipped: []string{"tcp/22", "udp/53"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoints := []*models.Endpoint{
+				{FrontendPort: 22, Mode: models.EndpointModeTCP, Balance: "roundrobin", BackendOptions: []string{}},
+				{FrontendPort: 80, Mode: models.EndpointModeHTTP, Balance: "roundrobin", BackendOptions: []string{}},
+				{FrontendPort: 53, Mode: models.EndpointModeUDP, Balance: "roundrobin", BackendOptions: []string{}},
+			}
+			skipped := applySessionAffinity(endpoints, tt.affinity)
+			if !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("applySessionAffinity() skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+			for i, ep := range endpoints {
+				if !reflect.DeepEqual(ep.BackendOptions, tt.wantOptions[i]) {
+					t.Errorf("backend options of port %d = %v, want %v", ep.FrontendPort, ep.BackendOptions, tt.wantOptions[i])
+				}
+				if ep.Balance != tt.wantBalance[i] {
+					t.Errorf("balance of port %d = %q, want %q", ep.FrontendPort, ep.Balance, tt.wantBalance[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Settings are the handler settings which can change while running, reloaded from the config file
+type Settings struct {
+	ProbePodImage     string
+	ProbeTimeout      time.Duration
+	APITimeout        time.Duration
+	DrainGrace        time.Duration
+	CertExpiryWarning time.Duration
+	// default instance refresh interval, 0: interval of the instance controller
+	RefreshInterval time.Duration
+}
+
+// apply reloaded settings, used by the next reconciles
+func (h *Handler) Reconfigure(settings Settings) {
+	h.settingsLock.Lock()
+	defer h.settingsLock.Unlock()
+	h.settings = settings
+}
+
+func (h *Handler) currentSettings() Settings {
+	h.settingsLock.RLock()
+	defer h.settingsLock.RUnlock()
+	return h.settings
+}
+
+// replace the cluster and ippool of objects without cluster or ippool annotation, must be set before start
+func (h *Handler) SetDefaults(cluster string, ippool string) {
+	h.defaultCluster = cluster
+	h.defaultIPPool = ippool
+}
+
+// handle services of the load balancer class only, services without class if empty, must be set before start
+func (h *Handler) SetLoadBalancerClass(class string) {
+	h.loadBalancerClass = class
+}
+
+// whether the load balancer service belongs to the class, services without class belong to the empty class
+func IsLoadBalancerClass(svc *v1.Service, class string) bool {
+	if svc.Spec.LoadBalancerClass == nil {
+		return class == ""
+	}
+	return *svc.Spec.LoadBalancerClass == class
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// client source ranges are rendered as acls of the endpoint, connections from other sources are rejected,
+// the instance status shows the ranges parsed back from the acls
+
+const (
+	allowedSourceAcl = "acl src_allowed src "
+	deniedSourceAcl  = "acl src_denied src "
+)
+
+// get allowed and denied client source ranges of service, normalized cidrs
+func getServiceSourceRanges(svc *v1.Service) ([]string, []string, error) {
+	allowed := svc.Spec.LoadBalancerSourceRanges
+	if len(allowed) == 0 {
+		allowed = splitRanges(svc.Annotations[v1.AnnotationLoadBalancerSourceRangesKey])
+	}
+	allowed, err := parseRanges(allowed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loadBalancerSourceRanges is invalid: %s", err.Error())
+	}
+	denied, err := parseRanges(splitRanges(svc.Annotations[DeniedSourceRangesKey]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("service annotation '%s' is invalid: %s", DeniedSourceRangesKey, err.Error())
+	}
+	return allowed, denied, nil
+}
+
+func splitRanges(value string) []string {
+	ranges := []string{}
+	for _, r := range strings.Split(value, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// parse cidrs, single ips are accepted as host ranges
+func parseRanges(ranges []string) ([]string, error) {
+	parsed := []string{}
+	for _, r := range ranges {
+		r = strings.TrimSpace(r)
+		if ip := net.ParseIP(r); ip != nil {
+			if ip.To4() != nil {
+				r = r + "/32"
+			} else {
+				r = r + "/128"
+			}
+		}
+		_, cidr, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a cidr", r)
+		}
+		parsed = append(parsed, cidr.String())
+	}
+	return parsed, nil
+}
+
+// reject connections from sources not allowed or denied, udp endpoints are returned as not enforced
+func applySourceRanges(endpoints []*models.Endpoint, allowed []string, denied []string) []string {
+	skipped := []string{}
+	if len(allowed) == 0 && len(denied) == 0 {
+		return skipped
+	}
+	for _, ep := range endpoints {
+		if ep.Mode == models.EndpointModeUDP {
+			skipped = append(skipped, portKey(ep.FrontendPort, ep.Mode))
+			continue
+		}
+		options := []string{}
+		if len(allowed) > 0 {
+			options = append(options, allowedSourceAcl+strings.Join(allowed, " "), "tcp-request content reject if !src_allowed")
+		}
+		if len(denied) > 0 {
+			options = append(options, deniedSourceAcl+strings.Join(denied, " "), "tcp-request content reject if src_denied")
+		}
+		ep.BackendOptions = append(options, ep.BackendOptions...)
+	}
+	return skipped
+}
+
+// source ranges accepted by the endpoints of instance
+func instanceSourceRanges(inst *crdv1.FlexLBInstance) []crdv1.EndpointSourceRanges {
+	ranges := []crdv1.EndpointSourceRanges{}
+	for _, ep := range inst.Spec.Config.Endpoints {
+		r := crdv1.EndpointSourceRanges{Port: ep.FrontendPort, Mode: ep.Mode}
+		for _, option := range ep.BackendOptions {
+			if strings.HasPrefix(option, allowedSourceAcl) {
+				r.Allowed = strings.Fields(strings.TrimPrefix(option, allowedSourceAcl))
+			} else if strings.HasPrefix(option, deniedSourceAcl) {
+				r.Denied = strings.Fields(strings.TrimPrefix(option, deniedSourceAcl))
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
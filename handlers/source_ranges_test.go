@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		ranges  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "cidrs normalized", ranges: []string{"10.0.0.1/24", " 192.168.0.0/16 "}, want: []string{"10.0.0.0/24", "192.168.0.0/16"}},
+		{name: "single ipv4", ranges: []string{"10.0.0.1"}, want: []string{"10.0.0.1/32"}},
+		{name: "single ipv6", ranges: []string{"fd00::1"}, want: []string{"fd00::1/128"}},
+		{name: "none", ranges: []string{}, want: []string{}},
+		{name: "invalid", ranges: []string{"10.0.0.0/33"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRanges(tt.ranges)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetServiceSourceRanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        []string
+		annotations map[string]string
+		wantAllowed []string
+		wantDenied  []string
+		wantErr     bool
+	}{
+		{name: "none", wantAllowed: []string{}, wantDenied: []string{}},
+		{
+			name:        "spec before annotation",
+			spec:        []string{"10.0.0.0/8"},
+			annotations: map[string]string{v1.AnnotationLoadBalancerSourceRangesKey: "192.168.0.0/16"},
+			wantAllowed: []string{"10.0.0.0/8"},
+			wantDenied:  []string{},
+		},
+		{
+			name:        "allowed annotation",
+			annotations: map[string]string{v1.AnnotationLoadBalancerSourceRangesKey: "10.0.0.0/8, ,192.168.0.0/16"},
+			wantAllowed: []string{"10.0.0.0/8", "192.168.0.0/16"},
+			wantDenied:  []string{},
+		},
+		{
+			name:        "denied annotation",
+			annotations: map[string]string{DeniedSourceRangesKey: "10.1.2.3"},
+			wantAllowed: []string{},
+			wantDenied:  []string{"10.1.2.3/32"},
+		},
+		{name: "invalid allowed", spec: []string{"any"}, wantErr: true},
+		{name: "invalid denied", annotations: map[string]string{DeniedSourceRangesKey: "10.0.0.0/8;10.1.0.0/16"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := testService(tt.annotations, servicePort(v1.ProtocolTCP, 80))
+			svc.Spec.LoadBalancerSourceRanges = tt.spec
+			allowed, denied, err := getServiceSourceRanges(svc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getServiceSourceRanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(allowed, tt.wantAllowed) || !reflect.DeepEqual(denied, tt.wantDenied) {
+				t.Errorf("getServiceSourceRanges() = %v, %v, want %v, %v", allowed, denied, tt.wantAllowed, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestApplySourceRanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     []string
+		denied      []string
+		wantOptions []string
+		wantSkipped []string
+	}{
+		{name: "no ranges", wantOptions: []string{"option tcplog"}, wantSkipped: []string{}},
+		{
+			name:    "allowed and denied",
+			allowed: []string{"10.0.0.0/8", "192.168.0.0/16"},
+			denied:  []string{"10.1.0.0/16"},
+			wantOptions: []string{
+				"acl src_allowed src 10.0.0.0/8 192.168.0.0/16",
+				"tcp-request content reject if !src_allowed",
+				"acl src_denied src 10.1.0.0/16",
+				"tcp-request content reject if src_denied",
+				"option tcplog",
+			},
+			wantSkipped: []string{"udp/53"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoints := []*models.Endpoint{
+				{FrontendPort: 80, Mode: models.EndpointModeTCP, BackendOptions: []string{"option tcplog"}},
+				{FrontendPort: 53, Mode: models.EndpointModeUDP, BackendOptions: []string{}},
+			}
+			skipped := applySourceRanges(endpoints, tt.allowed, tt.denied)
+			if !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("applySourceRanges() skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+			if !reflect.DeepEqual(endpoints[0].BackendOptions, tt.wantOptions) {
+				t.Errorf("backend options = %v, want %v", endpoints[0].BackendOptions, tt.wantOptions)
+			}
+			if len(endpoints[1].BackendOptions) != 0 {
+				t.Errorf("backend options of udp endpoint = %v, want none", endpoints[1].BackendOptions)
+			}
+		})
+	}
+}
+
+func TestInstanceSourceRanges(t *testing.T) {
+	endpoints := []*models.Endpoint{
+		{FrontendPort: 80, Mode: models.EndpointModeTCP, BackendOptions: []string{}},
+		{FrontendPort: 53, Mode: models.EndpointModeUDP, BackendOptions: []string{}},
+	}
+	applySourceRanges(endpoints, []string{"10.0.0.0/8", "192.168.0.0/16"}, []string{"10.1.0.0/16"})
+	inst := &crdv1.FlexLBInstance{Spec: crdv1.FlexLBInstanceSpec{Config: models.InstanceConfig{Endpoints: endpoints}}}
+
+	want := []crdv1.EndpointSourceRanges{
+		{Port: 80, Mode: models.EndpointModeTCP, Allowed: []string{"10.0.0.0/8", "192.168.0.0/16"}, Denied: []string{"10.1.0.0/16"}},
+		{Port: 53, Mode: models.EndpointModeUDP},
+	}
+	if got := instanceSourceRanges(inst); !reflect.DeepEqual(got, want) {
+		t.Errorf("instanceSourceRanges() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	utl "github.com/flexlet/utils"
+)
+
+// frontend tls termination: the instance records the tls secret of each frontend port in annotation,
+// the certificate and key are read from the secret when the config is pushed to flexlb, never kept in the instance
+
+// frontend options of tls terminating endpoint
+const tlsFrontendOptions = "ssl"
+
+// get tls secrets of service ports, port -> <namespace>/<secret>
+func getServiceTLSSecrets(svc *v1.Service) (map[string]string, error) {
+	secrets := map[string]string{}
+	secretName := strings.TrimSpace(svc.Annotations[TLSSecretKey])
+	if secretName == "" {
+		return secrets, nil
+	}
+	if errs := validation.IsDNS1123Subdomain(secretName); len(errs) > 0 {
+		return nil, fmt.Errorf("service annotation '%s' is invalid: %s", TLSSecretKey, strings.Join(errs, ", "))
+	}
+
+	ports, err := selectTCPPorts(svc, TLSPortsKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, port := range ports {
+		secrets[strconv.Itoa(int(port))] = svc.Namespace + "/" + secretName
+	}
+	return secrets, nil
+}
+
+// terminate tls on the frontend of endpoints with tls secret
+func applyTLSSecrets(endpoints []*models.Endpoint, secrets map[string]string) {
+	for _, ep := range endpoints {
+		if _, exist := secrets[strconv.Itoa(int(ep.FrontendPort))]; exist && ep.Mode != models.EndpointModeUDP {
+			addFrontendOption(ep, tlsFrontendOptions)
+		}
+	}
+}
+
+// tls secrets of instance recorded in annotation
+func getInstanceTLSSecrets(inst *crdv1.FlexLBInstance) map[string]string {
+	secrets := map[string]string{}
+	if data, exist := inst.Annotations[TLSSecretsKey]; exist {
+		json.Unmarshal([]byte(data), &secrets)
+	}
+	return secrets
+}
+
+// tls secrets annotation of instance, empty if no tls port
+func tlsSecretsAnnotation(secrets map[string]string) string {
+	if len(secrets) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(secrets)
+	return string(data)
+}
+
+// set tls secrets annotation of instance, removed if no tls port
+func setInstanceTLSSecrets(inst *crdv1.FlexLBInstance, secrets map[string]string) {
+	if inst.Annotations == nil {
+		inst.Annotations = map[string]string{}
+	}
+	if annotation := tlsSecretsAnnotation(secrets); annotation != "" {
+		inst.Annotations[TLSSecretsKey] = annotation
+	} else {
+		delete(inst.Annotations, TLSSecretsKey)
+	}
+}
+
+// keep tls secrets of the ports used by the other services sharing the instance
+func mergeSharedTLSSecrets(inst *crdv1.FlexLBInstance, secrets map[string]string, sharedPorts map[string]string) map[string]string {
+	for port, ref := range getInstanceTLSSecrets(inst) {
+		if _, shared := sharedPorts[models.EndpointModeTCP+"/"+port]; shared {
+			secrets[port] = ref
+		}
+	}
+	return secrets
+}
+
+// tls secrets referenced by instance, <namespace>/<secret>
+func InstanceTLSSecrets(inst *crdv1.FlexLBInstance) []string {
+	refs := []string{}
+	for _, ref := range getInstanceTLSSecrets(inst) {
+		if !utl.ListContains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// read tls secrets with reader instead of the cached client, must be set before start
+func (h *Handler) SetSecretReader(reader client.Reader) {
+	h.secretReader = reader
+}
+
+// resolve instance config pushed to flexlb: certificate and key of tls ports are read from their secrets,
+// returns the certificates status as well, and reports certificates expiring within the warning window
+func (h *Handler) resolveTLSConfig(k8s client.Client, ctx context.Context, inst *crdv1.FlexLBInstance) (*models.InstanceConfig, []crdv1.CertificateStatus, error) {
+	secrets := getInstanceTLSSecrets(inst)
+	if len(secrets) == 0 {
+		return &inst.Spec.Config, nil, nil
+	}
+
+	// copy endpoints, instance spec is kept without certificate material
+	config := inst.Spec.Config
+	config.Endpoints = make([]*models.Endpoint, len(inst.Spec.Config.Endpoints))
+	reader := client.Reader(k8s)
+	if h.secretReader != nil {
+		reader = h.secretReader
+	}
+	certs := []crdv1.CertificateStatus{}
+	for i, ep := range inst.Spec.Config.Endpoints {
+		copied := *ep
+		config.Endpoints[i] = &copied
+		ref, exist := secrets[strconv.Itoa(int(ep.FrontendPort))]
+		if !exist || ep.Mode == models.EndpointModeUDP {
+			continue
+		}
+		cert, key, notAfter, err := loadTLSSecret(reader, ctx, ref)
+		if err != nil {
+			return nil, nil, fmt.Errorf("port %d: %w", ep.FrontendPort, err)
+		}
+		copied.FrontendSslOptions = &models.EndpointFrontendSslOptions{
+			ServerCert: cert,
+			ServerKey:  key,
+		}
+		certs = append(certs, crdv1.CertificateStatus{Port: ep.FrontendPort, Secret: ref, NotAfter: metav1.NewTime(notAfter)})
+
+		if left := time.Until(notAfter); left <= 0 {
+			h.recorder.Eventf(inst, v1.EventTypeWarning, CertificateExpiring, "certificate of secret '%s' on port %d expired at %s",
+				ref, ep.FrontendPort, notAfter.Format(time.RFC3339))
+		} else if left < h.currentSettings().CertExpiryWarning {
+			h.recorder.Eventf(inst, v1.EventTypeWarning, CertificateExpiring, "certificate of secret '%s' on port %d expires at %s",
+				ref, ep.FrontendPort, notAfter.Format(time.RFC3339))
+		}
+	}
+	return &config, certs, nil
+}
+
+// load certificate and key of kubernetes.io/tls secret, with the certificate expiry
+// kubernetes api errors are kept, a missing or forbidden secret is classified by its status
+func loadTLSSecret(reader client.Reader, ctx context.Context, ref string) (string, string, time.Time, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 {
+		return "", "", time.Time{}, classError(crdv1.ErrorClassInvalidConfig, fmt.Errorf("invalid secret reference '%s'", ref))
+	}
+	secret := &v1.Secret{}
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: parts[0], Name: parts[1]}, secret); err != nil {
+		return "", "", time.Time{}, fmt.Errorf("get secret '%s' failed: %w", ref, err)
+	}
+	if secret.Type != v1.SecretTypeTLS {
+		return "", "", time.Time{}, classError(crdv1.ErrorClassInvalidConfig, fmt.Errorf("secret '%s' is not of type '%s'", ref, v1.SecretTypeTLS))
+	}
+	cert, key := secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey]
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return "", "", time.Time{}, classError(crdv1.ErrorClassInvalidConfig, fmt.Errorf("secret '%s' has invalid key pair: %s", ref, err.Error()))
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return "", "", time.Time{}, classError(crdv1.ErrorClassInvalidConfig, fmt.Errorf("secret '%s' has invalid certificate: %s", ref, err.Error()))
+	}
+	return string(cert), string(key), leaf.NotAfter, nil
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func TestSelectTCPPorts(t *testing.T) {
+	ports := []v1.ServicePort{servicePort(v1.ProtocolTCP, 80), servicePort(v1.ProtocolTCP, 443), servicePort(v1.ProtocolUDP, 53)}
+	tests := []struct {
+		name       string
+		annotation string
+		want       []int32
+		wantErr    string
+	}{
+		{name: "all tcp ports if not set", annotation: "", want: []int32{80, 443}},
+		{name: "listed ports", annotation: " 443 ", want: []int32{443}},
+		{name: "not a number", annotation: "https", wantErr: "port 'https' is not a number"},
+		{name: "udp port", annotation: "53", wantErr: "port 53 is not tcp"},
+		{name: "port not found", annotation: "443,8443", wantErr: "port 8443 not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := testService(map[string]string{TLSPortsKey: tt.annotation}, ports...)
+			got, err := selectTCPPorts(svc, TLSPortsKey)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("selectTCPPorts() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectTCPPorts() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectTCPPorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetServiceTLSSecrets(t *testing.T) {
+	ports := []v1.ServicePort{servicePort(v1.ProtocolTCP, 80), servicePort(v1.ProtocolTCP, 443)}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        map[string]string
+		wantErr     bool
+	}{
+		{name: "no secret", annotations: map[string]string{TLSPortsKey: "443"}, want: map[string]string{}},
+		{name: "all tcp ports", annotations: map[string]string{TLSSecretKey: "web-tls"}, want: map[string]string{"80": "default/web-tls", "443": "default/web-tls"}},
+		{name: "listed ports", annotations: map[string]string{TLSSecretKey: "web-tls", TLSPortsKey: "443"}, want: map[string]string{"443": "default/web-tls"}},
+		{name: "invalid secret name", annotations: map[string]string{TLSSecretKey: "Web_TLS"}, wantErr: true},
+		{name: "invalid ports", annotations: map[string]string{TLSSecretKey: "web-tls", TLSPortsKey: "8443"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getServiceTLSSecrets(testService(tt.annotations, ports...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getServiceTLSSecrets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getServiceTLSSecrets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTLSSecrets(t *testing.T) {
+	existing := "accept-proxy"
+	endpoints := []*models.Endpoint{
+		{FrontendPort: 80, Mode: models.EndpointModeTCP},
+		{FrontendPort: 443, Mode: models.EndpointModeTCP, FrontendOptions: &existing},
+		{FrontendPort: 443, Mode: models.EndpointModeUDP},
+	}
+	applyTLSSecrets(endpoints, map[string]string{"443": "default/web-tls"})
+
+	if endpoints[0].FrontendOptions != nil {
+		t.Errorf("frontend options of port 80 = %q, want none", *endpoints[0].FrontendOptions)
+	}
+	if got := *endpoints[1].FrontendOptions; got != "accept-proxy ssl" {
+		t.Errorf("frontend options of tcp port 443 = %q, want %q", got, "accept-proxy ssl")
+	}
+	if endpoints[2].FrontendOptions != nil {
+		t.Errorf("frontend options of udp port 443 = %q, want none", *endpoints[2].FrontendOptions)
+	}
+}
+
+func TestInstanceTLSSecrets(t *testing.T) {
+	inst := &crdv1.FlexLBInstance{}
+	secrets := map[string]string{"443": "default/web-tls"}
+
+	setInstanceTLSSecrets(inst, secrets)
+	if got := getInstanceTLSSecrets(inst); !reflect.DeepEqual(got, secrets) {
+		t.Errorf("getInstanceTLSSecrets() = %v, want %v", got, secrets)
+	}
+	setInstanceTLSSecrets(inst, map[string]string{"443": "default/web-tls", "8443": "default/web-tls", "9443": "default/api-tls"})
+	if got, want := InstanceTLSSecrets(inst), []string{"default/api-tls", "default/web-tls"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("InstanceTLSSecrets() = %v, want %v", got, want)
+	}
+
+	setInstanceTLSSecrets(inst, map[string]string{})
+	if _, exist := inst.Annotations[TLSSecretsKey]; exist {
+		t.Errorf("annotation '%s' kept without tls port", TLSSecretsKey)
+	}
+}
+
+func TestMergeSharedTLSSecrets(t *testing.T) {
+	inst := &crdv1.FlexLBInstance{}
+	setInstanceTLSSecrets(inst, map[string]string{"443": "default/shared-tls", "8443": "default/left-tls"})
+
+	got := mergeSharedTLSSecrets(inst, map[string]string{"80": "default/web-tls"}, map[string]string{models.EndpointModeTCP + "/443": "default/other"})
+	want := map[string]string{"80": "default/web-tls", "443": "default/shared-tls"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeSharedTLSSecrets() = %v, want %v", got, want)
+	}
+}
+
+// reader denying the secrets of namespace
+type forbiddenReader struct {
+	client.Client
+	namespace string
+	gets      int
+}
+
+func (r *forbiddenReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	r.gets++
+	if key.Namespace == r.namespace {
+		return apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, key.Name, fmt.Errorf("no rbac binding"))
+	}
+	return r.Client.Get(ctx, key, obj)
+}
+
+// kubernetes.io/tls secret of namespace default with a certificate expiring at notAfter
+func tlsSecret(t *testing.T, name string, notAfter time.Time) *v1.Secret {
+	cert, key, err := selfSignedCert("www.example.com", notAfter)
+	if err != nil {
+		t.Fatalf("generate certificate failed: %s", err)
+	}
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       v1.SecretTypeTLS,
+		Data:       map[string][]byte{v1.TLSCertKey: cert, v1.TLSPrivateKeyKey: key},
+	}
+}
+
+func TestLoadTLSSecret(t *testing.T) {
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	valid := tlsSecret(t, "valid", notAfter)
+	opaque := tlsSecret(t, "opaque", notAfter)
+	opaque.Type = v1.SecretTypeOpaque
+	mismatched := tlsSecret(t, "mismatched", notAfter)
+	mismatched.Data[v1.TLSPrivateKeyKey] = tlsSecret(t, "other", notAfter).Data[v1.TLSPrivateKeyKey]
+	k8s := newFakeClient(valid, opaque, mismatched)
+
+	reader := &forbiddenReader{Client: k8s, namespace: "restricted"}
+
+	tests := []struct {
+		name      string
+		ref       string
+		wantErr   string
+		wantClass string
+	}{
+		{name: "valid secret", ref: "default/valid"},
+		{name: "invalid reference", ref: "valid", wantErr: "invalid secret reference", wantClass: crdv1.ErrorClassInvalidConfig},
+		{name: "secret not found", ref: "default/missing", wantErr: "not found", wantClass: crdv1.ErrorClassNotFound},
+		{name: "secret forbidden", ref: "restricted/valid", wantErr: "forbidden", wantClass: crdv1.ErrorClassAuth},
+		{name: "not a tls secret", ref: "default/opaque", wantErr: "is not of type", wantClass: crdv1.ErrorClassInvalidConfig},
+		{name: "key of another certificate", ref: "default/mismatched", wantErr: "invalid key pair", wantClass: crdv1.ErrorClassInvalidConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, key, expiry, err := loadTLSSecret(reader, context.Background(), tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadTLSSecret() error = %v, want %q", err, tt.wantErr)
+				}
+				if class := ErrorClass(err); class != tt.wantClass {
+					t.Errorf("loadTLSSecret() error class = %q, want %q", class, tt.wantClass)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadTLSSecret() error = %v", err)
+			}
+			if cert != string(valid.Data[v1.TLSCertKey]) || key != string(valid.Data[v1.TLSPrivateKeyKey]) {
+				t.Errorf("loadTLSSecret() did not return the certificate and key of the secret")
+			}
+			if !expiry.Equal(notAfter) {
+				t.Errorf("loadTLSSecret() expiry = %s, want %s", expiry, notAfter)
+			}
+		})
+	}
+}
+
+func TestResolveTLSConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		notAfter  time.Duration
+		wantEvent string
+	}{
+		{name: "valid certificate", notAfter: 90 * 24 * time.Hour},
+		{name: "certificate expiring", notAfter: 24 * time.Hour, wantEvent: "expires at"},
+		{name: "certificate expired", notAfter: -time.Hour, wantEvent: "expired at"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, recorder := newTestHandler()
+			k8s := newFakeClient(tlsSecret(t, "web-tls", time.Now().Add(tt.notAfter)))
+			inst := &crdv1.FlexLBInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: crdv1.FlexLBInstanceSpec{Config: models.InstanceConfig{Endpoints: []*models.Endpoint{
+					{FrontendPort: 80, Mode: models.EndpointModeTCP},
+					{FrontendPort: 443, Mode: models.EndpointModeTCP},
+				}}},
+			}
+			setInstanceTLSSecrets(inst, map[string]string{"443": "default/web-tls"})
+
+			config, certs, err := h.resolveTLSConfig(k8s, context.Background(), inst)
+			if err != nil {
+				t.Fatalf("resolveTLSConfig() error = %v", err)
+			}
+			if config.Endpoints[0].FrontendSslOptions != nil || config.Endpoints[1].FrontendSslOptions == nil {
+				t.Fatalf("resolveTLSConfig() did not resolve the certificate of port 443 only")
+			}
+			if inst.Spec.Config.Endpoints[1].FrontendSslOptions != nil {
+				t.Errorf("resolveTLSConfig() kept the certificate in the instance spec")
+			}
+			if len(certs) != 1 || certs[0].Port != 443 || certs[0].Secret != "default/web-tls" {
+				t.Errorf("resolveTLSConfig() certificates = %v, want port 443 of default/web-tls", certs)
+			}
+
+			events := recordedEvents(recorder)
+			if tt.wantEvent == "" {
+				if len(events) != 0 {
+					t.Errorf("events = %v, want none", events)
+				}
+			} else if len(events) != 1 || !strings.Contains(events[0], CertificateExpiring) || !strings.Contains(events[0], tt.wantEvent) {
+				t.Errorf("events = %v, want %s event containing %q", events, CertificateExpiring, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestResolveTLSConfigReader(t *testing.T) {
+	h, _ := newTestHandler()
+	reader := &forbiddenReader{Client: newFakeClient(tlsSecret(t, "web-tls", time.Now().Add(time.Hour))), namespace: "restricted"}
+	h.SetSecretReader(reader)
+	inst := &crdv1.FlexLBInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       crdv1.FlexLBInstanceSpec{Config: models.InstanceConfig{Endpoints: []*models.Endpoint{{FrontendPort: 443, Mode: models.EndpointModeTCP}}}},
+	}
+
+	// the secret is read with the secret reader, not the client
+	setInstanceTLSSecrets(inst, map[string]string{"443": "default/web-tls"})
+	if _, _, err := h.resolveTLSConfig(newFakeClient(), context.Background(), inst); err != nil || reader.gets != 1 {
+		t.Fatalf("resolveTLSConfig() error = %v, reader gets = %d, want the secret read by the reader", err, reader.gets)
+	}
+
+	// api errors are kept through the port context
+	setInstanceTLSSecrets(inst, map[string]string{"443": "restricted/web-tls"})
+	_, _, err := h.resolveTLSConfig(newFakeClient(), context.Background(), inst)
+	if !apierrors.IsForbidden(err) || ErrorClass(err) != crdv1.ErrorClassAuth {
+		t.Errorf("resolveTLSConfig() error = %v, want the forbidden error classified auth", err)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -11,24 +13,29 @@ import (
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/flexlet/flexlb-kube-controller/agent"
+	configv1alpha1 "github.com/flexlet/flexlb-kube-controller/api/config/v1alpha1"
 	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
 	"github.com/flexlet/flexlb-kube-controller/controllers"
 	"github.com/flexlet/flexlb-kube-controller/handlers"
 	//+kubebuilder:scaffold:imports
 )
 
-const (
-	defaultRefreshInterval    = 30
-	defaultErrorRetryInterval = 1
-	defaultNamespace          = "kube-system"
-)
+// leader election id of manager if not set by config file
+const leaderElectionID = "82b77363.flexlb.flexlet.io"
 
 var (
 	scheme   = runtime.NewScheme()
@@ -43,7 +50,15 @@ func init() {
 }
 
 func main() {
+	// node network agent subcommand, runs as daemonset
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		agent.Main(os.Args[2:])
+		return
+	}
+
 	var (
+		configFile = flag.String("config", os.Getenv("FLEXLB_CONFIG"), "Config file of kind FlexLBControllerConfig, overridden by the flags and environment variables set")
+
 		// TODO: auto generated
 		metricsAddr          = flag.String("metrics-bind-address", os.Getenv("METRICS_BIND_ADDRESS"), "The address the metric endpoint binds to.")
 		probeAddr            = flag.String("health-probe-bind-address", os.Getenv("HEALTH_PROBE_BIND_ADDRESS"), "The address the probe endpoint binds to.")
@@ -54,9 +69,30 @@ func main() {
 		tlsClientKey  = flag.String("tls-client-key", os.Getenv("FLEXLB_TLS_CLIENT_KEY"), "FlexLB API server TLS client key")
 		tlsInsecure   = flag.Bool("tls-insecure", true, "FlexLB API server ignore insecure server certificate")
 
-		refreshInterval = flag.String("refresh-interval", os.Getenv("FLEXLB_REFRESH_INTERVAL"), "Instance refresh interval in seconds")
+		refreshInterval = flag.String("refresh-interval", os.Getenv("FLEXLB_REFRESH_INTERVAL"), "Default instance refresh interval in seconds, overridden by cluster spec.refresh")
+		lbClass         = flag.String("load-balancer-class", os.Getenv("FLEXLB_LOAD_BALANCER_CLASS"), "Handle services of the load balancer class only, services without class if not set")
+		defaultCluster  = flag.String("default-cluster", os.Getenv("FLEXLB_DEFAULT_CLUSTER"), "Cluster of services without cluster annotation")
+		defaultIPPool   = flag.String("default-ippool", os.Getenv("FLEXLB_DEFAULT_IPPOOL"), "IP pool of services without ippool annotation")
 		namespace       = flag.String("namespace", os.Getenv("FLEXLB_NAMESPACE"), "Namespace for flexlb clusters and temporary pods")
+		clusterNS       = flag.String("cluster-namespaces", os.Getenv("FLEXLB_CLUSTER_NAMESPACES"), "Comma separated namespaces of tenant clusters besides the flexlb namespace, '*' for all namespaces")
 		probePodImage   = flag.String("probe-pod-image", os.Getenv("FLEXLB_PROBE_POD_IMAGE"), "Node probe pod image")
+		probeTimeout    = flag.String("probe-timeout", os.Getenv("FLEXLB_PROBE_TIMEOUT"), "Node probe pod timeout in seconds")
+		networkAgent    = flag.Bool("node-network-agent", os.Getenv("FLEXLB_NODE_NETWORK_AGENT") == "true", "Node network is reported by the agent daemonset, disable probe pods")
+		apiTimeout      = flag.String("api-timeout", os.Getenv("FLEXLB_API_TIMEOUT"), "FlexLB API call timeout in seconds")
+		drainGrace      = flag.String("drain-grace-period", os.Getenv("FLEXLB_DRAIN_GRACE_PERIOD"), "Backends of cordoned or deleted nodes are drained for the grace period in seconds before removal")
+		certExpiry      = flag.String("cert-expiry-warning", os.Getenv("FLEXLB_CERT_EXPIRY_WARNING"), "Warn when a frontend tls certificate expires within the given days")
+
+		clusterConcurrency  = flag.String("cluster-concurrency", os.Getenv("FLEXLB_CLUSTER_CONCURRENCY"), "Max concurrent reconciles of FlexLBCluster")
+		instanceConcurrency = flag.String("instance-concurrency", os.Getenv("FLEXLB_INSTANCE_CONCURRENCY"), "Max concurrent reconciles of FlexLBInstance")
+		nodeConcurrency     = flag.String("node-concurrency", os.Getenv("FLEXLB_NODE_CONCURRENCY"), "Max concurrent reconciles of Node")
+		serviceConcurrency  = flag.String("service-concurrency", os.Getenv("FLEXLB_SERVICE_CONCURRENCY"), "Max concurrent reconciles of Service")
+		gatewayConcurrency  = flag.String("gateway-concurrency", os.Getenv("FLEXLB_GATEWAY_CONCURRENCY"), "Max concurrent reconciles of Gateway")
+		ingressConcurrency  = flag.String("ingress-concurrency", os.Getenv("FLEXLB_INGRESS_CONCURRENCY"), "Max concurrent reconciles of IngressClass")
+
+		gatewayAPI = flag.Bool("gateway-api", os.Getenv("FLEXLB_GATEWAY_API") == "true", "Enable Gateway API controllers, requires Gateway API CRDs installed")
+		dryRun     = flag.Bool("dry-run", os.Getenv("FLEXLB_DRY_RUN") == "true", "Plan only: log, count and report the actions as events, nothing is written to FlexLB or Kubernetes")
+		webhook    = flag.Bool("admission-webhook", os.Getenv("FLEXLB_ADMISSION_WEBHOOK") == "true", "Serve the service validating webhook of cluster and ippool access and quotas, requires webhook serving certificates")
+		ingress    = flag.Bool("ingress", os.Getenv("FLEXLB_INGRESS") != "false", "Enable the IngressClass controller")
 	)
 
 	// zap command line options:
@@ -74,71 +110,242 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     *metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: *probeAddr,
-		LeaderElection:         *enableLeaderElection,
-		LeaderElectionID:       "82b77363.flexlb.flexlet.io",
-	})
+	// flags and environment variables set override the config file, also on reload
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	// boolean environment variables are the flag defaults, set if present
+	for name, env := range map[string]string{
+		"node-network-agent": "FLEXLB_NODE_NETWORK_AGENT",
+		"gateway-api":        "FLEXLB_GATEWAY_API",
+		"dry-run":            "FLEXLB_DRY_RUN",
+		"admission-webhook":  "FLEXLB_ADMISSION_WEBHOOK",
+		"ingress":            "FLEXLB_INGRESS",
+	} {
+		if _, exist := os.LookupEnv(env); exist {
+			set[name] = true
+		}
+	}
+	overrides := func(c *configv1alpha1.FlexLBControllerConfig) {
+		f := &c.FlexLB
+		overrideString(&f.TLS.CACert, *tlsCaCert)
+		overrideString(&f.TLS.ClientCert, *tlsClientCert)
+		overrideString(&f.TLS.ClientKey, *tlsClientKey)
+		if set["tls-insecure"] || f.TLS.Insecure == nil {
+			f.TLS.Insecure = tlsInsecure
+		}
+		overrideString(&f.Namespace, *namespace)
+		if *clusterNS != "" {
+			f.ClusterNamespaces = nil
+			for _, ns := range strings.Split(*clusterNS, ",") {
+				f.ClusterNamespaces = append(f.ClusterNamespaces, strings.TrimSpace(ns))
+			}
+		}
+		overrideString(&f.Probe.Image, *probePodImage)
+		overrideString(&f.LoadBalancerClass, *lbClass)
+		overrideString(&f.DefaultCluster, *defaultCluster)
+		overrideString(&f.DefaultIPPool, *defaultIPPool)
+		overrideSeconds(&f.Probe.Timeout, *probeTimeout, time.Second)
+		overrideSeconds(&f.RefreshInterval, *refreshInterval, time.Second)
+		overrideSeconds(&f.APITimeout, *apiTimeout, time.Second)
+		overrideSeconds(&f.DrainGracePeriod, *drainGrace, time.Second)
+		overrideSeconds(&f.CertExpiryWarning, *certExpiry, 24*time.Hour)
+		overrideInt(&f.Concurrency.Cluster, *clusterConcurrency)
+		overrideInt(&f.Concurrency.Instance, *instanceConcurrency)
+		overrideInt(&f.Concurrency.Node, *nodeConcurrency)
+		overrideInt(&f.Concurrency.Service, *serviceConcurrency)
+		overrideInt(&f.Concurrency.Gateway, *gatewayConcurrency)
+		overrideInt(&f.Concurrency.Ingress, *ingressConcurrency)
+		overrideBool(&f.Features.NodeNetworkAgent, *networkAgent, set["node-network-agent"])
+		overrideBool(&f.Features.GatewayAPI, *gatewayAPI, set["gateway-api"])
+		overrideBool(&f.Features.DryRun, *dryRun, set["dry-run"])
+		overrideBool(&f.Features.AdmissionWebhook, *webhook, set["admission-webhook"])
+		if set["ingress"] || f.Features.Ingress == nil {
+			f.Features.Ingress = ingress
+		}
+	}
+	config, err := loadConfig(*configFile, overrides)
+	if err != nil {
+		setupLog.Error(err, "invalid config", "config", *configFile)
+		os.Exit(1)
+	}
+	flexlbConfig := config.FlexLB
+
+	options, err := ctrl.Options{
+		Scheme: scheme,
+		// only node probe pods and tls secrets are watched
+		NewCache: cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&corev1.Pod{}:    {Label: labels.SelectorFromSet(labels.Set{handlers.ProbePodLabel: "true"})},
+				&corev1.Secret{}: controllers.SecretCacheSelector(),
+			},
+		}),
+	}.AndFrom(config)
+	if err != nil {
+		setupLog.Error(err, "invalid manager config", "config", *configFile)
+		os.Exit(1)
+	}
+	// manager flags set override the config file, applied after it as AndFrom keeps only the options not set
+	overrideString(&options.MetricsBindAddress, *metricsAddr)
+	overrideString(&options.HealthProbeBindAddress, *probeAddr)
+	overrideBool(&options.LeaderElection, *enableLeaderElection, set["leader-elect"])
+	if options.Port == 0 {
+		options.Port = 9443
+	}
+	if options.LeaderElectionID == "" {
+		options.LeaderElectionID = leaderElectionID
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
 	// setup handler
-	handler := handlers.NewHandler(*tlsCaCert, *tlsClientCert, *tlsClientKey, *tlsInsecure, *namespace, *probePodImage, mgr.GetEventRecorderFor("flexlb-handler"))
+	recorder := mgr.GetEventRecorderFor("flexlb-handler")
+	handler := handlers.NewHandler(flexlbConfig.TLS.CACert, flexlbConfig.TLS.ClientCert, flexlbConfig.TLS.ClientKey, *flexlbConfig.TLS.Insecure,
+		flexlbConfig.Namespace, recorder)
+	handler.Reconfigure(handlerSettings(config))
+	handler.SetDefaults(flexlbConfig.DefaultCluster, flexlbConfig.DefaultIPPool)
+	handler.SetLoadBalancerClass(flexlbConfig.LoadBalancerClass)
+	handler.SetClusterNamespaces(flexlbConfig.ClusterNamespaces)
+	// key material is read from the api server, the manager caches the metadata of tls secrets only
+	handler.SetSecretReader(mgr.GetAPIReader())
 
-	refreshSeconds, err := strconv.Atoi(*refreshInterval)
-	if err != nil {
-		refreshSeconds = defaultRefreshInterval
+	// live reload of config file
+	if *configFile != "" {
+		if err := mgr.Add(&configReloader{path: *configFile, overrides: overrides, config: config, handler: handler}); err != nil {
+			setupLog.Error(err, "unable to add config reloader")
+			os.Exit(1)
+		}
+	}
+
+	// dry run: writes to flexlb and kubernetes are planned only
+	k8sClient := mgr.GetClient()
+	if flexlbConfig.Features.DryRun {
+		setupLog.Info("dry run mode, nothing is written to FlexLB or Kubernetes")
+		handler.EnableDryRun()
+		k8sClient = handlers.NewDryRunClient(k8sClient, recorder)
 	}
 
-	if namespace == nil || len(*namespace) == 0 {
-		ns := defaultNamespace
-		namespace = &ns
+	// cleanup probe pods left behind by last run
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if err := handler.CleanupProbePods(k8sClient, ctx); err != nil {
+			setupLog.Error(err, "unable to cleanup probe pods")
+		}
+		return nil
+	})); err != nil {
+		setupLog.Error(err, "unable to add probe pods cleanup")
+		os.Exit(1)
 	}
 
 	if err = (&controllers.FlexLBClusterReconciler{
-		Client:        mgr.GetClient(),
-		Scheme:        mgr.GetScheme(),
-		Namespace:     *namespace,
-		ChangeHandler: handler.ClusterChanged,
+		Client:                  k8sClient,
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: flexlbConfig.Concurrency.Cluster,
+		Namespace:               flexlbConfig.Namespace,
+		Namespaces:              flexlbConfig.ClusterNamespaces,
+		ChangeHandler:           handler.ClusterChanged,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "FlexLBCluster")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.FlexLBInstanceReconciler{
-		Client:          mgr.GetClient(),
-		Scheme:          mgr.GetScheme(),
-		RefreshInterval: time.Duration(refreshSeconds) * time.Second,
-		ChangeHandler:   handler.InstanceChanged,
-		DeleteHandler:   handler.InstanceDeleted,
+		Client:                  k8sClient,
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: flexlbConfig.Concurrency.Instance,
+		RefreshInterval:         flexlbConfig.RefreshInterval.Duration,
+		ChangeHandler:           handler.InstanceChanged,
+		DeleteHandler:           handler.InstanceDeleted,
+		RefreshPolicy:           handler.InstanceRefreshPolicy,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "FlexLBInstance")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.NodeReconciler{
-		Client:        mgr.GetClient(),
-		Scheme:        mgr.GetScheme(),
-		ChangeHandler: handler.NodeChanged,
-	}).SetupWithManager(mgr); err != nil {
+	nodeReconciler := &controllers.NodeReconciler{
+		Client:                  k8sClient,
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: flexlbConfig.Concurrency.Node,
+		Namespace:               flexlbConfig.Namespace,
+		ChangeHandler:           handler.NodeChanged,
+		DeleteHandler:           handler.NodeDeleted,
+	}
+	// node network reported by agent, no need to probe
+	if flexlbConfig.Features.NodeNetworkAgent {
+		nodeReconciler.ChangeHandler = nil
+	}
+	if err = nodeReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Node")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.ServiceReconciler{
-		Client:        mgr.GetClient(),
-		Scheme:        mgr.GetScheme(),
-		ChangeHandler: handler.ServiceChanged,
-		DeleteHandler: handler.ServiceDeleted,
+		Client:                  k8sClient,
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: flexlbConfig.Concurrency.Service,
+		ChangeHandler:           handler.ServiceChanged,
+		DeleteHandler:           handler.ServiceDeleted,
+		LoadBalancerClass:       flexlbConfig.LoadBalancerClass,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Node")
 		os.Exit(1)
 	}
+
+	if *flexlbConfig.Features.Ingress {
+		if err = (&controllers.IngressClassReconciler{
+			Client:                  k8sClient,
+			Scheme:                  mgr.GetScheme(),
+			MaxConcurrentReconciles: flexlbConfig.Concurrency.Ingress,
+			ChangeHandler:           handler.IngressClassChanged,
+			DeleteHandler:           handler.IngressClassDeleted,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "IngressClass")
+			os.Exit(1)
+		}
+	}
+
+	// admission check of services, also enforced on reconcile
+	if flexlbConfig.Features.AdmissionWebhook {
+		// instances are counted against quotas by the api server, the cache of the replica serving the webhook may be behind
+		webhookClient, err := client.NewDelegatingClient(client.NewDelegatingClientInput{CacheReader: mgr.GetAPIReader(), Client: k8sClient})
+		if err != nil {
+			setupLog.Error(err, "unable to create webhook client")
+			os.Exit(1)
+		}
+		if err = (&controllers.ServiceValidator{
+			Client:          webhookClient,
+			ValidateHandler: handler.ValidateService,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Service")
+			os.Exit(1)
+		}
+	}
+
+	// gateway api CRDs are optional
+	if flexlbConfig.Features.GatewayAPI {
+		if err = (&controllers.GatewayClassReconciler{
+			Client:                  k8sClient,
+			Scheme:                  mgr.GetScheme(),
+			MaxConcurrentReconciles: flexlbConfig.Concurrency.Gateway,
+			ChangeHandler:           handler.GatewayClassChanged,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "GatewayClass")
+			os.Exit(1)
+		}
+
+		if err = (&controllers.GatewayReconciler{
+			Client:                  k8sClient,
+			Scheme:                  mgr.GetScheme(),
+			MaxConcurrentReconciles: flexlbConfig.Concurrency.Gateway,
+			ChangeHandler:           handler.GatewayChanged,
+			DeleteHandler:           handler.GatewayDeleted,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Gateway")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -156,3 +363,40 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// parse positive integer option, use default value if not set or invalid
+func atoi(value string, defaultValue int) int {
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		return defaultValue
+	}
+	return i
+}
+
+// override config value by option if set
+func overrideString(value *string, option string) {
+	if option != "" {
+		*value = option
+	}
+}
+
+// override config value by boolean option if set, also to false
+func overrideBool(value *bool, option bool, set bool) {
+	if set {
+		*value = option
+	}
+}
+
+// override config value by positive integer option if set
+func overrideInt(value *int, option string) {
+	if i := atoi(option, 0); i > 0 {
+		*value = i
+	}
+}
+
+// override config duration by positive integer option in units if set
+func overrideSeconds(value *metav1.Duration, option string, unit time.Duration) {
+	if i := atoi(option, 0); i > 0 {
+		value.Duration = time.Duration(i) * unit
+	}
+}
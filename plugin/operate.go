@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// force the controller to reconcile service (and its instance) or instance by changing the resync annotation
+func (p *Plugin) Resync(ctx context.Context, ref string) error {
+	kind, name, err := splitKindName(ref)
+	if err != nil {
+		return err
+	}
+	key := types.NamespacedName{Namespace: p.namespace, Name: name}
+	now := time.Now().Format(time.RFC3339Nano)
+
+	switch kind {
+	case "service", "svc":
+		svc := &v1.Service{}
+		if err := p.k8s.Get(ctx, key, svc); err != nil {
+			return err
+		}
+		if err := p.annotate(ctx, svc, handlers.ResyncKey, now); err != nil {
+			return err
+		}
+		fmt.Printf("Service '%s/%s' resync requested\n", svc.Namespace, svc.Name)
+		instName, exist := svc.Annotations[handlers.InstanceKey]
+		if !exist {
+			return nil
+		}
+		key.Name = instName
+	case "flexlbinstance", "instance":
+	default:
+		return fmt.Errorf("resync of '%s' not supported, must be service or instance", kind)
+	}
+
+	inst := &crdv1.FlexLBInstance{}
+	if err := p.k8s.Get(ctx, key, inst); err != nil {
+		return err
+	}
+	if err := p.annotate(ctx, inst, handlers.ResyncKey, now); err != nil {
+		return err
+	}
+	fmt.Printf("Instance '%s/%s' resync requested\n", inst.Namespace, inst.Name)
+	return nil
+}
+
+func (p *Plugin) annotate(ctx context.Context, obj client.Object, key string, value string) error {
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	obj.SetAnnotations(annotations)
+	return p.k8s.Patch(ctx, obj, patch)
+}
+
+// release ip by deleting the instance holding it, the instance finalizer removes it from flexlb
+func (p *Plugin) ReleaseIp(ctx context.Context, ip string, clusterRef string, ippoolName string, force bool) error {
+	insts := &crdv1.FlexLBInstanceList{}
+	selector := labels.NewSelector()
+	if clusterRef != "" {
+		clusterKey, err := handlers.ParseClusterRef(clusterRef, p.flexlbNamespace)
+		if err != nil {
+			return err
+		}
+		selector = handlers.ClusterInstanceSelector(clusterKey, ippoolName, p.flexlbNamespace)
+	} else if ippoolName != "" {
+		selector = labels.SelectorFromSet(labels.Set{handlers.IPPoolKey: ippoolName})
+	}
+	if err := p.k8s.List(ctx, insts, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return err
+	}
+
+	released := false
+	for i := range insts.Items {
+		inst := &insts.Items[i]
+		if inst.Spec.Config.FrontendIpaddress != ip {
+			continue
+		}
+		owner, err := p.instanceOwner(ctx, inst)
+		if err != nil {
+			return err
+		}
+		if owner != "" && !force {
+			return fmt.Errorf("ip '%s' held by instance '%s/%s' of existing %s, use --force to release anyway",
+				ip, inst.Namespace, inst.Name, owner)
+		}
+		if err := p.k8s.Delete(ctx, inst); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		fmt.Printf("IP '%s' released, instance '%s/%s' deleted\n", ip, inst.Namespace, inst.Name)
+		released = true
+	}
+	if !released {
+		return fmt.Errorf("ip '%s' not allocated", ip)
+	}
+	return nil
+}
+
+// existing owner of instance, empty if all owners are gone
+func (p *Plugin) instanceOwner(ctx context.Context, inst *crdv1.FlexLBInstance) (string, error) {
+	for _, svcName := range handlers.GetInstanceServices(inst) {
+		svc := &v1.Service{}
+		if exist, err := p.exist(ctx, types.NamespacedName{Namespace: inst.Namespace, Name: svcName}, svc); exist || err != nil {
+			return "service '" + svcName + "'", err
+		}
+	}
+	if gwName, exist := inst.Annotations[handlers.GatewayKey]; exist {
+		gw := handlers.NewGatewayObject(handlers.GatewayGVK)
+		if exist, err := p.exist(ctx, types.NamespacedName{Namespace: inst.Namespace, Name: gwName}, gw); exist || err != nil {
+			return "gateway '" + gwName + "'", err
+		}
+	}
+	if className, exist := inst.Annotations[handlers.IngressClassKey]; exist {
+		class := &networkingv1.IngressClass{}
+		if exist, err := p.exist(ctx, types.NamespacedName{Name: className}, class); exist || err != nil {
+			return "ingress class '" + className + "'", err
+		}
+	}
+	return "", nil
+}
+
+func (p *Plugin) exist(ctx context.Context, key types.NamespacedName, obj client.Object) (bool, error) {
+	err := p.k8s.Get(ctx, key, obj)
+	if err == nil {
+		return true, nil
+	}
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
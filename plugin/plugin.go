@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// kubectl plugin (kubectl flexlb) to inspect and operate the flexlb resources of the controller
+
+const defaultFlexLBNamespace = "kube-system"
+
+const usage = `Usage: kubectl flexlb <command> [flags] [args]
+
+Commands:
+  service <name>            show instance, vip, pool, cluster, flexlb node status and backends of service
+  pools                     show ip utilization of the ippools
+  diff <instance>           diff instance spec against the live flexlb config
+  resync <service|instance>/<name>
+                            force the controller to reconcile service or instance
+  release-ip <ip>           release ip by deleting the instance holding it, owner must not exist unless --force
+
+Flags:
+`
+
+type Plugin struct {
+	k8s             client.Client
+	namespace       string
+	flexlbNamespace string
+
+	tlsCaCert     string
+	tlsClientCert string
+	tlsClientKey  string
+	tlsInsecure   bool
+}
+
+// entry of kubectl-flexlb
+func Main(args []string) {
+	fs := flag.NewFlagSet("kubectl-flexlb", flag.ExitOnError)
+	var (
+		kubeconfig      = fs.String("kubeconfig", "", "Path to the kubeconfig file")
+		namespace       = fs.String("namespace", "", "Namespace of the service or instance, default: namespace of current context")
+		flexlbNamespace = fs.String("flexlb-namespace", os.Getenv("FLEXLB_NAMESPACE"), "Namespace of flexlb clusters")
+
+		tlsCaCert     = fs.String("tls-ca-cert", os.Getenv("FLEXLB_TLS_CA_CERT"), "FlexLB API server TLS ca certificate")
+		tlsClientCert = fs.String("tls-client-cert", os.Getenv("FLEXLB_TLS_CLIENT_CERT"), "FlexLB API server TLS client certificate")
+		tlsClientKey  = fs.String("tls-client-key", os.Getenv("FLEXLB_TLS_CLIENT_KEY"), "FlexLB API server TLS client key")
+		tlsInsecure   = fs.Bool("tls-insecure", true, "FlexLB API server ignore insecure server certificate")
+
+		cluster = fs.String("cluster", "", "Cluster of pools or ip, <name> in the flexlb namespace or <namespace>/<name>, default: all clusters")
+		ippool  = fs.String("ippool", "", "IP pool of pools or ip, default: all pools")
+		force   = fs.Bool("force", false, "Release ip even if the owner of its instance exists")
+	)
+	fs.StringVar(namespace, "n", "", "Shorthand of --namespace")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	command := args[0]
+	positional := parseInterspersed(fs, args[1:])
+
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: *kubeconfig, Precedence: clientcmd.NewDefaultClientConfigLoadingRules().Precedence},
+		&clientcmd.ConfigOverrides{})
+	config, err := loader.ClientConfig()
+	exitOnError(err)
+	if *namespace == "" {
+		*namespace, _, err = loader.Namespace()
+		exitOnError(err)
+	}
+	if *flexlbNamespace == "" {
+		*flexlbNamespace = defaultFlexLBNamespace
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(crdv1.AddToScheme(scheme))
+	k8s, err := client.New(config, client.Options{Scheme: scheme})
+	exitOnError(err)
+
+	p := &Plugin{
+		k8s:             k8s,
+		namespace:       *namespace,
+		flexlbNamespace: *flexlbNamespace,
+		tlsCaCert:       *tlsCaCert,
+		tlsClientCert:   *tlsClientCert,
+		tlsClientKey:    *tlsClientKey,
+		tlsInsecure:     *tlsInsecure,
+	}
+
+	ctx := context.Background()
+	switch {
+	case command == "service" && len(positional) == 1:
+		err = p.ShowService(ctx, positional[0])
+	case command == "pools" && len(positional) == 0:
+		err = p.ShowPools(ctx, *cluster, *ippool)
+	case command == "diff" && len(positional) == 1:
+		err = p.Diff(ctx, positional[0])
+	case command == "resync" && len(positional) == 1:
+		err = p.Resync(ctx, positional[0])
+	case command == "release-ip" && len(positional) == 1:
+		err = p.ReleaseIp(ctx, positional[0], *cluster, *ippool, *force)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	exitOnError(err)
+}
+
+// parse flags mixed with positional args, kubectl style
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	positional := []string{}
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// cluster of instance, default cluster if not set
+func instanceCluster(inst *crdv1.FlexLBInstance) string {
+	if inst.Spec.Cluster != "" {
+		return inst.Spec.Cluster
+	}
+	return handlers.DefaultClusterName
+}
+
+// reference of cluster, the name of clusters in the flexlb namespace
+func (p *Plugin) clusterRef(key types.NamespacedName) string {
+	if key.Namespace == p.flexlbNamespace {
+		return key.Name
+	}
+	return key.String()
+}
+
+// ippool of instance, default ippool if not set
+func instanceIPPool(inst *crdv1.FlexLBInstance) string {
+	if inst.Spec.IPPool != "" {
+		return inst.Spec.IPPool
+	}
+	return handlers.DefaultIPPoolName
+}
+
+// split <kind>/<name>
+func splitKindName(ref string) (string, string, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("'%s' is not <kind>/<name>", ref)
+	}
+	return strings.ToLower(parts[0]), parts[1], nil
+}
@@ -0,0 +1,215 @@
+package plugin
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// plugin of namespace default on a fake client with the objects
+func newTestPlugin(objs ...client.Object) *Plugin {
+	scheme := runtime.NewScheme()
+	clientgoscheme.AddToScheme(scheme)
+	crdv1.AddToScheme(scheme)
+	return &Plugin{
+		k8s:             fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		namespace:       "default",
+		flexlbNamespace: defaultFlexLBNamespace,
+	}
+}
+
+func TestSplitKindName(t *testing.T) {
+	tests := []struct {
+		ref      string
+		wantKind string
+		wantName string
+		wantErr  bool
+	}{
+		{ref: "Service/web", wantKind: "service", wantName: "web"},
+		{ref: "instance/web-1a2b", wantKind: "instance", wantName: "web-1a2b"},
+		{ref: "web", wantErr: true},
+		{ref: "service/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			kind, name, err := splitKindName(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitKindName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if kind != tt.wantKind || name != tt.wantName {
+				t.Errorf("splitKindName() = %q, %q, want %q, %q", kind, name, tt.wantKind, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestRangeSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		want  int64
+	}{
+		{name: "ipv4 range", start: "192.168.100.10", end: "192.168.100.20", want: 11},
+		{name: "single ip", start: "10.0.0.1", end: "10.0.0.1", want: 1},
+		{name: "across octets", start: "10.0.0.0", end: "10.0.1.255", want: 512},
+		{name: "ipv6 range", start: "fd00::1", end: "fd00::100", want: 256},
+		{name: "reversed", start: "10.0.0.2", end: "10.0.0.1", want: 0},
+		{name: "invalid", start: "10.0.0", end: "10.0.0.1", want: 0},
+		{name: "too large", start: "::", end: "ffff::", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rangeSize(tt.start, tt.end); got != tt.want {
+				t.Errorf("rangeSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatStatus(t *testing.T) {
+	if got := formatStatus(nil); got != "<unknown>" {
+		t.Errorf("formatStatus(nil) = %q, want <unknown>", got)
+	}
+	if got := formatStatus(map[string]string{"flexlb-2": "down", "flexlb-1": "up"}); got != "flexlb-1=up flexlb-2=down" {
+		t.Errorf("formatStatus() = %q, want sorted node=status pairs", got)
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	namespace := fs.String("namespace", "", "")
+	force := fs.Bool("force", false, "")
+
+	positional := parseInterspersed(fs, []string{"10.0.0.1", "--force", "--namespace", "tenant-a", "extra"})
+	if !reflect.DeepEqual(positional, []string{"10.0.0.1", "extra"}) {
+		t.Errorf("parseInterspersed() = %v, want [10.0.0.1 extra]", positional)
+	}
+	if *namespace != "tenant-a" || !*force {
+		t.Errorf("flags namespace = %q, force = %v, want tenant-a, true", *namespace, *force)
+	}
+}
+
+func TestInstanceClusterAndIPPool(t *testing.T) {
+	inst := &crdv1.FlexLBInstance{}
+	if instanceCluster(inst) != handlers.DefaultClusterName || instanceIPPool(inst) != handlers.DefaultIPPoolName {
+		t.Errorf("cluster and ippool of instance = %q, %q, want the defaults", instanceCluster(inst), instanceIPPool(inst))
+	}
+	inst.Spec.Cluster, inst.Spec.IPPool = "tenant-a/edge", "dmz"
+	if instanceCluster(inst) != "tenant-a/edge" || instanceIPPool(inst) != "dmz" {
+		t.Errorf("cluster and ippool of instance = %q, %q, want tenant-a/edge, dmz", instanceCluster(inst), instanceIPPool(inst))
+	}
+
+	p := newTestPlugin()
+	if got := p.clusterRef(types.NamespacedName{Namespace: defaultFlexLBNamespace, Name: "default"}); got != "default" {
+		t.Errorf("clusterRef() of the flexlb namespace = %q, want default", got)
+	}
+	if got := p.clusterRef(types.NamespacedName{Namespace: "tenant-a", Name: "edge"}); got != "tenant-a/edge" {
+		t.Errorf("clusterRef() of a tenant namespace = %q, want tenant-a/edge", got)
+	}
+}
+
+// instance of namespace default holding ip, of the service
+func testInstance(name string, ip string, svcName string) *crdv1.FlexLBInstance {
+	return &crdv1.FlexLBInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{handlers.ServiceKey: svcName},
+		},
+		Spec: crdv1.FlexLBInstanceSpec{Config: models.InstanceConfig{Name: name, FrontendIpaddress: ip}},
+	}
+}
+
+func TestResync(t *testing.T) {
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{handlers.InstanceKey: "web-1a2b"}}}
+	inst := testInstance("web-1a2b", "192.168.100.10", "web")
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		ref         string
+		wantService bool
+		wantErr     bool
+	}{
+		{name: "service and its instance", ref: "svc/web", wantService: true},
+		{name: "instance only", ref: "instance/web-1a2b"},
+		{name: "unsupported kind", ref: "pod/web", wantErr: true},
+		{name: "service not found", ref: "service/api", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(svc.DeepCopy(), inst.DeepCopy())
+			err := p.Resync(ctx, tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			latestSvc, latestInst := &v1.Service{}, &crdv1.FlexLBInstance{}
+			p.k8s.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, latestSvc)
+			p.k8s.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web-1a2b"}, latestInst)
+			if _, exist := latestSvc.Annotations[handlers.ResyncKey]; exist != tt.wantService {
+				t.Errorf("service resync annotation set = %v, want %v", exist, tt.wantService)
+			}
+			if _, exist := latestInst.Annotations[handlers.ResyncKey]; !exist {
+				t.Errorf("instance resync annotation not set")
+			}
+		})
+	}
+}
+
+func TestReleaseIp(t *testing.T) {
+	ctx := context.Background()
+	orphan := testInstance("orphan-1a2b", "192.168.100.10", "orphan")
+	owned := testInstance("web-1a2b", "192.168.100.11", "web")
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+
+	tests := []struct {
+		name        string
+		ip          string
+		force       bool
+		wantErr     string
+		wantDeleted string
+	}{
+		{name: "owner gone", ip: "192.168.100.10", wantDeleted: "orphan-1a2b"},
+		{name: "owner exists", ip: "192.168.100.11", wantErr: "use --force"},
+		{name: "owner exists, forced", ip: "192.168.100.11", force: true, wantDeleted: "web-1a2b"},
+		{name: "not allocated", ip: "192.168.100.12", wantErr: "not allocated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugin(orphan.DeepCopy(), owned.DeepCopy(), svc.DeepCopy())
+			err := p.ReleaseIp(ctx, tt.ip, "", "", tt.force)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReleaseIp() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReleaseIp() error = %v", err)
+			}
+			err = p.k8s.Get(ctx, types.NamespacedName{Namespace: "default", Name: tt.wantDeleted}, &crdv1.FlexLBInstance{})
+			if !apierrors.IsNotFound(err) {
+				t.Errorf("instance '%s' not deleted, get error = %v", tt.wantDeleted, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+	"github.com/flexlet/flexlb-kube-controller/handlers"
+)
+
+// show instance, vip, pool, cluster, flexlb node status and rendered backends of service
+func (p *Plugin) ShowService(ctx context.Context, name string) error {
+	svc := &v1.Service{}
+	if err := p.k8s.Get(ctx, types.NamespacedName{Namespace: p.namespace, Name: name}, svc); err != nil {
+		return err
+	}
+	instName, exist := svc.Annotations[handlers.InstanceKey]
+	if !exist {
+		fmt.Printf("Service '%s/%s' has no flexlb instance\n", svc.Namespace, svc.Name)
+		return nil
+	}
+	inst := &crdv1.FlexLBInstance{}
+	if err := p.k8s.Get(ctx, types.NamespacedName{Namespace: svc.Namespace, Name: instName}, inst); err != nil {
+		return fmt.Errorf("instance '%s' of service: %s", instName, err.Error())
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Service:\t%s/%s\n", svc.Namespace, svc.Name)
+	fmt.Fprintf(w, "Instance:\t%s\n", inst.Name)
+	fmt.Fprintf(w, "Phase:\t%s\n", inst.Status.Phase)
+	fmt.Fprintf(w, "VIP:\t%s/%d on %s\n", inst.Spec.Config.FrontendIpaddress, inst.Spec.Config.FrontendNetPrefix, inst.Spec.Config.FrontendInterface)
+	fmt.Fprintf(w, "Cluster:\t%s\n", instanceCluster(inst))
+	fmt.Fprintf(w, "IP pool:\t%s\n", instanceIPPool(inst))
+	if services := handlers.GetInstanceServices(inst); len(services) > 1 {
+		fmt.Fprintf(w, "Shared by:\t%s\n", strings.Join(services, ", "))
+	}
+	fmt.Fprintf(w, "FlexLB nodes:\t%s\n", formatStatus(inst.Status.NodeStatus))
+	for _, d := range inst.Status.Draining {
+		fmt.Fprintf(w, "Draining:\t%s (%s) %s since %s\n", d.Node, d.Ipaddress, d.Mode, d.Since.Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+
+	fmt.Println()
+	printBackends(inst.Spec.Config.Endpoints)
+	return nil
+}
+
+// node=status pairs, sorted by node
+func formatStatus(status map[string]string) string {
+	if len(status) == 0 {
+		return "<unknown>"
+	}
+	pairs := []string{}
+	for node, s := range status {
+		pairs = append(pairs, node+"="+s)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+func printBackends(endpoints []*models.Endpoint) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PORT\tMODE\tBALANCE\tSERVER\tADDRESS\tOPTIONS")
+	for _, ep := range endpoints {
+		defaultOptions := ""
+		if ep.BackendDefaultServer != nil {
+			defaultOptions = *ep.BackendDefaultServer
+		}
+		if len(ep.BackendServers) == 0 {
+			fmt.Fprintf(w, "%d\t%s\t%s\t<none>\t\t\n", ep.FrontendPort, ep.Mode, ep.Balance)
+		}
+		for _, server := range ep.BackendServers {
+			options := defaultOptions
+			if server.Options != nil {
+				options = *server.Options
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s:%d\t%s\n", ep.FrontendPort, ep.Mode, ep.Balance, server.Name, server.Ipaddress, server.Port, options)
+		}
+	}
+	w.Flush()
+}
+
+// show allocated and total ips of the ippools
+func (p *Plugin) ShowPools(ctx context.Context, clusterRef string, ippoolName string) error {
+	var clusterKey types.NamespacedName
+	if clusterRef != "" {
+		key, err := handlers.ParseClusterRef(clusterRef, p.flexlbNamespace)
+		if err != nil {
+			return err
+		}
+		clusterKey = key
+	}
+	// clusters of all namespaces, tenants may own clusters
+	clusters := &crdv1.FlexLBClusterList{}
+	if err := p.k8s.List(ctx, clusters); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tIPPOOL\tRANGE\tALLOCATED\tTOTAL\tUTILIZATION")
+	for _, cluster := range clusters.Items {
+		key := types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name}
+		if clusterRef != "" && key != clusterKey {
+			continue
+		}
+		for _, pool := range cluster.Spec.IPPools {
+			if ippoolName != "" && pool.Name != ippoolName {
+				continue
+			}
+			insts := &crdv1.FlexLBInstanceList{}
+			if err := p.k8s.List(ctx, insts, client.MatchingLabelsSelector{Selector: handlers.ClusterInstanceSelector(key, pool.Name, p.flexlbNamespace)}); err != nil {
+				return err
+			}
+			allocated := len(insts.Items)
+			total := rangeSize(pool.Start, pool.End)
+			utilization := "-"
+			if total > 0 {
+				utilization = fmt.Sprintf("%.1f%%", float64(allocated)*100/float64(total))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s-%s\t%d\t%d\t%s\n", p.clusterRef(key), pool.Name, pool.Start, pool.End, allocated, total, utilization)
+		}
+	}
+	w.Flush()
+	return nil
+}
+
+// number of ips in range, 0 if invalid
+func rangeSize(start string, end string) int64 {
+	startIp, endIp := net.ParseIP(start), net.ParseIP(end)
+	if startIp == nil || endIp == nil {
+		return 0
+	}
+	size := new(big.Int).Sub(new(big.Int).SetBytes(endIp.To16()), new(big.Int).SetBytes(startIp.To16()))
+	if size.Sign() < 0 || !size.IsInt64() {
+		return 0
+	}
+	return size.Int64() + 1
+}
+
+// diff instance spec against the live flexlb config, tls material read from secrets is ignored
+func (p *Plugin) Diff(ctx context.Context, name string) error {
+	inst := &crdv1.FlexLBInstance{}
+	if err := p.k8s.Get(ctx, types.NamespacedName{Namespace: p.namespace, Name: name}, inst); err != nil {
+		return err
+	}
+	clusterKey, err := handlers.ParseClusterRef(instanceCluster(inst), p.flexlbNamespace)
+	if err != nil {
+		return err
+	}
+	cluster := &crdv1.FlexLBCluster{}
+	if err := p.k8s.Get(ctx, clusterKey, cluster); err != nil {
+		return fmt.Errorf("cluster of instance: %s", err.Error())
+	}
+	lb, err := handlers.NewFlexLBBackend(cluster.Spec.Endpoint, p.tlsCaCert, p.tlsClientCert, p.tlsClientKey, p.tlsInsecure)
+	if err != nil {
+		return fmt.Errorf("connect cluster '%s' failed: %s", cluster.Name, err.Error())
+	}
+	live, err := lb.GetInstance(ctx, inst.Spec.Config.Name)
+	if err != nil {
+		return fmt.Errorf("instance '%s' not found on flexlb: %s", inst.Spec.Config.Name, err.Error())
+	}
+
+	diff := cmp.Diff(live.Config, &inst.Spec.Config, cmpopts.IgnoreFields(models.Endpoint{}, "FrontendSslOptions"))
+	if diff == "" {
+		fmt.Printf("Instance '%s/%s' is in sync with flexlb (%s)\n", inst.Namespace, inst.Name, formatStatus(live.Status))
+		return nil
+	}
+	fmt.Printf("Instance '%s/%s' differs from flexlb (-flexlb +spec):\n%s", inst.Namespace, inst.Name, diff)
+	return nil
+}
@@ -71,8 +71,8 @@ function fn_main() {
     sed -i "s/client.key:.*$/client.key: ${CLIENT_KEY}/" ${SECRET_FILE}
 
     echo "==== install controller"
-    CONTROLLER_FILE=config/controller/flexlb-kube-controller.yaml
-    sed -i "s/probe-pod-image=.*$/probe-pod-image=${PROBE_POD_IMAGE}/" ${CONTROLLER_FILE}
+    CONFIG_FILE=config/controller/flexlb-config.yaml
+    sed -i "s/image:.*$/image: ${PROBE_POD_IMAGE}/" ${CONFIG_FILE}
     kubectl apply -f config/controller
     
     echo "==== generate flexlb cluster config"
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/flexlet/flexlb-kube-controller/test/fakeflexlb"
+)
+
+// run fake flexlb api server standalone, the controller connects with --tls-insecure
+func main() {
+	var (
+		listen = flag.String("listen", os.Getenv("FAKE_FLEXLB_LISTEN"), "Listen address, default: random local port")
+		nodes  = flag.String("nodes", os.Getenv("FAKE_FLEXLB_NODES"), "Comma separated flexlb node names")
+	)
+	flag.Parse()
+
+	names := []string{}
+	for _, node := range strings.Split(*nodes, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			names = append(names, node)
+		}
+	}
+
+	server := fakeflexlb.NewUnstartedServer(names...)
+	if *listen != "" {
+		if err := server.Listen(*listen); err != nil {
+			fmt.Fprintf(os.Stderr, "listen failed: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+	fmt.Printf("fake flexlb api server listening on %s\n", server.Endpoint())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+}
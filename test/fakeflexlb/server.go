@@ -0,0 +1,359 @@
+package fakeflexlb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/flexlet/flexlb-client-go/models"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+// fake flexlb api server: serves the readyz and instance apis of flexlb-client-go over tls, instances are kept
+// in memory and reported up on every ready node; failures and latency can be injected per operation
+
+// base path of flexlb api
+const BasePath = "/flexlb/v1"
+
+// flexlb node ready status
+const (
+	NodeReady    = "ready"
+	NodeNotReady = "not_ready"
+)
+
+// api operations
+const (
+	OpReadyz = "readyz"
+	OpList   = "list"
+	OpGet    = "get"
+	OpCreate = "create"
+	OpModify = "modify"
+	OpDelete = "delete"
+	OpStart  = "start"
+	OpStop   = "stop"
+)
+
+type Server struct {
+	*httptest.Server
+
+	lock      sync.Mutex
+	nodes     models.ReadyStatus
+	instances map[string]*models.Instance
+	stopped   map[string]bool
+	nextId    uint8
+	failures  map[string]int
+	latency   map[string]time.Duration
+	calls     map[string]int
+}
+
+// start fake server on a random local port, with the flexlb nodes, default: one node
+func NewServer(nodes ...string) *Server {
+	s := NewUnstartedServer(nodes...)
+	s.StartTLS()
+	return s
+}
+
+// fake server not started, listener may be replaced before StartTLS
+func NewUnstartedServer(nodes ...string) *Server {
+	if len(nodes) == 0 {
+		nodes = []string{"flexlb-1"}
+	}
+	s := &Server{
+		nodes:     models.ReadyStatus{},
+		instances: map[string]*models.Instance{},
+		stopped:   map[string]bool{},
+		failures:  map[string]int{},
+		latency:   map[string]time.Duration{},
+		calls:     map[string]int{},
+	}
+	for _, node := range nodes {
+		s.nodes[node] = NodeReady
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// listen on the address instead of a random local port
+func (s *Server) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.Server.Listener.Close()
+	s.Server.Listener = l
+	return nil
+}
+
+// host:port of server, the endpoint of FlexLBCluster
+func (s *Server) Endpoint() string {
+	u, _ := url.Parse(s.URL)
+	return u.Host
+}
+
+// set ready status of flexlb node, node is added if not exist
+func (s *Server) SetNodeStatus(node string, status string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nodes[node] = status
+}
+
+// fail operation with http status code, 0 to recover
+func (s *Server) Fail(op string, code int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if code == 0 {
+		delete(s.failures, op)
+	} else {
+		s.failures[op] = code
+	}
+}
+
+// delay responses of operation, 0 to respond immediately
+func (s *Server) SetLatency(op string, latency time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.latency[op] = latency
+}
+
+// number of calls of operation, failed calls included
+func (s *Server) Calls(op string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls[op]
+}
+
+// recover all failures, reset latencies and call counts, instances are kept
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failures = map[string]int{}
+	s.latency = map[string]time.Duration{}
+	s.calls = map[string]int{}
+}
+
+// copy of instance, nil if not exist
+func (s *Server) Instance(name string) *models.Instance {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	inst, exist := s.instances[name]
+	if !exist {
+		return nil
+	}
+	return s.copyInstance(inst)
+}
+
+// names of instances, sorted
+func (s *Server) Instances() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := []string{}
+	for name := range s.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, BasePath), "/")
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	var op string
+	switch {
+	case path == "/readyz" && r.Method == http.MethodGet:
+		op = OpReadyz
+	case path == "/instances" && r.Method == http.MethodGet:
+		op = OpList
+	case path == "/instances" && r.Method == http.MethodPost:
+		op = OpCreate
+	case path == "/instances" && r.Method == http.MethodPut:
+		op = OpModify
+	case len(parts) == 2 && parts[0] == "instances" && r.Method == http.MethodGet:
+		op = OpGet
+	case len(parts) == 2 && parts[0] == "instances" && r.Method == http.MethodDelete:
+		op = OpDelete
+	case len(parts) == 3 && parts[0] == "instances" && parts[2] == "start" && r.Method == http.MethodPost:
+		op = OpStart
+	case len(parts) == 3 && parts[0] == "instances" && parts[2] == "stop" && r.Method == http.MethodPost:
+		op = OpStop
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s not found", r.Method, r.URL.Path))
+		return
+	}
+
+	s.lock.Lock()
+	s.calls[op]++
+	latency, code := s.latency[op], s.failures[op]
+	s.lock.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if code != 0 {
+		writeError(w, code, fmt.Sprintf("injected failure of %s", op))
+		return
+	}
+
+	switch op {
+	case OpReadyz:
+		s.readyz(w)
+	case OpList:
+		s.list(w, r.URL.Query().Get("name"))
+	case OpCreate, OpModify:
+		cfg := &models.InstanceConfig{}
+		if err := json.NewDecoder(r.Body).Decode(cfg); err != nil || cfg.Name == "" {
+			writeError(w, http.StatusBadRequest, "invalid instance config")
+			return
+		}
+		if op == OpCreate {
+			s.create(w, cfg)
+		} else {
+			s.modify(w, cfg)
+		}
+	case OpGet:
+		s.get(w, parts[1])
+	case OpDelete:
+		s.delete(w, parts[1])
+	case OpStart, OpStop:
+		s.startStop(w, parts[1], op == OpStop)
+	}
+}
+
+func (s *Server) readyz(w http.ResponseWriter) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeJSON(w, s.nodes)
+}
+
+func (s *Server) list(w http.ResponseWriter, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	list := []*models.Instance{}
+	for _, inst := range s.instances {
+		if name == "" || inst.Config.Name == name {
+			list = append(list, s.copyInstance(inst))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Config.Name < list[j].Config.Name
+	})
+	writeJSON(w, list)
+}
+
+func (s *Server) get(w http.ResponseWriter, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	inst, exist := s.instances[name]
+	if !exist {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("instance '%s' not found", name))
+		return
+	}
+	writeJSON(w, s.copyInstance(inst))
+}
+
+func (s *Server) create(w http.ResponseWriter, cfg *models.InstanceConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, exist := s.instances[cfg.Name]; exist {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("instance '%s' already exists", cfg.Name))
+		return
+	}
+	if err := s.checkFrontend(cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.nextId++
+	inst := &models.Instance{ID: s.nextId, Config: cfg, LastModified: time.Now().Unix()}
+	s.instances[cfg.Name] = inst
+	writeJSON(w, s.copyInstance(inst))
+}
+
+func (s *Server) modify(w http.ResponseWriter, cfg *models.InstanceConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	inst, exist := s.instances[cfg.Name]
+	if !exist {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("instance '%s' not found", cfg.Name))
+		return
+	}
+	if err := s.checkFrontend(cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	inst.Config = cfg
+	inst.LastModified = time.Now().Unix()
+	writeJSON(w, s.copyInstance(inst))
+}
+
+func (s *Server) delete(w http.ResponseWriter, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, exist := s.instances[name]; !exist {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("instance '%s' not found", name))
+		return
+	}
+	delete(s.instances, name)
+	delete(s.stopped, name)
+	writeJSON(w, map[string]string{})
+}
+
+func (s *Server) startStop(w http.ResponseWriter, name string, stop bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	inst, exist := s.instances[name]
+	if !exist {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("instance '%s' not found", name))
+		return
+	}
+	s.stopped[name] = stop
+	writeJSON(w, s.copyInstance(inst))
+}
+
+// frontend ip is not used by other instances
+func (s *Server) checkFrontend(cfg *models.InstanceConfig) error {
+	for _, inst := range s.instances {
+		if inst.Config.Name != cfg.Name && inst.Config.FrontendIpaddress == cfg.FrontendIpaddress {
+			return fmt.Errorf("frontend ip '%s' used by instance '%s'", cfg.FrontendIpaddress, inst.Config.Name)
+		}
+	}
+	return nil
+}
+
+// copy of instance with status of ready nodes, caller holds the lock
+func (s *Server) copyInstance(inst *models.Instance) *models.Instance {
+	data, _ := json.Marshal(inst)
+	copied := &models.Instance{}
+	json.Unmarshal(data, copied)
+	copied.Status = map[string]string{}
+	for node, ready := range s.nodes {
+		if ready == NodeReady && !s.stopped[inst.Config.Name] {
+			copied.Status[node] = crdv1.InstanceStatusUp
+		} else {
+			copied.Status[node] = crdv1.InstanceStatusDown
+		}
+	}
+	return copied
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
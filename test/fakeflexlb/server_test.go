@@ -0,0 +1,160 @@
+package fakeflexlb
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	flexlb "github.com/flexlet/flexlb-client-go/client"
+	models "github.com/flexlet/flexlb-client-go/models"
+
+	crdv1 "github.com/flexlet/flexlb-kube-controller/api/v1"
+)
+
+func newClient(t *testing.T, s *Server) *flexlb.Flexlb {
+	lb, err := flexlb.NewTLSClient(s.Endpoint(), "", "", "", true, nil)
+	if err != nil {
+		t.Fatalf("create client failed: %s", err.Error())
+	}
+	return lb
+}
+
+func TestInstanceLifecycle(t *testing.T) {
+	s := NewServer("node-a", "node-b")
+	defer s.Close()
+	lb := newClient(t, s)
+
+	status, err := lb.GetReadyStatus()
+	if err != nil || len(status) != 2 || status["node-a"] != NodeReady {
+		t.Fatalf("unexpected ready status %v: %v", status, err)
+	}
+
+	cfg := &models.InstanceConfig{
+		Name:              "inst-1",
+		FrontendInterface: "eth0",
+		FrontendIpaddress: "192.168.1.10",
+		FrontendNetPrefix: 24,
+		Endpoints:         []*models.Endpoint{{FrontendPort: 80, Mode: models.EndpointModeTCP, Balance: "roundrobin"}},
+	}
+	created, err := lb.CreateInstance(cfg)
+	if err != nil {
+		t.Fatalf("create failed: %s", err.Error())
+	}
+	if created.Status["node-a"] != crdv1.InstanceStatusUp || created.Status["node-b"] != crdv1.InstanceStatusUp {
+		t.Errorf("unexpected status of created instance: %v", created.Status)
+	}
+	if _, err := lb.CreateInstance(cfg); err == nil {
+		t.Errorf("create of existing instance succeeded")
+	}
+
+	cfg.Endpoints[0].FrontendPort = 8080
+	if _, err := lb.ModifyInstance(cfg); err != nil {
+		t.Fatalf("modify failed: %s", err.Error())
+	}
+	got, err := lb.GetInstance("inst-1")
+	if err != nil || got.Config.Endpoints[0].FrontendPort != 8080 {
+		t.Errorf("modified config not returned: %v", err)
+	}
+
+	s.SetNodeStatus("node-b", NodeNotReady)
+	if got, _ := lb.GetInstance("inst-1"); got.Status["node-b"] != crdv1.InstanceStatusDown {
+		t.Errorf("instance up on not ready node: %v", got.Status)
+	}
+	if stopped, err := lb.StopInstance("inst-1"); err != nil || stopped.Status["node-a"] != crdv1.InstanceStatusDown {
+		t.Errorf("stopped instance not down: %v", err)
+	}
+
+	if list, err := lb.ListInstances(nil); err != nil || len(list) != 1 {
+		t.Errorf("unexpected instance list %v: %v", list, err)
+	}
+	if err := lb.DeleteInstance("inst-1"); err != nil {
+		t.Fatalf("delete failed: %s", err.Error())
+	}
+	if _, err := lb.GetInstance("inst-1"); err == nil {
+		t.Errorf("deleted instance still exists")
+	}
+}
+
+func TestFrontendConflict(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	lb := newClient(t, s)
+
+	config := func(name string, ip string) *models.InstanceConfig {
+		return &models.InstanceConfig{
+			Name:              name,
+			FrontendInterface: "eth0",
+			FrontendIpaddress: ip,
+			FrontendNetPrefix: 24,
+			Endpoints:         []*models.Endpoint{{FrontendPort: 80, Mode: models.EndpointModeTCP, Balance: "roundrobin"}},
+		}
+	}
+	if _, err := lb.CreateInstance(config("inst-1", "192.168.1.10")); err != nil {
+		t.Fatalf("create failed: %s", err.Error())
+	}
+	if _, err := lb.CreateInstance(config("inst-2", "192.168.1.10")); err == nil {
+		t.Errorf("create with used frontend ip succeeded")
+	}
+	if _, err := lb.CreateInstance(config("inst-2", "192.168.1.11")); err != nil {
+		t.Fatalf("create failed: %s", err.Error())
+	}
+	if _, err := lb.ModifyInstance(config("inst-2", "192.168.1.10")); err == nil {
+		t.Errorf("modify to used frontend ip succeeded")
+	}
+	if _, err := lb.ModifyInstance(config("inst-1", "192.168.1.10")); err != nil {
+		t.Errorf("modify keeping own frontend ip failed: %s", err.Error())
+	}
+	if names := s.Instances(); !reflect.DeepEqual(names, []string{"inst-1", "inst-2"}) {
+		t.Errorf("unexpected instances %v", names)
+	}
+}
+
+func TestInjectedFailure(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	lb := newClient(t, s)
+
+	s.Fail(OpReadyz, http.StatusInternalServerError)
+	if _, err := lb.GetReadyStatus(); err == nil {
+		t.Errorf("injected failure not returned")
+	}
+	s.Fail(OpReadyz, 0)
+	if _, err := lb.GetReadyStatus(); err != nil {
+		t.Errorf("failure not recovered: %s", err.Error())
+	}
+	if calls := s.Calls(OpReadyz); calls != 2 {
+		t.Errorf("unexpected calls %d", calls)
+	}
+}
+
+func TestLatencyAndReset(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	lb := newClient(t, s)
+
+	cfg := &models.InstanceConfig{Name: "inst-1", FrontendIpaddress: "192.168.1.10", FrontendNetPrefix: 24}
+	if _, err := lb.CreateInstance(cfg); err != nil {
+		t.Fatalf("create failed: %s", err.Error())
+	}
+	s.SetLatency(OpGet, 200*time.Millisecond)
+	s.Fail(OpList, http.StatusServiceUnavailable)
+	start := time.Now()
+	if _, err := lb.GetInstance("inst-1"); err != nil {
+		t.Fatalf("get failed: %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("latency not injected, responded in %s", elapsed)
+	}
+
+	s.Reset()
+	if calls := s.Calls(OpGet); calls != 0 {
+		t.Errorf("calls not reset: %d", calls)
+	}
+	if _, err := lb.ListInstances(nil); err != nil {
+		t.Errorf("failure not reset: %s", err.Error())
+	}
+	if s.Instance("inst-1") == nil {
+		t.Errorf("instance not kept on reset")
+	}
+}
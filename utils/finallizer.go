@@ -22,3 +22,20 @@ func UnsetFinalizer(meta *metav1.ObjectMeta) bool {
 	}
 	return false
 }
+
+// finalizer of objects without typed ObjectMeta, e.g. unstructured gateway api objects
+func SetObjectFinalizer(obj metav1.Object) bool {
+	if !utl.ListContains(obj.GetFinalizers(), finalizer) {
+		obj.SetFinalizers(append(obj.GetFinalizers(), finalizer))
+		return true
+	}
+	return false
+}
+
+func UnsetObjectFinalizer(obj metav1.Object) bool {
+	if utl.ListContains(obj.GetFinalizers(), finalizer) {
+		obj.SetFinalizers(utl.ListDelete(obj.GetFinalizers(), finalizer))
+		return true
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"sync"
+)
+
+// mutex per key, idle keys are released once nobody holds or waits for them
+type KeyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (m *KeyedMutex) Lock(key string) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = map[string]*keyedLock{}
+	}
+	l, exist := m.locks[key]
+	if !exist {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+}
+
+func (m *KeyedMutex) Unlock(key string) {
+	m.mu.Lock()
+	l, exist := m.locks[key]
+	if !exist {
+		m.mu.Unlock()
+		return
+	}
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, key)
+	}
+	m.mu.Unlock()
+
+	l.Unlock()
+}
@@ -36,3 +36,31 @@ func GetServiceOfEndpointSlice(k8s client.Client, ctx context.Context, endpointS
 	}
 	return service, nil
 }
+
+// get the services which have endpoints on the node
+func GetServicesOfNode(k8s client.Client, ctx context.Context, nodeName string) ([]types.NamespacedName, error) {
+	services := []types.NamespacedName{}
+	epslist := &disv1.EndpointSliceList{}
+	if err := k8s.List(ctx, epslist); err != nil {
+		return services, err
+	}
+	seen := map[types.NamespacedName]bool{}
+	for _, eps := range epslist.Items {
+		serviceName, exist := eps.Labels[disv1.LabelServiceName]
+		if !exist || serviceName == "" {
+			continue
+		}
+		for _, ep := range eps.Endpoints {
+			if ep.NodeName == nil || *ep.NodeName != nodeName {
+				continue
+			}
+			key := types.NamespacedName{Namespace: eps.Namespace, Name: serviceName}
+			if !seen[key] {
+				seen[key] = true
+				services = append(services, key)
+			}
+			break
+		}
+	}
+	return services, nil
+}